@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// auditRecord is one line of the --audit-log file.
+type auditRecord struct {
+	Time      string            `json:"time"`
+	User      string            `json:"user"`
+	UID       int               `json:"uid"`
+	PID       int               `json:"pid"`
+	Argv      []string          `json:"argv"` // null for encrypted files, as it may hold values
+	TraceID   string            `json:"trace_id"`
+	File      string            `json:"file"`
+	Operation string            `json:"operation"`
+	Metric    string            `json:"metric"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Before    *string           `json:"before"` // null if the series didn't exist
+	After     *string           `json:"after"`
+	Error     string            `json:"error,omitempty"`
+	Encrypted bool              `json:"encrypted,omitempty"` // values are left out
+}
+
+// newAuditRecord describes what an invocation did to one target. before is
+// the series' state ahead of the operation; runErr is the error the run
+// reports for this target, if any. Records for encrypted files leave out
+// the values and argv, which the log would otherwise keep in plain text.
+func newAuditRecord(t *target, req *request, families map[string]*dto.MetricFamily, before *seriesSnapshot, runErr error, encrypted bool) *auditRecord {
+	record := &auditRecord{
+		Time:      timeProvider.Now().UTC().Format(time.RFC3339Nano),
+		User:      currentUser(),
+		UID:       os.Getuid(),
+		PID:       os.Getpid(),
+		Argv:      os.Args,
+		TraceID:   t.errors.traceID,
+		File:      t.filename,
+		Operation: req.operation,
+		Metric:    req.metricName,
+		Labels:    changedLabels(req),
+	}
+	if runErr != nil {
+		record.Error = runErr.Error()
+	}
+	if encrypted {
+		record.Argv = nil
+		record.Encrypted = true
+		return record
+	}
+	if before != nil {
+		record.Before = stringPtr(strconv.FormatFloat(before.value, 'g', -1, 64))
+	}
+	if value, exists := seriesValue(families, req.metricName, record.Labels); exists {
+		record.After = stringPtr(strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	return record
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// appendAudit appends record to the audit log as a single JSON line. Lines
+// are written with one O_APPEND write, so concurrent invocations sharing a
+// log don't interleave.
+func appendAudit(filename string, record *auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total 4\n# TYPE temp gauge\ntemp 1\n")
+	auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	require.NoError(t, createTestApp().Run([]string{"omet", "--audit-log", auditLog, "-i", "-f", testFile, "jobs_total", "inc"}))
+	require.NoError(t, createTestApp().Run([]string{"omet", "--audit-log", auditLog, "-i", "-f", testFile, "-l", "env=prod", "requests_total", "inc"}))
+	err := createTestApp().Run([]string{"omet", "--audit-log", auditLog, "-i", "-f", testFile, "temp", "inc"})
+	require.Error(t, err)
+
+	file, err := os.Open(auditLog)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record auditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 3)
+
+	first := records[0]
+	assert.Equal(t, testFile, first.File)
+	assert.Equal(t, "inc", first.Operation)
+	assert.Equal(t, "jobs_total", first.Metric)
+	assert.Equal(t, os.Getpid(), first.PID)
+	assert.NotEmpty(t, first.User)
+	assert.NotEmpty(t, first.TraceID)
+	require.NotNil(t, first.Before)
+	require.NotNil(t, first.After)
+	assert.Equal(t, "4", *first.Before)
+	assert.Equal(t, "5", *first.After)
+	assert.Empty(t, first.Error)
+
+	assert.Nil(t, records[1].Before, "new series have no previous value")
+	assert.Equal(t, map[string]string{"env": "prod"}, records[1].Labels)
+
+	assert.Contains(t, records[2].Error, "not a counter")
+	assert.Equal(t, *records[2].Before, *records[2].After, "failed operations leave the series alone")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"omet/internal/backfill"
+	"omet/internal/metricsfile"
+
+	"github.com/urfave/cli/v2"
+)
+
+func backfillCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "backfill",
+		Usage:     "Record timestamped samples for Prometheus backfill tooling",
+		ArgsUsage: "<metric> [value]",
+		Description: `Adds samples with explicit timestamps to an OpenMetrics file that
+"promtool tsdb create-blocks-from openmetrics" can import. Every sample
+carries its timestamp, series are grouped and their points kept in time
+order, and the file ends with "# EOF". Existing points in the file are kept;
+a point at the same time as an existing one replaces it.
+
+Give one point with --timestamp and a value, or many with --csv, a file of
+time,value rows. Times are Unix milliseconds or RFC 3339; a header row is
+skipped. Use --csv - to read rows from stdin.
+
+The file has no omet checksum trailer, since nothing may follow "# EOF",
+and is meant for promtool rather than for node_exporter.
+
+Examples:
+  omet backfill -f backfill.prom -l job=backup --timestamp 1700000000000 backup_size_bytes 1024
+  omet backfill -f backfill.prom --type counter --csv requests.csv requests_total
+  promtool tsdb create-blocks-from openmetrics backfill.prom ./data`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "OpenMetrics file to add the samples to",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:    "label",
+				Aliases: []string{"l"},
+				Usage:   "Add label in KEY=VALUE format (can be repeated)",
+			},
+			&cli.StringFlag{
+				Name:  "type",
+				Value: "gauge",
+				Usage: "Type of the metric family (gauge, counter, untyped)",
+			},
+			&cli.StringFlag{
+				Name:  "help-text",
+				Usage: "HELP text for the metric family",
+			},
+			&cli.Int64Flag{
+				Name:  "timestamp",
+				Usage: "Time of the value, in Unix milliseconds",
+			},
+			&cli.StringFlag{
+				Name:  "csv",
+				Usage: "Read time,value rows from this file (- for stdin)",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for file lock",
+			},
+		},
+		Action: runBackfill,
+	}
+}
+
+func runBackfill(ctx *cli.Context) error {
+	metricType := ctx.String("type")
+	switch metricType {
+	case "gauge", "counter", "untyped":
+	default:
+		return fmt.Errorf("invalid --type %s for backfill (supported: gauge, counter, untyped)", metricType)
+	}
+
+	labels, err := parseLabels(ctx.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+
+	var points []backfill.Point
+	switch {
+	case ctx.IsSet("csv") && ctx.IsSet("timestamp"):
+		return fmt.Errorf("--csv and --timestamp are mutually exclusive")
+	case ctx.IsSet("csv"):
+		if ctx.NArg() != 1 {
+			return fmt.Errorf("backfill --csv requires a metric name")
+		}
+		if points, err = readBackfillCSV(ctx.String("csv")); err != nil {
+			return err
+		}
+	case ctx.IsSet("timestamp"):
+		if ctx.NArg() != 2 {
+			return fmt.Errorf("backfill --timestamp requires a metric name and a value")
+		}
+		value, err := strconv.ParseFloat(ctx.Args().Get(1), 64)
+		if err != nil {
+			return fmt.Errorf("invalid value %q: %w", ctx.Args().Get(1), err)
+		}
+		points = []backfill.Point{{TimestampMs: ctx.Int64("timestamp"), Samples: []backfill.Sample{{Value: value}}}}
+	default:
+		return fmt.Errorf("backfill requires --timestamp or --csv")
+	}
+
+	suffix := ""
+	if metricType == "counter" {
+		suffix = "_total"
+	}
+	for i := range points {
+		points[i].Samples[0].Suffix = suffix
+	}
+
+	return updateBackfillFile(ctx.String("file"), ctx.Duration("lock-timeout"), func(f *backfill.File) error {
+		for _, point := range points {
+			if err := f.Add(ctx.Args().First(), metricType, ctx.String("help-text"), labels, point); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updateBackfillFile applies update to the backfill file under its lock,
+// validating the result before replacing the file.
+func updateBackfillFile(filename string, lockTimeout time.Duration, update func(*backfill.File) error) error {
+	lock, err := metricsfile.NewFileLock(filename, lockTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+	defer lock.Close()
+
+	if err := lock.Lock(context.Background()); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	f, err := backfill.Read(lock.File())
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	if err := update(f); err != nil {
+		return err
+	}
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	return lock.Rewrite(func(file *os.File) error {
+		_, err := f.WriteTo(file)
+		return err
+	})
+}
+
+// readBackfillCSV reads time,value rows. A first row whose time doesn't
+// parse is taken as a header.
+func readBackfillCSV(filename string) ([]backfill.Point, error) {
+	var input io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var points []backfill.Point
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		timestamp, err := parseBackfillTime(record[0])
+		if err != nil {
+			if row == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s row %d: %w", filename, row, err)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s row %d: invalid value %q", filename, row, record[1])
+		}
+		points = append(points, backfill.Point{TimestampMs: timestamp, Samples: []backfill.Sample{{Value: value}}})
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%s: no time,value rows", filename)
+	}
+	return points, nil
+}
+
+// parseBackfillTime accepts Unix milliseconds or an RFC 3339 time.
+func parseBackfillTime(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected Unix milliseconds or RFC 3339", s)
+	}
+	return t.UnixMilli(), nil
+}
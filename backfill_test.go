@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfillCommand(t *testing.T) {
+	t.Run("adds timestamped points in time order", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "backfill.prom")
+		for _, args := range [][]string{
+			{"--timestamp", "1700000060000", "backup_size_bytes", "2048"},
+			{"--timestamp", "1700000000000", "backup_size_bytes", "1024"},
+			{"--timestamp", "1700000060000", "backup_size_bytes", "4096"},
+		} {
+			err := createTestApp().Run(append([]string{"omet", "backfill", "-f", out, "-l", "job=backup", "--help-text", "Backup size"}, args...))
+			require.NoError(t, err)
+		}
+
+		content, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, `# HELP backup_size_bytes Backup size
+# TYPE backup_size_bytes gauge
+backup_size_bytes{job="backup"} 1024 1700000000
+backup_size_bytes{job="backup"} 4096 1700000060
+# EOF
+`, string(content))
+	})
+
+	t.Run("reads time,value rows from CSV", func(t *testing.T) {
+		dir := t.TempDir()
+		out := filepath.Join(dir, "backfill.prom")
+		points := filepath.Join(dir, "points.csv")
+		require.NoError(t, os.WriteFile(points, []byte("time,value\n1700000000500,1\n2023-11-14T22:14:20Z,5\n"), 0644))
+
+		err := createTestApp().Run([]string{"omet", "backfill", "-f", out, "--type", "counter", "--csv", points, "requests_total"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, `# TYPE requests counter
+requests_total 1 1700000000.5
+requests_total 5 1700000060
+# EOF
+`, string(content))
+	})
+
+	t.Run("rejects bad input", func(t *testing.T) {
+		dir := t.TempDir()
+		out := filepath.Join(dir, "backfill.prom")
+		bad := filepath.Join(dir, "bad.csv")
+		require.NoError(t, os.WriteFile(bad, []byte("1700000000000,1\nyesterday,2\n"), 0644))
+
+		for name, args := range map[string][]string{
+			"no timestamp": {"up", "1"},
+			"bad type":     {"--type", "histogram", "--timestamp", "1", "up", "1"},
+			"bad name":     {"--timestamp", "1", "bad-name", "1"},
+			"bad csv time": {"--csv", bad, "up"},
+		} {
+			err := createTestApp().Run(append([]string{"omet", "backfill", "-f", out}, args...))
+			assert.Error(t, err, name)
+		}
+		content, _ := os.ReadFile(out)
+		assert.Empty(t, content, "failed runs leave the file untouched")
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+func benchCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "bench",
+		Usage: "Measure parse/apply/write throughput on a synthetic metrics file",
+		Description: `Generates a synthetic file of N families x M series (a mix of counters,
+gauges, and histograms) and times parsing, applying one operation per
+family, and writing it back out.
+
+Example:
+  omet bench --families 1000 --series 100 --iterations 3`,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "families",
+				Usage: "Number of metric families to generate",
+				Value: 100,
+			},
+			&cli.IntFlag{
+				Name:  "series",
+				Usage: "Number of series per family",
+				Value: 100,
+			},
+			&cli.IntFlag{
+				Name:  "iterations",
+				Usage: "Number of times to repeat each phase",
+				Value: 5,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return runBench(os.Stdout, ctx.Int("families"), ctx.Int("series"), ctx.Int("iterations"))
+		},
+	}
+}
+
+// generateSyntheticFamilies builds families*series series, cycling through
+// counters, gauges, and histograms.
+func generateSyntheticFamilies(families, series int) map[string]*dto.MetricFamily {
+	result := make(map[string]*dto.MetricFamily, families)
+	types := []dto.MetricType{dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_HISTOGRAM}
+
+	for f := 0; f < families; f++ {
+		metricType := types[f%len(types)]
+		name := fmt.Sprintf("bench_%s_%d", syntheticSuffix(metricType), f)
+		family := createMetricFamily(name, metricType)
+
+		for s := 0; s < series; s++ {
+			labels := map[string]string{"instance": fmt.Sprintf("host-%d", s), "job": "bench"}
+			metric := &dto.Metric{Label: createLabelPairs(labels)}
+			switch metricType {
+			case dto.MetricType_COUNTER:
+				metric.Counter = &dto.Counter{Value: float64Ptr(float64(s * 10))}
+			case dto.MetricType_GAUGE:
+				metric.Gauge = &dto.Gauge{Value: float64Ptr(float64(s) / 3)}
+			case dto.MetricType_HISTOGRAM:
+				metric.Histogram = createHistogram(defaultHistogramBuckets)
+			}
+			family.Metric = append(family.Metric, metric)
+		}
+		result[name] = family
+	}
+
+	return result
+}
+
+func syntheticSuffix(metricType dto.MetricType) string {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return "requests_total"
+	case dto.MetricType_GAUGE:
+		return "queue_depth"
+	default:
+		return "latency_seconds"
+	}
+}
+
+func runBench(output io.Writer, families, series, iterations int) error {
+	if families <= 0 || series <= 0 || iterations <= 0 {
+		return fmt.Errorf("families, series, and iterations must be positive")
+	}
+
+	var input bytes.Buffer
+	if err := writeMetrics(generateSyntheticFamilies(families, series), &input); err != nil {
+		return fmt.Errorf("failed to generate synthetic input: %w", err)
+	}
+	data := input.Bytes()
+	totalSeries := families * series
+
+	fmt.Fprintf(output, "Synthetic file: %d families x %d series (%d bytes)\n", families, series, len(data))
+
+	var parseTime, applyTime, writeTime time.Duration
+	for i := 0; i < iterations; i++ {
+		start := time.Now()
+		parsed, err := parseMetrics(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse synthetic input: %w", err)
+		}
+		parseTime += time.Since(start)
+
+		start = time.Now()
+		labels := map[string]string{"instance": "host-0", "job": "bench"}
+		for name, family := range parsed {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				err = incrementCounter(parsed, name, labels, 1)
+			case dto.MetricType_GAUGE:
+				err = setGauge(parsed, name, labels, 42)
+			case dto.MetricType_HISTOGRAM:
+				err = observeHistogram(parsed, name, labels, 0.2)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to apply operation: %w", err)
+			}
+		}
+		applyTime += time.Since(start)
+
+		start = time.Now()
+		if err := writeMetrics(parsed, io.Discard); err != nil {
+			return fmt.Errorf("failed to write metrics: %w", err)
+		}
+		writeTime += time.Since(start)
+	}
+
+	report := func(phase string, total time.Duration, units int, unit string) {
+		avg := total / time.Duration(iterations)
+		seconds := avg.Seconds()
+		if seconds == 0 {
+			seconds = 1e-9
+		}
+		fmt.Fprintf(output, "%-6s %12v/op %14.0f %s/s\n", phase, avg, float64(units)/seconds, unit)
+	}
+	report("parse", parseTime, totalSeries, "series")
+	report("apply", applyTime, families, "ops")
+	report("write", writeTime, totalSeries, "series")
+
+	return nil
+}
+
+// startProfiling starts CPU profiling if requested. The returned function
+// stops it and writes a heap profile if requested.
+func startProfiling(cpuProfile, memProfile string) (func() error, error) {
+	var cpuFile *os.File
+	if cpuProfile != "" {
+		var err error
+		cpuFile, err = os.Create(cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+	}
+
+	return func() error {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+		}
+
+		if memProfile != "" {
+			memFile, err := os.Create(memProfile)
+			if err != nil {
+				return fmt.Errorf("failed to create memory profile: %w", err)
+			}
+			defer memFile.Close()
+
+			runtime.GC() // Get up-to-date statistics
+			if err := pprof.WriteHeapProfile(memFile); err != nil {
+				return fmt.Errorf("failed to write memory profile: %w", err)
+			}
+		}
+		return nil
+	}, nil
+}
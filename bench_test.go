@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSyntheticFamilies(t *testing.T) {
+	families := generateSyntheticFamilies(6, 4)
+
+	require.Len(t, families, 6)
+	counts := make(map[dto.MetricType]int)
+	for _, family := range families {
+		counts[family.GetType()]++
+		assert.Len(t, family.Metric, 4)
+	}
+	assert.Equal(t, map[dto.MetricType]int{
+		dto.MetricType_COUNTER:   2,
+		dto.MetricType_GAUGE:     2,
+		dto.MetricType_HISTOGRAM: 2,
+	}, counts)
+}
+
+func TestRunBench(t *testing.T) {
+	var output bytes.Buffer
+	require.NoError(t, runBench(&output, 3, 5, 1))
+
+	assert.Contains(t, output.String(), "3 families x 5 series")
+	assert.Contains(t, output.String(), "parse")
+	assert.Contains(t, output.String(), "apply")
+	assert.Contains(t, output.String(), "write")
+
+	assert.Error(t, runBench(&output, 0, 5, 1))
+}
+
+func TestProfilingFlags(t *testing.T) {
+	dir := t.TempDir()
+	cpuProfile := filepath.Join(dir, "cpu.prof")
+	memProfile := filepath.Join(dir, "mem.prof")
+
+	captureOutput(t, func() {
+		err := createTestApp().Run([]string{"omet", "--cpuprofile", cpuProfile, "--memprofile", memProfile, "bench", "--families", "3", "--series", "2", "--iterations", "1"})
+		require.NoError(t, err)
+	})
+
+	for _, profile := range []string{cpuProfile, memProfile} {
+		info, err := os.Stat(profile)
+		require.NoError(t, err)
+		assert.NotZero(t, info.Size())
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	var input bytes.Buffer
+	require.NoError(b, writeMetrics(generateSyntheticFamilies(100, 100), &input))
+	data := input.Bytes()
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseMetrics(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	families := generateSyntheticFamilies(100, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var output bytes.Buffer
+		if err := writeMetrics(families, &output); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"omet/internal/schema"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// bucketCounts is pre-aggregated histogram data for observe-buckets:
+// cumulative counts by upper bound, the +Inf one being the total count.
+type bucketCounts struct {
+	bounds []float64 // ascending, ending with +Inf
+	counts []uint64
+	sum    float64
+}
+
+// parseBucketCounts parses "0.1:42,0.5:90,+Inf:100,sum:37.2". Counts are
+// cumulative, as in the exposition format, and +Inf is required since it
+// holds the total. sum defaults to 0.
+func parseBucketCounts(spec string) (*bucketCounts, error) {
+	b := &bucketCounts{}
+	counts := make(map[float64]uint64)
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid bucket %q: expected BOUND:COUNT or sum:VALUE", part)
+		}
+		if key == "sum" {
+			sum, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sum %q", value)
+			}
+			b.sum = sum
+			continue
+		}
+		bound, err := strconv.ParseFloat(key, 64)
+		if err != nil || math.IsNaN(bound) {
+			return nil, fmt.Errorf("invalid bucket bound %q", key)
+		}
+		count, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid count %q for bucket %s: expected a non-negative integer", value, key)
+		}
+		if _, dup := counts[bound]; dup {
+			return nil, fmt.Errorf("bucket %s given twice", key)
+		}
+		counts[bound] = count
+		b.bounds = append(b.bounds, bound)
+	}
+
+	sort.Float64s(b.bounds)
+	if len(b.bounds) == 0 || !math.IsInf(b.bounds[len(b.bounds)-1], 1) {
+		return nil, fmt.Errorf("missing +Inf bucket, which holds the total count")
+	}
+	for i, bound := range b.bounds {
+		b.counts = append(b.counts, counts[bound])
+		if i > 0 && b.counts[i] < b.counts[i-1] {
+			return nil, fmt.Errorf("bucket counts must be cumulative: le=%g has %d, fewer than the %d below it", bound, b.counts[i], b.counts[i-1])
+		}
+	}
+	return b, nil
+}
+
+// histogramFamily returns the histogram family an operation updates,
+// creating it as typeName (histogram or gaugehistogram) if given, or as a
+// histogram otherwise.
+func histogramFamily(families map[string]*dto.MetricFamily, name, typeName, operation string) (*dto.MetricFamily, error) {
+	metricType := dto.MetricType_HISTOGRAM
+	if family, exists := families[name]; exists {
+		metricType = family.GetType()
+	}
+	if typeName != "" {
+		var err error
+		if metricType, err = schema.ParseType(typeName); err != nil {
+			return nil, err
+		}
+		if metricType != dto.MetricType_HISTOGRAM && metricType != dto.MetricType_GAUGE_HISTOGRAM {
+			return nil, fmt.Errorf("%s can't create a %s", operation, typeName)
+		}
+	}
+	return getOrCreateFamily(families, name, metricType)
+}
+
+// observeBuckets adds pre-aggregated observations to a histogram series. A
+// new series takes its bucket layout from b; an existing one must have the
+// same bounds, since counts can't be split between differing buckets. A
+// gauge histogram is a snapshot, so its series is replaced by b instead. New
+// families are histograms unless typeName says gaugehistogram.
+func observeBuckets(families map[string]*dto.MetricFamily, name, typeName string, labels map[string]string, b *bucketCounts) error {
+	if b == nil {
+		return fmt.Errorf("observe-buckets requires bucket counts")
+	}
+	family, err := histogramFamily(families, name, typeName, "observe-buckets")
+	if err != nil {
+		return err
+	}
+
+	metric := findOrCreateMetric(family, labels)
+	if metric.Histogram == nil || family.GetType() == dto.MetricType_GAUGE_HISTOGRAM {
+		metric.Histogram = createHistogram(b.bounds[:len(b.bounds)-1])
+	}
+	histogram := metric.Histogram
+
+	// The +Inf bucket may be implicit in the existing series
+	buckets := histogram.Bucket
+	if n := len(buckets); n == 0 || !math.IsInf(buckets[n-1].GetUpperBound(), 1) {
+		buckets = append(buckets, &dto.Bucket{UpperBound: float64Ptr(math.Inf(1)), CumulativeCount: uint64Ptr(histogram.GetSampleCount())})
+	}
+	if len(buckets) != len(b.bounds) {
+		return fmt.Errorf("bucket layout of %s differs: it has %d buckets, %d given", formatSeries(name, labels), len(buckets), len(b.bounds))
+	}
+	for i, bucket := range buckets {
+		if bucket.GetUpperBound() != b.bounds[i] {
+			return fmt.Errorf("bucket layout of %s differs: it has le=%g where le=%g was given", formatSeries(name, labels), bucket.GetUpperBound(), b.bounds[i])
+		}
+	}
+
+	for i, bucket := range buckets {
+		bucket.CumulativeCount = uint64Ptr(bucket.GetCumulativeCount() + b.counts[i])
+	}
+	histogram.Bucket = buckets
+	histogram.SampleCount = uint64Ptr(histogram.GetSampleCount() + b.counts[len(b.counts)-1])
+	histogram.SampleSum = float64Ptr(histogram.GetSampleSum() + b.sum)
+	return nil
+}
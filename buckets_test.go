@@ -0,0 +1,143 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBucketCounts(t *testing.T) {
+	b, err := parseBucketCounts("0.5:90, +Inf:100,0.1:42,sum:37.2")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 0.5, math.Inf(1)}, b.bounds)
+	assert.Equal(t, []uint64{42, 90, 100}, b.counts)
+	assert.Equal(t, 37.2, b.sum)
+
+	for _, spec := range []string{
+		"",
+		"0.1:42,0.5:90",
+		"0.1:42,+Inf:10",
+		"0.1:-1,+Inf:10",
+		"0.1:1.5,+Inf:10",
+		"x:1,+Inf:10",
+		"0.1:1,0.1:2,+Inf:10",
+		"0.1,+Inf:10",
+		"+Inf:10,sum:lots",
+	} {
+		_, err := parseBucketCounts(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestObserveBuckets(t *testing.T) {
+	t.Run("creates and merges into a histogram", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		for i := 0; i < 2; i++ {
+			err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "vhost=www", "request_seconds", "observe-buckets", "0.1:42,0.5:90,+Inf:100,sum:37.2"})
+			require.NoError(t, err)
+		}
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `request_seconds_bucket{vhost="www",le="0.1"} 84`)
+		assert.Contains(t, string(content), `request_seconds_bucket{vhost="www",le="0.5"} 180`)
+		assert.Contains(t, string(content), `request_seconds_bucket{vhost="www",le="+Inf"} 200`)
+		assert.Contains(t, string(content), `request_seconds_sum{vhost="www"} 74.4`)
+		assert.Contains(t, string(content), `request_seconds_count{vhost="www"} 200`)
+	})
+
+	t.Run("refuses a different bucket layout", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "request_seconds", "observe", "0.2"}))
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "request_seconds", "observe-buckets", "0.1:1,+Inf:2"})
+		assert.ErrorContains(t, err, "bucket layout of request_seconds differs")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "request_seconds_count 1")
+	})
+
+	t.Run("works in line protocol", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		defer mockStdin(t, "request_seconds observe-buckets 1:3,+Inf:4,sum:5\n")()
+
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile}))
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `request_seconds_bucket{le="1"} 3`)
+		assert.Contains(t, string(content), "request_seconds_count 4")
+	})
+
+	t.Run("replaces a gauge histogram snapshot", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "gaugehistogram", "queue_age_seconds", "observe-buckets", "1:5,10:8,+Inf:9,sum:30"}))
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "queue_age_seconds", "observe-buckets", "1:2,+Inf:3,sum:4"}))
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "# TYPE queue_age_seconds gaugehistogram")
+		assert.Contains(t, string(content), `queue_age_seconds_bucket{le="1"} 2`)
+		assert.NotContains(t, string(content), `queue_age_seconds_bucket{le="10"}`)
+		assert.Contains(t, string(content), "queue_age_seconds_gsum 4")
+		assert.Contains(t, string(content), "queue_age_seconds_gcount 3")
+	})
+
+	t.Run("observes into a gauge histogram", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE queue_age_seconds gaugehistogram\nqueue_age_seconds_bucket{le=\"1\"} 1\nqueue_age_seconds_bucket{le=\"+Inf\"} 1\nqueue_age_seconds_gsum 0.5\nqueue_age_seconds_gcount 1\n")
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "queue_age_seconds", "observe", "2"}))
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `queue_age_seconds_bucket{le="+Inf"} 2`)
+		assert.Contains(t, string(content), "queue_age_seconds_gcount 2")
+	})
+
+	t.Run("observe creates the family as --type", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "gaugehistogram", "queue_age_seconds", "observe", "3"}))
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "# HELP queue_age_seconds Gauge histogram metric queue_age_seconds")
+		assert.Contains(t, string(content), "# TYPE queue_age_seconds gaugehistogram")
+		assert.Contains(t, string(content), "queue_age_seconds_gcount 1")
+
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "counter", "request_seconds", "observe", "3"})
+		assert.ErrorContains(t, err, "observe can't create a counter")
+	})
+
+	t.Run("refuses gauge histograms in .prom files", func(t *testing.T) {
+		testFile := filepath.Join(t.TempDir(), "queue.prom")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "gaugehistogram", "queue_age_seconds", "observe-buckets", "+Inf:1"})
+		assert.ErrorContains(t, err, "textfile collector rejects gaugehistogram")
+		assert.NoFileExists(t, testFile)
+
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "gaugehistogram", "queue_age_seconds", "observe", "3"})
+		assert.ErrorContains(t, err, "textfile collector rejects gaugehistogram")
+		assert.NoFileExists(t, testFile)
+	})
+
+	t.Run("--type is refused by operations that ignore it", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "gaugehistogram", "queue_age_seconds", "set", "3"})
+		assert.ErrorContains(t, err, "--type only applies to ensure, observe, and observe-buckets, not set")
+	})
+
+	t.Run("refuses to create other types", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "counter", "request_seconds", "observe-buckets", "+Inf:1"})
+		assert.ErrorContains(t, err, "observe-buckets can't create a counter")
+	})
+
+	t.Run("requires bucket counts", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "request_seconds", "observe-buckets"})
+		assert.ErrorContains(t, err, "observe-buckets requires bucket counts")
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// addClockSkewMetrics compares the previous write's omet_last_write, stamped
+// by the writing host's clock, with the file's mtime, stamped by the
+// filesystem (the server's clock on NFS), and with this host's clock. Skew
+// between them is a common cause of false "too old" healthcheck failures.
+//
+//	omet_clock_skew_seconds{against="mtime"}  mtime - omet_last_write
+//	omet_clock_skew_seconds{against="now"}    how far omet_last_write is in the future
+//
+// Under a second of mtime skew is normal, since omet_last_write is truncated
+// to whole seconds. Nothing is recorded without a previous write or under
+// --now, whose clock the filesystem doesn't share.
+func addClockSkewMetrics(families map[string]*dto.MetricFamily, modTime, now time.Time) {
+	if _, real := timeProvider.(RealTimeProvider); !real || modTime.IsZero() {
+		return
+	}
+	lastWrite, ok := seriesValue(families, "omet_last_write", map[string]string{})
+	if !ok {
+		return
+	}
+	written := time.Unix(int64(lastWrite), 0)
+
+	family, err := getOrCreateFamily(families, "omet_clock_skew_seconds", dto.MetricType_GAUGE)
+	if err != nil {
+		return
+	}
+	family.Help = stringPtr("Skew between omet_last_write of the previous write and the file's mtime or the current time")
+	skew := map[string]float64{
+		"mtime": modTime.Sub(written).Seconds(),
+		"now":   math.Max(0, written.Sub(now).Seconds()),
+	}
+	for against, seconds := range skew {
+		metric := findOrCreateMetric(family, map[string]string{"against": against})
+		metric.Gauge = &dto.Gauge{Value: float64Ptr(seconds)}
+	}
+}
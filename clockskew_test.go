@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockSkewMetrics(t *testing.T) {
+	t.Run("compares omet_last_write with the file's mtime", func(t *testing.T) {
+		now := time.Now()
+		testFile := createTempFile(t, fmt.Sprintf("omet_last_write %d\n", now.Unix()-120))
+		require.NoError(t, os.Chtimes(testFile, now, now.Add(-60*time.Second)))
+
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "jobs_total", "inc"}))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		skew, ok := seriesValue(families, "omet_clock_skew_seconds", map[string]string{"against": "mtime"})
+		require.True(t, ok)
+		assert.InDelta(t, 60, skew, 1.5)
+		skew, _ = seriesValue(families, "omet_clock_skew_seconds", map[string]string{"against": "now"})
+		assert.Equal(t, 0.0, skew)
+	})
+
+	t.Run("reports a last write in the future", func(t *testing.T) {
+		testFile := createTempFile(t, fmt.Sprintf("omet_last_write %d\n", time.Now().Unix()+300))
+
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "jobs_total", "inc"}))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		skew, _ := seriesValue(families, "omet_clock_skew_seconds", map[string]string{"against": "now"})
+		assert.InDelta(t, 300, skew, 1.5)
+	})
+
+	t.Run("skipped without a previous write or under --now", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "jobs_total", "inc"}))
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--now", "2024-01-01T00:00:00Z", "jobs_total", "inc"}))
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "omet_clock_skew_seconds")
+	})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+func compareCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "compare",
+		Usage:     "Fail when metrics in a current file drift from a baseline file",
+		ArgsUsage: "<baseline> <current>",
+		Description: `Compares every tracked series of the baseline file against the current file.
+A series fails when it is missing from the current file or when its value
+deviates from the baseline by more than the tolerance. OMET self-monitoring
+metrics (omet_*) are ignored unless named with --metric.
+
+Examples:
+  omet-healthcheck compare baseline.prom current.prom --tolerance 10%
+  omet-healthcheck compare --metric http_requests_total baseline.prom current.prom`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "tolerance",
+				Usage: "Maximum allowed relative deviation, as a percentage (10%) or fraction (0.1)",
+				Value: "0%",
+			},
+			&cli.StringSliceFlag{
+				Name:  "metric",
+				Usage: "Only compare these metric families (can be repeated)",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Output results in JSON format",
+			},
+			&cli.BoolFlag{
+				Name:  "verbose",
+				Usage: "Enable verbose output",
+			},
+		},
+		Action: runCompare,
+	}
+}
+
+func runCompare(ctx *cli.Context) error {
+	files, err := interspersedArgs(ctx)
+	if err != nil {
+		return err
+	}
+	if len(files) != 2 {
+		return fmt.Errorf("compare requires exactly two files: <baseline> <current>")
+	}
+	verbose := ctx.Bool("verbose")
+
+	tolerance, err := parseTolerance(ctx.String("tolerance"))
+	if err != nil {
+		return err
+	}
+
+	// Both files are parsed concurrently; large snapshots dominate the runtime
+	parsed, err := metricsfile.ParseFiles(files, 2, nil)
+	if err != nil {
+		return err
+	}
+	baseline, current := parsed[0], parsed[1]
+
+	result := HealthCheckResult{
+		Healthy: true,
+		Checks:  make(map[string]CheckResult),
+	}
+	compareFamilies(baseline, current, ctx.StringSlice("metric"), tolerance, &result, verbose)
+
+	if err := outputResult(&result, ctx.Bool("json"), verbose); err != nil {
+		return err
+	}
+
+	if !result.Healthy {
+		os.Exit(1) // Unhealthy
+	}
+	return nil
+}
+
+// interspersedArgs applies the flags among ctx's arguments and returns the
+// rest. urfave/cli stops parsing flags at the first argument, so flags
+// given after the files or target would otherwise be taken as more of them.
+// Everything after "--" is an argument.
+func interspersedArgs(ctx *cli.Context) ([]string, error) {
+	var args []string
+	rest := ctx.Args().Slice()
+	for i := 0; i < len(rest); i++ {
+		arg := rest[i]
+		if arg == "--" {
+			return append(args, rest[i+1:]...), nil
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			args = append(args, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		flag := lookupFlag(ctx.Command.Flags, name)
+		if flag == nil {
+			return nil, fmt.Errorf("flag provided but not defined: %s", arg)
+		}
+		if !hasValue {
+			if f, ok := flag.(cli.DocGenerationFlag); ok && !f.TakesValue() {
+				value = "true"
+			} else if i+1 < len(rest) {
+				i++
+				value = rest[i]
+			} else {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+		}
+		if err := ctx.Set(flag.Names()[0], value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for flag %s: %w", value, arg, err)
+		}
+	}
+	return args, nil
+}
+
+func lookupFlag(flags []cli.Flag, name string) cli.Flag {
+	for _, flag := range flags {
+		for _, n := range flag.Names() {
+			if n == name {
+				return flag
+			}
+		}
+	}
+	return nil
+}
+
+// parseTolerance accepts "10%" or a plain fraction like "0.1".
+func parseTolerance(input string) (float64, error) {
+	s := strings.TrimSpace(input)
+	percent := strings.HasSuffix(s, "%")
+	s = strings.TrimSuffix(s, "%")
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid tolerance: %s (expected e.g. 10%% or 0.1)", input)
+	}
+	if percent {
+		value /= 100
+	}
+	return value, nil
+}
+
+// comparableValues flattens a series into the values compared between files.
+// Histograms and summaries are compared by their sample count and sum.
+func comparableValues(family *dto.MetricFamily, metric *dto.Metric) map[string]float64 {
+	switch family.GetType() {
+	case dto.MetricType_HISTOGRAM:
+		return map[string]float64{
+			"_count": float64(metric.GetHistogram().GetSampleCount()),
+			"_sum":   metric.GetHistogram().GetSampleSum(),
+		}
+	case dto.MetricType_SUMMARY:
+		return map[string]float64{
+			"_count": float64(metric.GetSummary().GetSampleCount()),
+			"_sum":   metric.GetSummary().GetSampleSum(),
+		}
+	default:
+		return map[string]float64{"": metricValue(metric)}
+	}
+}
+
+// seriesKey renders a series as name{labels} with labels sorted by name.
+func seriesKey(name string, labels []*dto.LabelPair) string {
+	var parts []string
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+	}
+	sort.Strings(parts)
+	if len(parts) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(parts, ",") + "}"
+}
+
+func compareFamilies(baseline, current map[string]*dto.MetricFamily, metrics []string, tolerance float64, result *HealthCheckResult, verbose bool) {
+	tracked := metrics
+	if len(tracked) == 0 {
+		for name := range baseline {
+			if !strings.HasPrefix(name, "omet_") {
+				tracked = append(tracked, name)
+			}
+		}
+	}
+	sort.Strings(tracked)
+
+	for _, name := range tracked {
+		baseFamily, exists := baseline[name]
+		if !exists {
+			result.Healthy = false
+			result.Checks["compare:"+name] = CheckResult{
+				Passed:  false,
+				Message: fmt.Sprintf("Metric '%s' not found in baseline", name),
+			}
+			continue
+		}
+
+		// Index current series by key
+		currentValues := make(map[string]float64)
+		if currentFamily, ok := current[name]; ok {
+			for _, metric := range currentFamily.Metric {
+				key := seriesKey(name, metric.Label)
+				for suffix, value := range comparableValues(currentFamily, metric) {
+					currentValues[key+suffix] = value
+				}
+			}
+		}
+
+		for _, metric := range baseFamily.Metric {
+			key := seriesKey(name, metric.Label)
+			for suffix, baseValue := range comparableValues(baseFamily, metric) {
+				checkName := "compare:" + key + suffix
+				currentValue, found := currentValues[key+suffix]
+				if !found {
+					result.Healthy = false
+					result.Checks[checkName] = CheckResult{
+						Passed:  false,
+						Message: "Series missing from current file",
+					}
+					if verbose {
+						log.Printf("FAIL: %s missing from current file", key+suffix)
+					}
+					continue
+				}
+
+				deviation := relativeDeviation(baseValue, currentValue)
+				value := strconv.FormatFloat(currentValue, 'g', -1, 64)
+				if deviation > tolerance {
+					result.Healthy = false
+					result.Checks[checkName] = CheckResult{
+						Passed:  false,
+						Message: fmt.Sprintf("Deviation %.2f%% exceeds tolerance %.2f%% (baseline: %g, current: %g)", deviation*100, tolerance*100, baseValue, currentValue),
+						Value:   value,
+					}
+					if verbose {
+						log.Printf("FAIL: %s deviates %.2f%% (baseline: %g, current: %g)", key+suffix, deviation*100, baseValue, currentValue)
+					}
+				} else {
+					result.Checks[checkName] = CheckResult{
+						Passed:  true,
+						Message: fmt.Sprintf("Deviation %.2f%% within tolerance %.2f%%", deviation*100, tolerance*100),
+						Value:   value,
+					}
+					if verbose {
+						log.Printf("PASS: %s deviates %.2f%%", key+suffix, deviation*100)
+					}
+				}
+			}
+		}
+	}
+}
+
+// relativeDeviation returns |current-baseline| / |baseline|. A zero baseline
+// only tolerates a zero current value.
+func relativeDeviation(baseline, current float64) float64 {
+	if baseline == current {
+		return 0
+	}
+	if baseline == 0 {
+		return math.Inf(1)
+	}
+	return math.Abs(current-baseline) / math.Abs(baseline)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseTolerance(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    float64
+		expectError bool
+	}{
+		{input: "10%", expected: 0.1},
+		{input: "0.25", expected: 0.25},
+		{input: "0%", expected: 0},
+		{input: "-5%", expectError: true},
+		{input: "abc", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tolerance, err := parseTolerance(tt.input)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, tolerance, 1e-9)
+		})
+	}
+}
+
+func TestCompareFamilies(t *testing.T) {
+	baseline, err := parseMetrics(strings.NewReader(`# TYPE http_requests_total counter
+http_requests_total{code="200"} 100
+http_requests_total{code="500"} 10
+# TYPE queue_depth gauge
+queue_depth 50
+# TYPE omet_last_write gauge
+omet_last_write 1000
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		current       string
+		metrics       []string
+		tolerance     float64
+		expectHealthy bool
+		expectFailed  string
+	}{
+		{
+			name: "within tolerance passes",
+			current: `# TYPE http_requests_total counter
+http_requests_total{code="200"} 105
+http_requests_total{code="500"} 10
+# TYPE queue_depth gauge
+queue_depth 48
+# TYPE omet_last_write gauge
+omet_last_write 2000
+`,
+			tolerance:     0.1,
+			expectHealthy: true,
+		},
+		{
+			name: "deviation beyond tolerance fails",
+			current: `# TYPE http_requests_total counter
+http_requests_total{code="200"} 150
+http_requests_total{code="500"} 10
+# TYPE queue_depth gauge
+queue_depth 50
+`,
+			tolerance:     0.1,
+			expectHealthy: false,
+			expectFailed:  `compare:http_requests_total{code="200"}`,
+		},
+		{
+			name: "missing series fails",
+			current: `# TYPE http_requests_total counter
+http_requests_total{code="200"} 100
+# TYPE queue_depth gauge
+queue_depth 50
+`,
+			tolerance:     0.1,
+			expectHealthy: false,
+			expectFailed:  `compare:http_requests_total{code="500"}`,
+		},
+		{
+			name: "only selected metrics are compared",
+			current: `# TYPE queue_depth gauge
+queue_depth 50
+`,
+			metrics:       []string{"queue_depth"},
+			tolerance:     0,
+			expectHealthy: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, err := parseMetrics(strings.NewReader(tt.current))
+			require.NoError(t, err)
+
+			result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+			compareFamilies(baseline, current, tt.metrics, tt.tolerance, &result, false)
+
+			assert.Equal(t, tt.expectHealthy, result.Healthy)
+			if tt.expectFailed != "" {
+				check, exists := result.Checks[tt.expectFailed]
+				require.True(t, exists, "expected check %s in %v", tt.expectFailed, result.Checks)
+				assert.False(t, check.Passed)
+			}
+			for name := range result.Checks {
+				assert.NotContains(t, name, "omet_last_write", "self-monitoring metrics should be ignored")
+			}
+		})
+	}
+}
+
+func TestCompareFlagsAfterFiles(t *testing.T) {
+	dir := t.TempDir()
+	baseline, current := filepath.Join(dir, "baseline.prom"), filepath.Join(dir, "current.prom")
+	require.NoError(t, os.WriteFile(baseline, []byte("queue_depth 100\n"), 0644))
+	require.NoError(t, os.WriteFile(current, []byte("queue_depth 105\n"), 0644))
+
+	var files []string
+	var tolerance string
+	var metrics []string
+	app := &cli.App{Commands: []*cli.Command{{
+		Name:  "compare",
+		Flags: compareCommand().Flags,
+		Action: func(ctx *cli.Context) error {
+			var err error
+			files, err = interspersedArgs(ctx)
+			tolerance, metrics = ctx.String("tolerance"), ctx.StringSlice("metric")
+			return err
+		},
+	}}}
+	require.NoError(t, app.Run([]string{"omet-healthcheck", "compare", baseline, current, "--tolerance", "10%", "--metric=queue_depth", "--json"}))
+	assert.Equal(t, []string{baseline, current}, files)
+	assert.Equal(t, "10%", tolerance)
+	assert.Equal(t, []string{"queue_depth"}, metrics)
+
+	// The request's own syntax, end to end: within tolerance, so no exit
+	app = &cli.App{Commands: []*cli.Command{compareCommand()}}
+	require.NoError(t, app.Run([]string{"omet-healthcheck", "compare", baseline, current, "--tolerance", "10%"}))
+
+	err := app.Run([]string{"omet-healthcheck", "compare", baseline, current, "--bogus"})
+	assert.ErrorContains(t, err, "not defined: --bogus")
+	err = app.Run([]string{"omet-healthcheck", "compare", baseline, current, "--tolerance"})
+	assert.ErrorContains(t, err, "needs an argument")
+}
+
+func TestOutputResultJSON(t *testing.T) {
+	result := HealthCheckResult{
+		Healthy: false,
+		Checks: map[string]CheckResult{
+			`compare:queue_depth`: {Passed: false, Message: "queue_depth deviates by 50%"},
+		},
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	go func() {
+		defer w.Close()
+		assert.NoError(t, outputResult(&result, true, false))
+	}()
+	var output bytes.Buffer
+	output.ReadFrom(r)
+	os.Stdout = oldStdout
+
+	var decoded HealthCheckResult
+	require.NoError(t, json.Unmarshal(output.Bytes(), &decoded))
+	assert.Equal(t, result, decoded)
+	assert.Contains(t, output.String(), `"healthy": false`)
+}
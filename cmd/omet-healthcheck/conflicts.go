@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Problems listed in the check message before the rest are only counted
+const maxReportedConflicts = 5
+
+// checkSeriesConflicts fails when the file holds duplicate series or samples
+// that don't fit their family's TYPE, either of which makes Prometheus reject
+// the whole scrape.
+func checkSeriesConflicts(families map[string]*dto.MetricFamily, result *HealthCheckResult, verbose bool) {
+	problems := seriesConflicts(families)
+	if len(problems) == 0 {
+		result.Checks["series_conflicts"] = CheckResult{
+			Passed:  true,
+			Message: "No duplicate or conflicting series",
+			Value:   "0",
+		}
+		if verbose {
+			log.Printf("PASS: No duplicate or conflicting series")
+		}
+		return
+	}
+
+	reported := problems
+	if len(reported) > maxReportedConflicts {
+		reported = append(reported[:maxReportedConflicts:maxReportedConflicts], fmt.Sprintf("%d more", len(problems)-maxReportedConflicts))
+	}
+	result.Healthy = false
+	result.Checks["series_conflicts"] = CheckResult{
+		Passed:  false,
+		Message: strings.Join(reported, "; "),
+		Value:   fmt.Sprint(len(problems)),
+	}
+	if verbose {
+		for _, problem := range problems {
+			log.Printf("FAIL: %s", problem)
+		}
+	}
+}
+
+// seriesConflicts lists the problems found in families, sorted.
+func seriesConflicts(families map[string]*dto.MetricFamily) []string {
+	var problems []string
+	for name, family := range families {
+		seen := make(map[string]bool)
+		for _, metric := range family.Metric {
+			key := seriesKey(name, metric.Label)
+			if seen[key] {
+				problems = append(problems, fmt.Sprintf("duplicate series %s", key))
+			}
+			seen[key] = true
+
+			if family.GetType() == dto.MetricType_HISTOGRAM {
+				problems = append(problems, histogramConflicts(key, metric.GetHistogram())...)
+			}
+		}
+
+		// Samples the parser couldn't attach to a histogram or summary of
+		// the same base name end up in a family of their own
+		for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+			base, ok := strings.CutSuffix(name, suffix)
+			if !ok {
+				continue
+			}
+			if owner, ok := families[base]; ok && (owner.GetType() == dto.MetricType_HISTOGRAM || owner.GetType() == dto.MetricType_SUMMARY) {
+				problems = append(problems, fmt.Sprintf("%s samples don't fit %s's TYPE %s", name, base, strings.ToLower(owner.GetType().String())))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func histogramConflicts(key string, histogram *dto.Histogram) []string {
+	if len(histogram.GetBucket()) == 0 {
+		return []string{fmt.Sprintf("histogram %s has no _bucket samples", key)}
+	}
+	var problems []string
+	bounds := make(map[float64]bool)
+	for _, bucket := range histogram.GetBucket() {
+		if bounds[bucket.GetUpperBound()] {
+			problems = append(problems, fmt.Sprintf("duplicate bucket le=%q in %s", fmt.Sprint(bucket.GetUpperBound()), key))
+		}
+		bounds[bucket.GetUpperBound()] = true
+	}
+	return problems
+}
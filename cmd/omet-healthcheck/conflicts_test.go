@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSeriesConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		problems []string
+	}{
+		{
+			name:  "clean file passes",
+			input: "# TYPE jobs_total counter\njobs_total{job=\"a\"} 1\njobs_total{job=\"b\"} 2\n# TYPE latency histogram\nlatency_bucket{le=\"1\"} 1\nlatency_bucket{le=\"+Inf\"} 2\nlatency_sum 3\nlatency_count 2\n",
+		},
+		{
+			name:     "duplicate series",
+			input:    "jobs_total{job=\"a\",env=\"prod\"} 1\njobs_total{env=\"prod\",job=\"a\"} 2\n",
+			problems: []string{`duplicate series jobs_total{env="prod",job="a"}`},
+		},
+		{
+			name:     "histogram without buckets",
+			input:    "# TYPE latency histogram\nlatency_sum 3\nlatency_count 2\n",
+			problems: []string{"histogram latency has no _bucket samples"},
+		},
+		{
+			name:     "duplicate bucket",
+			input:    "# TYPE latency histogram\nlatency_bucket{le=\"1\"} 1\nlatency_bucket{le=\"1\"} 1\nlatency_count 1\n",
+			problems: []string{`duplicate bucket le="1" in latency`},
+		},
+		{
+			name:     "buckets on a summary",
+			input:    "# TYPE latency summary\nlatency_bucket{le=\"1\"} 1\nlatency_count 1\nlatency_sum 1\n",
+			problems: []string{"latency_bucket samples don't fit latency's TYPE summary"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families, err := parseMetrics(strings.NewReader(tt.input))
+			require.NoError(t, err)
+
+			result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+			checkSeriesConflicts(families, &result, false)
+
+			assert.Equal(t, tt.problems, seriesConflicts(families))
+			assert.Equal(t, len(tt.problems) == 0, result.Healthy)
+			assert.Equal(t, result.Healthy, result.Checks["series_conflicts"].Passed)
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// snapshotSuffix names the file, next to the checked file, where
+// --max-increase keeps the values it saw on earlier runs
+const snapshotSuffix = ".healthcheck"
+
+// IncreaseCheck describes a --max-increase assertion such as
+// "errors_total<100/5m": errors_total may grow by less than 100 in 5m.
+type IncreaseCheck struct {
+	Metric string
+	Limit  float64
+	Window time.Duration
+}
+
+func parseIncreaseCheck(spec string) (IncreaseCheck, error) {
+	var check IncreaseCheck
+
+	name, rest, ok := strings.Cut(spec, "<")
+	limitStr, windowStr, ok2 := strings.Cut(rest, "/")
+	if !ok || !ok2 || strings.TrimSpace(name) == "" {
+		return check, fmt.Errorf("invalid increase check: %s (expected NAME<LIMIT/WINDOW)", spec)
+	}
+	check.Metric = strings.TrimSpace(name)
+
+	limit, err := strconv.ParseFloat(strings.TrimSpace(limitStr), 64)
+	if err != nil {
+		return check, fmt.Errorf("invalid limit in %s: %w", spec, err)
+	}
+	check.Limit = limit
+
+	window, err := time.ParseDuration(strings.TrimSpace(windowStr))
+	if err != nil || window <= 0 {
+		return check, fmt.Errorf("invalid window in %s: must be a positive duration like 5m", spec)
+	}
+	check.Window = window
+
+	return check, nil
+}
+
+// increaseChecks parses every --max-increase flag.
+func increaseChecks(ctx *cli.Context) ([]IncreaseCheck, error) {
+	var checks []IncreaseCheck
+	for _, spec := range ctx.StringSlice("max-increase") {
+		check, err := parseIncreaseCheck(spec)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// snapshot is a value a --max-increase check saw on an earlier run.
+type snapshot struct {
+	Time   int64  // Unix seconds
+	Target string // file or URL checked
+	Series string // metric and selector
+	Value  float64
+}
+
+// snapshotPath is where the snapshots of target are kept: --snapshot-file,
+// or a file next to target. Stdin and URLs have nowhere to default to.
+func snapshotPath(ctx *cli.Context, target string) (string, error) {
+	if path := ctx.String("snapshot-file"); path != "" {
+		return path, nil
+	}
+	if target == "-" || isURL(target) {
+		return "", fmt.Errorf("--max-increase needs --snapshot-file when reading stdin or a URL")
+	}
+	return target + snapshotSuffix, nil
+}
+
+// readSnapshots reads a snapshot file, one "<unix>\t<target>\t<series>\t<value>"
+// line per snapshot. A missing file has no snapshots.
+func readSnapshots(path string) ([]snapshot, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var snapshots []snapshot
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: malformed snapshot", path, line)
+		}
+		unix, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid time: %w", path, line, err)
+		}
+		value, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid value: %w", path, line, err)
+		}
+		snapshots = append(snapshots, snapshot{Time: unix, Target: fields[1], Series: fields[2], Value: value})
+	}
+	return snapshots, scanner.Err()
+}
+
+// writeSnapshots replaces the snapshot file through a rename, so a
+// concurrent healthcheck never reads it half-written.
+func writeSnapshots(path string, snapshots []snapshot) error {
+	var b strings.Builder
+	for _, s := range snapshots {
+		fmt.Fprintf(&b, "%d\t%s\t%s\t%s\n", s.Time, s.Target, s.Series, strconv.FormatFloat(s.Value, 'g', -1, 64))
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// increaseBaseline picks the snapshot to measure an increase from: the
+// newest one at least window old, or failing that the oldest one, which
+// covers part of the window.
+func increaseBaseline(snapshots []snapshot, target, series string, now time.Time, window time.Duration) (snapshot, bool) {
+	cutoff := now.Add(-window).Unix()
+	var baseline snapshot
+	found := false
+	for _, s := range snapshots {
+		if s.Target != target || s.Series != series {
+			continue
+		}
+		switch {
+		case !found:
+			baseline, found = s, true
+		case s.Time <= cutoff:
+			if baseline.Time > cutoff || s.Time > baseline.Time {
+				baseline = s
+			}
+		case baseline.Time > cutoff && s.Time < baseline.Time:
+			baseline = s
+		}
+	}
+	return baseline, found
+}
+
+// pruneSnapshots drops the snapshots no future check can use: for each
+// series, those older than the newest one already outside its window.
+func pruneSnapshots(snapshots []snapshot, windows map[string]time.Duration, now time.Time) []snapshot {
+	type key struct{ target, series string }
+	keep := make(map[key]int64)
+	for _, s := range snapshots {
+		window, ok := windows[s.Series]
+		if !ok {
+			continue
+		}
+		k := key{s.Target, s.Series}
+		if s.Time <= now.Add(-window).Unix() && s.Time > keep[k] {
+			keep[k] = s.Time
+		}
+	}
+
+	kept := snapshots[:0]
+	for _, s := range snapshots {
+		if s.Time >= keep[key{s.Target, s.Series}] {
+			kept = append(kept, s)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Time < kept[j].Time })
+	return kept
+}
+
+// checkIncreases runs the --max-increase checks of target, then records the
+// values they saw in the snapshot file for the next run.
+func checkIncreases(families map[string]*dto.MetricFamily, checks []IncreaseCheck, selector Selector, target, path string, now time.Time, result *HealthCheckResult, verbose bool) error {
+	snapshots, err := readSnapshots(path)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshots: %w", err)
+	}
+
+	windows := make(map[string]time.Duration)
+	var seen []snapshot
+	for _, check := range checks {
+		series := check.Metric + selector.String()
+		if _, ok := windows[series]; !ok || check.Window > windows[series] {
+			windows[series] = check.Window
+		}
+		baseline, hasBaseline := increaseBaseline(snapshots, target, series, now, check.Window)
+		if value, ok := checkMaxIncrease(families, check, selector, baseline, hasBaseline, now, result, verbose); ok && !hasSnapshot(seen, series) {
+			seen = append(seen, snapshot{Time: now.Unix(), Target: target, Series: series, Value: value})
+		}
+	}
+	snapshots = append(snapshots, seen...)
+
+	if err := writeSnapshots(path, pruneSnapshots(snapshots, windows, now)); err != nil {
+		// The checks ran; only the next run loses its baseline
+		log.Printf("WARN: failed to save snapshots to %s: %v", path, err)
+	}
+	return nil
+}
+
+func hasSnapshot(snapshots []snapshot, series string) bool {
+	for _, s := range snapshots {
+		if s.Series == series {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMaxIncrease checks how much a counter or gauge grew since baseline,
+// returning its current value if it has one. A value below the baseline
+// means the counter was reset, so all of it counts as increase. Other
+// growth since a baseline older than the window is scaled to the window.
+func checkMaxIncrease(families map[string]*dto.MetricFamily, check IncreaseCheck, selector Selector, baseline snapshot, hasBaseline bool, now time.Time, result *HealthCheckResult, verbose bool) (float64, bool) {
+	checkName := fmt.Sprintf("max_increase:%s", check.Metric)
+
+	family, exists := families[check.Metric]
+	if !exists || len(family.Metric) == 0 {
+		result.Healthy = false
+		result.Checks[checkName] = CheckResult{
+			Passed:  false,
+			Message: fmt.Sprintf("Metric '%s' not found", check.Metric),
+		}
+		if verbose {
+			log.Printf("FAIL: Metric '%s' not found", check.Metric)
+		}
+		return 0, false
+	}
+
+	metric := selectMetric(family, selector)
+	if metric == nil {
+		result.Healthy = false
+		result.Checks[checkName] = CheckResult{
+			Passed:  false,
+			Message: fmt.Sprintf("Metric '%s' has no series matching %s", check.Metric, selector),
+		}
+		if verbose {
+			log.Printf("FAIL: Metric '%s' has no series matching %s", check.Metric, selector)
+		}
+		return 0, false
+	}
+	current := metricValue(metric)
+
+	if !hasBaseline {
+		result.Checks[checkName] = CheckResult{
+			Passed:  true,
+			Message: fmt.Sprintf("No earlier value of %s, recorded %g as the baseline", check.Metric, current),
+		}
+		if verbose {
+			log.Printf("PASS: No earlier value of %s, recorded %g as the baseline", check.Metric, current)
+		}
+		return current, true
+	}
+
+	increase := current - baseline.Value
+	since := now.Sub(time.Unix(baseline.Time, 0)).Round(time.Second)
+	over := since.String()
+	switch {
+	case current < baseline.Value:
+		increase = current
+	case since > check.Window:
+		// The baseline is older than the window, say because runs were
+		// missed; scale to the window rather than count all of since
+		increase = increase * float64(check.Window) / float64(since)
+		over = fmt.Sprintf("%v (scaled from %v)", check.Window, since)
+	}
+
+	if verbose {
+		log.Printf("DEBUG: %s went from %g to %g in %v, limit: %g per %v", check.Metric, baseline.Value, current, since, check.Limit, check.Window)
+	}
+
+	value := strconv.FormatFloat(increase, 'g', -1, 64)
+	if increase >= check.Limit {
+		result.Healthy = false
+		result.Checks[checkName] = CheckResult{
+			Passed:  false,
+			Message: fmt.Sprintf("%s increased by %g in %v (max: %g per %v)", check.Metric, increase, over, check.Limit, check.Window),
+			Value:   value,
+		}
+		if verbose {
+			log.Printf("FAIL: %s increased by %g in %v (max: %g per %v)", check.Metric, increase, over, check.Limit, check.Window)
+		}
+	} else {
+		result.Checks[checkName] = CheckResult{
+			Passed:  true,
+			Message: fmt.Sprintf("%s increase OK: %g in %v (max: %g per %v)", check.Metric, increase, over, check.Limit, check.Window),
+			Value:   value,
+		}
+		if verbose {
+			log.Printf("PASS: %s increase OK: %g in %v", check.Metric, increase, over)
+		}
+	}
+	return current, true
+}
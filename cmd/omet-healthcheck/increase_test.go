@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIncreaseCheck(t *testing.T) {
+	check, err := parseIncreaseCheck("errors_total<100/5m")
+	require.NoError(t, err)
+	assert.Equal(t, IncreaseCheck{Metric: "errors_total", Limit: 100, Window: 5 * time.Minute}, check)
+
+	for _, spec := range []string{"errors_total", "errors_total<100", "<100/5m", "errors_total<lots/5m", "errors_total<100/soon", "errors_total<100/0s"} {
+		_, err := parseIncreaseCheck(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCheckIncreases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.prom.healthcheck")
+	checks := []IncreaseCheck{{Metric: "errors_total", Limit: 100, Window: 5 * time.Minute}}
+	start := time.Unix(1700000000, 0)
+
+	run := func(value float64, now time.Time) CheckResult {
+		result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+		require.NoError(t, checkIncreases(createTestCounterFamily("errors_total", value), checks, nil, "metrics.prom", path, now, &result, false))
+		check := result.Checks["max_increase:errors_total"]
+		assert.Equal(t, result.Healthy, check.Passed)
+		return check
+	}
+
+	// The first run only records a baseline
+	check := run(1000, start)
+	assert.True(t, check.Passed)
+	assert.Contains(t, check.Message, "baseline")
+
+	// Within the window, the increase is measured from the oldest snapshot
+	check = run(1050, start.Add(time.Minute))
+	assert.True(t, check.Passed)
+	assert.Equal(t, "50", check.Value)
+
+	check = run(1100, start.Add(2*time.Minute))
+	assert.False(t, check.Passed)
+	assert.Equal(t, "100", check.Value)
+
+	// Once the window has passed, from the newest snapshot at least a window old
+	check = run(1150, start.Add(7*time.Minute))
+	assert.True(t, check.Passed)
+	assert.Equal(t, "50", check.Value)
+
+	// A reset counter counts in full
+	check = run(30, start.Add(8*time.Minute))
+	assert.True(t, check.Passed)
+	assert.Equal(t, "30", check.Value)
+
+	// Snapshots no longer needed are pruned
+	snapshots, err := readSnapshots(path)
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 3)
+	assert.Equal(t, start.Add(2*time.Minute).Unix(), snapshots[0].Time)
+	assert.Equal(t, "errors_total{}", snapshots[0].Series)
+
+	// After a gap, growth since the older baseline is scaled to the window
+	check = run(150, start.Add(20*time.Minute))
+	assert.True(t, check.Passed)
+	assert.Equal(t, "50", check.Value)
+	assert.Contains(t, check.Message, "scaled from 12m0s")
+}
+
+func TestCheckIncreasesMissingMetric(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshots")
+	checks := []IncreaseCheck{{Metric: "errors_total", Limit: 100, Window: time.Minute}}
+	result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+
+	require.NoError(t, checkIncreases(createTestCounterFamily("other_total", 1), checks, nil, "-", path, time.Now(), &result, false))
+	assert.False(t, result.Healthy)
+	assert.Contains(t, result.Checks["max_increase:errors_total"].Message, "not found")
+
+	snapshots, err := readSnapshots(path)
+	require.NoError(t, err)
+	assert.Empty(t, snapshots)
+}
+
+func TestReadSnapshotsRejectsMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshots")
+	require.NoError(t, os.WriteFile(path, []byte("1700000000\tmetrics.prom\n"), 0644))
+	_, err := readSnapshots(path)
+	assert.ErrorContains(t, err, "malformed snapshot")
+}
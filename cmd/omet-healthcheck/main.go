@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"omet/internal/metricsfile"
+
 	dto "github.com/prometheus/client_model/go"
-	"github.com/prometheus/common/expfmt"
 	"github.com/urfave/cli/v2"
 )
 
@@ -28,9 +35,36 @@ Examples:
   # Check if specific metric exists
   omet-healthcheck -f /shared/metrics.prom --metric-exists=omet_last_write
   
+  # Check the age of a specific labeled series
+  omet-healthcheck -f /shared/metrics.prom --age-metric=backup_last_success --selector 'job="backup"' --max-age=26h
+
+  # Check that the estimated p99 of a histogram stays below 0.5
+  omet-healthcheck -f /shared/metrics.prom --histogram-quantile 'request_duration_seconds:0.99<0.5'
+
+  # Fail when errors_total grows by 100 or more within 5 minutes
+  omet-healthcheck -f /shared/metrics.prom --max-increase 'errors_total<100/5m'
+
   # Multiple checks (all must pass)
   omet-healthcheck -f /shared/metrics.prom --max-age=300s --max-consecutive-errors=5
 
+  # Read under a shared lock to avoid racing an in-progress omet write
+  omet-healthcheck -f /shared/metrics.prom --lock --lock-timeout=5s --max-age=300s
+
+  # Check that node_exporter can read the file and nobody else can write it
+  omet-healthcheck -f /var/lib/node_exporter/app.prom --scrape-user node_exporter --expected-dir /var/lib/node_exporter
+
+  # Check every file, tolerating a fifth of them mid-update during a rollout
+  omet-healthcheck -f '/var/lib/node_exporter/*.prom' --max-age=300s --min-pass-ratio=80%
+
+  # Record each check's result as metrics Prometheus can alert on
+  omet-healthcheck -f /shared/metrics.prom --max-age=300s --record-to /shared/healthcheck.prom
+
+  # Run the same checks against a live exporter
+  omet-healthcheck --metric-exists=node_load1 https://host:9100/metrics
+
+  # Fail when metrics drift more than 10% from a baseline file
+  omet-healthcheck compare baseline.prom current.prom --tolerance 10%
+
 Exit codes:
   0 = healthy (all checks passed)
   1 = unhealthy (one or more checks failed)
@@ -40,7 +74,7 @@ Exit codes:
 			&cli.StringFlag{
 				Name:    "file",
 				Aliases: []string{"f"},
-				Usage:   "Input metrics file (default: stdin)",
+				Usage:   "Input metrics file, a quoted glob pattern checking each matching file, or an http(s):// endpoint to scrape (default: stdin)",
 				Value:   "-",
 			},
 			&cli.DurationFlag{
@@ -56,11 +90,99 @@ Exit codes:
 				Name:  "metric-exists",
 				Usage: "Check that specified metric exists",
 			},
+			&cli.StringFlag{
+				Name:  "age-metric",
+				Usage: "Gauge holding the Unix timestamp checked by --max-age",
+				Value: "omet_last_write",
+			},
+			&cli.StringFlag{
+				Name:  "selector",
+				Usage: "Apply checks to the series matching this label selector (e.g. 'job=\"backup\"')",
+			},
+			&cli.StringSliceFlag{
+				Name:  "histogram-quantile",
+				Usage: "Check estimated histogram quantile against a bound, as NAME:QUANTILE<BOUND (can be repeated)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "max-increase",
+				Usage: "Check a metric grew by less than LIMIT since the previous run at least WINDOW ago, as NAME<LIMIT/WINDOW (can be repeated)",
+			},
+			&cli.StringFlag{
+				Name:  "snapshot-file",
+				Usage: "Where --max-increase keeps values between runs (default: the metrics file plus .healthcheck)",
+			},
+			&cli.BoolFlag{
+				Name:  "check-conflicts",
+				Usage: "Check for duplicate series and samples that conflict with their family's TYPE (always part of the basic check)",
+			},
+			&cli.BoolFlag{
+				Name:  "check-permissions",
+				Usage: "Check that the file isn't world-writable (implied by --scrape-user and --expected-dir)",
+			},
+			&cli.StringFlag{
+				Name:  "scrape-user",
+				Usage: "Check that this user (e.g. node_exporter) can read the file",
+			},
+			&cli.StringFlag{
+				Name:  "expected-dir",
+				Usage: "Check that the file is in this directory (e.g. the textfile collector directory)",
+			},
+			&cli.StringFlag{
+				Name:  "min-pass-ratio",
+				Usage: "Stay healthy while at least this share of checks pass (e.g. 80% or 0.8)",
+			},
+			&cli.StringFlag{
+				Name:  "record-to",
+				Usage: "Also write check results as omet_healthcheck_* metrics to this file",
+			},
+			&cli.StringFlag{
+				Name:  "encrypt-key-file",
+				Usage: "Decrypt the metrics file with the AES-256 key in this file, as written by omet --encrypt-key-file",
+			},
+			&cli.BoolFlag{
+				Name:  "lock",
+				Usage: "Take a shared lock while reading so omet writes can't be observed half-done",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for file locks",
+			},
+			&cli.DurationFlag{
+				Name:  "scrape-timeout",
+				Value: 10 * time.Second,
+				Usage: "How long to wait for an http(s):// endpoint to respond",
+			},
+			&cli.StringFlag{
+				Name:  "ca-file",
+				Usage: "Also trust the CA certificates in this PEM file when scraping https:// endpoints",
+			},
+			&cli.StringFlag{
+				Name:  "cert-file",
+				Usage: "Client certificate (PEM) to present to https:// endpoints, with --key-file",
+			},
+			&cli.StringFlag{
+				Name:  "key-file",
+				Usage: "Private key (PEM) of --cert-file",
+			},
+			&cli.BoolFlag{
+				Name:  "insecure-skip-verify",
+				Usage: "Don't verify the certificate of https:// endpoints",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Output results in JSON format",
+			},
 			&cli.BoolFlag{
 				Name:  "verbose",
 				Usage: "Enable verbose output",
 			},
 		},
+		ArgsUsage: "[URL]",
+
+		Commands: []*cli.Command{
+			compareCommand(),
+		},
 
 		Action:    checkHealth,
 	}
@@ -72,44 +194,68 @@ Exit codes:
 }
 
 type HealthCheckResult struct {
-	Healthy              bool
-	Checks               map[string]CheckResult
-	Error                string
-	LastWriteTimestamp   *int64
-	ConsecutiveErrors    *float64
-	MetricsFound         []string
+	Healthy              bool                   `json:"healthy"`
+	Checks               map[string]CheckResult `json:"checks"`
+	Error                string                 `json:"error,omitempty"`
+	LastWriteTimestamp   *int64                 `json:"last_write_timestamp,omitempty"`
+	ConsecutiveErrors    *float64               `json:"consecutive_errors,omitempty"`
+	MetricsFound         []string               `json:"metrics_found,omitempty"`
 }
 
 type CheckResult struct {
-	Passed  bool
-	Message string
-	Value   string
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+	Value   string `json:"value,omitempty"`
 }
 
 func checkHealth(ctx *cli.Context) error {
+	args, err := interspersedArgs(ctx)
+	if err != nil {
+		return err
+	}
 	filename := ctx.String("file")
+	if len(args) > 0 {
+		if ctx.IsSet("file") || len(args) > 1 {
+			return fmt.Errorf("give one target, either -f or a URL")
+		}
+		filename = args[0]
+	}
 	verbose := ctx.Bool("verbose")
 
 	if verbose {
 		log.Printf("Checking health of metrics file: %s", filename)
 	}
 
-	// Parse metrics file
-	var families map[string]*dto.MetricFamily
-	var err error
-	
-	if filename == "-" {
-		families, err = parseMetrics(os.Stdin)
-	} else {
-		families, err = parseMetricsFile(filename)
+	var quantileChecks []QuantileCheck
+	for _, spec := range ctx.StringSlice("histogram-quantile") {
+		check, err := parseQuantileCheck(spec)
+		if err != nil {
+			return err
+		}
+		quantileChecks = append(quantileChecks, check)
 	}
-	
+	if _, err := increaseChecks(ctx); err != nil {
+		return err
+	}
+
+	var key []byte
+	if path := ctx.String("encrypt-key-file"); path != "" {
+		var err error
+		if key, err = metricsfile.LoadKey(path); err != nil {
+			return fmt.Errorf("failed to load --encrypt-key-file: %w", err)
+		}
+	}
+
+	selector, err := parseSelector(ctx.String("selector"))
 	if err != nil {
-		return fmt.Errorf("failed to parse metrics file: %w", err)
+		return err
 	}
 
-	if verbose {
-		log.Printf("Parsed %d metric families", len(families))
+	minPassRatio := 1.0
+	if ctx.IsSet("min-pass-ratio") {
+		if minPassRatio, err = parseTolerance(ctx.String("min-pass-ratio")); err != nil || minPassRatio > 1 {
+			return fmt.Errorf("invalid --min-pass-ratio: %s (expected e.g. 80%% or 0.8)", ctx.String("min-pass-ratio"))
+		}
 	}
 
 	// Perform health checks
@@ -118,72 +264,220 @@ func checkHealth(ctx *cli.Context) error {
 		Checks:  make(map[string]CheckResult),
 	}
 
+	if isURL(filename) {
+		families, err := scrapeTarget(ctx, filename, verbose)
+		if err != nil {
+			return fmt.Errorf("failed to scrape %s: %w", filename, err)
+		}
+		if err := runChecks(ctx, filename, families, quantileChecks, selector, &result, verbose); err != nil {
+			return err
+		}
+	} else if isGlob(filename) {
+		if err := checkGlob(ctx, filename, key, quantileChecks, selector, &result, verbose); err != nil {
+			return err
+		}
+	} else {
+		// Parse metrics file
+		var families map[string]*dto.MetricFamily
+
+		if filename == "-" {
+			families, err = parseStdin(key)
+		} else {
+			families, err = readMetricsFile(filename, checkedFamilies(ctx, quantileChecks), ctx.Bool("lock"), ctx.Duration("lock-timeout"), key, verbose)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to parse metrics file: %w", err)
+		}
+
+		if verbose {
+			log.Printf("Parsed %d metric families", len(families))
+		}
+
+		if err := runChecks(ctx, filename, families, quantileChecks, selector, &result, verbose); err != nil {
+			return err
+		}
+	}
+
+	if ctx.IsSet("min-pass-ratio") {
+		applyMinPassRatio(&result, minPassRatio, verbose)
+	}
+
+	// Output results
+	if err := outputResult(&result, ctx.Bool("json"), verbose); err != nil {
+		return err
+	}
+
+	if recordTo := ctx.String("record-to"); recordTo != "" {
+		if err := recordResults(recordTo, &result, ctx.Duration("lock-timeout"), time.Now()); err != nil {
+			// Don't let a recording failure mask the health result
+			log.Printf("WARN: failed to record results to %s: %v", recordTo, err)
+		} else if verbose {
+			log.Printf("Recorded results to %s", recordTo)
+		}
+	}
+
+	// Exit with appropriate code
+	if !result.Healthy {
+		os.Exit(1) // Unhealthy
+	}
+
+	return nil // Healthy
+}
+
+// runChecks runs the requested checks against the families of one file.
+func runChecks(ctx *cli.Context, filename string, families map[string]*dto.MetricFamily, quantileChecks []QuantileCheck, selector Selector, result *HealthCheckResult, verbose bool) error {
 	// Check 1: Max age (if specified)
 	if ctx.IsSet("max-age") {
 		maxAge := ctx.Duration("max-age")
-		checkMaxAge(families, maxAge, &result, verbose)
+		checkMaxAge(families, ctx.String("age-metric"), selector, maxAge, result, verbose)
 	}
 
 	// Check 2: Max consecutive errors (if specified)
 	if ctx.IsSet("max-consecutive-errors") {
 		maxErrors := ctx.Int("max-consecutive-errors")
 		if maxErrors >= 0 {
-			checkConsecutiveErrors(families, maxErrors, &result, verbose)
+			checkConsecutiveErrors(families, maxErrors, result, verbose)
 		}
 	}
 
 	// Check 3: Metric exists (if specified)
 	if ctx.IsSet("metric-exists") {
 		metricName := ctx.String("metric-exists")
-		checkMetricExists(families, metricName, &result, verbose)
+		checkMetricExists(families, metricName, selector, result, verbose)
 	}
 
-	// If no specific checks were requested, do basic health check
-	if !ctx.IsSet("max-age") && !ctx.IsSet("max-consecutive-errors") && !ctx.IsSet("metric-exists") {
-		checkBasicHealth(families, &result, verbose)
+	// Check 4: Histogram quantiles (if specified)
+	for _, check := range quantileChecks {
+		checkHistogramQuantile(families, check, selector, result, verbose)
 	}
 
-	// Output results
-	outputText(&result, verbose)
+	// Check 5: Increases since earlier runs (if specified)
+	if checks, _ := increaseChecks(ctx); len(checks) > 0 {
+		path, err := snapshotPath(ctx, filename)
+		if err != nil {
+			return err
+		}
+		if err := checkIncreases(families, checks, selector, filename, path, time.Now(), result, verbose); err != nil {
+			return err
+		}
+	}
 
-	// Exit with appropriate code
-	if !result.Healthy {
-		os.Exit(1) // Unhealthy
+	// Check 6: Permissions (if specified)
+	if ctx.Bool("check-permissions") || ctx.IsSet("scrape-user") || ctx.IsSet("expected-dir") {
+		if filename == "-" || isURL(filename) {
+			return fmt.Errorf("permission checks need a file (-f)")
+		}
+		checkPermissions(filename, ctx.String("scrape-user"), ctx.String("expected-dir"), result, verbose)
 	}
 
-	return nil // Healthy
+	// Check 7: Duplicate or conflicting series (if specified)
+	if ctx.Bool("check-conflicts") {
+		checkSeriesConflicts(families, result, verbose)
+	}
+
+	// If no specific checks were requested, do basic health check
+	if !ctx.IsSet("max-age") && !ctx.IsSet("max-consecutive-errors") && !ctx.IsSet("metric-exists") && !ctx.IsSet("histogram-quantile") && !ctx.IsSet("max-increase") && !ctx.Bool("check-conflicts") && !ctx.Bool("check-permissions") && !ctx.IsSet("scrape-user") && !ctx.IsSet("expected-dir") {
+		checkBasicHealth(families, result, verbose)
+		checkSeriesConflicts(families, result, verbose)
+	}
+	return nil
 }
 
 func parseMetricsFile(filename string) (map[string]*dto.MetricFamily, error) {
-	file, err := os.Open(filename)
+	return metricsfile.ParseFile(filename)
+}
+
+// checkedFamilies returns the families the requested checks look at, or nil
+// when the basic health or conflict check needs to see every family.
+func checkedFamilies(ctx *cli.Context, quantileChecks []QuantileCheck) []string {
+	if ctx.Bool("check-conflicts") {
+		return nil
+	}
+	var names []string
+	if ctx.IsSet("max-age") {
+		names = append(names, ctx.String("age-metric"))
+	}
+	if ctx.IsSet("max-consecutive-errors") {
+		names = append(names, "omet_consecutive_errors_total")
+	}
+	if ctx.IsSet("metric-exists") {
+		names = append(names, ctx.String("metric-exists"))
+	}
+	for _, check := range quantileChecks {
+		names = append(names, check.Metric)
+	}
+	checks, _ := increaseChecks(ctx)
+	for _, check := range checks {
+		names = append(names, check.Metric)
+	}
+	return names
+}
+
+// readMetricsFile parses a metrics file, taking the fast path of parsing only
+// the named families when the file carries omet's checksum trailer. Files
+// without one are parsed in full so corruption anywhere still fails the check.
+// Encrypted files are decrypted with key first.
+func readMetricsFile(filename string, names []string, shared bool, timeout time.Duration, key []byte, verbose bool) (map[string]*dto.MetricFamily, error) {
+	var data []byte
+	var err error
+	if shared {
+		data, err = metricsfile.ReadFileShared(filename, timeout)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err == nil && key != nil {
+		data, err = metricsfile.Decrypt(key, data)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	return parseMetrics(file)
+	if len(names) == 0 {
+		return metricsfile.Parse(bytes.NewReader(data))
+	}
+
+	families, partial, err := metricsfile.ParsePartial(data, names)
+	if verbose && err == nil {
+		if partial {
+			log.Printf("DEBUG: Checksum verified, parsed only %v", names)
+		} else {
+			log.Printf("DEBUG: No valid checksum trailer, parsed the whole file")
+		}
+	}
+	return families, err
 }
 
 func parseMetrics(input io.Reader) (map[string]*dto.MetricFamily, error) {
-	parser := expfmt.TextParser{}
-	families, err := parser.TextToMetricFamilies(input)
+	return metricsfile.Parse(input)
+}
+
+// parseStdin parses metrics piped in, decrypting them with key if set.
+func parseStdin(key []byte) (map[string]*dto.MetricFamily, error) {
+	if key == nil {
+		return parseMetrics(os.Stdin)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err == nil {
+		data, err = metricsfile.Decrypt(key, data)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return families, nil
+	return metricsfile.Parse(bytes.NewReader(data))
 }
 
-func checkMaxAge(families map[string]*dto.MetricFamily, maxAge time.Duration, result *HealthCheckResult, verbose bool) {
-	family, exists := families["omet_last_write"]
+func checkMaxAge(families map[string]*dto.MetricFamily, metricName string, selector Selector, maxAge time.Duration, result *HealthCheckResult, verbose bool) {
+	family, exists := families[metricName]
 	if !exists {
 		result.Healthy = false
 		result.Checks["max_age"] = CheckResult{
 			Passed:  false,
-			Message: "omet_last_write metric not found",
+			Message: fmt.Sprintf("%s metric not found", metricName),
 		}
 		if verbose {
-			log.Printf("DEBUG: omet_last_write metric not found")
-			log.Printf("FAIL: omet_last_write metric not found")
+			log.Printf("DEBUG: %s metric not found", metricName)
+			log.Printf("FAIL: %s metric not found", metricName)
 		}
 		return
 	}
@@ -192,17 +486,30 @@ func checkMaxAge(families map[string]*dto.MetricFamily, maxAge time.Duration, re
 		result.Healthy = false
 		result.Checks["max_age"] = CheckResult{
 			Passed:  false,
-			Message: "omet_last_write metric has no data",
+			Message: fmt.Sprintf("%s metric has no data", metricName),
+		}
+		if verbose {
+			log.Printf("DEBUG: %s metric has no data", metricName)
+			log.Printf("FAIL: %s metric has no data", metricName)
+		}
+		return
+	}
+
+	metric := selectMetric(family, selector)
+	if metric == nil {
+		result.Healthy = false
+		result.Checks["max_age"] = CheckResult{
+			Passed:  false,
+			Message: fmt.Sprintf("No %s series matches selector %s", metricName, selector),
 		}
 		if verbose {
-			log.Printf("DEBUG: omet_last_write metric has no data")
-			log.Printf("FAIL: omet_last_write metric has no data")
+			log.Printf("FAIL: No %s series matches selector %s", metricName, selector)
 		}
 		return
 	}
 
 	// Get timestamp from gauge
-	timestamp := int64(family.Metric[0].GetGauge().GetValue())
+	timestamp := int64(metricValue(metric))
 	result.LastWriteTimestamp = &timestamp
 	
 	lastWrite := time.Unix(timestamp, 0)
@@ -236,6 +543,8 @@ func checkMaxAge(families map[string]*dto.MetricFamily, maxAge time.Duration, re
 	}
 }
 
+// checkConsecutiveErrors reads omet's own unlabeled error counter, so
+// --selector doesn't apply to it.
 func checkConsecutiveErrors(families map[string]*dto.MetricFamily, maxErrors int, result *HealthCheckResult, verbose bool) {
 	family, exists := families["omet_consecutive_errors_total"]
 	if !exists {
@@ -295,7 +604,7 @@ func checkConsecutiveErrors(families map[string]*dto.MetricFamily, maxErrors int
 	}
 }
 
-func checkMetricExists(families map[string]*dto.MetricFamily, metricName string, result *HealthCheckResult, verbose bool) {
+func checkMetricExists(families map[string]*dto.MetricFamily, metricName string, selector Selector, result *HealthCheckResult, verbose bool) {
 	// Add list of found metrics for debugging
 	var metricNames []string
 	for name := range families {
@@ -303,14 +612,23 @@ func checkMetricExists(families map[string]*dto.MetricFamily, metricName string,
 	}
 	result.MetricsFound = metricNames
 
-	_, exists := families[metricName]
+	family, exists := families[metricName]
 	
 	if verbose {
 		log.Printf("DEBUG: Looking for metric '%s'", metricName)
 		log.Printf("DEBUG: Available metrics: %v", metricNames)
 	}
 	
-	if !exists {
+	if exists && len(selector) > 0 && selectMetric(family, selector) == nil {
+		result.Healthy = false
+		result.Checks["metric_exists"] = CheckResult{
+			Passed:  false,
+			Message: fmt.Sprintf("Metric '%s' has no series matching %s", metricName, selector),
+		}
+		if verbose {
+			log.Printf("FAIL: Metric '%s' has no series matching %s", metricName, selector)
+		}
+	} else if !exists {
 		result.Healthy = false
 		result.Checks["metric_exists"] = CheckResult{
 			Passed:  false,
@@ -375,11 +693,202 @@ func checkBasicHealth(families map[string]*dto.MetricFamily, result *HealthCheck
 	}
 }
 
+// metricValue returns the sample value of a counter, gauge, or untyped series.
+func metricValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.Gauge != nil:
+		return metric.GetGauge().GetValue()
+	case metric.Counter != nil:
+		return metric.GetCounter().GetValue()
+	default:
+		return metric.GetUntyped().GetValue()
+	}
+}
+
+// QuantileCheck describes a --histogram-quantile assertion such as
+// "request_duration_seconds:0.99<0.5".
+type QuantileCheck struct {
+	Metric    string
+	Quantile  float64
+	Bound     float64
+	Inclusive bool
+}
+
+func parseQuantileCheck(spec string) (QuantileCheck, error) {
+	var check QuantileCheck
+
+	name, rest, ok := strings.Cut(spec, ":")
+	if !ok || name == "" {
+		return check, fmt.Errorf("invalid histogram quantile check: %s (expected NAME:QUANTILE<BOUND)", spec)
+	}
+	check.Metric = name
+
+	quantileStr, boundStr, ok := strings.Cut(rest, "<")
+	if !ok {
+		return check, fmt.Errorf("invalid histogram quantile check: %s (expected NAME:QUANTILE<BOUND)", spec)
+	}
+	if strings.HasPrefix(boundStr, "=") {
+		check.Inclusive = true
+		boundStr = boundStr[1:]
+	}
+
+	quantile, err := strconv.ParseFloat(strings.TrimSpace(quantileStr), 64)
+	if err != nil || quantile < 0 || quantile > 1 {
+		return check, fmt.Errorf("invalid quantile in %s: must be between 0 and 1", spec)
+	}
+	check.Quantile = quantile
+
+	bound, err := strconv.ParseFloat(strings.TrimSpace(boundStr), 64)
+	if err != nil {
+		return check, fmt.Errorf("invalid bound in %s: %w", spec, err)
+	}
+	check.Bound = bound
+
+	return check, nil
+}
+
+// estimateQuantile estimates a quantile from cumulative histogram buckets
+// using linear interpolation, the same way PromQL's histogram_quantile does.
+// It returns NaN if the histogram has no observations.
+func estimateQuantile(q float64, histogram *dto.Histogram) float64 {
+	buckets := append([]*dto.Bucket(nil), histogram.GetBucket()...)
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].GetUpperBound() < buckets[j].GetUpperBound()
+	})
+
+	if len(buckets) == 0 || !math.IsInf(buckets[len(buckets)-1].GetUpperBound(), 1) {
+		// Treat the sample count as the implicit +Inf bucket
+		inf, count := math.Inf(1), histogram.GetSampleCount()
+		buckets = append(buckets, &dto.Bucket{
+			UpperBound:      &inf,
+			CumulativeCount: &count,
+		})
+	}
+
+	total := float64(buckets[len(buckets)-1].GetCumulativeCount())
+	if total == 0 {
+		return math.NaN()
+	}
+
+	rank := q * total
+	for i, bucket := range buckets {
+		count := float64(bucket.GetCumulativeCount())
+		if count < rank {
+			continue
+		}
+
+		upper := bucket.GetUpperBound()
+		if math.IsInf(upper, 1) {
+			// Can't interpolate into +Inf; return the highest finite bound
+			if i == 0 {
+				return 0
+			}
+			return buckets[i-1].GetUpperBound()
+		}
+
+		lower, prevCount := 0.0, 0.0
+		if i > 0 {
+			lower = buckets[i-1].GetUpperBound()
+			prevCount = float64(buckets[i-1].GetCumulativeCount())
+		} else if upper <= 0 {
+			return upper
+		}
+
+		if count == prevCount {
+			return upper
+		}
+		return lower + (upper-lower)*(rank-prevCount)/(count-prevCount)
+	}
+
+	return buckets[len(buckets)-1].GetUpperBound()
+}
+
+func checkHistogramQuantile(families map[string]*dto.MetricFamily, check QuantileCheck, selector Selector, result *HealthCheckResult, verbose bool) {
+	checkName := fmt.Sprintf("histogram_quantile:%s", check.Metric)
+
+	family, exists := families[check.Metric]
+	if !exists || family.GetType() != dto.MetricType_HISTOGRAM || len(family.Metric) == 0 {
+		result.Healthy = false
+		result.Checks[checkName] = CheckResult{
+			Passed:  false,
+			Message: fmt.Sprintf("Histogram '%s' not found", check.Metric),
+		}
+		if verbose {
+			log.Printf("FAIL: Histogram '%s' not found", check.Metric)
+		}
+		return
+	}
+
+	metric := selectMetric(family, selector)
+	if metric == nil {
+		result.Healthy = false
+		result.Checks[checkName] = CheckResult{
+			Passed:  false,
+			Message: fmt.Sprintf("Histogram '%s' has no series matching %s", check.Metric, selector),
+		}
+		if verbose {
+			log.Printf("FAIL: Histogram '%s' has no series matching %s", check.Metric, selector)
+		}
+		return
+	}
+
+	estimate := estimateQuantile(check.Quantile, metric.GetHistogram())
+
+	if verbose {
+		log.Printf("DEBUG: Estimated q%g of %s: %g, bound: %g", check.Quantile, check.Metric, estimate, check.Bound)
+	}
+
+	if math.IsNaN(estimate) {
+		result.Checks[checkName] = CheckResult{
+			Passed:  true,
+			Message: fmt.Sprintf("Histogram '%s' has no observations (assuming healthy)", check.Metric),
+		}
+		if verbose {
+			log.Printf("PASS: Histogram '%s' has no observations (assuming healthy)", check.Metric)
+		}
+		return
+	}
+
+	passed := estimate < check.Bound || (check.Inclusive && estimate == check.Bound)
+	if !passed {
+		result.Healthy = false
+		result.Checks[checkName] = CheckResult{
+			Passed:  false,
+			Message: fmt.Sprintf("Quantile %g of %s too high: %g (max: %g)", check.Quantile, check.Metric, estimate, check.Bound),
+			Value:   strconv.FormatFloat(estimate, 'g', -1, 64),
+		}
+		if verbose {
+			log.Printf("FAIL: Quantile %g of %s too high: %g (max: %g)", check.Quantile, check.Metric, estimate, check.Bound)
+		}
+	} else {
+		result.Checks[checkName] = CheckResult{
+			Passed:  true,
+			Message: fmt.Sprintf("Quantile %g of %s OK: %g (max: %g)", check.Quantile, check.Metric, estimate, check.Bound),
+			Value:   strconv.FormatFloat(estimate, 'g', -1, 64),
+		}
+		if verbose {
+			log.Printf("PASS: Quantile %g of %s OK: %g", check.Quantile, check.Metric, estimate)
+		}
+	}
+}
+
+// outputResult prints result as JSON with --json, or as text.
+func outputResult(result *HealthCheckResult, asJSON, verbose bool) error {
+	if !asJSON {
+		outputText(result, verbose)
+		return nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
 
 func outputText(result *HealthCheckResult, verbose bool) {
 	if result.Healthy {
 		fmt.Printf("HEALTHY")
-		if verbose {
+		if failed := failedChecks(result); verbose && failed > 0 {
+			fmt.Printf(" - %d of %d checks failed, within --min-pass-ratio", failed, len(result.Checks))
+		} else if verbose {
 			fmt.Printf(" - All checks passed")
 		}
 		fmt.Printf("\n")
@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"math"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"omet/internal/metricsfile"
+
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -44,7 +48,7 @@ func TestCheckMaxAge(t *testing.T) {
 				Checks:  make(map[string]CheckResult),
 			}
 
-			checkMaxAge(families, tt.maxAge, &result, false)
+			checkMaxAge(families, "omet_last_write", nil, tt.maxAge, &result, false)
 
 			assert.Equal(t, tt.expectHealthy, result.Healthy)
 			check, exists := result.Checks["max_age"]
@@ -158,7 +162,7 @@ func TestCheckMetricExists(t *testing.T) {
 				Checks:  make(map[string]CheckResult),
 			}
 
-			checkMetricExists(families, tt.metricName, &result, false)
+			checkMetricExists(families, tt.metricName, nil, &result, false)
 
 			assert.Equal(t, tt.expectHealthy, result.Healthy)
 			check, exists := result.Checks["metric_exists"]
@@ -353,6 +357,84 @@ omet_last_write 1234567890
 	assert.Equal(t, 1234567890.0, gaugeFamily.Metric[0].GetGauge().GetValue())
 }
 
+func TestParseQuantileCheck(t *testing.T) {
+	check, err := parseQuantileCheck("request_duration_seconds:0.99<0.5")
+	require.NoError(t, err)
+	assert.Equal(t, "request_duration_seconds", check.Metric)
+	assert.Equal(t, 0.99, check.Quantile)
+	assert.Equal(t, 0.5, check.Bound)
+	assert.False(t, check.Inclusive)
+
+	check, err = parseQuantileCheck("latency:0.5<=1")
+	require.NoError(t, err)
+	assert.True(t, check.Inclusive)
+
+	for _, invalid := range []string{"latency", "latency:0.99", "latency:1.5<1", ":0.9<1", "latency:0.9<abc"} {
+		_, err := parseQuantileCheck(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCheckHistogramQuantile(t *testing.T) {
+	// 100 observations: 50 <= 0.1, 90 <= 0.5, 100 <= 1
+	families := createTestHistogramFamily("request_duration_seconds",
+		[]float64{0.1, 0.5, 1}, []uint64{50, 90, 100}, 100, 30)
+
+	tests := []struct {
+		name          string
+		check         QuantileCheck
+		expectHealthy bool
+		expectMessage string
+	}{
+		{
+			name:          "median below bound passes",
+			check:         QuantileCheck{Metric: "request_duration_seconds", Quantile: 0.5, Bound: 0.2},
+			expectHealthy: true,
+			expectMessage: "OK",
+		},
+		{
+			name:          "p99 above bound fails",
+			check:         QuantileCheck{Metric: "request_duration_seconds", Quantile: 0.99, Bound: 0.5},
+			expectHealthy: false,
+			expectMessage: "too high",
+		},
+		{
+			name:          "missing histogram fails",
+			check:         QuantileCheck{Metric: "missing_seconds", Quantile: 0.99, Bound: 0.5},
+			expectHealthy: false,
+			expectMessage: "not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := HealthCheckResult{
+				Healthy: true,
+				Checks:  make(map[string]CheckResult),
+			}
+
+			checkHistogramQuantile(families, tt.check, nil, &result, false)
+
+			assert.Equal(t, tt.expectHealthy, result.Healthy)
+			check, exists := result.Checks["histogram_quantile:"+tt.check.Metric]
+			require.True(t, exists)
+			assert.Contains(t, check.Message, tt.expectMessage)
+		})
+	}
+}
+
+func TestEstimateQuantile(t *testing.T) {
+	families := createTestHistogramFamily("h", []float64{0.1, 0.5, 1}, []uint64{50, 90, 100}, 100, 30)
+	histogram := families["h"].Metric[0].GetHistogram()
+
+	assert.InDelta(t, 0.1, estimateQuantile(0.5, histogram), 1e-9)
+	assert.InDelta(t, 0.3, estimateQuantile(0.7, histogram), 1e-9)
+	assert.InDelta(t, 0.95, estimateQuantile(0.99, histogram), 1e-9)
+
+	empty := createTestHistogramFamily("e", []float64{0.1}, []uint64{0}, 0, 0)
+	assert.True(t, math.IsNaN(estimateQuantile(0.5, empty["e"].Metric[0].GetHistogram())))
+}
+
 // Helper functions (reuse from main_test.go)
 func createTestCounterFamily(name string, value float64) map[string]*dto.MetricFamily {
 	families := make(map[string]*dto.MetricFamily)
@@ -386,6 +468,37 @@ func createTestGaugeFamily(name string, value float64) map[string]*dto.MetricFam
 	return families
 }
 
+func createTestHistogramFamily(name string, bucketBounds []float64, bucketCounts []uint64, sampleCount uint64, sampleSum float64) map[string]*dto.MetricFamily {
+	families := make(map[string]*dto.MetricFamily)
+	metricType := dto.MetricType_HISTOGRAM
+
+	var buckets []*dto.Bucket
+	for i, bound := range bucketBounds {
+		upperBound := bound
+		count := bucketCounts[i]
+		buckets = append(buckets, &dto.Bucket{
+			UpperBound:      &upperBound,
+			CumulativeCount: &count,
+		})
+	}
+
+	families[name] = &dto.MetricFamily{
+		Name: &name,
+		Type: &metricType,
+		Help: stringPtr("Test histogram"),
+		Metric: []*dto.Metric{
+			{
+				Histogram: &dto.Histogram{
+					SampleCount: &sampleCount,
+					SampleSum:   &sampleSum,
+					Bucket:      buckets,
+				},
+			},
+		},
+	}
+	return families
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
@@ -459,10 +572,10 @@ omet_last_write 1.752981653e+09
 
 			// Manually run the checks based on args
 			if contains(tt.args, "--metric-exists=foobar") {
-				checkMetricExists(families, "foobar", &result, false)
+				checkMetricExists(families, "foobar", nil, &result, false)
 			}
 			if contains(tt.args, "--max-age=1s") {
-				checkMaxAge(families, 1*time.Second, &result, false)
+				checkMaxAge(families, "omet_last_write", nil, 1*time.Second, &result, false)
 			}
 			if contains(tt.args, "--max-consecutive-errors=0") {
 				checkConsecutiveErrors(families, 0, &result, false)
@@ -487,3 +600,49 @@ func contains(slice []string, item string) bool {
 	}
 	return false
 }
+
+func TestReadMetricsFileFastPath(t *testing.T) {
+	filename := t.TempDir() + "/metrics.prom"
+	content := "# TYPE omet_last_write gauge\nomet_last_write 1700000000\n# TYPE other gauge\nother 1\n"
+
+	lock, err := metricsfile.NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, lock.Lock(context.Background()))
+	require.NoError(t, lock.RewriteWithChecksum(func(file *os.File) error {
+		_, err := file.WriteString(content)
+		return err
+	}))
+	require.NoError(t, lock.Close())
+
+	families, err := readMetricsFile(filename, []string{"omet_last_write"}, false, time.Second, nil, false)
+	require.NoError(t, err)
+	assert.Len(t, families, 1, "only the checked family is parsed")
+	assert.Contains(t, families, "omet_last_write")
+
+	families, err = readMetricsFile(filename, nil, true, time.Second, nil, false)
+	require.NoError(t, err)
+	assert.Len(t, families, 2, "the basic check parses everything")
+
+	// Without a valid trailer the whole file is parsed, so corruption fails
+	require.NoError(t, os.WriteFile(filename, []byte(content+"broken {\n"), 0644))
+	_, err = readMetricsFile(filename, []string{"omet_last_write"}, false, time.Second, nil, false)
+	assert.Error(t, err)
+}
+
+func TestReadEncryptedMetricsFile(t *testing.T) {
+	filename := t.TempDir() + "/metrics.prom"
+	key := bytes.Repeat([]byte{1}, metricsfile.KeySize)
+	sealed, err := metricsfile.Encrypt(key, metricsfile.AppendChecksum([]byte("# TYPE omet_last_write gauge\nomet_last_write 1700000000\n")))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filename, sealed, 0644))
+
+	families, err := readMetricsFile(filename, []string{"omet_last_write"}, false, time.Second, key, false)
+	require.NoError(t, err)
+	assert.Contains(t, families, "omet_last_write")
+
+	_, err = readMetricsFile(filename, nil, false, time.Second, bytes.Repeat([]byte{2}, metricsfile.KeySize), false)
+	assert.ErrorIs(t, err, metricsfile.ErrDecrypt)
+
+	_, err = readMetricsFile(filename, nil, false, time.Second, nil, false)
+	assert.Error(t, err, "encrypted files don't parse without the key")
+}
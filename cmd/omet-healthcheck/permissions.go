@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Permission bits, as they appear in the "other" triplet
+const (
+	permRead   os.FileMode = 4
+	permSearch os.FileMode = 1
+)
+
+// checkPermissions fails when anyone can write filename, scrapeUser (if set)
+// can't read it, or it isn't in expectedDir (if set). These are common silent
+// causes of node metrics going missing: the collector just skips files it
+// can't open.
+func checkPermissions(filename, scrapeUser, expectedDir string, result *HealthCheckResult, verbose bool) {
+	problems, err := permissionProblems(filename, scrapeUser, expectedDir)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		result.Healthy = false
+		result.Checks["permissions"] = CheckResult{
+			Passed:  false,
+			Message: strings.Join(problems, "; "),
+		}
+		if verbose {
+			log.Printf("FAIL: %s", strings.Join(problems, "; "))
+		}
+		return
+	}
+
+	result.Checks["permissions"] = CheckResult{
+		Passed:  true,
+		Message: fmt.Sprintf("Permissions OK for %s", filename),
+	}
+	if verbose {
+		log.Printf("PASS: Permissions OK for %s", filename)
+	}
+}
+
+func permissionProblems(filename, scrapeUser, expectedDir string) ([]string, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	if mode := info.Mode().Perm(); mode&0002 != 0 {
+		problems = append(problems, fmt.Sprintf("%s is world-writable (mode %04o)", filename, mode))
+	}
+
+	if scrapeUser != "" {
+		u, err := user.Lookup(scrapeUser)
+		if err != nil {
+			return problems, fmt.Errorf("unknown scrape user %s: %w", scrapeUser, err)
+		}
+		gids, _ := u.GroupIds()
+		gids = append(gids, u.Gid)
+		if !permits(info, u.Uid, gids, permRead) {
+			problems = append(problems, fmt.Sprintf("%s is not readable by %s (mode %04o)", filename, scrapeUser, info.Mode().Perm()))
+		}
+		// Every directory on the way needs search permission too
+		for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+			if dirInfo, err := os.Stat(dir); err == nil && !permits(dirInfo, u.Uid, gids, permSearch) {
+				problems = append(problems, fmt.Sprintf("directory %s is not searchable by %s", dir, scrapeUser))
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+
+	if expectedDir != "" {
+		dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return problems, err
+		}
+		expected, err := filepath.EvalSymlinks(expectedDir)
+		if err != nil {
+			return problems, fmt.Errorf("invalid --expected-dir: %w", err)
+		}
+		if dir != expected {
+			problems = append(problems, fmt.Sprintf("%s is in %s, expected %s", filename, dir, expected))
+		}
+	}
+	return problems, nil
+}
+
+// permits reports whether the user with uid and gids has perm on info,
+// checking the owner, group, or other bits as the kernel would.
+func permits(info os.FileInfo, uid string, gids []string, perm os.FileMode) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || uid == "0" {
+		return true
+	}
+	mode := info.Mode().Perm()
+	switch {
+	case strconv.FormatUint(uint64(stat.Uid), 10) == uid:
+		return mode&(perm<<6) != 0
+	case slices.Contains(gids, strconv.FormatUint(uint64(stat.Gid), 10)):
+		return mode&(perm<<3) != 0
+	default:
+		return mode&perm != 0
+	}
+}
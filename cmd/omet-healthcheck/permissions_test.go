@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	dir := t.TempDir()
+	// t.TempDir's parent is private; let other users through
+	require.NoError(t, os.Chmod(filepath.Dir(dir), 0755))
+	require.NoError(t, os.Chmod(dir, 0755))
+	filename := filepath.Join(dir, "app.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("omet_last_write 1\n"), 0644))
+
+	check := func(scrapeUser, expectedDir string) CheckResult {
+		result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+		checkPermissions(filename, scrapeUser, expectedDir, &result, false)
+		assert.Equal(t, result.Healthy, result.Checks["permissions"].Passed)
+		return result.Checks["permissions"]
+	}
+
+	assert.True(t, check("", dir).Passed)
+
+	require.NoError(t, os.Chmod(filename, 0666))
+	assert.Contains(t, check("", "").Message, "is world-writable (mode 0666)")
+
+	assert.Contains(t, check("", t.TempDir()).Message, "expected")
+
+	if _, err := user.Lookup("nobody"); err == nil {
+		require.NoError(t, os.Chmod(filename, 0644))
+		assert.True(t, check("nobody", "").Passed)
+
+		require.NoError(t, os.Chmod(filename, 0600))
+		assert.Contains(t, check("nobody", "").Message, "is not readable by nobody")
+
+		require.NoError(t, os.Chmod(filename, 0644))
+		require.NoError(t, os.Chmod(dir, 0700))
+		assert.Contains(t, check("nobody", "").Message, "is not searchable by nobody")
+	}
+
+	assert.Contains(t, check("no-such-user-omet", "").Message, "unknown scrape user")
+}
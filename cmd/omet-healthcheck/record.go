@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// recordResults writes each check's outcome into a metrics file as
+// omet_healthcheck_* gauges, using the same locking and serialization as omet
+// so that the file can be shared with omet writers.
+func recordResults(filename string, result *HealthCheckResult, lockTimeout time.Duration, now time.Time) error {
+	lock, err := metricsfile.NewFileLock(filename, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := lock.Lock(context.Background()); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	families, err := parseMetrics(lock.File())
+	if err != nil {
+		// Refuse to clobber a file we can't understand
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	addHealthCheckMetrics(families, result, now)
+
+	return lock.RewriteWithChecksum(func(file *os.File) error {
+		return metricsfile.Write(families, file)
+	})
+}
+
+func addHealthCheckMetrics(families map[string]*dto.MetricFamily, result *HealthCheckResult, now time.Time) {
+	statusFamily := gaugeFamily(families, "omet_healthcheck_status", "Result of each omet-healthcheck check (1 = passed, 0 = failed)")
+	valueFamily := gaugeFamily(families, "omet_healthcheck_value", "Value measured by each omet-healthcheck check")
+
+	names := make([]string, 0, len(result.Checks))
+	for name := range result.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		check := result.Checks[name]
+		labels := map[string]string{"check": name}
+
+		status := 0.0
+		if check.Passed {
+			status = 1.0
+		}
+		setGaugeValue(statusFamily, labels, status)
+
+		if value, ok := numericCheckValue(check.Value); ok {
+			setGaugeValue(valueFamily, labels, value)
+		}
+	}
+
+	healthy := 0.0
+	if result.Healthy {
+		healthy = 1.0
+	}
+	setGaugeValue(gaugeFamily(families, "omet_healthcheck_healthy", "Whether the last omet-healthcheck run was healthy"), nil, healthy)
+	setGaugeValue(gaugeFamily(families, "omet_healthcheck_last_run", "Unix timestamp of last omet-healthcheck run"), nil, float64(now.Unix()))
+
+	if len(valueFamily.Metric) == 0 {
+		delete(families, "omet_healthcheck_value")
+	}
+}
+
+// numericCheckValue converts a check's Value into a number, treating
+// durations as seconds.
+func numericCheckValue(value string) (float64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f, true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d.Seconds(), true
+	}
+	return 0, false
+}
+
+func gaugeFamily(families map[string]*dto.MetricFamily, name, help string) *dto.MetricFamily {
+	family, exists := families[name]
+	if !exists || family.GetType() != dto.MetricType_GAUGE {
+		metricType := dto.MetricType_GAUGE
+		family = &dto.MetricFamily{Name: &name, Type: &metricType}
+		families[name] = family
+	}
+	family.Help = &help
+	return family
+}
+
+func setGaugeValue(family *dto.MetricFamily, labels map[string]string, value float64) {
+	for _, metric := range family.Metric {
+		if len(metric.Label) != len(labels) {
+			continue
+		}
+		matches := true
+		for _, label := range metric.Label {
+			if v, ok := labels[label.GetName()]; !ok || v != label.GetValue() {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			metric.Gauge = &dto.Gauge{Value: &value}
+			return
+		}
+	}
+
+	metric := &dto.Metric{Gauge: &dto.Gauge{Value: &value}}
+	for key, v := range labels {
+		name, labelValue := key, v
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &labelValue})
+	}
+	family.Metric = append(family.Metric, metric)
+}
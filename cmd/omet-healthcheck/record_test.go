@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordResults(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("# TYPE existing_metric gauge\nexisting_metric 7\n"), 0644))
+
+	result := HealthCheckResult{
+		Healthy: false,
+		Checks: map[string]CheckResult{
+			"max_age":            {Passed: false, Message: "Last write too old", Value: "10m0s"},
+			"consecutive_errors": {Passed: true, Message: "OK", Value: "2"},
+			"metric_exists":      {Passed: true, Message: "found"},
+		},
+	}
+	now := time.Unix(1700000000, 0)
+
+	require.NoError(t, recordResults(filename, &result, time.Second, now))
+
+	families, err := parseMetricsFile(filename)
+	require.NoError(t, err)
+
+	assert.Contains(t, families, "existing_metric", "existing metrics should be preserved")
+
+	status := make(map[string]float64)
+	for _, metric := range families["omet_healthcheck_status"].Metric {
+		status[metric.Label[0].GetValue()] = metric.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"max_age": 0, "consecutive_errors": 1, "metric_exists": 1}, status)
+
+	values := make(map[string]float64)
+	for _, metric := range families["omet_healthcheck_value"].Metric {
+		values[metric.Label[0].GetValue()] = metric.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"max_age": 600, "consecutive_errors": 2}, values)
+
+	assert.Equal(t, 0.0, families["omet_healthcheck_healthy"].Metric[0].GetGauge().GetValue())
+	assert.Equal(t, 1700000000.0, families["omet_healthcheck_last_run"].Metric[0].GetGauge().GetValue())
+
+	// Recording again updates series in place instead of duplicating them
+	result.Checks["max_age"] = CheckResult{Passed: true, Value: "1m0s"}
+	result.Healthy = true
+	require.NoError(t, recordResults(filename, &result, time.Second, now))
+
+	families, err = parseMetricsFile(filename)
+	require.NoError(t, err)
+	assert.Len(t, families["omet_healthcheck_status"].Metric, 3)
+	assert.Equal(t, 1.0, families["omet_healthcheck_healthy"].Metric[0].GetGauge().GetValue())
+}
+
+func TestRecordResultsRefusesUnparseableFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("this is not { metrics\n"), 0644))
+
+	result := HealthCheckResult{Healthy: true, Checks: map[string]CheckResult{}}
+	assert.Error(t, recordResults(filename, &result, time.Second, time.Now()))
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "this is not { metrics\n", string(content), "file should be left untouched")
+}
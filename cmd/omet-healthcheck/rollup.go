@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+func isGlob(filename string) bool {
+	return strings.ContainsAny(filename, "*?[")
+}
+
+// checkGlob runs the requested checks against every file matching pattern.
+// Check names are prefixed with the file they ran against, and a file that
+// can't be read or parsed fails a "parse" check rather than the whole run,
+// so --min-pass-ratio can tolerate files that are mid-update.
+func checkGlob(ctx *cli.Context, pattern string, key []byte, quantileChecks []QuantileCheck, selector Selector, result *HealthCheckResult, verbose bool) error {
+	filenames, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid file pattern %s: %w", pattern, err)
+	}
+	if len(filenames) == 0 {
+		return fmt.Errorf("no files match %s", pattern)
+	}
+
+	for _, filename := range filenames {
+		fileResult := HealthCheckResult{
+			Healthy: true,
+			Checks:  make(map[string]CheckResult),
+		}
+		families, err := readMetricsFile(filename, checkedFamilies(ctx, quantileChecks), ctx.Bool("lock"), ctx.Duration("lock-timeout"), key, verbose)
+		if err != nil {
+			fileResult.Healthy = false
+			fileResult.Checks["parse"] = CheckResult{
+				Passed:  false,
+				Message: fmt.Sprintf("Failed to parse metrics file: %v", err),
+			}
+			if verbose {
+				log.Printf("FAIL: %s: failed to parse metrics file: %v", filename, err)
+			}
+		} else if err := runChecks(ctx, filename, families, quantileChecks, selector, &fileResult, verbose); err != nil {
+			return err
+		}
+
+		if !fileResult.Healthy {
+			result.Healthy = false
+		}
+		for name, check := range fileResult.Checks {
+			result.Checks[filename+": "+name] = check
+		}
+	}
+	if verbose {
+		log.Printf("Checked %d files matching %s", len(filenames), pattern)
+	}
+	return nil
+}
+
+// applyMinPassRatio makes the result healthy when at least ratio of its
+// checks passed, whatever the individual failures.
+func applyMinPassRatio(result *HealthCheckResult, ratio float64, verbose bool) {
+	total := len(result.Checks)
+	passed := total - failedChecks(result)
+	result.Healthy = total > 0 && float64(passed) >= ratio*float64(total)
+	if verbose {
+		log.Printf("DEBUG: %d of %d checks passed (minimum ratio %g)", passed, total, ratio)
+	}
+}
+
+func failedChecks(result *HealthCheckResult) int {
+	failed := 0
+	for _, check := range result.Checks {
+		if !check.Passed {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCheckGlob(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Unix()
+	for name, content := range map[string]string{
+		"a.prom": fmt.Sprintf("omet_last_write %d\n", now),
+		"b.prom": fmt.Sprintf("omet_last_write %d\n", now),
+		"c.prom": fmt.Sprintf("omet_last_write %d\n", now-3600),
+		"d.prom": "omet_last_write{\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	var result HealthCheckResult
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.DurationFlag{Name: "max-age"},
+			&cli.StringFlag{Name: "age-metric", Value: "omet_last_write"},
+		},
+		Action: func(ctx *cli.Context) error {
+			result = HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+			return checkGlob(ctx, filepath.Join(dir, "*.prom"), nil, nil, nil, &result, false)
+		},
+	}
+	require.NoError(t, app.Run([]string{"omet-healthcheck", "--max-age", "5m"}))
+
+	assert.False(t, result.Healthy)
+	require.Len(t, result.Checks, 4)
+	assert.True(t, result.Checks[filepath.Join(dir, "a.prom")+": max_age"].Passed)
+	assert.False(t, result.Checks[filepath.Join(dir, "c.prom")+": max_age"].Passed)
+	assert.False(t, result.Checks[filepath.Join(dir, "d.prom")+": parse"].Passed)
+
+	applyMinPassRatio(&result, 0.75, false)
+	assert.False(t, result.Healthy, "2 of 4 is below 75%")
+	applyMinPassRatio(&result, 0.5, false)
+	assert.True(t, result.Healthy)
+
+	assert.Error(t, checkGlob(nil, filepath.Join(dir, "*.missing"), nil, nil, nil, &result, false))
+}
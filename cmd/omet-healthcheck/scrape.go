@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// scrapeAccept asks for the text format, which every exporter serves, ahead
+// of OpenMetrics.
+const scrapeAccept = "text/plain;version=0.0.4;q=1,application/openmetrics-text;version=1.0.0;q=0.5,*/*;q=0.1"
+
+// Largest response a scrape reads, as a guard against endpoints that
+// never stop sending
+const maxScrapeBytes = 64 << 20
+
+// isURL reports whether target is a scrape endpoint rather than a file.
+func isURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// scrapeOptions configure how an endpoint is scraped.
+type scrapeOptions struct {
+	timeout            time.Duration
+	caFile             string
+	certFile, keyFile  string
+	insecureSkipVerify bool
+}
+
+// client builds an HTTP client trusting caFile, if given, on top of the
+// system roots, and presenting the client certificate, if given.
+func (o scrapeOptions) client() (*http.Client, error) {
+	config := &tls.Config{InsecureSkipVerify: o.insecureSkipVerify}
+	if o.caFile != "" {
+		pem, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --ca-file: %w", err)
+		}
+		if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in --ca-file %s", o.caFile)
+		}
+	}
+	if o.certFile != "" || o.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Timeout: o.timeout, Transport: transport}, nil
+}
+
+// scrape fetches url the way Prometheus would and parses the response in
+// whichever format it came in.
+func scrape(client *http.Client, url string) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", scrapeAccept)
+	req.Header.Set("User-Agent", "omet-healthcheck")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxScrapeBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	if len(data) > maxScrapeBytes {
+		return nil, fmt.Errorf("%s: response larger than %d bytes", url, maxScrapeBytes)
+	}
+	return metricsfile.Parse(bytes.NewReader(data))
+}
+
+// scrapeTarget scrapes url with the client the flags describe.
+func scrapeTarget(ctx *cli.Context, url string, verbose bool) (map[string]*dto.MetricFamily, error) {
+	client, err := scrapeOptions{
+		timeout:            ctx.Duration("scrape-timeout"),
+		caFile:             ctx.String("ca-file"),
+		certFile:           ctx.String("cert-file"),
+		keyFile:            ctx.String("key-file"),
+		insecureSkipVerify: ctx.Bool("insecure-skip-verify"),
+	}.client()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	families, err := scrape(client, url)
+	if verbose && err == nil {
+		log.Printf("Scraped %d metric families from %s in %v", len(families), url, time.Since(start))
+	}
+	return families, err
+}
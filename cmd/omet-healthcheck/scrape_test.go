@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestScrape(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Contains(t, r.Header.Get("Accept"), "text/plain")
+		w.Write([]byte("# TYPE node_load1 gauge\nnode_load1 0.5\n"))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+
+	t.Run("trusts --ca-file", func(t *testing.T) {
+		client, err := scrapeOptions{timeout: 5 * time.Second, caFile: caFile}.client()
+		require.NoError(t, err)
+		families, err := scrape(client, server.URL+"/metrics")
+		require.NoError(t, err)
+		assert.Equal(t, 0.5, families["node_load1"].Metric[0].GetGauge().GetValue())
+	})
+
+	t.Run("rejects an unknown certificate", func(t *testing.T) {
+		client, err := scrapeOptions{timeout: 5 * time.Second}.client()
+		require.NoError(t, err)
+		_, err = scrape(client, server.URL+"/metrics")
+		assert.Error(t, err)
+
+		client, err = scrapeOptions{timeout: 5 * time.Second, insecureSkipVerify: true}.client()
+		require.NoError(t, err)
+		_, err = scrape(client, server.URL+"/metrics")
+		assert.NoError(t, err, "unless verification is off")
+	})
+
+	t.Run("fails on error statuses", func(t *testing.T) {
+		client, err := scrapeOptions{timeout: 5 * time.Second, caFile: caFile}.client()
+		require.NoError(t, err)
+		_, err = scrape(client, server.URL+"/missing")
+		assert.ErrorContains(t, err, "404")
+	})
+
+	t.Run("rejects a CA file without certificates", func(t *testing.T) {
+		bogus := filepath.Join(t.TempDir(), "bogus.pem")
+		require.NoError(t, os.WriteFile(bogus, []byte("not a certificate"), 0644))
+		_, err := scrapeOptions{caFile: bogus}.client()
+		assert.ErrorContains(t, err, "no certificates")
+	})
+}
+
+func TestFlagsAfterTarget(t *testing.T) {
+	var target string
+	var maxAge time.Duration
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "file", Aliases: []string{"f"}},
+			&cli.DurationFlag{Name: "max-age"},
+		},
+		Action: func(ctx *cli.Context) error {
+			args, err := interspersedArgs(ctx)
+			if err != nil {
+				return err
+			}
+			target, maxAge = args[0], ctx.Duration("max-age")
+			return nil
+		},
+	}
+	require.NoError(t, app.Run([]string{"omet-healthcheck", "https://host:9100/metrics", "--max-age", "5m"}))
+	assert.Equal(t, "https://host:9100/metrics", target)
+	assert.Equal(t, 5*time.Minute, maxAge)
+
+	app.Action = checkHealth
+	err := app.Run([]string{"omet-healthcheck", "https://host:9100/metrics", "-f", "other.prom"})
+	assert.ErrorContains(t, err, "give one target, either -f or a URL")
+}
+
+func TestIsURL(t *testing.T) {
+	assert.True(t, isURL("https://host:9100/metrics"))
+	assert.True(t, isURL("http://localhost/metrics?name[]=up"))
+	assert.False(t, isURL("/var/lib/node_exporter/*.prom"))
+	assert.False(t, isURL("-"))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"omet/internal/selector"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Selector is a set of label matchers that must all match a series.
+type Selector = selector.Selector
+
+// parseSelector parses selectors like `job="backup"` or
+// `{job="backup",env=~"prod|staging"}`.
+func parseSelector(input string) (Selector, error) {
+	return selector.Parse(input)
+}
+
+// selectMetric returns the first series in the family matching the selector.
+// With an empty selector it returns the first series, matching the behavior
+// of checks that predate label scoping.
+func selectMetric(family *dto.MetricFamily, selector Selector) *dto.Metric {
+	for _, metric := range family.Metric {
+		if selector.Matches(metric.Label) {
+			return metric
+		}
+	}
+	return nil
+}
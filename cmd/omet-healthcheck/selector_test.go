@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestSelectorScopedChecks(t *testing.T) {
+	gaugeType := dto.MetricType_GAUGE
+	families := map[string]*dto.MetricFamily{
+		"backup_last_success": {
+			Name: stringPtr("backup_last_success"),
+			Type: &gaugeType,
+			Metric: []*dto.Metric{
+				{
+					Label: []*dto.LabelPair{{Name: stringPtr("job"), Value: stringPtr("restore")}},
+					Gauge: &dto.Gauge{Value: float64Ptr(float64(time.Now().Unix()))},
+				},
+				{
+					Label: []*dto.LabelPair{{Name: stringPtr("job"), Value: stringPtr("backup")}},
+					Gauge: &dto.Gauge{Value: float64Ptr(float64(time.Now().Add(-2 * time.Hour).Unix()))},
+				},
+			},
+		},
+	}
+
+	t.Run("age check uses the selected series", func(t *testing.T) {
+		selector, err := parseSelector(`job="backup"`)
+		require.NoError(t, err)
+
+		result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+		checkMaxAge(families, "backup_last_success", selector, time.Hour, &result, false)
+
+		assert.False(t, result.Healthy)
+		assert.Contains(t, result.Checks["max_age"].Message, "Last write too old")
+	})
+
+	t.Run("unmatched selector fails age check", func(t *testing.T) {
+		selector, err := parseSelector(`job="archive"`)
+		require.NoError(t, err)
+
+		result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+		checkMaxAge(families, "backup_last_success", selector, 24*time.Hour, &result, false)
+
+		assert.False(t, result.Healthy)
+		assert.Contains(t, result.Checks["max_age"].Message, "matches selector")
+	})
+
+	t.Run("unmatched selector fails existence check", func(t *testing.T) {
+		selector, err := parseSelector(`job="archive"`)
+		require.NoError(t, err)
+
+		result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+		checkMetricExists(families, "backup_last_success", selector, &result, false)
+
+		assert.False(t, result.Healthy)
+		assert.Contains(t, result.Checks["metric_exists"].Message, "no series matching")
+	})
+
+	t.Run("selector doesn't apply to consecutive errors", func(t *testing.T) {
+		selector, err := parseSelector(`job="backup"`)
+		require.NoError(t, err)
+		withErrors := map[string]*dto.MetricFamily{
+			"backup_last_success":           families["backup_last_success"],
+			"omet_consecutive_errors_total": createTestGaugeFamily("omet_consecutive_errors_total", 2)["omet_consecutive_errors_total"],
+		}
+
+		// omet's self-metric has no labels, so the selector mustn't leave it unmatched
+		result := HealthCheckResult{Healthy: true, Checks: make(map[string]CheckResult)}
+		app := &cli.App{
+			Flags: []cli.Flag{&cli.IntFlag{Name: "max-consecutive-errors"}},
+			Action: func(ctx *cli.Context) error {
+				return runChecks(ctx, "metrics.prom", withErrors, nil, selector, &result, false)
+			},
+		}
+		require.NoError(t, app.Run([]string{"omet-healthcheck", "--max-consecutive-errors", "5"}))
+
+		assert.True(t, result.Healthy)
+		assert.Equal(t, "2", result.Checks["consecutive_errors"].Value)
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// converters write families in each --to format of omet convert.
+var converters = map[string]func(map[string]*dto.MetricFamily, io.Writer) error{
+	"text":        metricsfile.Write,
+	"openmetrics": metricsfile.WriteOpenMetrics,
+	"json":        metricsfile.WriteJSON,
+	"proto":       metricsfile.WriteProtobuf,
+	"influx":      metricsfile.WriteInflux,
+}
+
+func convertCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "convert",
+		Usage:     "Convert a metrics file between exposition formats",
+		ArgsUsage: "[<file>]",
+		Description: `Reads a file in any format omet understands, detected by extension or
+content (Prometheus text, OpenMetrics, protobuf, or prom2json JSON), and
+writes it in the --to format:
+
+  text         Prometheus text format
+  openmetrics  OpenMetrics text, ending with "# EOF"
+  json         prom2json's JSON
+  proto        length-delimited protobuf MetricFamily messages
+  influx       InfluxDB line protocol, as Telegraf's prometheus input writes it
+
+Reads stdin when no file is given.
+
+Examples:
+  omet convert --to openmetrics -o app.om app.prom
+  curl -s -H 'Accept: application/vnd.google.protobuf' http://localhost:9100/metrics | omet convert --to text`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "to",
+				Usage:    "Output format: text, openmetrics, json, proto, or influx",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Write to this file (default: stdout)",
+			},
+		},
+		Action: runConvert,
+	}
+}
+
+func runConvert(ctx *cli.Context) error {
+	convert, ok := converters[ctx.String("to")]
+	if !ok {
+		return fmt.Errorf("invalid --to: %s (supported: text, openmetrics, json, proto, influx)", ctx.String("to"))
+	}
+	if ctx.NArg() > 1 {
+		return fmt.Errorf("convert takes at most one input file")
+	}
+
+	filename := ctx.Args().First()
+	if filename == "" {
+		filename = "-"
+	}
+	var families map[string]*dto.MetricFamily
+	var err error
+	if filename == "-" {
+		families, err = metricsfile.Parse(os.Stdin)
+	} else {
+		families, err = metricsfile.ParseFile(filename)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	var output io.Writer = os.Stdout
+	if path := ctx.String("output"); path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		defer file.Close()
+		output = file
+	}
+	return convert(families, output)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"omet/internal/metricsfile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const convertInput = `# HELP jobs_total Jobs run
+# TYPE jobs_total counter
+jobs_total{job="a b"} 3
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 0.3
+latency_seconds_count 2
+`
+
+func TestConvert(t *testing.T) {
+	input := createTempFile(t, convertInput)
+
+	for _, format := range []string{"text", "openmetrics", "json", "proto"} {
+		t.Run(format, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "converted")
+			require.NoError(t, createTestApp().Run([]string{"omet", "convert", "--to", format, "-o", output, input}))
+
+			families, err := metricsfile.ParseFile(output)
+			require.NoError(t, err)
+			value, ok := seriesValue(families, "jobs_total", map[string]string{"job": "a b"})
+			assert.True(t, ok)
+			assert.Equal(t, 3.0, value)
+			assert.Equal(t, "Jobs run", families["jobs_total"].GetHelp())
+			histogram := families["latency_seconds"].Metric[0].GetHistogram()
+			assert.Equal(t, uint64(2), histogram.GetSampleCount())
+			assert.Equal(t, 0.3, histogram.GetSampleSum())
+		})
+	}
+
+	t.Run("influx", func(t *testing.T) {
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "convert", "--to", "influx", input}))
+		})
+		assert.Equal(t, "jobs_total,job=a\\ b counter=3\nlatency_seconds count=2,sum=0.3,0.1=1\n", output)
+	})
+
+	t.Run("reads stdin", func(t *testing.T) {
+		defer mockStdin(t, convertInput)()
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "convert", "--to", "openmetrics"}))
+		})
+		assert.Contains(t, output, "# TYPE jobs counter\n")
+		assert.Contains(t, output, "# EOF\n")
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		err := createTestApp().Run([]string{"omet", "convert", "--to", "yaml", input})
+		assert.ErrorContains(t, err, "invalid --to: yaml")
+	})
+
+	t.Run("fails on unparseable input", func(t *testing.T) {
+		broken := createTempFile(t, "not valid {\n")
+		err := createTestApp().Run([]string{"omet", "convert", "--to", "json", broken})
+		assert.ErrorContains(t, err, "failed to parse")
+	})
+}
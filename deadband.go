@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// deadband is --deadband: how far a set may move a gauge before it's worth
+// writing, either absolute or relative to the current value.
+type deadband struct {
+	threshold float64
+	relative  bool
+}
+
+// parseDeadband accepts "5%" or an absolute amount like "0.5".
+func parseDeadband(input string) (*deadband, error) {
+	s := strings.TrimSpace(input)
+	relative := strings.HasSuffix(s, "%")
+	threshold, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || threshold < 0 || math.IsNaN(threshold) {
+		return nil, fmt.Errorf("invalid --deadband: %s (expected e.g. 5%% or 0.5)", input)
+	}
+	if relative {
+		threshold /= 100
+	}
+	return &deadband{threshold: threshold, relative: relative}, nil
+}
+
+// holds reports whether setting the series of u to value would change it
+// by no more than the deadband. New series are always written.
+func (d *deadband) holds(families map[string]*dto.MetricFamily, u *request, value float64) bool {
+	if d == nil || u.operation != "set" {
+		return false
+	}
+	family, ok := families[u.metricName]
+	if !ok || family.GetType() != dto.MetricType_GAUGE {
+		return false
+	}
+	current, ok := seriesValue(families, u.metricName, u.labels)
+	if !ok {
+		return false
+	}
+	limit := d.threshold
+	if d.relative {
+		limit *= math.Abs(current)
+	}
+	return math.Abs(value-current) <= limit
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeadband(t *testing.T) {
+	d, err := parseDeadband("5%")
+	require.NoError(t, err)
+	assert.Equal(t, &deadband{threshold: 0.05, relative: true}, d)
+
+	d, err = parseDeadband("0.5")
+	require.NoError(t, err)
+	assert.Equal(t, &deadband{threshold: 0.5}, d)
+
+	for _, input := range []string{"", "%", "-1", "five", "NaN"} {
+		_, err := parseDeadband(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestDeadband(t *testing.T) {
+	const initial = "# TYPE temperature_celsius gauge\ntemperature_celsius{sensor=\"cpu\"} 60\n"
+	set := func(t *testing.T, testFile string, args ...string) {
+		t.Helper()
+		base := []string{"omet", "-i", "-f", testFile, "--deadband", "5%"}
+		require.NoError(t, createTestApp().Run(append(base, args...)))
+	}
+
+	t.Run("leaves the file alone within the deadband", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		before, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+
+		set(t, testFile, "-l", "sensor=cpu", "temperature_celsius", "set", "62.5")
+		after, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, string(before), string(after))
+	})
+
+	t.Run("writes beyond the deadband", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		set(t, testFile, "-l", "sensor=cpu", "temperature_celsius", "set", "63.5")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, _ := seriesValue(families, "temperature_celsius", map[string]string{"sensor": "cpu"})
+		assert.Equal(t, 63.5, value)
+	})
+
+	t.Run("always writes new series", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		set(t, testFile, "-l", "sensor=gpu", "temperature_celsius", "set", "60")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		_, exists := seriesValue(families, "temperature_celsius", map[string]string{"sensor": "gpu"})
+		assert.True(t, exists)
+	})
+
+	t.Run("rejects an invalid deadband", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--deadband", "lots", "temperature_celsius", "set", "1"})
+		assert.ErrorContains(t, err, "invalid --deadband")
+	})
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"omet/internal/metricsfile"
+	"omet/internal/schema"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// How many values of each label a catalog lists as examples
+const maxExampleLabelValues = 5
+
+func docsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "docs",
+		Usage: "Generate a catalog of the metric families in a file or schema",
+		Description: `Lists every family with its type, unit, help, the labels its series
+use with a few example values, and an example series, as a Markdown
+table or as JSON. Run it from CI to publish an inventory that keeps up
+with the textfiles.
+
+With --schema, declared families are listed even before any series is
+written, and declared help and units take precedence over the file's.
+OMET's own omet_* self-metrics are left out unless --include-self.
+
+Examples:
+  omet docs -f /var/lib/node_exporter/app.prom > METRICS.md
+  omet docs --schema schema.yaml --format json`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "Metrics file to document (default: stdin, unless --schema is given)",
+			},
+			&cli.StringFlag{
+				Name:  "schema",
+				Usage: "Schema file declaring the metric families",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format: markdown or json",
+				Value: "markdown",
+			},
+			&cli.BoolFlag{
+				Name:  "include-self",
+				Usage: "Include OMET's omet_* self-metrics",
+			},
+		},
+		Action: runDocs,
+	}
+}
+
+// familyDoc is one entry of the catalog.
+type familyDoc struct {
+	Name    string     `json:"name"`
+	Type    string     `json:"type"`
+	Unit    string     `json:"unit,omitempty"`
+	Help    string     `json:"help,omitempty"`
+	Labels  []labelDoc `json:"labels,omitempty"`
+	Series  int        `json:"series"`
+	Example string     `json:"example,omitempty"`
+}
+
+// labelDoc is a label of a family and some of the values it takes.
+type labelDoc struct {
+	Name     string   `json:"name"`
+	Values   []string `json:"values"`
+	Distinct int      `json:"distinct_values"`
+}
+
+func runDocs(ctx *cli.Context) error {
+	var render func(io.Writer, []familyDoc) error
+	switch ctx.String("format") {
+	case "markdown":
+		render = writeDocsMarkdown
+	case "json":
+		render = func(w io.Writer, docs []familyDoc) error {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(docs)
+		}
+	default:
+		return fmt.Errorf("invalid --format: %s (supported: markdown, json)", ctx.String("format"))
+	}
+
+	var declared *schema.Schema
+	if filename := ctx.String("schema"); filename != "" {
+		var err error
+		if declared, err = schema.Load(filename); err != nil {
+			return fmt.Errorf("failed to load schema: %w", err)
+		}
+	}
+
+	filename := ctx.String("file")
+	if filename == "" && declared == nil {
+		filename = "-"
+	}
+	var families map[string]*dto.MetricFamily
+	var err error
+	switch filename {
+	case "":
+	case "-":
+		families, err = metricsfile.Parse(os.Stdin)
+	default:
+		families, err = metricsfile.ParseFile(filename)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	docs, err := buildDocs(families, declared, ctx.Bool("include-self"))
+	if err != nil {
+		return err
+	}
+	return render(os.Stdout, docs)
+}
+
+// buildDocs catalogs the families of a file, a schema, or both, sorted by
+// name. Labels and examples come from the file's series and, for families
+// without any, from the schema's known label sets.
+func buildDocs(families map[string]*dto.MetricFamily, declared *schema.Schema, includeSelf bool) ([]familyDoc, error) {
+	byName := make(map[string]*familyDoc)
+	entry := func(name string) *familyDoc {
+		if doc, ok := byName[name]; ok {
+			return doc
+		}
+		doc := &familyDoc{Name: name}
+		byName[name] = doc
+		return doc
+	}
+
+	for name, family := range families {
+		if strings.HasPrefix(name, "omet_") && !includeSelf {
+			continue
+		}
+		doc := entry(name)
+		doc.Type = typeName(family)
+		doc.Unit = family.GetUnit()
+		doc.Help = family.GetHelp()
+		doc.Series = len(family.Metric)
+
+		labelSets := make([]map[string]string, 0, len(family.Metric))
+		for _, metric := range family.Metric {
+			labels := make(map[string]string, len(metric.Label))
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			labelSets = append(labelSets, labels)
+		}
+		doc.Labels = collectLabels(labelSets)
+
+		if len(family.Metric) > 0 {
+			metrics := append([]*dto.Metric(nil), family.Metric...)
+			sort.Slice(metrics, func(i, j int) bool { return labelString(metrics[i].Label) < labelString(metrics[j].Label) })
+			example := name
+			if len(metrics[0].Label) > 0 {
+				example += labelString(metrics[0].Label)
+			}
+			doc.Example = example + " " + seriesSummary(family.GetType(), metrics[0], func(value float64) string {
+				return strconv.FormatFloat(value, 'g', -1, 64)
+			})
+		}
+	}
+
+	if declared != nil {
+		for _, family := range declared.Families {
+			metricType, err := family.MetricType()
+			if err != nil {
+				return nil, err
+			}
+			doc := entry(family.Name)
+			doc.Type = metricsfile.TypeName(metricType)
+			if family.Unit != "" {
+				doc.Unit = family.Unit
+			}
+			if family.Help != "" {
+				doc.Help = family.Help
+			}
+			if doc.Series == 0 {
+				doc.Series = len(family.Series)
+				doc.Labels = collectLabels(family.Series)
+			}
+		}
+	}
+
+	docs := make([]familyDoc, 0, len(byName))
+	for _, doc := range byName {
+		docs = append(docs, *doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs, nil
+}
+
+// collectLabels lists the label names used across labelSets, each with its
+// first few values in sorted order.
+func collectLabels(labelSets []map[string]string) []labelDoc {
+	values := make(map[string]map[string]bool)
+	for _, labels := range labelSets {
+		for name, value := range labels {
+			if values[name] == nil {
+				values[name] = make(map[string]bool)
+			}
+			values[name][value] = true
+		}
+	}
+
+	labels := make([]labelDoc, 0, len(values))
+	for name, seen := range values {
+		label := labelDoc{Name: name, Distinct: len(seen)}
+		for value := range seen {
+			label.Values = append(label.Values, value)
+		}
+		sort.Strings(label.Values)
+		if len(label.Values) > maxExampleLabelValues {
+			label.Values = label.Values[:maxExampleLabelValues]
+		}
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+// writeDocsMarkdown renders the catalog as a Markdown table.
+func writeDocsMarkdown(w io.Writer, docs []familyDoc) error {
+	cell := func(s string) string {
+		return strings.ReplaceAll(strings.ReplaceAll(s, "|", `\|`), "\n", " ")
+	}
+	code := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "`" + cell(s) + "`"
+	}
+
+	var b strings.Builder
+	b.WriteString("# Metrics\n\n")
+	b.WriteString("| Metric | Type | Unit | Help | Labels | Series | Example |\n")
+	b.WriteString("|--------|------|------|------|--------|--------|---------|\n")
+	for _, doc := range docs {
+		labels := make([]string, 0, len(doc.Labels))
+		for _, label := range doc.Labels {
+			values := strings.Join(label.Values, ", ")
+			if label.Distinct > len(label.Values) {
+				values += ", …"
+			}
+			labels = append(labels, code(label.Name)+" ("+cell(values)+")")
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %d | %s |\n",
+			code(doc.Name), doc.Type, cell(doc.Unit), cell(doc.Help), strings.Join(labels, "<br>"), doc.Series, code(doc.Example))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"omet/internal/schema"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildDocs(t *testing.T) {
+	families, err := parseMetrics(strings.NewReader(`# HELP backups_total Total backups
+# TYPE backups_total counter
+backups_total{job="restore"} 1
+backups_total{job="backup"} 3
+# TYPE omet_last_write gauge
+omet_last_write 1700000000
+`))
+	require.NoError(t, err)
+	declared, err := schema.Parse([]byte(`families:
+  - name: backups_total
+    type: counter
+    unit: backups
+  - name: queue_depth
+    type: gauge
+    help: Items waiting
+    series:
+      - {queue: orders}
+`))
+	require.NoError(t, err)
+
+	docs, err := buildDocs(families, declared, false)
+	require.NoError(t, err)
+	require.Len(t, docs, 2, "self-metrics are left out")
+
+	assert.Equal(t, familyDoc{
+		Name:    "backups_total",
+		Type:    "counter",
+		Unit:    "backups",
+		Help:    "Total backups",
+		Labels:  []labelDoc{{Name: "job", Values: []string{"backup", "restore"}, Distinct: 2}},
+		Series:  2,
+		Example: `backups_total{job="backup"} 3`,
+	}, docs[0])
+	assert.Equal(t, familyDoc{
+		Name:   "queue_depth",
+		Type:   "gauge",
+		Help:   "Items waiting",
+		Labels: []labelDoc{{Name: "queue", Values: []string{"orders"}, Distinct: 1}},
+		Series: 1,
+	}, docs[1], "declared families are listed before any series is written")
+
+	docs, err = buildDocs(families, nil, true)
+	require.NoError(t, err)
+	assert.Len(t, docs, 2)
+	assert.Equal(t, "omet_last_write", docs[1].Name)
+}
+
+func TestDocsMarkdown(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, writeDocsMarkdown(&b, []familyDoc{{
+		Name:   "jobs_total",
+		Type:   "counter",
+		Help:   "Jobs | tasks",
+		Labels: []labelDoc{{Name: "job", Values: []string{"a", "b", "c", "d", "e"}, Distinct: 7}},
+		Series: 7,
+	}}))
+	assert.Contains(t, b.String(), "| `jobs_total` | counter |  | Jobs \\| tasks | `job` (a, b, c, d, e, …) | 7 |  |\n")
+}
+
+func TestDocsCommand(t *testing.T) {
+	testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total 1\n")
+
+	output := captureOutput(t, func() {
+		require.NoError(t, createTestApp().Run([]string{"omet", "docs", "-f", testFile, "--format", "json"}))
+	})
+	assert.Contains(t, output, `"name": "jobs_total"`)
+
+	err := createTestApp().Run([]string{"omet", "docs", "-f", testFile, "--format", "html"})
+	assert.ErrorContains(t, err, "invalid --format")
+}
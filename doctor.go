@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	"github.com/urfave/cli/v2"
+)
+
+func doctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "doctor",
+		Usage:     "Diagnose common problems with a metrics file and its environment",
+		ArgsUsage: " ",
+		Description: `Checks that the file exists and has workable permissions, that its lock
+can be taken, for entries of dead writers in the fair-lock queue, that
+it parses and its checksum trailer matches, for clock skew against
+omet_last_write, and for node_exporter textfile collector conventions.
+Each finding comes with a suggested fix. Exits non-zero if any check
+failed.
+
+Examples:
+  omet doctor -f /var/lib/node_exporter/app.prom`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Metrics file to check",
+				Required: true,
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 2 * time.Second,
+				Usage: "How long to wait for the file lock before reporting it as held",
+			},
+			&cli.StringFlag{
+				Name:  "encrypt-key-file",
+				Usage: "Key for checking an encrypted file",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			var key []byte
+			if path := ctx.String("encrypt-key-file"); path != "" {
+				var err error
+				if key, err = metricsfile.LoadKey(path); err != nil {
+					return fmt.Errorf("failed to load --encrypt-key-file: %w", err)
+				}
+			}
+			findings := diagnose(ctx.String("file"), ctx.Duration("lock-timeout"), key)
+			printFindings(os.Stdout, findings)
+
+			failed := 0
+			for _, finding := range findings {
+				if finding.level == levelFail {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d check(s) failed", failed)
+			}
+			return nil
+		},
+	}
+}
+
+const (
+	levelOK   = "ok"
+	levelWarn = "warn"
+	levelFail = "fail"
+)
+
+// finding is the outcome of one doctor check.
+type finding struct {
+	level   string
+	check   string
+	message string
+}
+
+// maxClockSkew is how far in the future omet_last_write may be before the
+// clocks of the writing and checking hosts are considered out of sync.
+const maxClockSkew = time.Minute
+
+// accessWrite is W_OK for access(2).
+const accessWrite = 2
+
+// diagnose runs every check against filename. It never modifies the file.
+func diagnose(filename string, lockTimeout time.Duration, key []byte) (findings []finding) {
+	add := func(level, check, format string, args ...any) {
+		findings = append(findings, finding{level, check, fmt.Sprintf(format, args...)})
+	}
+
+	dir := filepath.Dir(filename)
+	defer func() {
+		if filepath.Ext(filename) != ".prom" {
+			add(levelWarn, "textfile", "name doesn't end in .prom; node_exporter's textfile collector ignores it")
+		}
+	}()
+
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		add(levelWarn, "exists", "file doesn't exist yet; 'omet init' or the first 'omet -i' creates it")
+		if syscall.Access(dir, accessWrite) != nil {
+			add(levelFail, "permissions", "directory %s isn't writable, so the file can't be created", dir)
+		}
+		return findings
+	}
+	if err != nil {
+		add(levelFail, "exists", "%v", err)
+		return findings
+	}
+	if !info.Mode().IsRegular() {
+		add(levelFail, "exists", "%s is not a regular file", filename)
+		return findings
+	}
+	add(levelOK, "exists", "%d bytes, mode %04o, modified %s ago", info.Size(), info.Mode().Perm(), time.Since(info.ModTime()).Round(time.Second))
+
+	// Permissions
+	if syscall.Access(filename, accessWrite) != nil {
+		add(levelFail, "permissions", "not writable by this user; run omet as the file's owner or fix its mode")
+	} else {
+		add(levelOK, "permissions", "writable by this user")
+	}
+	if info.Mode().Perm()&0004 == 0 {
+		add(levelWarn, "permissions", "not readable by other users, and node_exporter usually runs as its own user (chmod o+r %s)", filename)
+	}
+	if syscall.Access(dir, accessWrite) != nil {
+		add(levelWarn, "permissions", "directory %s isn't writable, so the journal and lock queue can't be kept next to the file", dir)
+	}
+
+	// Locking
+	lock, err := metricsfile.NewReadLock(filename, lockTimeout)
+	if err != nil {
+		add(levelFail, "lock", "%v", err)
+	} else {
+		start := time.Now()
+		if err := lock.LockShared(context.Background()); err != nil {
+			add(levelFail, "lock", "another process has held the lock for over %v; look for a hung writer (lsof %s)", lockTimeout, filename)
+		} else {
+			add(levelOK, "lock", "acquired in %v", time.Since(start).Round(time.Microsecond))
+		}
+		lock.Close()
+	}
+	if alive, dead, err := metricsfile.QueueEntries(filename); err != nil {
+		add(levelWarn, "lock", "can't read the fair-lock queue: %v", err)
+	} else {
+		if dead > 0 {
+			add(levelWarn, "lock", "%d fair-lock queue entries belong to exited processes; the next --fair-lock writer prunes them", dead)
+		}
+		if alive > 0 {
+			add(levelOK, "lock", "%d writers waiting in the fair-lock queue", alive)
+		}
+	}
+
+	// Contents
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		add(levelFail, "parse", "%v", err)
+		return findings
+	}
+	if metricsfile.IsEncrypted(data) {
+		if key == nil {
+			add(levelWarn, "parse", "file is encrypted; pass --encrypt-key-file to check its contents")
+			return findings
+		}
+		if data, err = metricsfile.Decrypt(key, data); err != nil {
+			add(levelFail, "parse", "%v", err)
+			return findings
+		}
+	}
+	families, err := metricsfile.Parse(bytes.NewReader(data))
+	if err != nil {
+		add(levelFail, "parse", "%v; the textfile collector drops the whole file", err)
+		return findings
+	}
+	add(levelOK, "parse", "%d families", len(families))
+	switch {
+	case metricsfile.Verify(data):
+		add(levelOK, "checksum", "trailer matches")
+	case bytes.Contains(data, []byte("\n# omet-crc32 ")) || bytes.HasPrefix(data, []byte("# omet-crc32 ")):
+		add(levelWarn, "checksum", "trailer doesn't match; the file was changed by another tool after omet wrote it")
+	default:
+		add(levelOK, "checksum", "no trailer (not written in place by omet)")
+	}
+
+	// Histograms written by other tools
+	for _, series := range inconsistentHistograms(families) {
+		add(levelWarn, "histograms", "%s: %s; omet --repair-histograms fixes what it can", series.name, strings.Join(series.problems, "; "))
+	}
+
+	// Label consistency
+	if inconsistent := inconsistentFamilies(families); len(inconsistent) > 0 {
+		add(levelWarn, "labels", "series of %s don't all carry the same label names; updating them with --label-consistency=fill pads the rest", strings.Join(inconsistent, ", "))
+	}
+
+	// Clock skew
+	if lastWrite, ok := seriesValue(families, "omet_last_write", nil); ok {
+		written := time.Unix(int64(lastWrite), 0)
+		if skew := written.Sub(timeProvider.Now()); skew > maxClockSkew {
+			add(levelWarn, "clock", "omet_last_write is %v in the future; the writing host's clock is ahead of this one (check NTP)", skew.Round(time.Second))
+		} else {
+			add(levelOK, "clock", "last write %v ago", timeProvider.Now().Sub(written).Round(time.Second))
+		}
+	}
+
+	// Textfile collector conventions (the name is checked on return)
+	if bytes.Contains(data, []byte("\r\n")) {
+		add(levelWarn, "textfile", "file has CRLF line endings, meant for Windows consumers; drop --crlf for node_exporter")
+	}
+	return findings
+}
+
+func printFindings(w io.Writer, findings []finding) {
+	for _, finding := range findings {
+		fmt.Fprintf(w, "%-4s  %-11s  %s\n", strings.ToUpper(finding.level), finding.check, finding.message)
+	}
+}
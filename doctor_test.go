@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findingsByCheck indexes findings as "level: message" per check.
+func findingsByCheck(findings []finding) map[string][]string {
+	byCheck := make(map[string][]string)
+	for _, f := range findings {
+		byCheck[f.check] = append(byCheck[f.check], f.level+": "+f.message)
+	}
+	return byCheck
+}
+
+func TestDoctor(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("healthy file", func(t *testing.T) {
+		filename := filepath.Join(dir, "app.prom")
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", filename, "jobs_total", "inc"}))
+		require.NoError(t, os.Chmod(filename, 0644))
+
+		findings := diagnose(filename, time.Second, nil)
+		for _, f := range findings {
+			assert.Equal(t, levelOK, f.level, "%s: %s", f.check, f.message)
+		}
+		byCheck := findingsByCheck(findings)
+		assert.Contains(t, byCheck["checksum"], "ok: trailer matches")
+		assert.Contains(t, byCheck, "clock")
+		require.NoError(t, createTestApp().Run([]string{"omet", "doctor", "-f", filename}))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		byCheck := findingsByCheck(diagnose(filepath.Join(dir, "missing.txt"), time.Second, nil))
+		assert.Contains(t, byCheck["exists"][0], "doesn't exist yet")
+		assert.Contains(t, byCheck["textfile"][0], "doesn't end in .prom")
+	})
+
+	t.Run("broken file fails", func(t *testing.T) {
+		filename := filepath.Join(dir, "broken.prom")
+		require.NoError(t, os.WriteFile(filename, []byte("broken {\n"), 0644))
+		byCheck := findingsByCheck(diagnose(filename, time.Second, nil))
+		assert.Contains(t, byCheck["parse"][0], "fail: ")
+		assert.ErrorContains(t, createTestApp().Run([]string{"omet", "doctor", "-f", filename}), "1 check(s) failed")
+	})
+
+	t.Run("clock skew", func(t *testing.T) {
+		filename := filepath.Join(dir, "skew.prom")
+		future := time.Now().Add(time.Hour).Unix()
+		require.NoError(t, os.WriteFile(filename, []byte(fmt.Sprintf("# TYPE omet_last_write gauge\nomet_last_write %d\n", future)), 0644))
+		byCheck := findingsByCheck(diagnose(filename, time.Second, nil))
+		assert.Contains(t, byCheck["clock"][0], "in the future")
+	})
+
+	t.Run("held lock and dead queue entries", func(t *testing.T) {
+		filename := filepath.Join(dir, "locked.prom")
+		require.NoError(t, os.WriteFile(filename, nil, 0644))
+		require.NoError(t, os.WriteFile(filename+".lockq", []byte("999999999 dead-writer\n"), 0644))
+		holder, err := metricsfile.NewFileLock(filename, time.Second)
+		require.NoError(t, err)
+		require.NoError(t, holder.Lock(context.Background()))
+		defer holder.Close()
+
+		lockFindings := findingsByCheck(diagnose(filename, 50*time.Millisecond, nil))["lock"]
+		require.Len(t, lockFindings, 2)
+		assert.Contains(t, lockFindings[0], "fail: another process has held the lock")
+		assert.Contains(t, lockFindings[1], "warn: 1 fair-lock queue entries belong to exited processes")
+	})
+
+	t.Run("encrypted file", func(t *testing.T) {
+		filename := filepath.Join(dir, "secret.prom")
+		key := make([]byte, metricsfile.KeySize)
+		sealed, err := metricsfile.Encrypt(key, []byte("# TYPE a gauge\na 1\n"))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filename, sealed, 0644))
+
+		assert.Contains(t, findingsByCheck(diagnose(filename, time.Second, nil))["parse"][0], "encrypted")
+		assert.Equal(t, "ok: 1 families", findingsByCheck(diagnose(filename, time.Second, key))["parse"][0])
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// encryptKeyFlag is --encrypt-key-file for commands that read metrics files
+// written by omet --encrypt-key-file.
+func encryptKeyFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "encrypt-key-file",
+		Usage: "Decrypt the metrics file with the AES-256 key in this file, as written by omet --encrypt-key-file",
+	}
+}
+
+// loadEncryptKey loads the --encrypt-key-file key, nil if none was given.
+func loadEncryptKey(ctx *cli.Context) ([]byte, error) {
+	path := ctx.String("encrypt-key-file")
+	if path == "" {
+		return nil, nil
+	}
+	key, err := metricsfile.LoadKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load --encrypt-key-file: %w", err)
+	}
+	return key, nil
+}
+
+// parseSealed parses metrics a command read, decrypting them with key as
+// metricsfile.Unseal does.
+func parseSealed(input io.Reader, key []byte) (map[string]*dto.MetricFamily, error) {
+	data, err := io.ReadAll(input)
+	if err == nil {
+		data, err = metricsfile.Unseal(key, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metricsfile.Parse(bytes.NewReader(data))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"omet/internal/metricsfile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptKeyFile(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, metricsfile.KeySize)
+	keyFile := filepath.Join(t.TempDir(), "omet.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600))
+	testFile := createTempFile(t, "# TYPE revenue_total counter\nrevenue_total 100\n")
+
+	run := func(args ...string) error {
+		return createTestApp().Run(append([]string{"omet", "--encrypt-key-file", keyFile, "--verify-write", "-i", "-f", testFile}, args...))
+	}
+	err := run("revenue_total", "inc", "5")
+	assert.ErrorIs(t, err, metricsfile.ErrNotEncrypted, "a plaintext file isn't read with a key")
+	require.NoError(t, run("--encrypt-plaintext", "revenue_total", "inc", "5"), "unless it is being converted")
+	require.NoError(t, run("revenue_total", "inc", "5"))
+
+	data, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.True(t, metricsfile.IsEncrypted(data))
+	assert.NotContains(t, string(data), "revenue_total")
+	plaintext, err := metricsfile.Decrypt(key, data)
+	require.NoError(t, err)
+	assert.Contains(t, string(plaintext), "revenue_total 110\n")
+	assert.True(t, metricsfile.Verify(plaintext))
+	assert.NoFileExists(t, testFile+journalSuffix, "the journal would hold values in plain text")
+
+	t.Run("wrong key leaves the file alone", func(t *testing.T) {
+		otherKey := filepath.Join(t.TempDir(), "other.key")
+		require.NoError(t, os.WriteFile(otherKey, bytes.Repeat([]byte{0x43}, metricsfile.KeySize), 0600))
+		err := createTestApp().Run([]string{"omet", "--encrypt-key-file", otherKey, "-i", "-f", testFile, "revenue_total", "inc"})
+		assert.ErrorIs(t, err, metricsfile.ErrDecrypt)
+
+		after, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, data, after)
+	})
+
+	t.Run("read-only commands decrypt", func(t *testing.T) {
+		for _, tt := range []struct {
+			args []string
+			want string
+		}{
+			{[]string{"stat", "-f", testFile}, "Families:"},
+			{[]string{"show", "--color", "never", "-f", testFile}, "revenue_total"},
+			{[]string{"grep", "-f", testFile, "revenue"}, "revenue_total 110"},
+			{[]string{"export", "-f", testFile}, "revenue_total"},
+			{[]string{"merge", testFile}, "revenue_total 110"},
+		} {
+			args := tt.args
+			var err error
+			output := captureOutput(t, func() {
+				err = createTestApp().Run(append([]string{"omet", args[0], "--encrypt-key-file", keyFile}, args[1:]...))
+			})
+			require.NoError(t, err, args[0])
+			assert.Contains(t, output, tt.want, args[0])
+
+			err = createTestApp().Run(append([]string{"omet"}, args...))
+			assert.ErrorIs(t, err, metricsfile.ErrEncrypted, "%s without a key", args[0])
+		}
+	})
+
+	t.Run("merge encrypts its output file", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "merged.prom")
+		require.NoError(t, createTestApp().Run([]string{"omet", "merge", "--encrypt-key-file", keyFile, "-o", output, testFile}))
+		merged, err := os.ReadFile(output)
+		require.NoError(t, err)
+		plaintext, err := metricsfile.Decrypt(key, merged)
+		require.NoError(t, err)
+		assert.Contains(t, string(plaintext), "revenue_total 110\n")
+	})
+
+	t.Run("audit records leave out values", func(t *testing.T) {
+		auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+		require.NoError(t, run("--audit-log", auditLog, "revenue_total", "inc", "129.99"))
+		record, err := os.ReadFile(auditLog)
+		require.NoError(t, err)
+		assert.NotContains(t, string(record), "129.99")
+		assert.Contains(t, string(record), `"before":null,"after":null`)
+		assert.Contains(t, string(record), `"encrypted":true`)
+	})
+
+	t.Run("invalid key file", func(t *testing.T) {
+		badKey := filepath.Join(t.TempDir(), "bad.key")
+		require.NoError(t, os.WriteFile(badKey, []byte("hunter2\n"), 0600))
+		err := createTestApp().Run([]string{"omet", "--encrypt-key-file", badKey, "-i", "-f", testFile, "revenue_total", "inc"})
+		assert.ErrorContains(t, err, "--encrypt-key-file")
+	})
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	"github.com/urfave/cli/v2"
+)
+
+func everyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "every",
+		Usage:     "Repeat an omet invocation at a fixed interval",
+		ArgsUsage: "<interval> [flags] -- <omet args>...",
+		Description: `Runs omet with the given arguments every interval from a single
+long-lived process, for sub-minute textfile updates cron can't schedule.
+Each wait is randomized by up to --jitter of the interval so a fleet started
+together drifts apart. Runs start on a fixed cadence: a run that takes a
+while shortens the next wait rather than delaying every later run.
+
+Each run takes the file lock as a one-off invocation would, so other
+writers interleave with it. With --hold-lock, the lock of each in-place file
+is taken by the first run that gets it and held until the loop stops:
+later runs skip locking, but other writers wait or time out meanwhile.
+--flush-interval and --flush-max-ops then batch runs: they update a
+staging copy of the file, which is written to the file once the interval
+has passed or that many runs are pending, and when the loop stops. That
+saves a rewrite per run, at the cost of the file lagging behind by up to
+the interval. A failed run is logged and the loop carries on.
+Values should come from --value-cmd, --value-from, or --from, since stdin
+is read once at most. SIGINT or SIGTERM stops the loop after the current
+run.
+
+Examples:
+  omet every 15s -- -i -f /var/lib/node_exporter/disk.prom --value-cmd 'df --output=avail / | tail -1' root_available_kbytes set
+  omet every 1m --jitter 0 -- -i -f app.prom heartbeat_total inc
+
+Flags for every itself may come before or after the interval, up to --.
+Without --, they are picked out of the arguments, and the rest is passed
+to omet.`,
+		Flags: []cli.Flag{
+			&cli.Float64Flag{
+				Name:  "jitter",
+				Value: 0.1,
+				Usage: "Randomize each wait by up to this fraction of the interval, in [0, 1)",
+			},
+			&cli.IntFlag{
+				Name:  "count",
+				Usage: "Stop after this many runs (default: run until interrupted)",
+			},
+			&cli.BoolFlag{
+				Name:  "hold-lock",
+				Usage: "Keep the lock of each in-place file from the first run until the loop stops",
+			},
+			&cli.DurationFlag{
+				Name:  "flush-interval",
+				Usage: "With --hold-lock, batch runs in a staging copy and write the file at most this often (default: after every run)",
+			},
+			&cli.IntFlag{
+				Name:  "flush-max-ops",
+				Usage: "With --hold-lock, write the file once this many runs are batched, even within --flush-interval",
+			},
+		},
+		Action: runEvery,
+	}
+}
+
+// everyArgs applies every's own flags among the arguments after the
+// interval, which urfave/cli leaves unparsed, and returns the omet
+// arguments. Up to --, only every's flags may appear; without --, they are
+// picked out wherever they are, since omet has none of the same names.
+func everyArgs(ctx *cli.Context, args []string) ([]string, error) {
+	end := slices.Index(args, "--")
+	var omet []string
+	for i := 0; i < len(args); i++ {
+		if i == end {
+			return append(omet, args[i+1:]...), nil
+		}
+		arg := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		var flag cli.Flag
+		if strings.HasPrefix(arg, "-") {
+			flag = lookupFlag(ctx.Command.Flags, name)
+		}
+		if flag == nil {
+			if end >= 0 {
+				return nil, fmt.Errorf("unexpected %s before --: omet every [flags] <interval> [flags] -- <omet args>", arg)
+			}
+			omet = append(omet, arg)
+			continue
+		}
+		if !hasValue {
+			if f, ok := flag.(cli.DocGenerationFlag); ok && !f.TakesValue() {
+				value = "true"
+			} else if i+1 < len(args) && i+1 != end {
+				i++
+				value = args[i]
+			} else {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+		}
+		if err := ctx.Set(flag.Names()[0], value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for flag %s: %w", value, arg, err)
+		}
+	}
+	return omet, nil
+}
+
+func lookupFlag(flags []cli.Flag, name string) cli.Flag {
+	for _, flag := range flags {
+		if slices.Contains(flag.Names(), name) {
+			return flag
+		}
+	}
+	return nil
+}
+
+// heldLocks keeps the locks of in-place files across the runs of omet
+// every --hold-lock, by filename: openTargets adds each lock it takes and
+// reuses it on later runs. It is nil otherwise, and every run locks its
+// files itself.
+var heldLocks map[string]*heldFile
+
+// stageHeldFiles makes runs write staging copies of the held files, which
+// flushHeldFiles writes back, for every --flush-interval and --flush-max-ops.
+var stageHeldFiles bool
+
+// heldFile is a file whose lock every --hold-lock keeps.
+type heldFile struct {
+	lock    *metricsfile.FileLock
+	staging *metricsfile.FileLock // the copy runs read and write; nil unless staging
+	dirty   bool                  // staging changed since the last flush
+	sync    metricsfile.SyncMode  // the strictest --sync of those changes
+}
+
+// holdLock keeps lock for later runs, with a staging copy of its file if
+// stageHeldFiles is set.
+func holdLock(filename string, lock *metricsfile.FileLock) (*heldFile, error) {
+	held := &heldFile{lock: lock}
+	if stageHeldFiles {
+		staging, err := os.CreateTemp("", "."+filepath.Base(filename)+".staging*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create staging copy of %s: %w", filename, err)
+		}
+		_, err = io.Copy(staging, io.NewSectionReader(lock.File(), 0, math.MaxInt64))
+		staging.Close()
+		if err == nil {
+			held.staging, err = metricsfile.NewFileLock(staging.Name(), 0)
+		}
+		if err != nil {
+			os.Remove(staging.Name())
+			return nil, fmt.Errorf("failed to create staging copy of %s: %w", filename, err)
+		}
+	}
+	heldLocks[filename] = held
+	return held, nil
+}
+
+// runLock returns the lock runs use: the staging copy's, if there is one.
+func (h *heldFile) runLock() *metricsfile.FileLock {
+	if h.staging != nil {
+		return h.staging
+	}
+	return h.lock
+}
+
+// staged records that a run rewrote the staging copy.
+func (h *heldFile) staged(mode metricsfile.SyncMode) {
+	h.dirty = true
+	h.sync = max(h.sync, mode)
+}
+
+// flush writes the staging copy to the file, if a run changed it.
+func (h *heldFile) flush() error {
+	if h.staging == nil || !h.dirty {
+		return nil
+	}
+	data, err := os.ReadFile(h.staging.File().Name())
+	if err != nil {
+		return err
+	}
+	err = h.lock.Rewrite(func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	})
+	if err == nil {
+		err = h.lock.Sync(h.sync)
+	}
+	if err != nil {
+		return err
+	}
+	h.dirty, h.sync = false, metricsfile.SyncNone
+	return nil
+}
+
+// release closes the held lock and removes the staging copy. Changes not
+// flushed yet are lost.
+func (h *heldFile) release() {
+	if h.staging != nil {
+		os.Remove(h.staging.File().Name())
+		h.staging.Close()
+	}
+	h.lock.Close()
+}
+
+// flushHeldFiles writes every changed staging copy to its file.
+func flushHeldFiles() error {
+	var errs []error
+	for filename, held := range heldLocks {
+		if err := held.flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush %s: %w", filename, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// releaseHeldLocks releases the locks collected by every --hold-lock.
+func releaseHeldLocks() {
+	for _, held := range heldLocks {
+		held.release()
+	}
+	heldLocks, stageHeldFiles = nil, false
+}
+
+func runEvery(ctx *cli.Context) error {
+	if ctx.NArg() < 2 {
+		return fmt.Errorf("every requires an interval and omet arguments, e.g. every 30s -- -i -f app.prom jobs_total inc")
+	}
+	interval, err := time.ParseDuration(ctx.Args().First())
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid interval %q: expected a positive duration such as 30s", ctx.Args().First())
+	}
+	args, err := everyArgs(ctx, ctx.Args().Slice()[1:])
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("every requires omet arguments after the interval, e.g. every 30s -- -i -f app.prom jobs_total inc")
+	}
+	jitter := ctx.Float64("jitter")
+	if jitter < 0 || jitter >= 1 {
+		return fmt.Errorf("invalid --jitter %g: must be in [0, 1)", jitter)
+	}
+	flushInterval, flushMaxOps := ctx.Duration("flush-interval"), ctx.Int("flush-max-ops")
+	if flushInterval < 0 || flushMaxOps < 0 {
+		return fmt.Errorf("--flush-interval and --flush-max-ops can't be negative")
+	}
+	batching := flushInterval > 0 || flushMaxOps > 0
+	if batching && !ctx.Bool("hold-lock") {
+		return fmt.Errorf("--flush-interval and --flush-max-ops need --hold-lock, since runs keep their changes from other writers until a flush")
+	}
+	if ctx.Bool("hold-lock") {
+		heldLocks, stageHeldFiles = make(map[string]*heldFile), batching
+		defer releaseHeldLocks()
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	count := ctx.Int("count")
+	runs, failed := 0, 0
+	pending, lastFlush := 0, time.Now()
+	for {
+		start := time.Now()
+		if err := newApp().Run(append([]string{"omet"}, args...)); err != nil {
+			log.Printf("WARN: run %d failed: %v", runs+1, err)
+			failed++
+		}
+		runs++
+		pending++
+		if batching && (flushMaxOps > 0 && pending >= flushMaxOps || flushInterval > 0 && time.Since(lastFlush) >= flushInterval) {
+			if err := flushHeldFiles(); err != nil {
+				// Staging keeps the changes for the next flush
+				log.Printf("WARN: %v", err)
+			} else {
+				pending, lastFlush = 0, time.Now()
+			}
+		}
+		if count > 0 && runs == count {
+			break
+		}
+		if !wait(stop, jitteredInterval(interval, jitter)-time.Since(start)) {
+			break
+		}
+	}
+
+	if err := flushHeldFiles(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d runs failed", failed, runs)
+	}
+	return nil
+}
+
+// jitteredInterval returns interval moved by a random amount of up to
+// jitter times itself in either direction.
+func jitteredInterval(interval time.Duration, jitter float64) time.Duration {
+	return interval + time.Duration((rand.Float64()*2-1)*jitter*float64(interval))
+}
+
+// wait sleeps for d, returning false if ctx is done first.
+func wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
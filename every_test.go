@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvery(t *testing.T) {
+	t.Run("repeats the invocation", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "every", "--count", "3", "--jitter", "0", "10ms", "--", "-i", "-f", testFile, "heartbeat_total", "inc"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "heartbeat_total 3")
+	})
+
+	t.Run("carries on after a failed run", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "every", "--count", "2", "10ms", "--", "-i", "-f", testFile, "bad-name", "inc"})
+		assert.ErrorContains(t, err, "2 of 2 runs failed")
+	})
+
+	t.Run("rejects invalid arguments", func(t *testing.T) {
+		assert.Error(t, createTestApp().Run([]string{"omet", "every", "soon", "--", "-i", "jobs_total", "inc"}))
+		assert.Error(t, createTestApp().Run([]string{"omet", "every", "30s"}))
+		assert.Error(t, createTestApp().Run([]string{"omet", "every", "--jitter", "1.5", "30s", "--", "jobs_total", "inc"}))
+	})
+
+	t.Run("accepts its own flags after the interval", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		require.NoError(t, createTestApp().Run([]string{"omet", "every", "10ms", "--jitter", "0", "--count", "2", "--", "-i", "-f", testFile, "heartbeat_total", "inc"}))
+		require.NoError(t, createTestApp().Run([]string{"omet", "every", "10ms", "-i", "-f", testFile, "--count=2", "heartbeat_total", "inc"}))
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "heartbeat_total 4")
+
+		err = createTestApp().Run([]string{"omet", "every", "1m", "-i", "--", "jobs_total", "inc"})
+		assert.ErrorContains(t, err, "unexpected -i before --")
+	})
+
+	t.Run("holds the lock between runs", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		locked := make(chan error, 1)
+		go func() {
+			// Between the first and second run
+			time.Sleep(100 * time.Millisecond)
+			lock, err := metricsfile.NewFileLock(testFile, 10*time.Millisecond)
+			if err == nil {
+				err = lock.Lock(context.Background())
+				lock.Close()
+			}
+			locked <- err
+		}()
+
+		err := createTestApp().Run([]string{"omet", "every", "--hold-lock", "--jitter", "0", "--count", "2", "200ms", "--", "-i", "-f", testFile, "heartbeat_total", "inc"})
+		require.NoError(t, err)
+		var timeout *metricsfile.TimeoutError
+		assert.ErrorAs(t, <-locked, &timeout)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "heartbeat_total 2")
+
+		// Released once the loop stops
+		lock, err := metricsfile.NewFileLock(testFile, 10*time.Millisecond)
+		require.NoError(t, err)
+		defer lock.Close()
+		assert.NoError(t, lock.Lock(context.Background()))
+	})
+
+	t.Run("batches runs between flushes", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		seen := make(chan string, 2)
+		go func() {
+			// After the first run, then after the second
+			for _, delay := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+				time.Sleep(delay)
+				content, _ := os.ReadFile(testFile)
+				seen <- string(content)
+			}
+		}()
+
+		err := createTestApp().Run([]string{"omet", "every", "--hold-lock", "--flush-max-ops", "2", "--jitter", "0", "--count", "3", "200ms", "--", "-i", "-f", testFile, "heartbeat_total", "inc"})
+		require.NoError(t, err)
+		assert.NotContains(t, <-seen, "heartbeat_total", "the first run is only staged")
+		assert.Contains(t, <-seen, "heartbeat_total 2\n", "the second run flushes both")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "heartbeat_total 3\n", "the rest is flushed when the loop stops")
+		assert.True(t, metricsfile.Verify(content))
+	})
+
+	t.Run("batching needs the lock held", func(t *testing.T) {
+		err := createTestApp().Run([]string{"omet", "every", "--flush-interval", "1m", "30s", "--", "-i", "jobs_total", "inc"})
+		assert.ErrorContains(t, err, "need --hold-lock")
+	})
+}
+
+func TestJitteredInterval(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitteredInterval(time.Second, 0.1)
+		assert.GreaterOrEqual(t, d, 900*time.Millisecond)
+		assert.LessOrEqual(t, d, 1100*time.Millisecond)
+	}
+	assert.Equal(t, time.Second, jitteredInterval(time.Second, 0))
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"omet/internal/backfill"
+	"omet/internal/parquet"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+func exportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Export samples as a table for offline analysis",
+		Description: `Writes one row per exposed sample with typed columns:
+
+  metric        family name
+  sample        sample name (with _bucket, _sum, or _count for histograms
+                and summaries)
+  type          family type
+  labels        labels as a JSON object, without le and quantile
+  le            histogram bucket bound (null otherwise)
+  quantile      summary quantile (null otherwise)
+  value         sample value
+  timestamp_ms  sample timestamp, if the file has one (null otherwise)
+
+Parquet files load directly into DuckDB, Spark, or pandas.
+
+With --for-backfill, writes an OpenMetrics exposition for "promtool tsdb
+create-blocks-from openmetrics" instead: every sample timestamped, series
+grouped with their points in time order, and a closing "# EOF". Samples
+without a timestamp take the file's modification time, and counter, gauge,
+and untyped series also get the values recorded in the file's --history.
+The result is validated before anything is written.
+
+Examples:
+  omet export -f app.prom --format parquet -o app.parquet
+  duckdb -c "SELECT metric, sum(value) FROM 'app.parquet' GROUP BY metric"
+  omet export -f app.prom --format csv | column -s, -t
+  omet export -f app.prom --for-backfill -o backfill.prom`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "Metrics file to export (default: stdin)",
+				Value:   "-",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format: csv or parquet",
+				Value: "csv",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Write to this file (default: stdout)",
+			},
+			&cli.BoolFlag{
+				Name:  "for-backfill",
+				Usage: "Write timestamped OpenMetrics for promtool tsdb create-blocks-from openmetrics",
+			},
+			encryptKeyFlag(),
+		},
+		Action: runExport,
+	}
+}
+
+// exportRow is one exposed sample.
+type exportRow struct {
+	metric      string
+	sample      string
+	metricType  string
+	labels      string
+	le          *float64
+	quantile    *float64
+	value       float64
+	timestampMs *int64
+}
+
+var exportColumns = []parquet.Column{
+	{Name: "metric", Type: parquet.String},
+	{Name: "sample", Type: parquet.String},
+	{Name: "type", Type: parquet.String},
+	{Name: "labels", Type: parquet.String},
+	{Name: "le", Type: parquet.Double, Optional: true},
+	{Name: "quantile", Type: parquet.Double, Optional: true},
+	{Name: "value", Type: parquet.Double},
+	{Name: "timestamp_ms", Type: parquet.Int64, Optional: true},
+}
+
+func runExport(ctx *cli.Context) error {
+	format := ctx.String("format")
+	if format != "csv" && format != "parquet" {
+		return fmt.Errorf("invalid --format: %s (supported: csv, parquet)", format)
+	}
+
+	forBackfill := ctx.Bool("for-backfill")
+	if forBackfill && ctx.IsSet("format") {
+		return fmt.Errorf("--for-backfill always writes OpenMetrics; drop --format")
+	}
+
+	key, err := loadEncryptKey(ctx)
+	if err != nil {
+		return err
+	}
+	filename := ctx.String("file")
+	var input io.Reader = os.Stdin
+	modified := timeProvider.Now()
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", filename, err)
+		}
+		defer file.Close()
+		if stat, err := file.Stat(); err == nil {
+			modified = stat.ModTime()
+		}
+		input = file
+	}
+	families, err := parseSealed(input, key)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	var rows []exportRow
+	var backfillFile *backfill.File
+	if forBackfill {
+		var history []historyPoint
+		if filename != "-" {
+			if history, err = readAllHistory(filename); err != nil {
+				return err
+			}
+		}
+		if backfillFile, err = backfillFromFamilies(families, history, modified.UnixMilli()); err != nil {
+			return err
+		}
+	} else if rows, err = exportRows(families); err != nil {
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if path := ctx.String("output"); path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		defer file.Close()
+		output = file
+	}
+
+	if backfillFile != nil {
+		_, err := backfillFile.WriteTo(output)
+		return err
+	}
+	if format == "parquet" {
+		return writeParquet(rows, output)
+	}
+	return writeCSV(rows, output)
+}
+
+// exportRows flattens families into samples, sorted by family name, in
+// the order series appear within each family.
+func exportRows(families map[string]*dto.MetricFamily) ([]exportRow, error) {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows []exportRow
+	for _, name := range names {
+		family := families[name]
+		metricType := typeName(family)
+		for _, metric := range family.Metric {
+			labels := make(map[string]string, len(metric.Label))
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			encoded, err := json.Marshal(labels)
+			if err != nil {
+				return nil, err
+			}
+			base := exportRow{metric: name, sample: name, metricType: metricType, labels: string(encoded), timestampMs: metric.TimestampMs}
+			add := func(suffix string, value float64, le, quantile *float64) {
+				row := base
+				row.sample = name + suffix
+				row.value, row.le, row.quantile = value, le, quantile
+				rows = append(rows, row)
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := metric.GetHistogram()
+				for _, bucket := range h.GetBucket() {
+					add("_bucket", float64(bucket.GetCumulativeCount()), float64Ptr(bucket.GetUpperBound()), nil)
+				}
+				if n := len(h.GetBucket()); n == 0 || !math.IsInf(h.GetBucket()[n-1].GetUpperBound(), 1) {
+					add("_bucket", float64(h.GetSampleCount()), float64Ptr(math.Inf(1)), nil)
+				}
+				countSuffix, sumSuffix := histogramSuffixes(family)
+				add(sumSuffix, h.GetSampleSum(), nil, nil)
+				add(countSuffix, float64(h.GetSampleCount()), nil, nil)
+			case dto.MetricType_SUMMARY:
+				s := metric.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), nil, float64Ptr(q.GetQuantile()))
+				}
+				add("_sum", s.GetSampleSum(), nil, nil)
+				add("_count", float64(s.GetSampleCount()), nil, nil)
+			case dto.MetricType_COUNTER:
+				add("", metric.GetCounter().GetValue(), nil, nil)
+			case dto.MetricType_GAUGE:
+				add("", metric.GetGauge().GetValue(), nil, nil)
+			default:
+				add("", metric.GetUntyped().GetValue(), nil, nil)
+			}
+		}
+	}
+	return rows, nil
+}
+
+func writeParquet(rows []exportRow, output io.Writer) error {
+	w := parquet.NewWriter(exportColumns)
+	for _, row := range rows {
+		values := []any{row.metric, row.sample, row.metricType, row.labels, nil, nil, row.value, nil}
+		if row.le != nil {
+			values[4] = *row.le
+		}
+		if row.quantile != nil {
+			values[5] = *row.quantile
+		}
+		if row.timestampMs != nil {
+			values[7] = *row.timestampMs
+		}
+		if err := w.Append(values...); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteTo(output)
+	return err
+}
+
+func writeCSV(rows []exportRow, output io.Writer) error {
+	w := csv.NewWriter(output)
+	header := make([]string, len(exportColumns))
+	for i, column := range exportColumns {
+		header[i] = column.Name
+	}
+	w.Write(header)
+
+	optional := func(value *float64) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*value, 'g', -1, 64)
+	}
+	for _, row := range rows {
+		timestamp := ""
+		if row.timestampMs != nil {
+			timestamp = strconv.FormatInt(*row.timestampMs, 10)
+		}
+		w.Write([]string{row.metric, row.sample, row.metricType, row.labels, optional(row.le), optional(row.quantile), strconv.FormatFloat(row.value, 'g', -1, 64), timestamp})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// backfillFromFamilies converts families into a backfill exposition. A
+// sample without a timestamp is placed at defaultMs. History points are
+// added for the counter, gauge, and untyped series still in the file.
+func backfillFromFamilies(families map[string]*dto.MetricFamily, history []historyPoint, defaultMs int64) (*backfill.File, error) {
+	bySeries := make(map[string][]historyPoint)
+	for _, point := range history {
+		bySeries[point.series] = append(bySeries[point.series], point)
+	}
+
+	f := backfill.NewFile()
+	for name, family := range families {
+		metricType := typeName(family)
+		for _, metric := range family.Metric {
+			labels := make(map[string]string, len(metric.Label))
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			add := func(timestampMs int64, samples ...backfill.Sample) error {
+				return f.Add(name, metricType, family.GetHelp(), labels, backfill.Point{TimestampMs: timestampMs, Samples: samples})
+			}
+
+			var samples []backfill.Sample
+			switch family.GetType() {
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := metric.GetHistogram()
+				for _, bucket := range h.GetBucket() {
+					samples = append(samples, backfill.Sample{Suffix: "_bucket", Bound: backfill.FormatBound(bucket.GetUpperBound()), Value: float64(bucket.GetCumulativeCount())})
+				}
+				if n := len(h.GetBucket()); n == 0 || !math.IsInf(h.GetBucket()[n-1].GetUpperBound(), 1) {
+					samples = append(samples, backfill.Sample{Suffix: "_bucket", Bound: "+Inf", Value: float64(h.GetSampleCount())})
+				}
+				countSuffix, sumSuffix := histogramSuffixes(family)
+				samples = append(samples,
+					backfill.Sample{Suffix: countSuffix, Value: float64(h.GetSampleCount())},
+					backfill.Sample{Suffix: sumSuffix, Value: h.GetSampleSum()})
+			case dto.MetricType_SUMMARY:
+				s := metric.GetSummary()
+				for _, q := range s.GetQuantile() {
+					samples = append(samples, backfill.Sample{Bound: backfill.FormatBound(q.GetQuantile()), Value: q.GetValue()})
+				}
+				samples = append(samples,
+					backfill.Sample{Suffix: "_count", Value: float64(s.GetSampleCount())},
+					backfill.Sample{Suffix: "_sum", Value: s.GetSampleSum()})
+			default:
+				suffix, value := "", metric.GetUntyped().GetValue()
+				switch family.GetType() {
+				case dto.MetricType_COUNTER:
+					suffix, value = "_total", metric.GetCounter().GetValue()
+				case dto.MetricType_GAUGE:
+					value = metric.GetGauge().GetValue()
+				}
+				for _, point := range bySeries[formatSeries(name, labels)] {
+					if err := add(point.time*1000, backfill.Sample{Suffix: suffix, Value: point.value}); err != nil {
+						return nil, err
+					}
+				}
+				samples = []backfill.Sample{{Suffix: suffix, Value: value}}
+			}
+
+			timestampMs := defaultMs
+			if metric.TimestampMs != nil {
+				timestampMs = metric.GetTimestampMs()
+			}
+			if err := add(timestampMs, samples...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("cannot export for backfill: %w", err)
+	}
+	return f, nil
+}
+
+// histogramSuffixes returns the suffixes of a histogram family's count and
+// sum samples, which gauge histograms write as _gcount and _gsum.
+func histogramSuffixes(family *dto.MetricFamily) (count, sum string) {
+	if family.GetType() == dto.MetricType_GAUGE_HISTOGRAM {
+		return "_gcount", "_gsum"
+	}
+	return "_count", "_sum"
+}
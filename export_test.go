@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"omet/internal/backfill"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const exportMetrics = `# TYPE jobs_total counter
+jobs_total{job="db"} 3 1700000000000
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 1
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 1.5
+latency_seconds_count 2
+# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.99"} 0.2
+rpc_seconds_sum 4
+rpc_seconds_count 10
+`
+
+func TestExport(t *testing.T) {
+	testFile := createTempFile(t, exportMetrics)
+
+	t.Run("csv", func(t *testing.T) {
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "export", "-f", testFile}))
+		})
+		assert.Equal(t, `metric,sample,type,labels,le,quantile,value,timestamp_ms
+jobs_total,jobs_total,counter,"{""job"":""db""}",,,3,1700000000000
+latency_seconds,latency_seconds_bucket,histogram,{},0.5,,1,
+latency_seconds,latency_seconds_bucket,histogram,{},+Inf,,2,
+latency_seconds,latency_seconds_sum,histogram,{},,,1.5,
+latency_seconds,latency_seconds_count,histogram,{},,,2,
+rpc_seconds,rpc_seconds,summary,{},,0.99,0.2,
+rpc_seconds,rpc_seconds_sum,summary,{},,,4,
+rpc_seconds,rpc_seconds_count,summary,{},,,10,
+`, output)
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "out.parquet")
+		require.NoError(t, createTestApp().Run([]string{"omet", "export", "-f", testFile, "--format", "parquet", "-o", output}))
+
+		data, err := os.ReadFile(output)
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(data, []byte("PAR1")))
+		assert.True(t, bytes.HasSuffix(data, []byte("PAR1")))
+		assert.Contains(t, string(data), "latency_seconds_bucket")
+		assert.Contains(t, string(data), "timestamp_ms")
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		err := createTestApp().Run([]string{"omet", "export", "-f", testFile, "--format", "xlsx"})
+		assert.ErrorContains(t, err, "supported: csv, parquet")
+	})
+
+	t.Run("for backfill", func(t *testing.T) {
+		backfillFile := createTempFile(t, exportMetrics)
+		modified := time.Unix(1700000100, 0)
+		require.NoError(t, os.Chtimes(backfillFile, modified, modified))
+		history := "1699999900\tjobs_total{job=\"db\"}\t1\n1699999900\tgone_total\t7\n"
+		require.NoError(t, os.WriteFile(backfillFile+historySuffix, []byte(history), 0644))
+
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "export", "-f", backfillFile, "--for-backfill"}))
+		})
+		assert.Equal(t, `# TYPE jobs counter
+jobs_total{job="db"} 1 1699999900
+jobs_total{job="db"} 3 1700000000
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 1 1700000100
+latency_seconds_bucket{le="+Inf"} 2 1700000100
+latency_seconds_count 2 1700000100
+latency_seconds_sum 1.5 1700000100
+# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.99"} 0.2 1700000100
+rpc_seconds_count 10 1700000100
+rpc_seconds_sum 4 1700000100
+# EOF
+`, output, "history of series no longer in the file is left out")
+
+		_, err := backfill.Read(strings.NewReader(output))
+		assert.NoError(t, err)
+
+		err = createTestApp().Run([]string{"omet", "export", "-f", backfillFile, "--for-backfill", "--format", "csv"})
+		assert.Error(t, err)
+	})
+
+}
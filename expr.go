@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"omet/internal/selector"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// expression is an arithmetic expression over series in the same file, as
+// used by --from: numbers, series references such as
+// disk_free_bytes{mount="/"}, + - * /, and parentheses.
+type expression interface {
+	eval(families map[string]*dto.MetricFamily) (float64, error)
+}
+
+type numberExpr float64
+
+type seriesExpr struct {
+	name     string
+	selector selector.Selector
+}
+
+type unaryExpr struct {
+	operand expression
+}
+
+type binaryExpr struct {
+	op          byte
+	left, right expression
+}
+
+func (n numberExpr) eval(map[string]*dto.MetricFamily) (float64, error) {
+	return float64(n), nil
+}
+
+// eval resolves the reference to exactly one series with a plain value.
+func (s seriesExpr) eval(families map[string]*dto.MetricFamily) (float64, error) {
+	family, exists := families[s.name]
+	if !exists {
+		return 0, fmt.Errorf("metric %s not found", s.name)
+	}
+
+	var matched []*dto.Metric
+	for _, metric := range family.Metric {
+		if s.selector.Matches(metric.Label) {
+			matched = append(matched, metric)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return 0, fmt.Errorf("no series of %s matches %s", s.name, s.selector)
+	case 1:
+	default:
+		return 0, fmt.Errorf("%d series of %s match %s, expected one", len(matched), s.name, s.selector)
+	}
+
+	metric := matched[0]
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return metric.GetCounter().GetValue(), nil
+	case dto.MetricType_GAUGE:
+		return metric.GetGauge().GetValue(), nil
+	case dto.MetricType_UNTYPED:
+		return metric.GetUntyped().GetValue(), nil
+	default:
+		return 0, fmt.Errorf("metric %s is a %s and has no single value", s.name, typeName(family))
+	}
+}
+
+func (u unaryExpr) eval(families map[string]*dto.MetricFamily) (float64, error) {
+	value, err := u.operand.eval(families)
+	return -value, err
+}
+
+func (b binaryExpr) eval(families map[string]*dto.MetricFamily) (float64, error) {
+	left, err := b.left.eval(families)
+	if err != nil {
+		return 0, err
+	}
+	right, err := b.right.eval(families)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	default:
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	}
+}
+
+// parseExpression parses a --from expression.
+func parseExpression(input string) (expression, error) {
+	p := &exprParser{input: input}
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", input, err)
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at offset %d", input, p.input[p.pos:], p.pos)
+	}
+	return expr, nil
+}
+
+// exprParser is a recursive descent parser with the usual precedence:
+// sum := product (('+'|'-') product)*, product := factor (('*'|'/') factor)*.
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *exprParser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (expression, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseFactor() (expression, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '-':
+		p.pos++
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{operand: operand}, nil
+	case c == '(':
+		p.pos++
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case c == '.' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.input) && strings.IndexByte("0123456789.eE", p.input[p.pos]) >= 0 {
+			// Allow an exponent sign, as in 1e-3
+			if (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') && p.pos+1 < len(p.input) && (p.input[p.pos+1] == '-' || p.input[p.pos+1] == '+') {
+				p.pos++
+			}
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+		}
+		return numberExpr(value), nil
+	case c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return p.parseSeries()
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+	}
+}
+
+func (p *exprParser) parseSeries() (expression, error) {
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if !(c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			break
+		}
+		p.pos++
+	}
+	series := seriesExpr{name: p.input[start:p.pos]}
+
+	if p.pos < len(p.input) && p.input[p.pos] == '{' {
+		// Find the closing brace, skipping quoted label values
+		end := p.pos + 1
+		for inQuote := false; end < len(p.input); end++ {
+			c := p.input[end]
+			if inQuote && c == '\\' {
+				end++
+			} else if c == '"' {
+				inQuote = !inQuote
+			} else if c == '}' && !inQuote {
+				break
+			}
+		}
+		if end >= len(p.input) {
+			return nil, fmt.Errorf("missing closing brace for %s", series.name)
+		}
+
+		sel, err := selector.Parse(p.input[p.pos : end+1])
+		if err != nil {
+			return nil, err
+		}
+		series.selector = sel
+		p.pos = end + 1
+	}
+
+	return series, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const exprMetrics = `# TYPE disk_free_bytes gauge
+disk_free_bytes{mount="/"} 25
+disk_free_bytes{mount="/home"} 10
+# TYPE disk_total_bytes gauge
+disk_total_bytes{mount="/"} 100
+disk_total_bytes{mount="/home"} 40
+# TYPE requests_total counter
+requests_total 8
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="+Inf"} 1
+latency_seconds_sum 1
+latency_seconds_count 1
+`
+
+func TestParseExpression(t *testing.T) {
+	families, err := parseMetrics(strings.NewReader(exprMetrics))
+	require.NoError(t, err)
+
+	tests := []struct {
+		expr     string
+		expected float64
+		wantErr  string
+	}{
+		{expr: `disk_free_bytes{mount="/"} / disk_total_bytes{mount="/"}`, expected: 0.25},
+		{expr: `1 - disk_free_bytes{mount="/home"} / disk_total_bytes{mount="/home"}`, expected: 0.75},
+		{expr: `(requests_total + 2) * 10`, expected: 100},
+		{expr: `-requests_total`, expected: -8},
+		{expr: `requests_total * 1e-3`, expected: 0.008},
+		{expr: `2 + 3 * 4`, expected: 14},
+		{expr: `disk_free_bytes / 2`, wantErr: "2 series of disk_free_bytes match"},
+		{expr: `missing_metric`, wantErr: "not found"},
+		{expr: `disk_free_bytes{mount="/var"}`, wantErr: "no series"},
+		{expr: `latency_seconds`, wantErr: "no single value"},
+		{expr: `requests_total / 0`, wantErr: "division by zero"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parseExpression(tt.expr)
+			require.NoError(t, err)
+
+			value, err := expr.eval(families)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, value, 1e-12)
+		})
+	}
+
+	for _, invalid := range []string{"", "1 +", "(1 + 2", "a{mount=\"/\"", "1 2", "a{mount=/}", "$x"} {
+		_, err := parseExpression(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestFromFlag(t *testing.T) {
+	testFile := createTempFile(t, exprMetrics)
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "mount=/", "--from", `disk_free_bytes{mount="/"} / disk_total_bytes{mount="/"}`, "disk_free_ratio", "set"})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `disk_free_ratio{mount="/"} 0.25`)
+
+	err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--from", `missing_metric`, "disk_free_ratio", "set"})
+	assert.ErrorContains(t, err, "failed to evaluate --from")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+func grepCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "grep",
+		Usage:     "Search metric names, labels, and HELP text",
+		ArgsUsage: "<pattern>",
+		Description: `Prints the series whose metric name, label names, or label values match
+the pattern (a regular expression), with their family's HELP and TYPE
+lines. Labels are also matched as written in the file, so job="backup"
+finds the series with that label. A family whose name or HELP text matches is printed whole. Sample
+values are not searched.
+
+Exits with an error if nothing matches.
+
+Examples:
+  omet grep -f /var/lib/node_exporter/app.prom backup
+  omet grep -f app.prom -i 'disk|filesystem'
+  omet grep -f app.prom -F 'job="backup"'`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "Metrics file to search (default: stdin)",
+				Value:   "-",
+			},
+			&cli.BoolFlag{
+				Name:    "ignore-case",
+				Aliases: []string{"i"},
+				Usage:   "Match case-insensitively",
+			},
+			&cli.BoolFlag{
+				Name:    "fixed-strings",
+				Aliases: []string{"F"},
+				Usage:   "Treat the pattern as a literal string",
+			},
+			encryptKeyFlag(),
+		},
+		Action: runGrep,
+	}
+}
+
+func runGrep(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("grep requires exactly one pattern")
+	}
+	pattern := ctx.Args().First()
+	if ctx.Bool("fixed-strings") {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if ctx.Bool("ignore-case") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	key, err := loadEncryptKey(ctx)
+	if err != nil {
+		return err
+	}
+	filename := ctx.String("file")
+	var input io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", filename, err)
+		}
+		defer file.Close()
+		input = file
+	}
+	families, err := parseSealed(input, key)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	matched := grepFamilies(families, re)
+	if len(matched) == 0 {
+		return fmt.Errorf("no metrics match %q", ctx.Args().First())
+	}
+	return writeMetrics(matched, os.Stdout)
+}
+
+// grepFamilies returns the families whose name or HELP matches re, and for
+// the rest, the series with a matching label name, value, or name="value"
+// pair as the file spells it.
+func grepFamilies(families map[string]*dto.MetricFamily, re *regexp.Regexp) map[string]*dto.MetricFamily {
+	matched := make(map[string]*dto.MetricFamily)
+	for name, family := range families {
+		if re.MatchString(name) || re.MatchString(family.GetHelp()) {
+			matched[name] = family
+			continue
+		}
+
+		var metrics []*dto.Metric
+		for _, metric := range family.Metric {
+			for _, label := range metric.Label {
+				if re.MatchString(label.GetName()) || re.MatchString(label.GetValue()) || re.MatchString(renderLabel(label)) {
+					metrics = append(metrics, metric)
+					break
+				}
+			}
+		}
+		if len(metrics) > 0 {
+			matched[name] = &dto.MetricFamily{
+				Name:   family.Name,
+				Help:   family.Help,
+				Type:   family.Type,
+				Unit:   family.Unit,
+				Metric: metrics,
+			}
+		}
+	}
+	return matched
+}
+
+// grepLabelEscaper escapes a label value as the text format does.
+var grepLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// renderLabel formats a label as it appears in the file, e.g. job="backup".
+func renderLabel(label *dto.LabelPair) string {
+	return label.GetName() + `="` + grepLabelEscaper.Replace(label.GetValue()) + `"`
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrep(t *testing.T) {
+	testFile := createTempFile(t, `# HELP backups_total Total number of backups
+# TYPE backups_total counter
+backups_total{job="db"} 3
+# HELP jobs_total Jobs run by the scheduler
+# TYPE jobs_total counter
+jobs_total{job="backup"} 1
+jobs_total{job="cleanup"} 2
+# HELP disk_free_bytes Free space per mount
+# TYPE disk_free_bytes gauge
+disk_free_bytes{mount="/Backup"} 100
+`)
+	grep := func(args ...string) (string, error) {
+		var err error
+		output := captureOutput(t, func() {
+			err = createTestApp().Run(append([]string{"omet", "grep", "-f", testFile}, args...))
+		})
+		return output, err
+	}
+
+	t.Run("matches names, label values, and help", func(t *testing.T) {
+		output, err := grep("backup")
+		require.NoError(t, err)
+		assert.Contains(t, output, `backups_total{job="db"} 3`, "name match prints the whole family")
+		assert.Contains(t, output, "# HELP jobs_total Jobs run by the scheduler")
+		assert.Contains(t, output, `jobs_total{job="backup"} 1`)
+		assert.NotContains(t, output, "cleanup", "non-matching series are left out")
+		assert.NotContains(t, output, "disk_free_bytes", "matching is case-sensitive")
+
+		output, err = grep("scheduler")
+		require.NoError(t, err)
+		assert.Contains(t, output, `jobs_total{job="cleanup"} 2`, "help match prints the whole family")
+	})
+
+	t.Run("ignore case and fixed strings", func(t *testing.T) {
+		output, err := grep("-i", "BACKUP")
+		require.NoError(t, err)
+		assert.Contains(t, output, `disk_free_bytes{mount="/Backup"} 100`)
+
+		output, err = grep("-F", "/Backup")
+		require.NoError(t, err)
+		assert.Contains(t, output, "disk_free_bytes")
+		assert.NotContains(t, output, "jobs_total")
+	})
+
+	t.Run("name and value pairs", func(t *testing.T) {
+		output, err := grep("-F", `job="backup"`)
+		require.NoError(t, err)
+		assert.Contains(t, output, `jobs_total{job="backup"} 1`)
+		assert.NotContains(t, output, "backups_total")
+		assert.NotContains(t, output, "cleanup")
+
+		output, err = grep(`mount="/[A-Z]`)
+		require.NoError(t, err)
+		assert.Contains(t, output, "disk_free_bytes")
+	})
+
+	t.Run("values are not searched", func(t *testing.T) {
+		_, err := grep("100")
+		assert.ErrorContains(t, err, "no metrics match")
+	})
+}
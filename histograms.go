@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// checkHistograms looks for inconsistent histogram series, as other tools
+// may write them, and warns about each unless quiet. With repair, the ones
+// that can be are fixed instead; see metricsfile.RepairHistogram.
+func checkHistograms(families map[string]*dto.MetricFamily, filename string, repair, quiet, verbose bool) {
+	for _, series := range inconsistentHistograms(families) {
+		problems := strings.Join(series.problems, "; ")
+		switch {
+		case repair && metricsfile.RepairHistogram(series.histogram):
+			if verbose {
+				log.Printf("Repaired histogram %s in %s: %s", series.name, filename, problems)
+			}
+		case repair && !quiet:
+			log.Printf("WARN: can't repair histogram %s in %s: %s", series.name, filename, problems)
+		case !quiet:
+			log.Printf("WARN: histogram %s in %s is inconsistent: %s (use --repair-histograms to fix)", series.name, filename, problems)
+		}
+	}
+}
+
+// histogramProblems is an inconsistent histogram series.
+type histogramProblems struct {
+	name      string
+	histogram *dto.Histogram
+	problems  []string
+}
+
+// inconsistentHistograms checks every histogram and gauge histogram series,
+// returning those with problems in name order.
+func inconsistentHistograms(families map[string]*dto.MetricFamily) []histogramProblems {
+	var found []histogramProblems
+	for name, family := range families {
+		if family.GetType() != dto.MetricType_HISTOGRAM && family.GetType() != dto.MetricType_GAUGE_HISTOGRAM {
+			continue
+		}
+		for _, metric := range family.Metric {
+			if problems := metricsfile.CheckHistogram(metric.GetHistogram()); len(problems) > 0 {
+				series := name
+				if len(metric.Label) > 0 {
+					series += labelString(metric.Label)
+				}
+				found = append(found, histogramProblems{name: series, histogram: metric.GetHistogram(), problems: problems})
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].name < found[j].name })
+	return found
+}
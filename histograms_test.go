@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairHistograms(t *testing.T) {
+	// Written by another tool: a decreasing bucket and a stale _count
+	const broken = `# TYPE rpc_seconds histogram
+rpc_seconds_bucket{method="get",le="0.1"} 4
+rpc_seconds_bucket{method="get",le="1"} 3
+rpc_seconds_bucket{method="get",le="+Inf"} 6
+rpc_seconds_count{method="get"} 5
+rpc_seconds_sum{method="get"} 2.5
+`
+	run := func(t *testing.T, testFile string, flags ...string) {
+		t.Helper()
+		args := append([]string{"omet", "-i", "-q", "-f", testFile}, flags...)
+		require.NoError(t, createTestApp().Run(append(args, "jobs_total", "inc")))
+	}
+	problems := func(t *testing.T, testFile string) []histogramProblems {
+		t.Helper()
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		return inconsistentHistograms(families)
+	}
+
+	t.Run("left alone by default", func(t *testing.T) {
+		testFile := createTempFile(t, broken)
+		run(t, testFile)
+		found := problems(t, testFile)
+		require.Len(t, found, 1)
+		assert.Equal(t, `rpc_seconds{method="get"}`, found[0].name)
+		assert.Equal(t, []string{`bucket le="1" count 3 is below le="0.1" count 4`, `le="+Inf" count 6 doesn't match _count 5`}, found[0].problems)
+	})
+
+	t.Run("repaired with --repair-histograms", func(t *testing.T) {
+		testFile := createTempFile(t, broken)
+		run(t, testFile, "--repair-histograms")
+		assert.Empty(t, problems(t, testFile))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, _ := seriesValue(families, "rpc_seconds", map[string]string{"method": "get"})
+		assert.Equal(t, 6.0, value, "_count is raised to the +Inf bucket")
+	})
+
+	t.Run("doctor warns", func(t *testing.T) {
+		testFile := createTempFile(t, broken)
+		findings := findingsByCheck(diagnose(testFile, 0, nil))
+		require.Len(t, findings["histograms"], 1)
+		assert.Contains(t, findings["histograms"][0], `warn: rpc_seconds{method="get"}: bucket le="1" count 3`)
+	})
+}
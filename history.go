@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// historySuffix names the file next to a metrics file that records the
+// values written by --history runs. Lines are "<unix time>\t<series>\t<value>".
+const historySuffix = ".history"
+
+// Values kept per file unless --history-size says otherwise
+const defaultHistorySize = 1000
+
+// historyPoint is one recorded value of a series.
+type historyPoint struct {
+	time   int64
+	series string
+	value  float64
+}
+
+// appendHistory records the value the request left its series at, keeping
+// the newest size points. Callers hold the metrics file's lock.
+func appendHistory(filename string, families map[string]*dto.MetricFamily, req *request, size int) error {
+	labels := changedLabels(req)
+	value, exists := seriesValue(families, req.metricName, labels)
+	if !exists {
+		return nil
+	}
+
+	data, err := os.ReadFile(filename + historySuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	}
+	lines = append(lines, fmt.Sprintf("%d\t%s\t%s\n", timeProvider.Now().Unix(), formatSeries(req.metricName, labels), strconv.FormatFloat(value, 'g', -1, 64)))
+	if len(lines) > size {
+		lines = lines[len(lines)-size:]
+	}
+
+	tmp := filename + historySuffix + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "")), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename+historySuffix)
+}
+
+// readHistory returns the recorded points for metric, oldest first.
+func readHistory(filename, metric string) ([]historyPoint, error) {
+	data, err := os.ReadFile(filename + historySuffix)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no history for %s (record it with --history)", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseHistory(data, metric)
+}
+
+// readAllHistory returns every recorded point, oldest first, or none if
+// the file has no history.
+func readAllHistory(filename string) ([]historyPoint, error) {
+	data, err := os.ReadFile(filename + historySuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return parseHistory(data, "")
+}
+
+// parseHistory parses history lines, keeping only metric's unless it is
+// empty. Malformed lines are skipped.
+func parseHistory(data []byte, metric string) ([]historyPoint, error) {
+	var points []historyPoint
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		if name, _, _ := strings.Cut(fields[1], "{"); metric != "" && name != metric {
+			continue
+		}
+		timestamp, err1 := strconv.ParseInt(fields[0], 10, 64)
+		value, err2 := strconv.ParseFloat(fields[2], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		points = append(points, historyPoint{time: timestamp, series: fields[1], value: value})
+	}
+	return points, scanner.Err()
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as block characters scaled between their
+// minimum and maximum. NaN values are drawn as spaces.
+func sparkline(values []float64) string {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			low, high = math.Min(low, v), math.Max(high, v)
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		switch {
+		case math.IsNaN(v) || math.IsInf(v, 0):
+			b.WriteRune(' ')
+		case high == low:
+			b.WriteRune(sparkBlocks[len(sparkBlocks)/2-1])
+		default:
+			b.WriteRune(sparkBlocks[int((v-low)/(high-low)*float64(len(sparkBlocks)-1)+0.5)])
+		}
+	}
+	return b.String()
+}
+
+func sparkCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "spark",
+		Usage:     "Draw a sparkline of a metric's recorded history",
+		ArgsUsage: "<metric>",
+		Description: `Reads the values recorded by in-place runs with --history (kept in
+metrics.prom.history) and draws one sparkline per series, with the
+minimum, maximum, and latest value.
+
+Examples:
+  omet -i -f app.prom --history -l queue=orders queue_depth set 42
+  omet spark -f app.prom queue_depth
+  omet spark -f app.prom -l queue=orders --points 120 queue_depth`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Metrics file whose history to show",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:    "label",
+				Aliases: []string{"l"},
+				Usage:   "Only show the series with exactly these labels (KEY=VALUE, can be repeated)",
+			},
+			&cli.IntFlag{
+				Name:  "points",
+				Value: 60,
+				Usage: "Number of most recent values to draw",
+			},
+		},
+		Action: runSpark,
+	}
+}
+
+func runSpark(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("spark requires a metric name")
+	}
+	metric := ctx.Args().First()
+	if ctx.Int("points") < 1 {
+		return fmt.Errorf("--points must be at least 1")
+	}
+
+	var only string
+	if ctx.IsSet("label") {
+		labels, err := parseLabels(ctx.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+		only = formatSeries(metric, labels)
+	}
+
+	points, err := readHistory(ctx.String("file"), metric)
+	if err != nil {
+		return err
+	}
+
+	bySeries := make(map[string][]historyPoint)
+	for _, point := range points {
+		if only == "" || point.series == only {
+			bySeries[point.series] = append(bySeries[point.series], point)
+		}
+	}
+	if len(bySeries) == 0 {
+		return fmt.Errorf("no history recorded for %s", metric)
+	}
+
+	names := make([]string, 0, len(bySeries))
+	width := 0
+	for series := range bySeries {
+		names = append(names, series)
+		width = max(width, len(series))
+	}
+	sort.Strings(names)
+
+	for _, series := range names {
+		recent := bySeries[series]
+		if n := ctx.Int("points"); len(recent) > n {
+			recent = recent[len(recent)-n:]
+		}
+		values := make([]float64, len(recent))
+		low, high := math.Inf(1), math.Inf(-1)
+		for i, point := range recent {
+			values[i] = point.value
+			low, high = math.Min(low, point.value), math.Max(high, point.value)
+		}
+		last := recent[len(recent)-1]
+		fmt.Printf("%-*s  %s  min %s  max %s  last %s (%s ago)\n", width, series, sparkline(values),
+			humanizeValue(low), humanizeValue(high), humanizeValue(last.value),
+			timeProvider.Now().Sub(time.Unix(last.time, 0)).Round(time.Second))
+	}
+	return nil
+}
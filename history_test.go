@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSparkline(t *testing.T) {
+	assert.Equal(t, "▁▂▃▄▅▆▇█", sparkline([]float64{0, 1, 2, 3, 4, 5, 6, 7}))
+	assert.Equal(t, "▄▄▄", sparkline([]float64{5, 5, 5}))
+	assert.Equal(t, "▁ █", sparkline([]float64{1, math.NaN(), 2}))
+}
+
+func TestHistory(t *testing.T) {
+	clock := setupMockTime(t, time.Unix(1700000000, 0))
+	testFile := createTempFile(t, "")
+
+	for i, depth := range []string{"1", "4", "9", "2"} {
+		clock.SetTime(time.Unix(1700000000+int64(i)*60, 0))
+		args := []string{"omet", "-i", "-f", testFile, "--history", "--history-size", "3", "-l", "queue=orders", "queue_depth", "set", depth}
+		require.NoError(t, createTestApp().Run(args))
+	}
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--history", "-l", "queue=mail", "queue_depth", "set", "5"}))
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "jobs_total", "inc"}), "runs without --history record nothing")
+
+	points, err := readHistory(testFile, "queue_depth")
+	require.NoError(t, err)
+	require.Len(t, points, 4, "the first point fell out when --history-size was 3")
+	assert.Equal(t, `queue_depth{queue="orders"}`, points[0].series)
+	assert.Equal(t, 4.0, points[0].value)
+
+	output := captureOutput(t, func() {
+		require.NoError(t, createTestApp().Run([]string{"omet", "spark", "-f", testFile, "queue_depth"}))
+	})
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, `queue_depth{queue="mail"}    ▄  min 5  max 5  last 5 (0s ago)`, lines[0])
+	assert.Equal(t, `queue_depth{queue="orders"}  ▃█▁  min 2  max 9  last 2 (0s ago)`, lines[1])
+
+	output = captureOutput(t, func() {
+		require.NoError(t, createTestApp().Run([]string{"omet", "spark", "-f", testFile, "-l", "queue=orders", "--points", "2", "queue_depth"}))
+	})
+	assert.Equal(t, "queue_depth{queue=\"orders\"}  █▁  min 2  max 9  last 2 (0s ago)\n", output)
+
+	err = createTestApp().Run([]string{"omet", "spark", "-f", testFile, "jobs_total"})
+	assert.ErrorContains(t, err, "no history recorded for jobs_total")
+}
+
+func TestHistorySize(t *testing.T) {
+	testFile := createTempFile(t, "")
+
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--history", "--history-size", "0", "queue_depth", "set", "1"}))
+	assert.NoFileExists(t, testFile+historySuffix, "a size of 0 records nothing")
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--history", "--history-size", "-1", "queue_depth", "set", "2"})
+	assert.ErrorContains(t, err, "invalid --history-size -1")
+	assert.NoFileExists(t, testFile+historySuffix)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+)
+
+// postWrite runs --post-write-cmd after a successful in-place write, while
+// the file is still locked. The command runs through sh -c with the write
+// described in OMET_* environment variables; its output goes to stderr so
+// stdout stays clean. A failure is logged as well as returned, since the
+// write itself already happened.
+func (t *target) postWrite(ctx *cli.Context, req *request) error {
+	command := ctx.String("post-write-cmd")
+	if command == "" {
+		return nil
+	}
+	if req.verbose {
+		log.Printf("Running post-write command for %s: %s", t.filename, command)
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"OMET_FILE="+t.filename,
+		"OMET_METRICS="+req.metricName,
+		"OMET_SERIES="+formatSeries(req.metricName, changedLabels(req)),
+		"OMET_OPERATION="+req.operation,
+		"OMET_OLD_VALUE="+formatHookValue(t.oldValue),
+		"OMET_NEW_VALUE="+formatHookValue(t.newValue),
+		"OMET_TRACE_ID="+t.errors.traceID,
+	)
+	if err := cmd.Run(); err != nil {
+		err = fmt.Errorf("post-write command for %s failed: %w", t.filename, err)
+		log.Printf("WARN: %v", err)
+		return err
+	}
+	return nil
+}
+
+// formatHookValue formats a value for the environment, empty if the series
+// doesn't exist.
+func formatHookValue(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'g', -1, 64)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostWriteCmd(t *testing.T) {
+	testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total{job=\"backup\"} 4\n")
+	envFile := filepath.Join(t.TempDir(), "env")
+	command := `printf '%s\n' "$OMET_FILE" "$OMET_METRICS" "$OMET_SERIES" "$OMET_OPERATION" "$OMET_OLD_VALUE" "$OMET_NEW_VALUE" > ` + envFile
+
+	require.NoError(t, createTestApp().Run([]string{"omet", "--post-write-cmd", command, "-i", "-f", testFile, "-l", "job=backup", "jobs_total", "inc"}))
+	data, err := os.ReadFile(envFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testFile, "jobs_total", `jobs_total{job="backup"}`, "inc", "4", "5"}, strings.Split(strings.TrimSpace(string(data)), "\n"))
+
+	t.Run("not run when the operation fails", func(t *testing.T) {
+		require.NoError(t, os.Remove(envFile))
+		require.Error(t, createTestApp().Run([]string{"omet", "--post-write-cmd", command, "-i", "-f", testFile, "-l", "job=backup", "jobs_total", "set", "1"}))
+		assert.NoFileExists(t, envFile)
+	})
+
+	t.Run("failing command fails the run after writing", func(t *testing.T) {
+		err := createTestApp().Run([]string{"omet", "--post-write-cmd", "exit 3", "-i", "-f", testFile, "-l", "job=backup", "jobs_total", "inc"})
+		assert.ErrorContains(t, err, "post-write command")
+		data, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `jobs_total{job="backup"} 6`)
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+func ingestCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "ingest",
+		Usage: "Merge a Prometheus exposition from stdin into a metrics file",
+		Description: `Reads a full exposition on stdin and merges it into the file under its
+lock, recording omet's self-monitoring metrics like any other update. It's
+the safe replacement for appending with cat >>.
+
+Series that don't exist yet are added. Existing ones are combined by type:
+  --counter-policy    sum (default) or replace
+  --gauge-policy      replace (default), sum, max, or min
+  --histogram-policy  merge (default), adding counts, sums and buckets of
+                      the same layout, or replace
+Untyped series follow the gauge policy and summaries are replaced. A family
+whose type differs from the file's is refused.
+
+Examples:
+  ./exporter.sh | omet ingest -f /var/lib/node_exporter/app.prom
+  omet ingest -f app.prom --gauge-policy max < peaks.prom`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Metrics file to merge into, created if missing",
+				Required: true,
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for file lock",
+			},
+		}, mergeStrategyFlags("sum", "replace", "merge")...),
+		Action: runIngest,
+	}
+}
+
+func runIngest(ctx *cli.Context) error {
+	strategies, err := loadMergeStrategies(ctx)
+	if err != nil {
+		return err
+	}
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+	ingested, err := metricsfile.Parse(bytes.NewReader(input))
+	if err != nil {
+		return fmt.Errorf("failed to parse stdin: %w", err)
+	}
+
+	filename := ctx.String("file")
+	lock, err := metricsfile.NewFileLock(filename, ctx.Duration("lock-timeout"))
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+	defer lock.Close()
+	lockStart := time.Now()
+	if err := lock.Lock(context.Background()); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	lockWaitTime := time.Since(lockStart)
+
+	families, err := metricsfile.Parse(lock.File())
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	if err := ingestFamilies(families, ingested, strategies); err != nil {
+		return err
+	}
+
+	addOperationalMetrics(families, "ingest", int64(len(input)), lockWaitTime, &ErrorCollector{})
+	return lock.RewriteWithChecksum(func(file *os.File) error {
+		return writeMetricsWithSelfMonitoring(families, file)
+	})
+}
+
+// ingestFamilies merges src into dst by strategy. Nothing is changed if a
+// family's type conflicts or a histogram can't be merged.
+func ingestFamilies(dst, src map[string]*dto.MetricFamily, strategies *mergeStrategies) error {
+	merged := make(map[string]*dto.MetricFamily, len(src))
+	for name, family := range src {
+		existing, exists := dst[name]
+		if !exists {
+			merged[name] = family
+			continue
+		}
+		if existing.GetType() != family.GetType() {
+			return fmt.Errorf("family %s is a %s in the file but a %s on stdin", name, typeName(existing), typeName(family))
+		}
+
+		result := proto.Clone(existing).(*dto.MetricFamily)
+		if family.Help != nil {
+			result.Help = family.Help
+		}
+		for _, metric := range family.Metric {
+			if err := strategies.mergeSeries(result, metric); err != nil {
+				return err
+			}
+		}
+		merged[name] = result
+	}
+
+	for name, family := range merged {
+		dst[name] = family
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngest(t *testing.T) {
+	const content = `# TYPE jobs_total counter
+jobs_total{job="a"} 10
+# TYPE queue_depth gauge
+queue_depth 4
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.5
+latency_seconds_count 3
+`
+	const input = `# TYPE jobs_total counter
+jobs_total{job="a"} 5
+jobs_total{job="b"} 1
+# TYPE queue_depth gauge
+queue_depth 2
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="1"} 1
+latency_seconds_bucket{le="+Inf"} 1
+latency_seconds_sum 0.5
+latency_seconds_count 1
+`
+	value := func(t *testing.T, filename, name string, labels map[string]string) float64 {
+		families, err := parseMetrics(mustOpen(t, filename))
+		require.NoError(t, err)
+		value, ok := seriesValue(families, name, labels)
+		require.True(t, ok, name)
+		return value
+	}
+
+	t.Run("default policies", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		defer mockStdin(t, input)()
+		require.NoError(t, createTestApp().Run([]string{"omet", "ingest", "-f", testFile}))
+
+		assert.Equal(t, 15.0, value(t, testFile, "jobs_total", map[string]string{"job": "a"}))
+		assert.Equal(t, 1.0, value(t, testFile, "jobs_total", map[string]string{"job": "b"}))
+		assert.Equal(t, 2.0, value(t, testFile, "queue_depth", map[string]string{}))
+		assert.Equal(t, 1.0, value(t, testFile, "omet_operations_by_type_total", map[string]string{"operation": "ingest"}))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		histogram := families["latency_seconds"].Metric[0].GetHistogram()
+		assert.Equal(t, uint64(4), histogram.GetSampleCount())
+		assert.Equal(t, 3.0, histogram.GetSampleSum())
+		assert.Equal(t, uint64(3), histogram.Bucket[0].GetCumulativeCount())
+	})
+
+	t.Run("other policies", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		defer mockStdin(t, input)()
+		require.NoError(t, createTestApp().Run([]string{"omet", "ingest", "-f", testFile, "--counter-policy", "replace", "--gauge-policy", "max"}))
+
+		assert.Equal(t, 5.0, value(t, testFile, "jobs_total", map[string]string{"job": "a"}))
+		assert.Equal(t, 4.0, value(t, testFile, "queue_depth", map[string]string{}))
+	})
+
+	t.Run("conflicts leave the file alone", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		defer mockStdin(t, "# TYPE queue_depth gauge\nqueue_depth 9\n# TYPE jobs_total gauge\njobs_total{job=\"a\"} 1\n")()
+		assert.ErrorContains(t, createTestApp().Run([]string{"omet", "ingest", "-f", testFile}), "jobs_total is a counter")
+		assert.Equal(t, 4.0, value(t, testFile, "queue_depth", map[string]string{}))
+
+		defer mockStdin(t, "# TYPE latency_seconds histogram\nlatency_seconds_bucket{le=\"5\"} 1\nlatency_seconds_bucket{le=\"+Inf\"} 1\nlatency_seconds_sum 1\nlatency_seconds_count 1\n")()
+		assert.ErrorContains(t, createTestApp().Run([]string{"omet", "ingest", "-f", testFile}), "different buckets")
+	})
+
+	t.Run("invalid policy", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		assert.Error(t, createTestApp().Run([]string{"omet", "ingest", "-f", testFile, "--gauge-policy", "avg"}))
+	})
+}
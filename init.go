@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"omet/internal/metricsfile"
+	"omet/internal/schema"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+func initCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "init",
+		Usage: "Create a metrics file pre-populated from a schema",
+		Description: `Writes every family declared in a YAML schema, with HELP, TYPE, and UNIT
+lines and a zero-valued series for each known label set, so dashboards
+show zeros instead of "no data" before the first real update.
+
+Example schema:
+  families:
+    - name: backups_total
+      type: counter
+      help: Total number of backups
+      series:
+        - {job: backup}
+        - {job: restore}
+    - name: backup_duration_seconds
+      type: histogram
+      unit: seconds
+      buckets: [1, 5, 30, 60]
+
+Example:
+  omet init -f metrics.prom --from schema.yaml`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Metrics file to create",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "from",
+				Usage:    "Schema file declaring the metric families",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Overwrite a file that already has content",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for file lock",
+			},
+		},
+		Action: runInit,
+	}
+}
+
+func runInit(ctx *cli.Context) error {
+	s, err := schema.Load(ctx.String("from"))
+	if err != nil {
+		return fmt.Errorf("failed to load schema: %w", err)
+	}
+
+	families, err := familiesFromSchema(s)
+	if err != nil {
+		return err
+	}
+
+	filename := ctx.String("file")
+	lock, err := metricsfile.NewFileLock(filename, ctx.Duration("lock-timeout"))
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+	defer lock.Close()
+
+	if err := lock.Lock(context.Background()); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	if stat, err := lock.File().Stat(); err == nil && stat.Size() > 0 && !ctx.Bool("force") {
+		return fmt.Errorf("%s already exists and is not empty (use --force to overwrite)", filename)
+	}
+
+	return lock.RewriteWithChecksum(func(file *os.File) error {
+		return writeMetrics(families, file)
+	})
+}
+
+// familiesFromSchema builds the declared families with a zero-valued series
+// per known label set. Families without labels get a single unlabeled series.
+func familiesFromSchema(s *schema.Schema) (map[string]*dto.MetricFamily, error) {
+	families := make(map[string]*dto.MetricFamily)
+	for _, declared := range s.Families {
+		metricType, err := declared.MetricType()
+		if err != nil {
+			return nil, err
+		}
+
+		family := createMetricFamily(declared.Name, metricType)
+		if declared.Help != "" {
+			family.Help = stringPtr(declared.Help)
+		}
+		if declared.Unit != "" {
+			family.Unit = stringPtr(declared.Unit)
+		}
+
+		series := declared.Series
+		if len(series) == 0 {
+			series = []map[string]string{nil}
+		}
+		for _, labels := range series {
+			metric := findOrCreateMetric(family, labels)
+			switch metricType {
+			case dto.MetricType_COUNTER:
+				metric.Counter = &dto.Counter{Value: float64Ptr(0)}
+			case dto.MetricType_GAUGE:
+				metric.Gauge = &dto.Gauge{Value: float64Ptr(0)}
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				buckets := declared.Buckets
+				if len(buckets) == 0 {
+					buckets = defaultHistogramBuckets
+				}
+				metric.Histogram = createHistogram(buckets)
+			default:
+				metric.Untyped = &dto.Untyped{Value: float64Ptr(0)}
+			}
+		}
+
+		families[declared.Name] = family
+	}
+	return families, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `families:
+  - name: backups_total
+    type: counter
+    help: Total number of backups
+    series:
+      - {job: backup}
+      - {job: restore}
+  - name: backup_duration_seconds
+    type: histogram
+    unit: seconds
+    buckets: [1, 5]
+  - name: queue_depth
+    type: gauge
+`
+
+func TestInitCommand(t *testing.T) {
+	t.Run("creates zero-valued series from schema", func(t *testing.T) {
+		dir := t.TempDir()
+		schemaFile := filepath.Join(dir, "schema.yaml")
+		metricsFile := filepath.Join(dir, "metrics.prom")
+		require.NoError(t, os.WriteFile(schemaFile, []byte(testSchema), 0644))
+
+		err := createTestApp().Run([]string{"omet", "init", "-f", metricsFile, "--from", schemaFile})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(metricsFile)
+		require.NoError(t, err)
+		output := string(content)
+		assert.Contains(t, output, "# HELP backups_total Total number of backups")
+		assert.Contains(t, output, `backups_total{job="backup"} 0`)
+		assert.Contains(t, output, `backups_total{job="restore"} 0`)
+		assert.Contains(t, output, "# UNIT backup_duration_seconds seconds")
+		assert.Contains(t, output, `backup_duration_seconds_bucket{le="5"} 0`)
+		assert.Contains(t, output, "queue_depth 0")
+
+		// The file is immediately usable by normal operations
+		err = createTestApp().Run([]string{"omet", "-i", "-f", metricsFile, "-l", "job=backup", "backups_total", "inc"})
+		require.NoError(t, err)
+		content, err = os.ReadFile(metricsFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `backups_total{job="backup"} 1`)
+		assert.Contains(t, string(content), "# UNIT backup_duration_seconds seconds")
+	})
+
+	t.Run("refuses to overwrite without --force", func(t *testing.T) {
+		dir := t.TempDir()
+		schemaFile := filepath.Join(dir, "schema.yaml")
+		require.NoError(t, os.WriteFile(schemaFile, []byte(testSchema), 0644))
+		metricsFile := createTempFile(t, "up 1\n")
+
+		err := createTestApp().Run([]string{"omet", "init", "-f", metricsFile, "--from", schemaFile})
+		assert.ErrorContains(t, err, "already exists")
+
+		err = createTestApp().Run([]string{"omet", "init", "-f", metricsFile, "--from", schemaFile, "--force"})
+		require.NoError(t, err)
+		content, err := os.ReadFile(metricsFile)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "up 1")
+	})
+
+	t.Run("invalid schema", func(t *testing.T) {
+		dir := t.TempDir()
+		schemaFile := filepath.Join(dir, "schema.yaml")
+		require.NoError(t, os.WriteFile(schemaFile, []byte("families: [{name: up, type: summary}]"), 0644))
+
+		err := createTestApp().Run([]string{"omet", "init", "-f", filepath.Join(dir, "m.prom"), "--from", schemaFile})
+		assert.ErrorContains(t, err, "unsupported type")
+	})
+}
@@ -0,0 +1,336 @@
+// Package backfill reads and writes timestamped OpenMetrics expositions of
+// the kind `promtool tsdb create-blocks-from openmetrics` imports: every
+// sample carries a timestamp, families and series appear in a fixed order,
+// each series' points are in increasing time order, and the file ends with
+// "# EOF".
+package backfill
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Family is a metric family. Type is an OpenMetrics type: counter, gauge,
+// histogram, summary, or unknown. Counter family names don't include the
+// _total suffix their samples carry.
+type Family struct {
+	Name    string
+	Type    string
+	Help    string
+	Metrics []*Metric
+}
+
+// Metric is one labeled series of a family.
+type Metric struct {
+	Labels map[string]string
+	Points []Point
+}
+
+// Point is a metric's value at one time: a single sample for counters,
+// gauges, and unknown metrics, or all buckets, quantiles, _sum, and _count
+// samples of a histogram or summary.
+type Point struct {
+	TimestampMs int64
+	Samples     []Sample
+}
+
+// Sample is one exposed line of a point.
+type Sample struct {
+	Suffix string  // appended to the family name, e.g. _total or _bucket
+	Bound  string  // le or quantile value, for buckets and quantiles
+	Value  float64 // the sample value
+}
+
+// File is a backfill exposition, keyed by family name.
+type File struct {
+	Families map[string]*Family
+}
+
+func NewFile() *File {
+	return &File{Families: make(map[string]*Family)}
+}
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// suffixes lists the sample suffixes each type exposes, in output order.
+var suffixes = map[string][]string{
+	"counter":   {"_total", "_created"},
+	"gauge":     {""},
+	"unknown":   {""},
+	"histogram": {"_bucket", "_count", "_sum", "_created"},
+	"summary":   {"", "_count", "_sum", "_created"},
+}
+
+// boundLabel is the label holding Sample.Bound for a type.
+func boundLabel(familyType string) string {
+	switch familyType {
+	case "histogram":
+		return "le"
+	case "summary":
+		return "quantile"
+	}
+	return ""
+}
+
+// family returns the named family, creating it with the given type. It is
+// an error for the family to exist with another type.
+func (f *File) family(name, familyType, help string) (*Family, error) {
+	if _, ok := suffixes[familyType]; !ok {
+		return nil, fmt.Errorf("unsupported type %s for %s", familyType, name)
+	}
+	family, ok := f.Families[name]
+	if !ok {
+		family = &Family{Name: name, Type: familyType}
+		f.Families[name] = family
+	} else if family.Type != familyType {
+		return nil, fmt.Errorf("%s is a %s, not a %s", name, family.Type, familyType)
+	}
+	if help != "" {
+		family.Help = help
+	}
+	return family, nil
+}
+
+// metric returns the family's series with exactly these labels, creating it.
+func (family *Family) metric(labels map[string]string) *Metric {
+	for _, metric := range family.Metrics {
+		if sameLabels(metric.Labels, labels) {
+			return metric
+		}
+	}
+	copied := make(map[string]string, len(labels))
+	for name, value := range labels {
+		copied[name] = value
+	}
+	metric := &Metric{Labels: copied}
+	family.Metrics = append(family.Metrics, metric)
+	return metric
+}
+
+func sameLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Add records a point of the named family's series with the given labels,
+// replacing any point already recorded at the same time. Counter names may
+// be given with or without _total.
+func (f *File) Add(name, familyType, help string, labels map[string]string, point Point) error {
+	if familyType == "untyped" {
+		familyType = "unknown"
+	}
+	if familyType == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	family, err := f.family(name, familyType, help)
+	if err != nil {
+		return err
+	}
+	family.metric(labels).add(point)
+	return nil
+}
+
+func (metric *Metric) add(point Point) {
+	i := sort.Search(len(metric.Points), func(i int) bool {
+		return metric.Points[i].TimestampMs >= point.TimestampMs
+	})
+	if i < len(metric.Points) && metric.Points[i].TimestampMs == point.TimestampMs {
+		metric.Points[i] = point
+		return
+	}
+	metric.Points = append(metric.Points, Point{})
+	copy(metric.Points[i+1:], metric.Points[i:])
+	metric.Points[i] = point
+}
+
+// Validate checks everything promtool requires that the model itself
+// doesn't guarantee: valid names, samples matching their family's type, and
+// no NaN bucket bounds.
+func (f *File) Validate() error {
+	for name, family := range f.Families {
+		if !metricNameRE.MatchString(name) {
+			return fmt.Errorf("invalid metric name: %s", name)
+		}
+		allowed := make(map[string]bool)
+		for _, suffix := range suffixes[family.Type] {
+			allowed[suffix] = true
+		}
+		bound := boundLabel(family.Type)
+		for _, metric := range family.Metrics {
+			for label := range metric.Labels {
+				if !labelNameRE.MatchString(label) || strings.HasPrefix(label, "__") {
+					return fmt.Errorf("%s: invalid label name %q", name, label)
+				}
+				if bound != "" && label == bound {
+					return fmt.Errorf("%s: label %s is reserved for %s samples", name, label, family.Type)
+				}
+			}
+			for _, point := range metric.Points {
+				if len(point.Samples) == 0 {
+					return fmt.Errorf("%s: empty point at %d", name, point.TimestampMs)
+				}
+				for _, sample := range point.Samples {
+					if !allowed[sample.Suffix] {
+						return fmt.Errorf("%s: %s sample %s%s", name, family.Type, name, sample.Suffix)
+					}
+					hasBound := sample.Bound != ""
+					needsBound := bound != "" && (sample.Suffix == "_bucket" || (family.Type == "summary" && sample.Suffix == ""))
+					if hasBound != needsBound {
+						return fmt.Errorf("%s: sample %s%s has a misplaced %s", name, name, sample.Suffix, bound)
+					}
+					if hasBound {
+						if value, err := parseFloat(sample.Bound); err != nil || math.IsNaN(value) {
+							return fmt.Errorf("%s: invalid %s %q", name, bound, sample.Bound)
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the file: families by name, series by labels, points by
+// time, and samples in exposition order within each point.
+func (f *File) WriteTo(out io.Writer) (int64, error) {
+	var w bytes.Buffer
+
+	names := make([]string, 0, len(f.Families))
+	for name := range f.Families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := f.Families[name]
+		if family.Help != "" {
+			fmt.Fprintf(&w, "# HELP %s %s\n", name, escapeHelp(family.Help))
+		}
+		fmt.Fprintf(&w, "# TYPE %s %s\n", name, family.Type)
+
+		metrics := append([]*Metric(nil), family.Metrics...)
+		sort.SliceStable(metrics, func(i, j int) bool {
+			return formatLabels(metrics[i].Labels, "", "") < formatLabels(metrics[j].Labels, "", "")
+		})
+		bound := boundLabel(family.Type)
+		for _, metric := range metrics {
+			for _, point := range metric.Points {
+				timestamp := formatTimestamp(point.TimestampMs)
+				for _, sample := range sortSamples(family.Type, point.Samples) {
+					labels := formatLabels(metric.Labels, "", "")
+					if sample.Bound != "" {
+						labels = formatLabels(metric.Labels, bound, sample.Bound)
+					}
+					fmt.Fprintf(&w, "%s%s%s %s %s\n", name, sample.Suffix, labels, formatFloat(sample.Value), timestamp)
+				}
+			}
+		}
+	}
+	fmt.Fprint(&w, "# EOF\n")
+
+	n, err := out.Write(w.Bytes())
+	return int64(n), err
+}
+
+// sortSamples orders a point's samples by suffix, then by numeric bound.
+func sortSamples(familyType string, samples []Sample) []Sample {
+	rank := make(map[string]int)
+	for i, suffix := range suffixes[familyType] {
+		rank[suffix] = i
+	}
+	sorted := append([]Sample(nil), samples...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if rank[sorted[i].Suffix] != rank[sorted[j].Suffix] {
+			return rank[sorted[i].Suffix] < rank[sorted[j].Suffix]
+		}
+		a, _ := parseFloat(sorted[i].Bound)
+		b, _ := parseFloat(sorted[j].Bound)
+		return a < b
+	})
+	return sorted
+}
+
+// formatLabels renders labels sorted by name, with an extra bound label
+// (le or quantile) last, as OpenMetrics writers conventionally do.
+func formatLabels(labels map[string]string, boundName, boundValue string) string {
+	if len(labels) == 0 && boundName == "" {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, escapeLabel(labels[name])))
+	}
+	if boundName != "" {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, boundName, boundValue))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string { return labelEscaper.Replace(value) }
+func escapeHelp(value string) string  { return helpEscaper.Replace(value) }
+
+// formatTimestamp renders milliseconds as OpenMetrics seconds.
+func formatTimestamp(ms int64) string {
+	sign := ""
+	if ms < 0 {
+		sign, ms = "-", -ms
+	}
+	if ms%1000 == 0 {
+		return fmt.Sprintf("%s%d", sign, ms/1000)
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%03d", sign, ms/1000, ms%1000), "0")
+}
+
+// FormatBound renders an le or quantile value the way Read and WriteTo
+// expect.
+func FormatBound(value float64) string {
+	return formatFloat(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func parseFloat(value string) (float64, error) {
+	switch value {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
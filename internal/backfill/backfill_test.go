@@ -0,0 +1,104 @@
+package backfill
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleFile = `# HELP jobs Jobs run
+# TYPE jobs counter
+jobs_total{job="backup"} 1 1700000000
+jobs_total{job="backup"} 3 1700000060.5
+jobs_total{job="restore"} 7 1700000000
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 1 1700000000
+latency_seconds_bucket{le="+Inf"} 2 1700000000
+latency_seconds_count 2 1700000000
+latency_seconds_sum 1.5 1700000000
+# TYPE temperature gauge
+temperature{room="a \"b\""} NaN 1700000000
+# EOF
+`
+
+func TestRoundTrip(t *testing.T) {
+	f, err := Read(strings.NewReader(sampleFile))
+	require.NoError(t, err)
+	require.NoError(t, f.Validate())
+
+	jobs := f.Families["jobs"]
+	require.NotNil(t, jobs)
+	assert.Equal(t, "counter", jobs.Type)
+	assert.Equal(t, "Jobs run", jobs.Help)
+	require.Len(t, jobs.Metrics, 2)
+	assert.Equal(t, int64(1700000060500), jobs.Metrics[0].Points[1].TimestampMs)
+	assert.Len(t, f.Families["latency_seconds"].Metrics[0].Points[0].Samples, 4, "histogram samples form one point")
+	assert.True(t, math.IsNaN(f.Families["temperature"].Metrics[0].Points[0].Samples[0].Value))
+
+	var out bytes.Buffer
+	_, err = f.WriteTo(&out)
+	require.NoError(t, err)
+	assert.Equal(t, sampleFile, out.String())
+}
+
+func TestAdd(t *testing.T) {
+	f := NewFile()
+	labels := map[string]string{"job": "backup"}
+	for _, point := range []Point{
+		{TimestampMs: 3000, Samples: []Sample{{Suffix: "_total", Value: 3}}},
+		{TimestampMs: 1000, Samples: []Sample{{Suffix: "_total", Value: 1}}},
+		{TimestampMs: 2000, Samples: []Sample{{Suffix: "_total", Value: 5}}},
+		{TimestampMs: 2000, Samples: []Sample{{Suffix: "_total", Value: 2}}},
+	} {
+		require.NoError(t, f.Add("jobs_total", "counter", "", labels, point))
+	}
+	assert.Error(t, f.Add("jobs", "gauge", "", labels, Point{}), "type conflicts are rejected")
+
+	var out bytes.Buffer
+	_, err := f.WriteTo(&out)
+	require.NoError(t, err)
+	assert.Equal(t, `# TYPE jobs counter
+jobs_total{job="backup"} 1 1
+jobs_total{job="backup"} 2 2
+jobs_total{job="backup"} 3 3
+# EOF
+`, out.String(), "points are ordered by time and a repeated time replaces the value")
+}
+
+func TestValidate(t *testing.T) {
+	for name, add := range map[string]func(f *File) error{
+		"metric name": func(f *File) error {
+			return f.Add("bad-name", "gauge", "", nil, Point{Samples: []Sample{{Value: 1}}})
+		},
+		"label name": func(f *File) error {
+			return f.Add("ok", "gauge", "", map[string]string{"__name": "x"}, Point{Samples: []Sample{{Value: 1}}})
+		},
+		"suffix": func(f *File) error {
+			return f.Add("ok", "gauge", "", nil, Point{Samples: []Sample{{Suffix: "_total", Value: 1}}})
+		},
+		"bucket bound": func(f *File) error {
+			return f.Add("ok", "histogram", "", nil, Point{Samples: []Sample{{Suffix: "_bucket", Value: 1}}})
+		},
+	} {
+		f := NewFile()
+		require.NoError(t, add(f), name)
+		assert.Error(t, f.Validate(), name)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"no timestamp":  "# TYPE a gauge\na 1\n",
+		"bad value":     "a x 1\n",
+		"after EOF":     "a 1 1\n# EOF\na 2 2\n",
+		"unterminated":  "a{b=\"c} 1 1\n",
+		"type conflict": "# TYPE a gauge\n# TYPE a counter\n",
+	} {
+		_, err := Read(strings.NewReader(content))
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,208 @@
+package backfill
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Read parses an exposition written by WriteTo. Every sample needs a
+// timestamp; samples of a histogram or summary with the same labels and
+// timestamp form one point.
+func Read(r io.Reader) (*File, error) {
+	f := NewFile()
+	var current *Family
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	eof := false
+	typed := make(map[string]bool)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if eof {
+			if strings.TrimSpace(line) != "" {
+				return nil, fmt.Errorf("line %d: content after # EOF", lineNo)
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			switch {
+			case line == "# EOF":
+				eof = true
+			case len(fields) >= 3 && fields[1] == "TYPE":
+				familyType := strings.Join(fields[3:], "")
+				if family, ok := f.Families[fields[2]]; ok && !typed[fields[2]] && len(family.Metrics) == 0 {
+					// Only declared by HELP so far.
+					if _, ok := suffixes[familyType]; ok {
+						family.Type = familyType
+					}
+				}
+				family, err := f.family(fields[2], familyType, "")
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+				typed[fields[2]] = true
+				current = family
+			case len(fields) >= 3 && fields[1] == "HELP":
+				help := ""
+				if len(fields) == 4 {
+					help = unescapeHelp(fields[3])
+				}
+				family, ok := f.Families[fields[2]]
+				if !ok {
+					family = &Family{Name: fields[2], Type: "unknown"}
+					f.Families[fields[2]] = family
+				}
+				family.Help = help
+				current = family
+			}
+			continue
+		}
+
+		name, labels, value, timestamp, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		family, suffix := current, ""
+		if family == nil || !belongs(family, name, &suffix) {
+			if family, err = f.family(name, "unknown", ""); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			suffix = ""
+		}
+		current = family
+
+		sample := Sample{Suffix: suffix, Value: value}
+		if bound := boundLabel(family.Type); bound != "" {
+			sample.Bound = labels[bound]
+			delete(labels, bound)
+		}
+		family.metric(labels).addSample(timestamp, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// belongs reports whether a sample name is part of family, setting its
+// suffix.
+func belongs(family *Family, name string, suffix *string) bool {
+	for _, candidate := range suffixes[family.Type] {
+		if name == family.Name+candidate {
+			*suffix = candidate
+			return true
+		}
+	}
+	return false
+}
+
+func (metric *Metric) addSample(timestampMs int64, sample Sample) {
+	i := sort.Search(len(metric.Points), func(i int) bool {
+		return metric.Points[i].TimestampMs >= timestampMs
+	})
+	if i < len(metric.Points) && metric.Points[i].TimestampMs == timestampMs {
+		metric.Points[i].Samples = append(metric.Points[i].Samples, sample)
+		return
+	}
+	metric.add(Point{TimestampMs: timestampMs, Samples: []Sample{sample}})
+}
+
+// parseSample parses `name{labels} value timestamp`, with the timestamp in
+// seconds.
+func parseSample(line string) (name string, labels map[string]string, value float64, timestampMs int64, err error) {
+	end := strings.IndexAny(line, "{ ")
+	if end <= 0 {
+		return "", nil, 0, 0, fmt.Errorf("invalid sample: %s", line)
+	}
+	name, rest := line[:end], line[end:]
+	labels = make(map[string]string)
+	if strings.HasPrefix(rest, "{") {
+		if rest, err = parseLabels(rest[1:], labels); err != nil {
+			return "", nil, 0, 0, err
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if i := indexOf(fields, "#"); i >= 0 {
+		fields = fields[:i] // drop exemplars
+	}
+	if len(fields) != 2 {
+		return "", nil, 0, 0, fmt.Errorf("%s: expected a value and a timestamp", name)
+	}
+	if value, err = parseFloat(fields[0]); err != nil {
+		return "", nil, 0, 0, fmt.Errorf("%s: invalid value %q", name, fields[0])
+	}
+	seconds, err := parseFloat(fields[1])
+	if err != nil || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return "", nil, 0, 0, fmt.Errorf("%s: invalid timestamp %q", name, fields[1])
+	}
+	return name, labels, value, int64(math.Round(seconds * 1000)), nil
+}
+
+// parseLabels parses label pairs up to the closing brace into labels and
+// returns the rest of the line.
+func parseLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " ")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		eq := strings.Index(s, "=")
+		if eq <= 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return "", fmt.Errorf("invalid labels at %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+2:]
+
+		var value strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			if c == '"' {
+				s, closed = s[i+1:], true
+				break
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return "", fmt.Errorf("unterminated value for label %s", name)
+		}
+		labels[name] = value.String()
+		s = strings.TrimPrefix(strings.TrimLeft(s, " "), ",")
+	}
+}
+
+func indexOf(fields []string, target string) int {
+	for i, field := range fields {
+		if field == target {
+			return i
+		}
+	}
+	return -1
+}
+
+var helpUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+
+func unescapeHelp(value string) string {
+	return helpUnescaper.Replace(value)
+}
@@ -0,0 +1,199 @@
+package metricsfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// sortedFamilies returns families ordered by name, so converted output is
+// stable between runs.
+func sortedFamilies(families map[string]*dto.MetricFamily) []*dto.MetricFamily {
+	sorted := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		sorted = append(sorted, family)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+	return sorted
+}
+
+// WriteOpenMetrics writes families as OpenMetrics text, ending with "# EOF".
+func WriteOpenMetrics(families map[string]*dto.MetricFamily, output io.Writer) error {
+	for _, family := range sortedFamilies(families) {
+		if _, err := expfmt.MetricFamilyToOpenMetrics(output, family); err != nil {
+			return fmt.Errorf("failed to write %s: %w", family.GetName(), err)
+		}
+	}
+	_, err := expfmt.FinalizeOpenMetrics(output)
+	return err
+}
+
+// WriteProtobuf writes families as length-delimited MetricFamily messages.
+func WriteProtobuf(families map[string]*dto.MetricFamily, output io.Writer) error {
+	for _, family := range sortedFamilies(families) {
+		if _, err := protodelim.MarshalTo(output, family); err != nil {
+			return fmt.Errorf("failed to write %s: %w", family.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes families in prom2json's format, the one parseJSON reads.
+func WriteJSON(families map[string]*dto.MetricFamily, output io.Writer) error {
+	var out []map[string]any
+	for _, family := range sortedFamilies(families) {
+		metrics := make([]map[string]any, 0, len(family.Metric))
+		for _, metric := range family.Metric {
+			m := map[string]any{}
+			if len(metric.Label) > 0 {
+				labels := make(map[string]string, len(metric.Label))
+				for _, label := range metric.Label {
+					labels[label.GetName()] = label.GetValue()
+				}
+				m["labels"] = labels
+			}
+			if metric.TimestampMs != nil {
+				m["timestamp_ms"] = strconv.FormatInt(metric.GetTimestampMs(), 10)
+			}
+			switch {
+			case metric.Histogram != nil:
+				buckets := make(map[string]string)
+				for _, bucket := range metric.GetHistogram().GetBucket() {
+					buckets[formatFloat(bucket.GetUpperBound())] = strconv.FormatUint(bucket.GetCumulativeCount(), 10)
+				}
+				m["buckets"] = buckets
+				m["count"] = strconv.FormatUint(metric.GetHistogram().GetSampleCount(), 10)
+				m["sum"] = formatFloat(metric.GetHistogram().GetSampleSum())
+			case metric.Summary != nil:
+				quantiles := make(map[string]string)
+				for _, q := range metric.GetSummary().GetQuantile() {
+					quantiles[formatFloat(q.GetQuantile())] = formatFloat(q.GetValue())
+				}
+				m["quantiles"] = quantiles
+				m["count"] = strconv.FormatUint(metric.GetSummary().GetSampleCount(), 10)
+				m["sum"] = formatFloat(metric.GetSummary().GetSampleSum())
+			default:
+				m["value"] = formatFloat(simpleValue(metric))
+			}
+			metrics = append(metrics, m)
+		}
+
+		f := map[string]any{"name": family.GetName(), "type": family.GetType().String(), "metrics": metrics}
+		if family.Help != nil {
+			f["help"] = family.GetHelp()
+		}
+		out = append(out, f)
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// WriteInflux writes families as InfluxDB line protocol, the way Telegraf's
+// prometheus input does: a measurement per family, labels as tags, and a
+// counter, gauge, or value field; histograms and summaries put their count,
+// sum, and each bucket bound or quantile in fields of one line.
+func WriteInflux(families map[string]*dto.MetricFamily, output io.Writer) error {
+	for _, family := range sortedFamilies(families) {
+		for _, metric := range family.Metric {
+			labels := make([]string, 0, len(metric.Label))
+			for _, label := range metric.Label {
+				labels = append(labels, influxEscape(label.GetName(), ",= ")+"="+influxEscape(label.GetValue(), ",= "))
+			}
+			sort.Strings(labels)
+			series := strings.Join(append([]string{influxEscape(family.GetName(), ", ")}, labels...), ",")
+
+			var fields []string
+			add := func(key string, value float64) {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					return // Line protocol has no representation for these
+				}
+				fields = append(fields, influxEscape(key, ",= ")+"="+strconv.FormatFloat(value, 'f', -1, 64))
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("counter", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("gauge", metric.GetGauge().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := metric.GetHistogram()
+				add("count", float64(h.GetSampleCount()))
+				add("sum", h.GetSampleSum())
+				for _, bucket := range h.GetBucket() {
+					if !math.IsInf(bucket.GetUpperBound(), 1) {
+						add(formatFloat(bucket.GetUpperBound()), float64(bucket.GetCumulativeCount()))
+					}
+				}
+			case dto.MetricType_SUMMARY:
+				s := metric.GetSummary()
+				add("count", float64(s.GetSampleCount()))
+				add("sum", s.GetSampleSum())
+				for _, q := range s.GetQuantile() {
+					add(formatFloat(q.GetQuantile()), q.GetValue())
+				}
+			default:
+				add("value", metric.GetUntyped().GetValue())
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			line := series + " " + strings.Join(fields, ",")
+			if metric.TimestampMs != nil {
+				line += " " + strconv.FormatInt(metric.GetTimestampMs()*int64(1e6), 10)
+			}
+			if _, err := fmt.Fprintln(output, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// influxEscape backslash-escapes the characters special in a line protocol
+// measurement, tag key, tag value, or field key.
+func influxEscape(s, special string) string {
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// simpleValue is the value of a counter, gauge, or untyped series.
+func simpleValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.Counter != nil:
+		return metric.GetCounter().GetValue()
+	case metric.Gauge != nil:
+		return metric.GetGauge().GetValue()
+	}
+	return metric.GetUntyped().GetValue()
+}
+
+// formatFloat formats a value the way the text format does, with +Inf,
+// -Inf, and NaN spelled out.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
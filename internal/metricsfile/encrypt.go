@@ -0,0 +1,116 @@
+package metricsfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// encryptedMagic starts every encrypted file. It is followed by the GCM
+// nonce and the sealed contents, with the magic as additional data.
+var encryptedMagic = []byte("omet-aes256gcm-v1\n")
+
+// KeySize is the length of an encryption key, for AES-256.
+const KeySize = 32
+
+// ErrDecrypt is returned when an encrypted file can't be opened with the
+// given key.
+var ErrDecrypt = errors.New("failed to decrypt: wrong key or corrupted file")
+
+// ErrNotEncrypted is returned when a key is given for a file that holds
+// plaintext, which means it was written without the key.
+var ErrNotEncrypted = errors.New("file isn't encrypted, but a key was given")
+
+// ErrEncrypted is returned when an encrypted file is read without a key.
+var ErrEncrypted = errors.New("file is encrypted; pass --encrypt-key-file")
+
+// LoadKey reads an AES-256 key from filename: 32 raw bytes, or the same
+// encoded as hex or base64 (surrounding whitespace is ignored).
+func LoadKey(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == KeySize {
+		return data, nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key file %s must hold a %d-byte key, raw or hex or base64 encoded (try: head -c %d /dev/urandom | base64)", filename, KeySize, KeySize)
+}
+
+// IsEncrypted reports whether data was written by Encrypt.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// Encrypt seals plaintext with AES-256-GCM under a fresh random nonce.
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(encryptedMagic)+gcm.NonceSize(), len(encryptedMagic)+gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	copy(out, encryptedMagic)
+	nonce := out[len(encryptedMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(out, nonce, plaintext, encryptedMagic), nil
+}
+
+// Decrypt opens data written by Encrypt. Empty data, a file that doesn't
+// exist yet, is returned as is; other plaintext is ErrNotEncrypted.
+func Decrypt(key, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		if len(bytes.TrimSpace(data)) == 0 {
+			return data, nil
+		}
+		return nil, ErrNotEncrypted
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := data[len(encryptedMagic):]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], encryptedMagic)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// Unseal returns data ready to parse: decrypted with key, or unchanged if
+// there is no key. Encrypted data without a key is ErrEncrypted, rather
+// than failing to parse.
+func Unseal(key, data []byte) ([]byte, error) {
+	if key != nil {
+		return Decrypt(key, data)
+	}
+	if IsEncrypted(data) {
+		return nil, ErrEncrypted
+	}
+	return data, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
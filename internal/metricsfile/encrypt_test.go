@@ -0,0 +1,70 @@
+package metricsfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+
+	sealed, err := Encrypt(key, []byte(sampleMetrics))
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+	assert.NotContains(t, string(sealed), "http_requests_total")
+
+	opened, err := Decrypt(key, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, sampleMetrics, string(opened))
+
+	again, err := Encrypt(key, []byte(sampleMetrics))
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "every write uses a fresh nonce")
+
+	_, err = Decrypt(bytes.Repeat([]byte{8}, KeySize), sealed)
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = Decrypt(key, sealed)
+	assert.ErrorIs(t, err, ErrDecrypt, "tampering is detected")
+
+	_, err = Decrypt(key, []byte(sampleMetrics))
+	assert.ErrorIs(t, err, ErrNotEncrypted, "plaintext is refused")
+	empty, err := Decrypt(key, nil)
+	require.NoError(t, err)
+	assert.Empty(t, empty, "empty files are new files")
+
+	_, err = Unseal(nil, again)
+	assert.ErrorIs(t, err, ErrEncrypted)
+	plain, err := Unseal(nil, []byte(sampleMetrics))
+	require.NoError(t, err)
+	assert.Equal(t, sampleMetrics, string(plain), "plaintext passes through without a key")
+}
+
+func TestLoadKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, KeySize)
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"raw":    string(key),
+		"hex":    hex.EncodeToString(key) + "\n",
+		"base64": base64.StdEncoding.EncodeToString(key) + "\n",
+	} {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+		loaded, err := LoadKey(filename)
+		require.NoError(t, err, name)
+		assert.Equal(t, key, loaded, name)
+	}
+
+	short := filepath.Join(dir, "short")
+	require.NoError(t, os.WriteFile(short, []byte("secret\n"), 0600))
+	_, err := LoadKey(short)
+	assert.ErrorContains(t, err, "32-byte key")
+}
@@ -0,0 +1,457 @@
+package metricsfile
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// errFallback makes parseFast hand the input to the expfmt parser. It covers
+// anything outside the plain text format omet writes — escapes, quoted
+// names, summaries, odd whitespace — and every syntax error, so error
+// messages always come from expfmt.
+var errFallback = errors.New("fall back to expfmt")
+
+// slabSize is how many protobuf structs parseFast allocates at once.
+const slabSize = 1024
+
+// fastParser scans the common text format directly from one string holding
+// the whole input, so label values and HELP text are substrings of it
+// rather than fresh allocations, and allocates protobuf structs in slabs.
+// It produces the same families as expfmt's TextParser or gives up.
+type fastParser struct {
+	src      string
+	pos      int
+	families map[string]*dto.MetricFamily
+
+	// histograms maps family name and labels without "le" to the series
+	// their samples accumulate into.
+	histograms map[string]*dto.Metric
+	names      map[string]*string // interned label names
+	scratch    []*dto.LabelPair   // labels of the sample being parsed
+
+	metrics []dto.Metric
+	pairs   []dto.LabelPair
+	strs    []string
+	floats  []float64
+	gauges  []dto.Gauge
+	counts  []dto.Counter
+	untyped []dto.Untyped
+	sets    []*dto.LabelPair // backing store for label slices
+}
+
+// parseFast parses data like expfmt's TextParser, or returns errFallback.
+func parseFast(data []byte) (map[string]*dto.MetricFamily, error) {
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		return nil, errFallback // expfmt reports the missing final newline
+	}
+	p := &fastParser{
+		src:        string(data),
+		families:   make(map[string]*dto.MetricFamily),
+		histograms: make(map[string]*dto.Metric),
+		names:      make(map[string]*string),
+	}
+
+	for p.pos < len(p.src) {
+		var err error
+		p.skipBlank()
+		switch p.src[p.pos] {
+		case '\n':
+			p.pos++
+		case '#':
+			err = p.comment()
+		default:
+			err = p.sample()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, family := range p.families {
+		if len(family.Metric) == 0 {
+			delete(p.families, name)
+		}
+	}
+	return p.families, nil
+}
+
+func (p *fastParser) skipBlank() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// line returns the rest of the current line and moves past its newline.
+func (p *fastParser) line() string {
+	end := p.pos + strings.IndexByte(p.src[p.pos:], '\n')
+	line := p.src[p.pos:end]
+	p.pos = end + 1
+	return line
+}
+
+// comment handles HELP and TYPE lines and skips any other comment.
+func (p *fastParser) comment() error {
+	p.pos++ // '#'
+	keyword, rest := cutToken(p.line())
+	if keyword != "HELP" && keyword != "TYPE" {
+		return nil
+	}
+	name, rest := cutToken(rest)
+	rest = strings.TrimLeft(rest, " \t")
+	if !isMetricName(name) || rest == "" || strings.IndexByte(rest, '\\') >= 0 {
+		return errFallback
+	}
+
+	family, _ := p.family(name)
+	switch keyword {
+	case "HELP":
+		if family.Help != nil {
+			return errFallback
+		}
+		family.Help = &rest
+	case "TYPE":
+		metricType, ok := ParseTypeName(rest)
+		if !ok || family.Type != nil {
+			return errFallback
+		}
+		family.Type = metricType.Enum()
+	}
+	return nil
+}
+
+// cutToken splits off the first blank- or tab-separated token.
+func cutToken(s string) (token, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	end := strings.IndexAny(s, " \t")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// family finds the family a name belongs to, resolving histogram and
+// summary suffixes like expfmt does, and returns the suffix matched.
+func (p *fastParser) family(name string) (*dto.MetricFamily, string) {
+	if family, ok := p.families[name]; ok {
+		return family, ""
+	}
+	for _, suffix := range []string{"_count", "_sum", "_bucket", "_gcount", "_gsum"} {
+		base, ok := strings.CutSuffix(name, suffix)
+		if !ok || base == "" {
+			continue
+		}
+		if family, ok := p.families[base]; ok && ownsSuffix(family.GetType(), suffix) {
+			return family, suffix
+		}
+	}
+	// Copied so only a new family moves a name to the heap
+	familyName := name
+	family := &dto.MetricFamily{Name: &familyName}
+	p.families[name] = family
+	return family, ""
+}
+
+// sample parses one sample line.
+func (p *fastParser) sample() error {
+	start := p.pos
+	for p.pos < len(p.src) && isNameByte(p.src[p.pos], p.pos > start) {
+		p.pos++
+	}
+	if p.pos == start {
+		return errFallback // e.g. '{' first or a quoted name
+	}
+	family, suffix := p.family(p.src[start:p.pos])
+	if family.Type == nil {
+		family.Type = dto.MetricType_UNTYPED.Enum()
+	}
+	metricType := family.GetType()
+	if metricType == dto.MetricType_SUMMARY {
+		return errFallback
+	}
+	histogram := isHistogram(metricType)
+	if histogram && suffix == "" {
+		return errFallback
+	}
+
+	p.skipBlank()
+	labels, bucket, err := p.labels(histogram, suffix == "_bucket")
+	if err != nil {
+		return err
+	}
+
+	// Value, then an optional timestamp, then the newline
+	end := p.pos
+	for end < len(p.src) && p.src[end] != ' ' && p.src[end] != '\t' && p.src[end] != '\n' {
+		end++
+	}
+	value, err := parseValue(p.src[p.pos:end])
+	if err != nil {
+		return errFallback
+	}
+	p.pos = end
+	var timestamp *int64
+	if p.src[p.pos] != '\n' {
+		p.skipBlank()
+		end = p.pos + strings.IndexByte(p.src[p.pos:], '\n')
+		ts, err := strconv.ParseInt(p.src[p.pos:end], 10, 64)
+		if err != nil {
+			return errFallback
+		}
+		timestamp = &ts
+		p.pos = end
+	}
+	p.pos++ // '\n'
+
+	if !histogram {
+		metric := p.metric()
+		metric.Label = labels
+		metric.TimestampMs = timestamp
+		switch metricType {
+		case dto.MetricType_COUNTER:
+			metric.Counter = p.counter(value)
+		case dto.MetricType_GAUGE:
+			metric.Gauge = p.gauge(value)
+		case dto.MetricType_UNTYPED:
+			metric.Untyped = p.untypedValue(value)
+		default:
+			return errFallback
+		}
+		family.Metric = append(family.Metric, metric)
+		return nil
+	}
+
+	key := histogramKey(family.GetName(), labels)
+	metric, ok := p.histograms[key]
+	if !ok {
+		metric = p.metric()
+		metric.Label = labels
+		metric.Histogram = &dto.Histogram{}
+		p.histograms[key] = metric
+		family.Metric = append(family.Metric, metric)
+	}
+	if timestamp != nil {
+		metric.TimestampMs = timestamp
+	}
+	switch suffix {
+	case "_count", "_gcount":
+		count := uint64(value)
+		metric.Histogram.SampleCount = &count
+	case "_sum", "_gsum":
+		metric.Histogram.SampleSum = p.float(value)
+	case "_bucket":
+		count := uint64(value)
+		metric.Histogram.Bucket = append(metric.Histogram.Bucket, &dto.Bucket{
+			UpperBound:      p.float(bucket),
+			CumulativeCount: &count,
+		})
+	}
+	return nil
+}
+
+// labels parses an optional label set. For histograms the "le" label is
+// returned as the bucket bound instead of as a label, and bucket samples
+// must have one.
+func (p *fastParser) labels(histogram, isBucket bool) ([]*dto.LabelPair, float64, error) {
+	bucket := math.NaN()
+	if p.src[p.pos] != '{' {
+		if isBucket {
+			return nil, 0, errFallback
+		}
+		return nil, bucket, nil
+	}
+	p.pos++
+
+	labels := p.scratch[:0]
+	for {
+		p.skipBlank()
+		if p.src[p.pos] == '}' {
+			break
+		}
+
+		start := p.pos
+		for p.pos < len(p.src) && isLabelNameByte(p.src[p.pos], p.pos > start) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		p.skipBlank()
+		if name == "" || name == "__name__" || p.src[p.pos] != '=' {
+			return nil, 0, errFallback
+		}
+		p.pos++
+		p.skipBlank()
+		if p.src[p.pos] != '"' {
+			return nil, 0, errFallback
+		}
+		p.pos++
+
+		end := strings.IndexAny(p.src[p.pos:], "\"\\\n")
+		if end < 0 || p.src[p.pos+end] != '"' {
+			return nil, 0, errFallback // escapes and newlines go to expfmt
+		}
+		value := p.src[p.pos : p.pos+end]
+		p.pos += end + 1
+		if !utf8.ValidString(value) {
+			return nil, 0, errFallback
+		}
+
+		for _, label := range labels {
+			if label.GetName() == name {
+				return nil, 0, errFallback
+			}
+		}
+		if histogram && name == "le" {
+			bound, err := parseValue(value)
+			if err != nil {
+				return nil, 0, errFallback
+			}
+			bucket = bound
+		} else {
+			labels = append(labels, p.pair(name, value))
+		}
+
+		p.skipBlank()
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, 0, errFallback
+		}
+		if p.src[p.pos] == '}' {
+			break
+		}
+	}
+	p.pos++ // '}'
+	p.skipBlank()
+
+	if isBucket && math.IsNaN(bucket) {
+		return nil, 0, errFallback
+	}
+	p.scratch = labels
+	return p.labelSet(labels), bucket, nil
+}
+
+// histogramKey identifies a histogram series regardless of label order.
+func histogramKey(name string, labels []*dto.LabelPair) string {
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = label.GetName() + "\xff" + label.GetValue()
+	}
+	sort.Strings(parts)
+	return name + "\xfe" + strings.Join(parts, "\xfe")
+}
+
+// parseValue parses a float the way expfmt does.
+func parseValue(s string) (float64, error) {
+	if strings.ContainsAny(s, "pP_") {
+		return 0, errFallback
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func isNameByte(b byte, continuation bool) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || b == '_' || b == ':' || (continuation && b >= '0' && b <= '9')
+}
+
+func isLabelNameByte(b byte, continuation bool) bool {
+	return b != ':' && isNameByte(b, continuation)
+}
+
+func isMetricName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isNameByte(name[i], i > 0) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// The helpers below hand out structs from slabs, trading a little unused
+// capacity for far fewer allocations on large files.
+
+func (p *fastParser) metric() *dto.Metric {
+	if len(p.metrics) == 0 {
+		p.metrics = make([]dto.Metric, slabSize)
+	}
+	metric := &p.metrics[0]
+	p.metrics = p.metrics[1:]
+	return metric
+}
+
+func (p *fastParser) pair(name, value string) *dto.LabelPair {
+	interned, ok := p.names[name]
+	if !ok {
+		labelName := name
+		interned = &labelName
+		p.names[name] = interned
+	}
+	if len(p.pairs) == 0 {
+		p.pairs = make([]dto.LabelPair, slabSize)
+		p.strs = make([]string, slabSize)
+	}
+	pair := &p.pairs[0]
+	p.strs[0] = value
+	pair.Name, pair.Value = interned, &p.strs[0]
+	p.pairs, p.strs = p.pairs[1:], p.strs[1:]
+	return pair
+}
+
+// labelSet copies labels out of the scratch buffer into a slab.
+func (p *fastParser) labelSet(labels []*dto.LabelPair) []*dto.LabelPair {
+	if len(labels) == 0 {
+		return nil
+	}
+	if len(p.sets) < len(labels) {
+		p.sets = make([]*dto.LabelPair, max(slabSize, len(labels)))
+	}
+	set := p.sets[:len(labels):len(labels)]
+	copy(set, labels)
+	p.sets = p.sets[len(labels):]
+	return set
+}
+
+func (p *fastParser) float(value float64) *float64 {
+	if len(p.floats) == 0 {
+		p.floats = make([]float64, slabSize)
+	}
+	p.floats[0] = value
+	f := &p.floats[0]
+	p.floats = p.floats[1:]
+	return f
+}
+
+func (p *fastParser) gauge(value float64) *dto.Gauge {
+	if len(p.gauges) == 0 {
+		p.gauges = make([]dto.Gauge, slabSize)
+	}
+	gauge := &p.gauges[0]
+	gauge.Value = p.float(value)
+	p.gauges = p.gauges[1:]
+	return gauge
+}
+
+func (p *fastParser) counter(value float64) *dto.Counter {
+	if len(p.counts) == 0 {
+		p.counts = make([]dto.Counter, slabSize)
+	}
+	counter := &p.counts[0]
+	counter.Value = p.float(value)
+	p.counts = p.counts[1:]
+	return counter
+}
+
+func (p *fastParser) untypedValue(value float64) *dto.Untyped {
+	if len(p.untyped) == 0 {
+		p.untyped = make([]dto.Untyped, slabSize)
+	}
+	untyped := &p.untyped[0]
+	untyped.Value = p.float(value)
+	p.untyped = p.untyped[1:]
+	return untyped
+}
@@ -0,0 +1,108 @@
+package metricsfile
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestParseFastMatchesExpfmt checks that whenever the fast parser accepts an
+// input it produces exactly what expfmt does.
+func TestParseFastMatchesExpfmt(t *testing.T) {
+	inputs := map[string]struct {
+		input    string
+		fallback bool
+	}{
+		"sample file":          {input: sampleMetrics},
+		"empty":                {input: ""},
+		"untyped and comments": {input: "# just a comment\n\n  plain 1\nplain{a=\"b\"} 2 1700000000000\n"},
+		"help and type order":  {input: "# TYPE up gauge\n# HELP up Whether it's up\nup{job=\"x\"} 1\n"},
+		"special values":       {input: "a NaN\nb +Inf\nc -Inf\nd 1e-3\n"},
+		"blank padding":        {input: "m { a = \"1\" , b=\"2\", } 3\n"},
+		"histogram label order": {input: "# TYPE h histogram\n" +
+			"h_bucket{a=\"1\",b=\"2\",le=\"1\"} 1\nh_bucket{b=\"2\",a=\"1\",le=\"+Inf\"} 2\nh_sum{a=\"1\",b=\"2\"} 3\nh_count{le=\"9\",a=\"1\",b=\"2\"} 2\n"},
+		"suffix of other type": {input: "# TYPE g gauge\ng 1\ng_count 2\n# HELP g_sum not part of g\ng_sum 3\n"},
+		"empty family dropped": {input: "# HELP nothing No samples\n# TYPE nothing counter\n"},
+		"utf8 label value":     {input: "m{city=\"Zürich\"} 1\n"},
+
+		"escaped label":         {input: "m{path=\"a\\\"b\"} 1\n", fallback: true},
+		"escaped help":          {input: "# HELP m line\\nbreak\nm 1\n", fallback: true},
+		"summary":               {input: "# TYPE s summary\ns{quantile=\"0.5\"} 1\ns_sum 2\ns_count 3\n", fallback: true},
+		"no final newline":      {input: "m 1", fallback: true},
+		"trailing blank":        {input: "m 1 \n", fallback: true},
+		"bad value":             {input: "m one\n", fallback: true},
+		"braces first":          {input: "{__name__=\"m\"} 1\n", fallback: true},
+		"second type":           {input: "# TYPE m gauge\n# TYPE m counter\nm 1\n", fallback: true},
+		"type after samples":    {input: "m 1\n# TYPE m gauge\n", fallback: true},
+		"duplicate label names": {input: "m{a=\"1\",a=\"2\"} 1\n", fallback: true},
+		"hex float":             {input: "m 0x1p3\n", fallback: true},
+		"bucket without le":     {input: "# TYPE h histogram\nh_bucket 1\n", fallback: true},
+	}
+
+	for name, tt := range inputs {
+		t.Run(name, func(t *testing.T) {
+			fast, err := parseFast([]byte(tt.input))
+			if tt.fallback {
+				assert.ErrorIs(t, err, errFallback)
+				return
+			}
+			require.NoError(t, err)
+
+			parser := expfmt.TextParser{}
+			expected, err := parser.TextToMetricFamilies(strings.NewReader(tt.input))
+			require.NoError(t, err)
+
+			require.Len(t, fast, len(expected))
+			for name, family := range expected {
+				assert.True(t, proto.Equal(family, fast[name]), "family %s:\nexpfmt: %v\nfast:   %v", name, family, fast[name])
+			}
+		})
+	}
+}
+
+// syntheticFile renders families*series counter and gauge samples with a
+// few labels each, roughly the shape of a large textfile collector file.
+func syntheticFile(families, series int) []byte {
+	var out bytes.Buffer
+	for f := 0; f < families; f++ {
+		kind := "counter"
+		if f%2 == 1 {
+			kind = "gauge"
+		}
+		fmt.Fprintf(&out, "# HELP family_%d_total Synthetic family %d\n# TYPE family_%d_total %s\n", f, f, f, kind)
+		for s := 0; s < series; s++ {
+			fmt.Fprintf(&out, "family_%d_total{instance=\"host-%d\",job=\"synthetic\",shard=\"%d\"} %d\n", f, s, s%16, s*7)
+		}
+	}
+	return out.Bytes()
+}
+
+func BenchmarkParseText(b *testing.B) {
+	data := syntheticFile(100, 1000) // 100k series
+
+	b.Run("fast", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := parseFast(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("expfmt", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parser := expfmt.TextParser{}
+			if _, err := parser.TextToMetricFamilies(bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
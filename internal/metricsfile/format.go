@@ -0,0 +1,325 @@
+package metricsfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+)
+
+// Format is an exposition format omet reads. Whatever the input, output is
+// always the Prometheus text format.
+type Format int
+
+const (
+	FormatText        Format = iota // Prometheus text format
+	FormatOpenMetrics               // OpenMetrics text, ending with "# EOF"
+	FormatProtobuf                  // length-delimited MetricFamily messages
+	FormatJSON                      // prom2json output
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatOpenMetrics:
+		return "openmetrics"
+	case FormatProtobuf:
+		return "protobuf"
+	case FormatJSON:
+		return "json"
+	}
+	return "text"
+}
+
+// formatsByExtension are the file extensions that settle the format without
+// looking at the content.
+var formatsByExtension = map[string]Format{
+	".pb":          FormatProtobuf,
+	".proto":       FormatProtobuf,
+	".json":        FormatJSON,
+	".om":          FormatOpenMetrics,
+	".openmetrics": FormatOpenMetrics,
+}
+
+// DetectFormat guesses the format of data, by filename's extension where it
+// is telling and otherwise by content: JSON starts with a bracket, protobuf
+// has control bytes text never does, and OpenMetrics ends with "# EOF".
+func DetectFormat(data []byte, filename string) Format {
+	if format, ok := formatsByExtension[strings.ToLower(filepath.Ext(filename))]; ok {
+		return format
+	}
+
+	head := data[:min(len(data), 512)]
+	for _, b := range head {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return FormatProtobuf
+		}
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, utf8BOM))
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return FormatJSON
+	}
+	if bytes.HasSuffix(trimmed, []byte("# EOF")) {
+		return FormatOpenMetrics
+	}
+	return FormatText
+}
+
+// parseProtobuf reads length-delimited MetricFamily messages, as served to
+// Prometheus with the protobuf content type.
+func parseProtobuf(data []byte) (map[string]*dto.MetricFamily, error) {
+	families := make(map[string]*dto.MetricFamily)
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		family := &dto.MetricFamily{}
+		err := protodelim.UnmarshalFrom(reader, family)
+		if errors.Is(err, io.EOF) {
+			return families, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid protobuf metric family: %w", err)
+		}
+		if !isMetricName(family.GetName()) {
+			return nil, fmt.Errorf("invalid metric name %q", family.GetName())
+		}
+		if existing, ok := families[family.GetName()]; ok {
+			existing.Metric = append(existing.Metric, family.Metric...)
+			continue
+		}
+		families[family.GetName()] = family
+	}
+}
+
+// jsonFamily is a metric family as written by prom2json.
+type jsonFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+type jsonMetric struct {
+	Labels      map[string]string     `json:"labels"`
+	TimestampMs jsonNumber            `json:"timestamp_ms"`
+	Value       jsonNumber            `json:"value"`
+	Buckets     map[string]jsonNumber `json:"buckets"`
+	Quantiles   map[string]jsonNumber `json:"quantiles"`
+	Count       jsonNumber            `json:"count"`
+	Sum         jsonNumber            `json:"sum"`
+}
+
+// jsonNumber accepts a number or, as prom2json writes them, a string such
+// as "1.5" or "+Inf".
+type jsonNumber struct {
+	value float64
+	set   bool
+}
+
+func (n *jsonNumber) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	value, err := parseValue(s)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	n.value, n.set = value, true
+	return nil
+}
+
+// parseJSON reads prom2json output: an array of families, or a single one.
+func parseJSON(data []byte) (map[string]*dto.MetricFamily, error) {
+	var decoded []jsonFamily
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, utf8BOM))
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var single jsonFamily
+		if err := json.Unmarshal(trimmed, &single); err != nil {
+			return nil, fmt.Errorf("invalid JSON metrics: %w", err)
+		}
+		decoded = []jsonFamily{single}
+	} else if err := json.Unmarshal(trimmed, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid JSON metrics: %w", err)
+	}
+
+	families := make(map[string]*dto.MetricFamily, len(decoded))
+	for _, f := range decoded {
+		if !isMetricName(f.Name) {
+			return nil, fmt.Errorf("invalid metric name %q", f.Name)
+		}
+		metricType, ok := ParseTypeName(strings.ToLower(f.Type))
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q for %s", f.Type, f.Name)
+		}
+		family, ok := families[f.Name]
+		if !ok {
+			family = &dto.MetricFamily{Name: &f.Name, Type: metricType.Enum()}
+			if f.Help != "" {
+				family.Help = &f.Help
+			}
+			families[f.Name] = family
+		}
+		for _, m := range f.Metrics {
+			metric, err := m.metric(metricType)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			family.Metric = append(family.Metric, metric)
+		}
+	}
+	return families, nil
+}
+
+func (m jsonMetric) metric(metricType dto.MetricType) (*dto.Metric, error) {
+	metric := &dto.Metric{}
+	for name, value := range m.Labels {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	if m.TimestampMs.set {
+		metric.TimestampMs = proto.Int64(int64(m.TimestampMs.value))
+	}
+
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		metric.Counter = &dto.Counter{Value: &m.Value.value}
+	case dto.MetricType_GAUGE:
+		metric.Gauge = &dto.Gauge{Value: &m.Value.value}
+	case dto.MetricType_UNTYPED:
+		metric.Untyped = &dto.Untyped{Value: &m.Value.value}
+	case dto.MetricType_SUMMARY:
+		summary := &dto.Summary{SampleCount: proto.Uint64(uint64(m.Count.value)), SampleSum: &m.Sum.value}
+		for q, value := range m.Quantiles {
+			quantile, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quantile %q", q)
+			}
+			summary.Quantile = append(summary.Quantile, &dto.Quantile{Quantile: &quantile, Value: &value.value})
+		}
+		sort.Slice(summary.Quantile, func(i, j int) bool {
+			return summary.Quantile[i].GetQuantile() < summary.Quantile[j].GetQuantile()
+		})
+		metric.Summary = summary
+	default:
+		histogram := &dto.Histogram{SampleCount: proto.Uint64(uint64(m.Count.value)), SampleSum: &m.Sum.value}
+		hasInf := false
+		for le, count := range m.Buckets {
+			bound, err := parseValue(le)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bucket bound %q", le)
+			}
+			hasInf = hasInf || math.IsInf(bound, 1)
+			histogram.Bucket = append(histogram.Bucket, &dto.Bucket{UpperBound: &bound, CumulativeCount: proto.Uint64(uint64(count.value))})
+		}
+		if !hasInf {
+			// Implied by the count, but the text format spells it out
+			histogram.Bucket = append(histogram.Bucket, &dto.Bucket{UpperBound: proto.Float64(math.Inf(1)), CumulativeCount: histogram.SampleCount})
+		}
+		sort.Slice(histogram.Bucket, func(i, j int) bool {
+			return histogram.Bucket[i].GetUpperBound() < histogram.Bucket[j].GetUpperBound()
+		})
+		metric.Histogram = histogram
+	}
+	return metric, nil
+}
+
+// openMetricsTypes maps OpenMetrics-only types to their nearest text format
+// type.
+var openMetricsTypes = map[string]string{
+	"unknown":  "untyped",
+	"stateset": "gauge",
+	"info":     "gauge",
+}
+
+// openMetricsToText rewrites OpenMetrics as the Prometheus text format:
+// counter and info families take the _total and _info names of their
+// samples, _created samples and exemplars are dropped, timestamps become
+// milliseconds, and "# EOF" goes.
+func openMetricsToText(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+
+	// Families whose samples carry a suffix the text format keeps in the
+	// family name
+	renamed := make(map[string]string)
+	typed := make(map[string]bool)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "#" || fields[1] != "TYPE" {
+			continue
+		}
+		typed[fields[2]] = true
+		switch fields[3] {
+		case "counter":
+			if !strings.HasSuffix(fields[2], "_total") {
+				renamed[fields[2]] = fields[2] + "_total"
+			}
+		case "info":
+			renamed[fields[2]] = fields[2] + "_info"
+		}
+	}
+
+	var out strings.Builder
+	for _, line := range lines {
+		if line == "# EOF" {
+			break
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && (fields[1] == "TYPE" || fields[1] == "HELP" || fields[1] == "UNIT") {
+				if name, ok := renamed[fields[2]]; ok {
+					line = strings.Replace(line, fields[2], name, 1)
+				}
+				if fields[1] == "TYPE" && len(fields) == 4 {
+					if textType, ok := openMetricsTypes[fields[3]]; ok {
+						line = strings.TrimSuffix(line, fields[3]) + textType
+					}
+				}
+			}
+			out.WriteString(line + "\n")
+			continue
+		}
+
+		series, rest := splitSample(line)
+		name, _, _ := strings.Cut(series, "{")
+		if base, ok := strings.CutSuffix(name, "_created"); ok && typed[base] {
+			continue
+		}
+		rest, _, _ = strings.Cut(rest, " # ") // Exemplar
+		fields := strings.Fields(rest)
+		if len(fields) == 2 {
+			if seconds, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				fields[1] = strconv.FormatInt(int64(math.Round(seconds*1000)), 10)
+			}
+		}
+		out.WriteString(series + " " + strings.Join(fields, " ") + "\n")
+	}
+	return []byte(out.String())
+}
+
+// splitSample splits a sample line after its name and labels, skipping
+// over braces and spaces inside quoted label values.
+func splitSample(line string) (series, rest string) {
+	inLabels, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"' && inLabels:
+			quoted = !quoted
+		case quoted:
+		case c == '{':
+			inLabels = true
+		case c == '}':
+			inLabels = false
+		case (c == ' ' || c == '\t') && !inLabels:
+			return line[:i], line[i:]
+		}
+	}
+	return line, ""
+}
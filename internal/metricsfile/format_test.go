@@ -0,0 +1,124 @@
+package metricsfile
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// protobufMetrics encodes sampleMetrics as length-delimited protobuf.
+func protobufMetrics(t *testing.T) []byte {
+	families, err := Parse(strings.NewReader(sampleMetrics))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	for _, name := range []string{"http_requests_total", "latency_seconds", "queue_depth"} {
+		_, err := protodelim.MarshalTo(&buf, families[name])
+		require.NoError(t, err)
+	}
+	return buf.Bytes()
+}
+
+const jsonMetrics = `[
+  {"name": "http_requests_total", "help": "Total HTTP requests", "type": "COUNTER",
+   "metrics": [{"labels": {"method": "GET"}, "value": "85"}]},
+  {"name": "queue_depth", "type": "GAUGE", "metrics": [{"value": 42}]},
+  {"name": "latency_seconds", "type": "HISTOGRAM",
+   "metrics": [{"buckets": {"0.1": "3", "+Inf": "5"}, "count": "5", "sum": "1.5"}]}
+]`
+
+const openMetrics = `# HELP http_requests Total HTTP requests
+# TYPE http_requests counter
+http_requests_total{method="GET"} 85 1700000000.5 # {trace_id="abc"} 1 1700000000
+http_requests_created{method="GET"} 1699990000
+# TYPE queue_depth gauge
+queue_depth 42
+# TYPE build info
+build_info{version="1.2"} 1
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 3
+latency_seconds_bucket{le="+Inf"} 5
+latency_seconds_count 5
+latency_seconds_sum 1.5
+# EOF
+`
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatText, DetectFormat([]byte(sampleMetrics), ""))
+	assert.Equal(t, FormatOpenMetrics, DetectFormat([]byte(openMetrics), ""))
+	assert.Equal(t, FormatJSON, DetectFormat([]byte(jsonMetrics), ""))
+	assert.Equal(t, FormatProtobuf, DetectFormat(protobufMetrics(t), ""))
+	assert.Equal(t, FormatText, DetectFormat(nil, ""))
+
+	// The extension decides where it's telling
+	assert.Equal(t, FormatJSON, DetectFormat([]byte("  \n"), "metrics.json"))
+	assert.Equal(t, FormatProtobuf, DetectFormat([]byte("#\n"), "metrics.PB"))
+	assert.Equal(t, FormatText, DetectFormat([]byte(sampleMetrics), "metrics.prom"))
+}
+
+func TestParseFormats(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"protobuf":    protobufMetrics(t),
+		"json":        []byte(jsonMetrics),
+		"openmetrics": []byte(openMetrics),
+	} {
+		t.Run(name, func(t *testing.T) {
+			families, err := Parse(bytes.NewReader(data))
+			require.NoError(t, err)
+
+			counter := families["http_requests_total"]
+			require.NotNil(t, counter)
+			assert.Equal(t, dto.MetricType_COUNTER, counter.GetType())
+			assert.Equal(t, "Total HTTP requests", counter.GetHelp())
+			assert.Equal(t, 85.0, counter.Metric[0].GetCounter().GetValue())
+			assert.Equal(t, 42.0, families["queue_depth"].Metric[0].GetGauge().GetValue())
+
+			histogram := families["latency_seconds"].Metric[0].GetHistogram()
+			assert.Equal(t, uint64(5), histogram.GetSampleCount())
+			assert.Equal(t, 1.5, histogram.GetSampleSum())
+			assert.Equal(t, uint64(3), histogram.Bucket[0].GetCumulativeCount())
+			require.Len(t, histogram.Bucket, 2)
+			assert.True(t, math.IsInf(histogram.Bucket[1].GetUpperBound(), 1))
+			assert.Equal(t, uint64(5), histogram.Bucket[1].GetCumulativeCount(), "the +Inf bucket is kept")
+		})
+	}
+}
+
+func TestParseOpenMetrics(t *testing.T) {
+	families, err := Parse(strings.NewReader(openMetrics))
+	require.NoError(t, err)
+
+	assert.NotContains(t, families, "http_requests_created")
+	assert.Equal(t, int64(1700000000500), families["http_requests_total"].Metric[0].GetTimestampMs())
+	assert.Equal(t, dto.MetricType_GAUGE, families["build_info"].GetType())
+}
+
+func TestParseJSONAddsInfBucket(t *testing.T) {
+	families, err := Parse(strings.NewReader(`[{"name": "latency_seconds", "type": "HISTOGRAM",
+  "metrics": [{"buckets": {"0.1": "3"}, "count": "5", "sum": "1.5"}]}]`))
+	require.NoError(t, err)
+
+	buckets := families["latency_seconds"].Metric[0].GetHistogram().GetBucket()
+	require.Len(t, buckets, 2)
+	assert.True(t, math.IsInf(buckets[1].GetUpperBound(), 1))
+	assert.Equal(t, uint64(5), buckets[1].GetCumulativeCount(), "taken from the count")
+}
+
+func TestParseFileByExtension(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"name": "up", "type": "GAUGE", "metrics": [{"value": "1"}]}`), 0644))
+
+	families, err := ParseFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, families["up"].Metric[0].GetGauge().GetValue())
+
+	_, err = Parse(strings.NewReader(`[{"name": "up", "type": "SOMETHING"}]`))
+	assert.ErrorContains(t, err, `unknown type "SOMETHING"`)
+}
@@ -0,0 +1,81 @@
+package metricsfile
+
+import (
+	"bytes"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// OpenMetrics gauge histograms are written "gaugehistogram" in TYPE lines,
+// with _gcount and _gsum samples instead of _count and _sum. Their buckets
+// are cumulative like a histogram's, but the distribution is a snapshot that
+// can shrink as well as grow.
+const gaugeHistogramType = "gaugehistogram"
+
+// TypeName returns the name of a metric type as written in TYPE lines.
+func TypeName(metricType dto.MetricType) string {
+	if metricType == dto.MetricType_GAUGE_HISTOGRAM {
+		return gaugeHistogramType
+	}
+	return strings.ToLower(metricType.String())
+}
+
+// ParseTypeName is the inverse of TypeName. It also accepts the protobuf
+// enum names in lower case, such as gauge_histogram.
+func ParseTypeName(name string) (dto.MetricType, bool) {
+	if name == gaugeHistogramType {
+		return dto.MetricType_GAUGE_HISTOGRAM, true
+	}
+	value, ok := dto.MetricType_value[strings.ToUpper(name)]
+	return dto.MetricType(value), ok
+}
+
+// ownsSuffix reports whether samples of a family of this type carry suffix.
+func ownsSuffix(metricType dto.MetricType, suffix string) bool {
+	switch metricType {
+	case dto.MetricType_HISTOGRAM:
+		return suffix == "_bucket" || suffix == "_count" || suffix == "_sum"
+	case dto.MetricType_GAUGE_HISTOGRAM:
+		return suffix == "_bucket" || suffix == "_gcount" || suffix == "_gsum"
+	case dto.MetricType_SUMMARY:
+		return suffix == "_count" || suffix == "_sum"
+	}
+	return false
+}
+
+// isHistogram reports whether a family's series are histograms.
+func isHistogram(metricType dto.MetricType) bool {
+	return metricType == dto.MetricType_HISTOGRAM || metricType == dto.MetricType_GAUGE_HISTOGRAM
+}
+
+// disguiseGaugeHistograms rewrites gauge histograms in data as plain
+// histograms, which expfmt's parser understands, returning the rewritten
+// input and the names of the families to turn back into gauge histograms.
+func disguiseGaugeHistograms(data []byte) ([]byte, []string) {
+	if !bytes.Contains(data, []byte(gaugeHistogramType)) {
+		return data, nil
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	var names []string
+	for i, line := range lines {
+		fields := strings.Fields(string(line))
+		if len(fields) == 4 && fields[0] == "#" && fields[1] == "TYPE" && fields[3] == gaugeHistogramType {
+			names = append(names, fields[2])
+			lines[i] = []byte("# TYPE " + fields[2] + " histogram")
+		}
+	}
+	for i, line := range lines {
+		for _, name := range names {
+			for _, suffix := range []string{"_gcount", "_gsum"} {
+				prefix := []byte(name + suffix)
+				if !bytes.HasPrefix(line, prefix) || len(line) == len(prefix) || !bytes.ContainsRune([]byte("{ \t"), rune(line[len(prefix)])) {
+					continue
+				}
+				rest := line[len(prefix):]
+				lines[i] = append([]byte(name+strings.Replace(suffix, "_g", "_", 1)), rest...)
+			}
+		}
+	}
+	return bytes.Join(lines, []byte("\n")), names
+}
@@ -0,0 +1,97 @@
+package metricsfile
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// CheckHistogram returns the inconsistencies of a histogram series, as
+// tools other than omet may write them: bucket bounds that are NaN,
+// repeated, or out of order, cumulative counts that go down, and a +Inf
+// bucket that is missing or disagrees with the sample count. A histogram
+// without buckets has nothing to check.
+func CheckHistogram(h *dto.Histogram) []string {
+	buckets := h.GetBucket()
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	for _, bucket := range buckets {
+		if math.IsNaN(bucket.GetUpperBound()) {
+			return []string{"bucket bound le=\"NaN\""}
+		}
+	}
+	var problems []string
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i].GetUpperBound() < buckets[i-1].GetUpperBound() {
+			problems = append(problems, "buckets out of le order")
+			break
+		}
+	}
+
+	sorted := sortedBuckets(buckets)
+	for i := 1; i < len(sorted); i++ {
+		previous, bucket := sorted[i-1], sorted[i]
+		if bucket.GetUpperBound() == previous.GetUpperBound() {
+			return append(problems, fmt.Sprintf("bucket le=%q repeated", formatFloat(bucket.GetUpperBound())))
+		}
+		if bucket.GetCumulativeCount() < previous.GetCumulativeCount() {
+			problems = append(problems, fmt.Sprintf("bucket le=%q count %d is below le=%q count %d",
+				formatFloat(bucket.GetUpperBound()), bucket.GetCumulativeCount(), formatFloat(previous.GetUpperBound()), previous.GetCumulativeCount()))
+		}
+	}
+
+	last := sorted[len(sorted)-1]
+	switch {
+	case !math.IsInf(last.GetUpperBound(), 1):
+		problems = append(problems, "no le=\"+Inf\" bucket")
+	case last.GetCumulativeCount() != h.GetSampleCount():
+		problems = append(problems, fmt.Sprintf("le=\"+Inf\" count %d doesn't match _count %d", last.GetCumulativeCount(), h.GetSampleCount()))
+	}
+	return problems
+}
+
+// RepairHistogram fixes the inconsistencies CheckHistogram reports where the
+// intent is clear, never dropping an observation: buckets are sorted, a
+// count below the bucket before it is raised to match, and the +Inf bucket,
+// added if missing, and the sample count are both raised to the larger of
+// the two. NaN or repeated bounds can't be repaired; RepairHistogram leaves
+// such a histogram alone and returns false.
+func RepairHistogram(h *dto.Histogram) bool {
+	buckets := sortedBuckets(h.GetBucket())
+	if len(buckets) == 0 {
+		return true
+	}
+	for i, bucket := range buckets {
+		if math.IsNaN(bucket.GetUpperBound()) || i > 0 && bucket.GetUpperBound() == buckets[i-1].GetUpperBound() {
+			return false
+		}
+	}
+
+	var running uint64
+	for _, bucket := range buckets {
+		running = max(running, bucket.GetCumulativeCount())
+		count := running
+		bucket.CumulativeCount = &count
+	}
+	if !math.IsInf(buckets[len(buckets)-1].GetUpperBound(), 1) {
+		inf := math.Inf(1)
+		buckets = append(buckets, &dto.Bucket{UpperBound: &inf})
+	}
+
+	count, infCount := max(running, h.GetSampleCount()), max(running, h.GetSampleCount())
+	buckets[len(buckets)-1].CumulativeCount = &infCount
+	h.SampleCount = &count
+	h.Bucket = buckets
+	return true
+}
+
+// sortedBuckets returns buckets in le order, leaving the slice alone.
+func sortedBuckets(buckets []*dto.Bucket) []*dto.Bucket {
+	sorted := append([]*dto.Bucket(nil), buckets...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].GetUpperBound() < sorted[j].GetUpperBound() })
+	return sorted
+}
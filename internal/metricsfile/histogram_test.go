@@ -0,0 +1,84 @@
+package metricsfile
+
+import (
+	"math"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// histogram builds a histogram from le:count pairs.
+func histogram(count uint64, buckets ...float64) *dto.Histogram {
+	h := &dto.Histogram{SampleCount: &count}
+	for i := 0; i < len(buckets); i += 2 {
+		bound, cumulative := buckets[i], uint64(buckets[i+1])
+		h.Bucket = append(h.Bucket, &dto.Bucket{UpperBound: &bound, CumulativeCount: &cumulative})
+	}
+	return h
+}
+
+// bucketCounts flattens h into le, count pairs and the sample count.
+func bucketCounts(h *dto.Histogram) ([]float64, uint64) {
+	var pairs []float64
+	for _, bucket := range h.Bucket {
+		pairs = append(pairs, bucket.GetUpperBound(), float64(bucket.GetCumulativeCount()))
+	}
+	return pairs, h.GetSampleCount()
+}
+
+func TestCheckHistogram(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		name      string
+		histogram *dto.Histogram
+		problems  []string
+	}{
+		{"consistent", histogram(5, 0.1, 2, 1, 4, inf, 5), nil},
+		{"no buckets", histogram(5), nil},
+		{"out of order", histogram(5, 1, 4, 0.1, 2, inf, 5), []string{"buckets out of le order"}},
+		{"decreasing", histogram(5, 0.1, 4, 1, 3, inf, 5), []string{`bucket le="1" count 3 is below le="0.1" count 4`}},
+		{"+Inf disagrees", histogram(7, 0.1, 2, inf, 5), []string{`le="+Inf" count 5 doesn't match _count 7`}},
+		{"no +Inf", histogram(5, 0.1, 2, 1, 4), []string{`no le="+Inf" bucket`}},
+		{"repeated bound", histogram(5, 1, 2, 1, 3, inf, 5), []string{`bucket le="1" repeated`}},
+		{"NaN bound", histogram(5, 1, 2, math.NaN(), 3, inf, 5), []string{`bucket bound le="NaN"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.problems, CheckHistogram(tt.histogram))
+		})
+	}
+}
+
+func TestRepairHistogram(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		name      string
+		histogram *dto.Histogram
+		buckets   []float64
+		count     uint64
+	}{
+		{"sorts buckets", histogram(5, 1, 4, 0.1, 2, inf, 5), []float64{0.1, 2, 1, 4, inf, 5}, 5},
+		{"raises decreasing counts", histogram(5, 0.1, 4, 1, 3, inf, 5), []float64{0.1, 4, 1, 4, inf, 5}, 5},
+		{"raises _count to +Inf", histogram(3, 0.1, 2, inf, 5), []float64{0.1, 2, inf, 5}, 5},
+		{"raises +Inf to _count", histogram(7, 0.1, 2, inf, 5), []float64{0.1, 2, inf, 7}, 7},
+		{"adds +Inf", histogram(3, 0.1, 2, 1, 4), []float64{0.1, 2, 1, 4, inf, 4}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.True(t, RepairHistogram(tt.histogram))
+			buckets, count := bucketCounts(tt.histogram)
+			assert.Equal(t, tt.buckets, buckets)
+			assert.Equal(t, tt.count, count)
+			assert.Empty(t, CheckHistogram(tt.histogram))
+		})
+	}
+
+	t.Run("repeated bounds can't be repaired", func(t *testing.T) {
+		h := histogram(5, 1, 2, 1, 3, inf, 5)
+		assert.False(t, RepairHistogram(h))
+		buckets, _ := bucketCounts(h)
+		assert.Equal(t, []float64{1, 2, 1, 3, inf, 5}, buckets)
+	})
+}
@@ -0,0 +1,83 @@
+package metricsfile
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// TimeoutError is returned when a lock isn't acquired in time. Holder is
+// the process holding the lock when it can be found, which is only on Linux.
+type TimeoutError struct {
+	Timeout time.Duration
+	Holder  *Holder
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("lock timeout after %v", e.Timeout)
+	}
+	return fmt.Sprintf("lock timeout after %v (held by %s)", e.Timeout, e.Holder)
+}
+
+// Holder is a process holding a file lock.
+type Holder struct {
+	PID  int
+	Comm string // command name, empty if it couldn't be read
+}
+
+func (h *Holder) String() string {
+	if h.Comm == "" {
+		return fmt.Sprintf("pid %d", h.PID)
+	}
+	return fmt.Sprintf("pid %d (%s)", h.PID, h.Comm)
+}
+
+// procLocks lists the kernel's file locks on Linux.
+var procLocks = "/proc/locks"
+
+// lockHolder looks up the process holding a lock on file in /proc/locks. It
+// returns nil when there's none or the system can't tell. For flock locks
+// the kernel reports the process that took the lock, which may have passed
+// the descriptor on to a child.
+func lockHolder(file *os.File) *Holder {
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	dev := uint64(stat.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	want := fmt.Sprintf("%02x:%02x:%d", major, minor, stat.Ino)
+
+	locks, err := os.Open(procLocks)
+	if err != nil {
+		return nil
+	}
+	defer locks.Close()
+
+	scanner := bufio.NewScanner(locks)
+	for scanner.Scan() {
+		// 1: FLOCK  ADVISORY  WRITE 1234 08:01:5678 0 EOF
+		// Waiters are listed as "1: -> FLOCK ..." and skipped.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[1] == "->" || fields[5] != want {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[4])
+		if err != nil || pid <= 0 {
+			continue // OFD locks have no owning process
+		}
+		comm, _ := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+		return &Holder{PID: pid, Comm: strings.TrimSpace(string(comm))}
+	}
+	return nil
+}
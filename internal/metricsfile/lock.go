@@ -0,0 +1,187 @@
+// Package metricsfile holds the file handling shared by omet and
+// omet-healthcheck: locking, parsing, and serializing metrics files.
+package metricsfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// FileLock represents a file lock with timeout
+type FileLock struct {
+	file     *os.File
+	filename string
+	flag     int
+	locked   bool
+	timeout  time.Duration
+}
+
+// maxReopens bounds how often a lock follows a file that keeps being
+// replaced while we wait for it.
+const maxReopens = 3
+
+func NewFileLock(filename string, timeout time.Duration) (*FileLock, error) {
+	return openFileLock(filename, os.O_RDWR|os.O_CREATE, timeout)
+}
+
+// NewReadLock opens an existing file read-only for taking a shared lock,
+// so readers can get a consistent snapshot without blocking each other.
+func NewReadLock(filename string, timeout time.Duration) (*FileLock, error) {
+	return openFileLock(filename, os.O_RDONLY, timeout)
+}
+
+func openFileLock(filename string, flag int, timeout time.Duration) (*FileLock, error) {
+	file, err := os.OpenFile(filename, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for locking: %w", err)
+	}
+
+	return &FileLock{
+		file:     file,
+		filename: filename,
+		flag:     flag,
+		timeout:  timeout,
+	}, nil
+}
+
+// Stale reports whether the path no longer refers to the open file, because
+// it was rotated, replaced by a rename, or removed.
+func (fl *FileLock) Stale() (bool, error) {
+	opened, err := fl.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	current, err := os.Stat(fl.filename)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(opened, current), nil
+}
+
+// Reopen releases the lock and opens the path again, picking up a file that
+// replaced the one we had open. The new file is not locked.
+func (fl *FileLock) Reopen() error {
+	file, err := os.OpenFile(fl.filename, fl.flag, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen %s: %w", fl.filename, err)
+	}
+	fl.Close()
+	fl.file = file
+	return nil
+}
+
+// File returns the underlying file, positioned wherever the last
+// read or write left it.
+func (fl *FileLock) File() *os.File {
+	return fl.file
+}
+
+// Locked reports whether the lock is currently held.
+func (fl *FileLock) Locked() bool {
+	return fl.locked
+}
+
+// Lock acquires an exclusive lock, for writers.
+func (fl *FileLock) Lock(ctx context.Context) error {
+	return fl.lock(ctx, syscall.LOCK_EX)
+}
+
+// LockShared acquires a shared lock, for readers. It excludes writers
+// holding Lock but not other readers.
+func (fl *FileLock) LockShared(ctx context.Context) error {
+	return fl.lock(ctx, syscall.LOCK_SH)
+}
+
+// lock acquires the lock and then makes sure the path still refers to the
+// locked file. Another process may have renamed a new file into place while
+// we waited; writing to the old inode would silently lose the update, so the
+// lock follows the path instead.
+func (fl *FileLock) lock(ctx context.Context, how int) error {
+	if fl.locked {
+		return fmt.Errorf("already locked")
+	}
+
+	for reopens := 0; ; reopens++ {
+		if err := fl.flock(ctx, how); err != nil {
+			return err
+		}
+
+		stale, err := fl.Stale()
+		if err != nil || !stale {
+			return nil // Not replaced, or we can't tell
+		}
+		if reopens == maxReopens {
+			fl.Unlock()
+			return fmt.Errorf("%s keeps being replaced, gave up after %d reopens", fl.filename, reopens)
+		}
+		if err := fl.Reopen(); err != nil {
+			return err
+		}
+	}
+}
+
+func (fl *FileLock) flock(ctx context.Context, how int) error {
+	// Create a context with timeout
+	lockCtx, cancel := context.WithTimeout(ctx, fl.timeout)
+	defer cancel()
+
+	// Try to acquire lock with timeout
+	// Read the descriptor up front; on timeout the goroutine outlives this
+	// call and must not touch fl.file while it's being closed
+	fd := int(fl.file.Fd())
+	done := make(chan error, 1)
+	go func() {
+		err := syscall.Flock(fd, how)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to acquire lock: %w", err)
+		}
+		fl.locked = true
+		return nil
+	case <-lockCtx.Done():
+		return &TimeoutError{Timeout: fl.timeout, Holder: lockHolder(fl.file)}
+	}
+}
+
+func (fl *FileLock) Unlock() error {
+	if !fl.locked {
+		return nil
+	}
+
+	err := syscall.Flock(int(fl.file.Fd()), syscall.LOCK_UN)
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+
+	fl.locked = false
+	return nil
+}
+
+func (fl *FileLock) Close() error {
+	if fl.locked {
+		fl.Unlock()
+	}
+	return fl.file.Close()
+}
+
+// Rewrite replaces the contents of the locked file with whatever write
+// produces.
+func (fl *FileLock) Rewrite(write func(*os.File) error) error {
+	if _, err := fl.file.Seek(0, 0); err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+	if err := fl.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate: %w", err)
+	}
+	return write(fl.file)
+}
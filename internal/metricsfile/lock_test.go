@@ -0,0 +1,183 @@
+package metricsfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLock(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+
+	lock, err := NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	defer lock.Close()
+
+	require.NoError(t, lock.Lock(context.Background()))
+	assert.True(t, lock.Locked())
+	assert.Error(t, lock.Lock(context.Background()), "double lock should fail")
+
+	t.Run("second lock times out while held", func(t *testing.T) {
+		other, err := NewFileLock(filename, 50*time.Millisecond)
+		require.NoError(t, err)
+		defer other.Close()
+
+		err = other.Lock(context.Background())
+		assert.ErrorContains(t, err, "lock timeout")
+		assert.False(t, other.Locked())
+	})
+
+	t.Run("timeout names the holder", func(t *testing.T) {
+		if _, err := os.Stat(procLocks); err != nil {
+			t.Skip("no /proc/locks")
+		}
+		other, err := NewFileLock(filename, 50*time.Millisecond)
+		require.NoError(t, err)
+		defer other.Close()
+
+		var timeout *TimeoutError
+		require.ErrorAs(t, other.Lock(context.Background()), &timeout)
+		require.NotNil(t, timeout.Holder)
+		assert.Equal(t, os.Getpid(), timeout.Holder.PID)
+		assert.NotEmpty(t, timeout.Holder.Comm)
+		assert.ErrorContains(t, timeout, fmt.Sprintf("held by pid %d", os.Getpid()))
+	})
+
+	require.NoError(t, lock.Unlock())
+	assert.False(t, lock.Locked())
+}
+
+func TestFileLockRewrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("a much longer original content\n"), 0644))
+
+	lock, err := NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	defer lock.Close()
+	require.NoError(t, lock.Lock(context.Background()))
+
+	err = lock.Rewrite(func(file *os.File) error {
+		_, err := file.WriteString("short\n")
+		return err
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "short\n", string(content))
+}
+
+func TestFileLockShared(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("queue_depth 1\n"), 0644))
+
+	reader, err := NewReadLock(filename, time.Second)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.NoError(t, reader.LockShared(context.Background()))
+
+	t.Run("other readers are not blocked", func(t *testing.T) {
+		other, err := NewReadLock(filename, 50*time.Millisecond)
+		require.NoError(t, err)
+		defer other.Close()
+		assert.NoError(t, other.LockShared(context.Background()))
+	})
+
+	t.Run("writers wait for readers", func(t *testing.T) {
+		writer, err := NewFileLock(filename, 50*time.Millisecond)
+		require.NoError(t, err)
+		defer writer.Close()
+		assert.ErrorContains(t, writer.Lock(context.Background()), "lock timeout")
+	})
+
+	t.Run("read lock does not create missing files", func(t *testing.T) {
+		_, err := NewReadLock(filepath.Join(t.TempDir(), "missing.prom"), time.Second)
+		assert.Error(t, err)
+	})
+}
+
+func TestParseFileShared(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("# TYPE queue_depth gauge\nqueue_depth 3\n"), 0644))
+
+	families, err := ParseFileShared(filename, time.Second, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, families["queue_depth"].Metric[0].GetGauge().GetValue())
+
+	writer, err := NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	defer writer.Close()
+	require.NoError(t, writer.Lock(context.Background()))
+
+	_, err = ParseFileShared(filename, 50*time.Millisecond, nil)
+	assert.ErrorContains(t, err, "lock timeout")
+}
+
+func TestFileLockFollowsReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "metrics.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("old 1\n"), 0644))
+
+	lock, err := NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	defer lock.Close()
+
+	stale, err := lock.Stale()
+	require.NoError(t, err)
+	assert.False(t, stale)
+
+	// Another tool atomically replaces the file after we opened it
+	replacement := filepath.Join(dir, "metrics.prom.tmp")
+	require.NoError(t, os.WriteFile(replacement, []byte("new 1\n"), 0644))
+	require.NoError(t, os.Rename(replacement, filename))
+
+	stale, err = lock.Stale()
+	require.NoError(t, err)
+	assert.True(t, stale)
+
+	require.NoError(t, lock.Lock(context.Background()))
+	require.NoError(t, lock.Rewrite(func(file *os.File) error {
+		_, err := file.WriteString("new 2\n")
+		return err
+	}))
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "new 2\n", string(content), "the write lands in the file now at the path")
+
+	t.Run("removed file is recreated", func(t *testing.T) {
+		require.NoError(t, lock.Unlock())
+		require.NoError(t, os.Remove(filename))
+
+		require.NoError(t, lock.Lock(context.Background()))
+		_, err := os.Stat(filename)
+		assert.NoError(t, err)
+	})
+}
+
+func TestFileLockSync(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+	lock, err := NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	defer lock.Close()
+	require.NoError(t, lock.Lock(context.Background()))
+
+	for _, name := range []string{"none", "data", "full"} {
+		mode, err := ParseSyncMode(name)
+		require.NoError(t, err)
+		require.NoError(t, lock.RewriteWithChecksum(func(file *os.File) error {
+			_, err := file.WriteString("up 1\n")
+			return err
+		}))
+		assert.NoError(t, lock.Sync(mode), name)
+	}
+
+	_, err = ParseSyncMode("always")
+	assert.ErrorContains(t, err, `invalid sync mode "always"`)
+}
@@ -0,0 +1,48 @@
+package metricsfile
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ParseFiles parses several metrics files with at most workers running at
+// once (GOMAXPROCS if workers <= 0). Results are in the order of filenames,
+// and an error names the first failing file in that order, so callers
+// merging the results behave exactly as if the files were read one by one.
+// Files are decrypted with key as Unseal does.
+func ParseFiles(filenames []string, workers int, key []byte) ([]map[string]*dto.MetricFamily, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(filenames))
+
+	results := make([]map[string]*dto.MetricFamily, len(filenames))
+	errs := make([]error, len(filenames))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], errs[i] = ParseFileWithKey(filenames[i], key)
+			}
+		}()
+	}
+	for i := range filenames {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filenames[i], err)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,203 @@
+package metricsfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// utf8BOM is the byte order mark some Windows tools prepend to text files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Parse reads metric families in Prometheus text format, or any other
+// format DetectFormat recognizes. A leading UTF-8 BOM and CRLF line endings
+// are accepted transparently.
+func Parse(input io.Reader) (map[string]*dto.MetricFamily, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return parseBytes(data)
+}
+
+func parseBytes(data []byte) (map[string]*dto.MetricFamily, error) {
+	return parseNamed(data, "")
+}
+
+// parseNamed parses data in whichever format DetectFormat finds, which
+// filename's extension may decide.
+func parseNamed(data []byte, filename string) (map[string]*dto.MetricFamily, error) {
+	switch DetectFormat(data, filename) {
+	case FormatProtobuf:
+		return parseProtobuf(data)
+	case FormatJSON:
+		return parseJSON(data)
+	case FormatOpenMetrics:
+		return parseText(openMetricsToText(Normalize(data)))
+	}
+	return parseText(Normalize(data))
+}
+
+func parseText(data []byte) (map[string]*dto.MetricFamily, error) {
+	families, err := parseFast(data)
+	if err == errFallback {
+		disguised, gaugeHistograms := disguiseGaugeHistograms(data)
+		families, err = parseExpfmt(disguised)
+		for _, name := range gaugeHistograms {
+			if family, ok := families[name]; ok {
+				family.Type = dto.MetricType_GAUGE_HISTOGRAM.Enum()
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	parseUnits(data, families)
+	return families, nil
+}
+
+// parseExpfmt runs expfmt's TextParser, turning the panics some malformed
+// input causes in it, such as a line of just "{}", into errors. A sample
+// without a metric name is also an error; expfmt merges it into whichever
+// family came before instead.
+func parseExpfmt(data []byte) (families map[string]*dto.MetricFamily, err error) {
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if unnamedSample(line) {
+			return nil, fmt.Errorf("text format parsing error in line %d: sample without a metric name", i+1)
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			families, err = nil, fmt.Errorf("text format parsing error: %v", r)
+		}
+	}()
+	parser := expfmt.TextParser{}
+	return parser.TextToMetricFamilies(bytes.NewReader(data))
+}
+
+// unnamedSample reports whether line is a sample starting with its labels.
+// Only a quoted metric name, as in {"my.metric",job="a"}, may come first.
+func unnamedSample(line []byte) bool {
+	line = bytes.TrimLeft(line, " \t")
+	if len(line) == 0 || line[0] != '{' {
+		return false
+	}
+	rest := bytes.TrimLeft(line[1:], " \t")
+	if len(rest) == 0 || rest[0] != '"' {
+		return true
+	}
+	for i := 1; i < len(rest); i++ {
+		switch rest[i] {
+		case '\\':
+			i++
+		case '"':
+			after := bytes.TrimLeft(rest[i+1:], " \t")
+			return len(after) == 0 || (after[0] != ',' && after[0] != '}')
+		}
+	}
+	return true
+}
+
+// parseUnits restores "# UNIT <name> <unit>" comments, which the text format
+// parser skips as generic comments.
+func parseUnits(data []byte, families map[string]*dto.MetricFamily) {
+	if !bytes.Contains(data, []byte("# UNIT ")) {
+		return
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) != 4 || fields[0] != "#" || fields[1] != "UNIT" {
+			continue
+		}
+		if family, ok := families[fields[2]]; ok {
+			unit := fields[3]
+			family.Unit = &unit
+		}
+	}
+}
+
+// Normalize strips a leading UTF-8 BOM and converts CRLF line endings to LF.
+func Normalize(data []byte) []byte {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if bytes.Contains(data, []byte("\r\n")) {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	}
+	return data
+}
+
+// ErrInputTooLarge is returned by ParseLimited for oversized input.
+var ErrInputTooLarge = errors.New("input too large")
+
+// ParseLimited is like Parse but fails with ErrInputTooLarge instead of
+// reading more than maxBytes (0 for no limit).
+func ParseLimited(input io.Reader, maxBytes int64) (map[string]*dto.MetricFamily, error) {
+	if maxBytes <= 0 {
+		return Parse(input)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(input, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrInputTooLarge, maxBytes)
+	}
+
+	return parseBytes(data)
+}
+
+// ParseFile opens and parses a metrics file without locking it.
+func ParseFile(filename string) (map[string]*dto.MetricFamily, error) {
+	return ParseFileWithKey(filename, nil)
+}
+
+// ParseFileWithKey is like ParseFile, but decrypts the file with key as
+// Unseal does.
+func ParseFileWithKey(filename string, key []byte) (map[string]*dto.MetricFamily, error) {
+	data, err := os.ReadFile(filename)
+	if err == nil {
+		data, err = Unseal(key, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseNamed(data, filename)
+}
+
+// ParseFileShared parses a metrics file while holding a shared lock, so it
+// never observes a writer's half-written output. The file is decrypted with
+// key as Unseal does.
+func ParseFileShared(filename string, timeout time.Duration, key []byte) (map[string]*dto.MetricFamily, error) {
+	data, err := ReadFileShared(filename, timeout)
+	if err == nil {
+		data, err = Unseal(key, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseNamed(data, filename)
+}
+
+// ReadFileShared reads a metrics file while holding a shared lock.
+func ReadFileShared(filename string, timeout time.Duration) ([]byte, error) {
+	lock, err := NewReadLock(filename, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+
+	if err := lock.LockShared(context.Background()); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	return io.ReadAll(lock.File())
+}
@@ -0,0 +1,186 @@
+package metricsfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleMetrics = `# HELP http_requests_total Total HTTP requests
+# TYPE http_requests_total counter
+http_requests_total{method="GET"} 85
+# HELP queue_depth Current queue depth
+# TYPE queue_depth gauge
+queue_depth 42
+# HELP latency_seconds Request latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 3
+latency_seconds_bucket{le="+Inf"} 5
+latency_seconds_count 5
+latency_seconds_sum 1.5
+`
+
+func TestParse(t *testing.T) {
+	families, err := Parse(strings.NewReader(sampleMetrics))
+	require.NoError(t, err)
+
+	require.Len(t, families, 3)
+	assert.Equal(t, dto.MetricType_COUNTER, families["http_requests_total"].GetType())
+	assert.Equal(t, 42.0, families["queue_depth"].Metric[0].GetGauge().GetValue())
+	assert.Equal(t, uint64(5), families["latency_seconds"].Metric[0].GetHistogram().GetSampleCount())
+
+	_, err = Parse(strings.NewReader("not valid {\n"))
+	assert.Error(t, err)
+}
+
+func TestParseFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+	require.NoError(t, os.WriteFile(filename, []byte(sampleMetrics), 0644))
+
+	families, err := ParseFile(filename)
+	require.NoError(t, err)
+	assert.Len(t, families, 3)
+
+	_, err = ParseFile(filepath.Join(t.TempDir(), "missing.prom"))
+	assert.Error(t, err)
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	families, err := Parse(strings.NewReader(sampleMetrics))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(families, &buf))
+
+	reparsed, err := Parse(&buf)
+	require.NoError(t, err)
+	assert.Len(t, reparsed, 3)
+	assert.Equal(t, 85.0, reparsed["http_requests_total"].Metric[0].GetCounter().GetValue())
+	assert.Equal(t, uint64(3), reparsed["latency_seconds"].Metric[0].GetHistogram().GetBucket()[0].GetCumulativeCount())
+}
+
+func TestParseRejectsUnnamedSamples(t *testing.T) {
+	for _, input := range []string{"a 1\n{}\n", "a 1\n{job=\"x\"} 2\n", "a 1\n{\"job\"=\"x\"} 2\n"} {
+		_, err := Parse(strings.NewReader(input))
+		assert.ErrorContains(t, err, "sample without a metric name", input)
+	}
+
+	families, err := Parse(strings.NewReader("a 1\n{\"my.metric\",job=\"x\"} 2\n"))
+	require.NoError(t, err)
+	assert.Contains(t, families, "my.metric")
+}
+
+func TestParseLimited(t *testing.T) {
+	families, err := ParseLimited(strings.NewReader(sampleMetrics), int64(len(sampleMetrics)))
+	require.NoError(t, err)
+	assert.Len(t, families, 3)
+
+	_, err = ParseLimited(strings.NewReader(sampleMetrics), int64(len(sampleMetrics)-1))
+	assert.ErrorIs(t, err, ErrInputTooLarge)
+
+	families, err = ParseLimited(strings.NewReader(sampleMetrics), 0)
+	require.NoError(t, err, "zero disables the limit")
+	assert.Len(t, families, 3)
+}
+
+func TestParseNormalizesBOMAndCRLF(t *testing.T) {
+	windows := "\xEF\xBB\xBF" + strings.ReplaceAll(sampleMetrics, "\n", "\r\n")
+
+	families, err := Parse(strings.NewReader(windows))
+	require.NoError(t, err)
+	assert.Len(t, families, 3)
+	assert.Equal(t, "Total HTTP requests", families["http_requests_total"].GetHelp())
+
+	families, err = ParseLimited(strings.NewReader(windows), int64(len(windows)))
+	require.NoError(t, err)
+	assert.Len(t, families, 3)
+}
+
+func TestCRLFWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCRLFWriter(&buf)
+
+	n, err := w.Write([]byte("a 1\nb 2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 8, n, "should report bytes consumed, not written")
+	assert.Equal(t, "a 1\r\nb 2\r\n", buf.String())
+}
+
+func TestUnitRoundTrip(t *testing.T) {
+	input := "# HELP backup_duration_seconds Backup duration\n# TYPE backup_duration_seconds gauge\n# UNIT backup_duration_seconds seconds\nbackup_duration_seconds 12\n"
+
+	families, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, "seconds", families["backup_duration_seconds"].GetUnit())
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(families, &buf))
+	assert.Equal(t, input, buf.String())
+}
+
+func TestGaugeHistogramRoundTrip(t *testing.T) {
+	input := `# HELP queue_age_seconds Age of queued jobs
+# TYPE queue_age_seconds gaugehistogram
+queue_age_seconds_bucket{queue="a",le="10"} 4
+queue_age_seconds_bucket{queue="a",le="+Inf"} 6
+queue_age_seconds_gcount{queue="a"} 6
+queue_age_seconds_gsum{queue="a"} 95
+`
+	families, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	family := families["queue_age_seconds"]
+	require.NotNil(t, family)
+	assert.Equal(t, dto.MetricType_GAUGE_HISTOGRAM, family.GetType())
+	assert.Equal(t, uint64(6), family.Metric[0].GetHistogram().GetSampleCount())
+	assert.Equal(t, 95.0, family.Metric[0].GetHistogram().GetSampleSum())
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(families, &buf))
+	assert.Equal(t, input, buf.String())
+
+	// Escapes send the input through expfmt, which needs gauge histograms
+	// disguised as histograms
+	escaped := strings.ReplaceAll(input, `queue="a"`, `queue="a\\b"`)
+	fallback, err := Parse(strings.NewReader(escaped))
+	require.NoError(t, err)
+	assert.Equal(t, dto.MetricType_GAUGE_HISTOGRAM, fallback["queue_age_seconds"].GetType())
+	assert.Equal(t, uint64(6), fallback["queue_age_seconds"].Metric[0].GetHistogram().GetSampleCount())
+	assert.Equal(t, 95.0, fallback["queue_age_seconds"].Metric[0].GetHistogram().GetSampleSum())
+
+	partial, _, err := ParsePartial(AppendChecksum([]byte(sampleMetrics+input)), []string{"queue_age_seconds"})
+	require.NoError(t, err)
+	require.Len(t, partial, 1)
+	assert.Equal(t, uint64(6), partial["queue_age_seconds"].Metric[0].GetHistogram().GetSampleCount())
+}
+
+func TestParseFiles(t *testing.T) {
+	dir := t.TempDir()
+	var filenames []string
+	for i := 0; i < 10; i++ {
+		filename := filepath.Join(dir, fmt.Sprintf("%d.prom", i))
+		require.NoError(t, os.WriteFile(filename, []byte(fmt.Sprintf("file_index %d\n", i)), 0644))
+		filenames = append(filenames, filename)
+	}
+
+	for _, workers := range []int{0, 1, 3, 20} {
+		results, err := ParseFiles(filenames, workers, nil)
+		require.NoError(t, err)
+		require.Len(t, results, 10)
+		for i, families := range results {
+			assert.Equal(t, float64(i), families["file_index"].Metric[0].GetUntyped().GetValue(), "results keep argument order")
+		}
+	}
+
+	broken := filepath.Join(dir, "broken.prom")
+	require.NoError(t, os.WriteFile(broken, []byte("not { valid\n"), 0644))
+	missing := filepath.Join(dir, "missing.prom")
+	_, err := ParseFiles(append([]string{filenames[0], broken}, missing), 4, nil)
+	assert.ErrorContains(t, err, "broken.prom", "the first failure in argument order is reported")
+}
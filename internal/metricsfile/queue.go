@@ -0,0 +1,211 @@
+package metricsfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// queueSuffix names the sidecar file LockFair queues writers in. It doesn't
+// end in .prom, so the textfile collector ignores it.
+const queueSuffix = ".lockq"
+
+// queuePoll is how often a queued writer checks whether it reached the head.
+const queuePoll = 10 * time.Millisecond
+
+var queueSeq atomic.Uint64
+
+// QueueStats describes the lock queue as a writer found it on joining.
+type QueueStats struct {
+	Ahead int // writers queued before us
+}
+
+// LockFair acquires the exclusive lock in arrival order with other LockFair
+// callers. Plain flock wakes waiters in no particular order, so under heavy
+// contention an unlucky writer can starve until it times out. Writers
+// instead append themselves to a queue file next to the metrics file and
+// only the head of the queue competes for the lock; it leaves the queue once
+// it holds the lock, so a holder that crashes is released by the kernel as
+// usual. Each queued writer also holds a lock on a ticket file of its own,
+// which the kernel releases if it dies; entries whose ticket isn't locked
+// are pruned. Unlike checking a PID, that works across PID namespaces and
+// isn't fooled by a new process reusing a dead writer's PID.
+func (fl *FileLock) LockFair(ctx context.Context) (QueueStats, error) {
+	queue, err := os.OpenFile(fl.filename+queueSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return QueueStats{}, fmt.Errorf("failed to open lock queue: %w", err)
+	}
+	defer queue.Close()
+
+	token := fmt.Sprintf("%d-%d-%d", os.Getpid(), time.Now().UnixNano(), queueSeq.Add(1))
+	ticket, err := os.OpenFile(ticketPath(queue.Name(), token), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return QueueStats{}, fmt.Errorf("failed to create lock queue ticket: %w", err)
+	}
+	defer func() {
+		os.Remove(ticket.Name())
+		ticket.Close()
+	}()
+	if err := syscall.Flock(int(ticket.Fd()), syscall.LOCK_EX); err != nil {
+		return QueueStats{}, fmt.Errorf("failed to lock queue ticket: %w", err)
+	}
+
+	var stats QueueStats
+	err = updateQueue(queue, func(entries []string) []string {
+		stats.Ahead = len(entries)
+		return append(entries, token)
+	})
+	if err != nil {
+		return stats, err
+	}
+	defer updateQueue(queue, func(entries []string) []string {
+		return removeEntry(entries, token)
+	})
+
+	deadline := time.Now().Add(fl.timeout)
+	for {
+		head := false
+		err := updateQueue(queue, func(entries []string) []string {
+			head = len(entries) > 0 && entries[0] == token
+			return entries
+		})
+		if err != nil {
+			return stats, err
+		}
+		if head {
+			break
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return stats, fmt.Errorf("lock timeout after %v (%d writers were queued ahead)", fl.timeout, stats.Ahead)
+		}
+		select {
+		case <-ctx.Done():
+			return stats, ctx.Err()
+		case <-time.After(min(queuePoll, remaining)):
+		}
+	}
+
+	// At the head: wait for the current holder with whatever time is left
+	timeout := fl.timeout
+	fl.timeout = max(time.Until(deadline), time.Millisecond)
+	defer func() { fl.timeout = timeout }()
+	return stats, fl.lock(ctx, syscall.LOCK_EX)
+}
+
+// updateQueue rewrites the queue file under its own short-lived lock,
+// pruning entries whose writers no longer hold their ticket, along with the
+// tickets.
+func updateQueue(queue *os.File, update func([]string) []string) error {
+	if err := syscall.Flock(int(queue.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock queue: %w", err)
+	}
+	defer syscall.Flock(int(queue.Fd()), syscall.LOCK_UN)
+
+	info, err := queue.Stat()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, info.Size())
+	if _, err := queue.ReadAt(data, 0); err != nil && info.Size() > 0 {
+		return fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	var entries []string
+	for _, entry := range strings.Split(string(data), "\n") {
+		if entry == "" {
+			continue
+		}
+		if !entryAlive(queue.Name(), entry) {
+			os.Remove(ticketPath(queue.Name(), entry))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	entries = update(entries)
+
+	content := strings.Join(entries, "\n")
+	if len(entries) > 0 {
+		content += "\n"
+	}
+	if err := queue.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write queue: %w", err)
+	}
+	_, err = queue.WriteAt([]byte(content), 0)
+	return err
+}
+
+// ticketPath returns the ticket file of a queue entry, a "pid-nanos-seq"
+// token unique to one LockFair call.
+func ticketPath(queueName, entry string) string {
+	return queueName + "." + entry
+}
+
+// entryAlive reports whether the writer that queued an entry still waits,
+// that is, whether it still holds its ticket's lock.
+func entryAlive(queueName, entry string) bool {
+	ticket, err := os.Open(ticketPath(queueName, entry))
+	if err != nil {
+		return false
+	}
+	defer ticket.Close()
+	err = syscall.Flock(int(ticket.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		syscall.Flock(int(ticket.Fd()), syscall.LOCK_UN)
+		return false
+	}
+	return errors.Is(err, syscall.EWOULDBLOCK)
+}
+
+func removeEntry(entries []string, token string) []string {
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry != token {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// QueueEntries counts the writers waiting in filename's fair-lock queue.
+// dead counts entries of writers that exited while queued, which the next
+// LockFair prunes. A missing queue file has no entries.
+func QueueEntries(filename string) (alive, dead int, err error) {
+	data, err := os.ReadFile(filename + queueSuffix)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range strings.Split(string(data), "\n") {
+		switch {
+		case entry == "":
+		case entryAlive(filename+queueSuffix, entry):
+			alive++
+		default:
+			dead++
+		}
+	}
+	return alive, dead, nil
+}
+
+// RemoveQueue removes filename's fair-lock queue and any ticket files left
+// in it, for callers that are done with the file altogether.
+func RemoveQueue(filename string) {
+	entries, _ := os.ReadDir(filepath.Dir(filename))
+	prefix := filepath.Base(filename) + queueSuffix + "."
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) {
+			os.Remove(filepath.Join(filepath.Dir(filename), entry.Name()))
+		}
+	}
+	os.Remove(filename + queueSuffix)
+}
@@ -0,0 +1,106 @@
+package metricsfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queueLen(t *testing.T, filename string) int {
+	data, err := os.ReadFile(filename + queueSuffix)
+	require.NoError(t, err)
+	return strings.Count(string(data), "\n")
+}
+
+func TestLockFairIsFIFO(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+
+	holder, err := NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, holder.Lock(context.Background()))
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lock, err := NewFileLock(filename, 5*time.Second)
+			require.NoError(t, err)
+			defer lock.Close()
+
+			stats, err := lock.LockFair(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, i, stats.Ahead)
+
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+		}(i)
+
+		// Let each writer join before the next one arrives
+		require.Eventually(t, func() bool { return queueLen(t, filename) == i+1 }, time.Second, time.Millisecond)
+	}
+
+	require.NoError(t, holder.Close())
+	wg.Wait()
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+	assert.Equal(t, 0, queueLen(t, filename), "writers leave the queue")
+	tickets, err := filepath.Glob(filename + queueSuffix + ".*")
+	require.NoError(t, err)
+	assert.Empty(t, tickets, "and remove their tickets")
+}
+
+func TestLockFairPrunesAndTimesOut(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+	require.NoError(t, os.WriteFile(filename+queueSuffix, []byte("999999999 dead-writer\ncrashed-writer\n"), 0644))
+	require.NoError(t, os.WriteFile(filename+queueSuffix+".crashed-writer", nil, 0644))
+
+	lock, err := NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	stats, err := lock.LockFair(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Ahead, "entries of dead processes are pruned")
+	assert.NoFileExists(t, filename+queueSuffix+".crashed-writer", "their tickets are removed")
+
+	waiter, err := NewFileLock(filename, 50*time.Millisecond)
+	require.NoError(t, err)
+	defer waiter.Close()
+	_, err = waiter.LockFair(context.Background())
+	assert.ErrorContains(t, err, "lock timeout")
+	assert.Equal(t, 0, queueLen(t, filename), "a writer that gives up leaves the queue")
+
+	require.NoError(t, lock.Close())
+}
+
+func TestQueueEntries(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+	alive, dead, err := QueueEntries(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 0, alive+dead)
+
+	// A live writer holds its ticket; the PID in a token means nothing, so
+	// an entry whose ticket exists but isn't held is dead
+	ticket, err := os.Create(filename + queueSuffix + ".2-200-2")
+	require.NoError(t, err)
+	defer ticket.Close()
+	require.NoError(t, syscall.Flock(int(ticket.Fd()), syscall.LOCK_EX))
+	require.NoError(t, os.WriteFile(filename+queueSuffix+".3-300-3", nil, 0644))
+
+	entries := "1-100-1\n2-200-2\n3-300-3\n"
+	require.NoError(t, os.WriteFile(filename+queueSuffix, []byte(entries), 0644))
+	alive, dead, err = QueueEntries(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 1, alive)
+	assert.Equal(t, 2, dead)
+}
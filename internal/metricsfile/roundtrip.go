@@ -0,0 +1,125 @@
+package metricsfile
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// CheckRoundTrip writes families and parses the result back, reporting the
+// first way it differs from families: what would be lost or changed if the
+// output were read again.
+func CheckRoundTrip(families map[string]*dto.MetricFamily) error {
+	var buf bytes.Buffer
+	if err := Write(families, &buf); err != nil {
+		return err
+	}
+	parsed, err := parseText(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("output doesn't parse: %w", err)
+	}
+	return Equivalent(families, parsed)
+}
+
+// Equivalent reports the first difference between want and got, or nil.
+// Families are compared by type, help, unit, and series, in any order.
+// Families without series and sample timestamps are ignored, since the text
+// format can't express the first and Write leaves out the second, which
+// the textfile collector rejects.
+func Equivalent(want, got map[string]*dto.MetricFamily) error {
+	for _, name := range familyNames(want, got) {
+		w, g := want[name], got[name]
+		switch {
+		case len(w.GetMetric()) == 0 && len(g.GetMetric()) == 0:
+			continue
+		case len(g.GetMetric()) == 0:
+			return fmt.Errorf("%s is missing", name)
+		case len(w.GetMetric()) == 0:
+			return fmt.Errorf("%s is unexpected", name)
+		case w.GetType() != g.GetType():
+			return fmt.Errorf("%s: type %s became %s", name, TypeName(w.GetType()), TypeName(g.GetType()))
+		case w.GetHelp() != g.GetHelp():
+			return fmt.Errorf("%s: help %q became %q", name, w.GetHelp(), g.GetHelp())
+		case w.GetUnit() != g.GetUnit():
+			return fmt.Errorf("%s: unit %q became %q", name, w.GetUnit(), g.GetUnit())
+		}
+
+		wantSeries, gotSeries := describeSeries(w), describeSeries(g)
+		for i := range max(len(wantSeries), len(gotSeries)) {
+			switch {
+			case i >= len(gotSeries):
+				return fmt.Errorf("%s: series %s is missing", name, wantSeries[i])
+			case i >= len(wantSeries):
+				return fmt.Errorf("%s: series %s is unexpected", name, gotSeries[i])
+			case wantSeries[i] != gotSeries[i]:
+				return fmt.Errorf("%s: series %s became %s", name, wantSeries[i], gotSeries[i])
+			}
+		}
+	}
+	return nil
+}
+
+// familyNames is the sorted union of the family names in a and b.
+func familyNames(a, b map[string]*dto.MetricFamily) []string {
+	seen := make(map[string]bool, len(a))
+	var names []string
+	for _, families := range []map[string]*dto.MetricFamily{a, b} {
+		for name := range families {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describeSeries renders each series of family as labels and values in a
+// canonical form, sorted, so series can be compared as strings.
+func describeSeries(family *dto.MetricFamily) []string {
+	series := make([]string, 0, len(family.Metric))
+	for _, metric := range family.Metric {
+		labels := make([]string, 0, len(metric.Label))
+		for _, label := range metric.Label {
+			if label.GetValue() != "" {
+				// An empty value is the same as no label
+				labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+		}
+		sort.Strings(labels)
+
+		var values []string
+		switch family.GetType() {
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			h := metric.GetHistogram()
+			for _, bucket := range h.GetBucket() {
+				values = append(values, fmt.Sprintf("le=%s:%d", canonicalFloat(bucket.GetUpperBound()), bucket.GetCumulativeCount()))
+			}
+			values = append(values, fmt.Sprintf("count=%d", h.GetSampleCount()), "sum="+canonicalFloat(h.GetSampleSum()))
+		case dto.MetricType_SUMMARY:
+			s := metric.GetSummary()
+			for _, q := range s.GetQuantile() {
+				values = append(values, fmt.Sprintf("q=%s:%s", canonicalFloat(q.GetQuantile()), canonicalFloat(q.GetValue())))
+			}
+			values = append(values, fmt.Sprintf("count=%d", s.GetSampleCount()), "sum="+canonicalFloat(s.GetSampleSum()))
+		default:
+			values = append(values, canonicalFloat(simpleValue(metric)))
+		}
+		series = append(series, "{"+strings.Join(labels, ",")+"} "+strings.Join(values, " "))
+	}
+	sort.Strings(series)
+	return series
+}
+
+// canonicalFloat formats value exactly, with every NaN alike.
+func canonicalFloat(value float64) string {
+	if math.IsNaN(value) {
+		return "NaN"
+	}
+	return formatFloat(value)
+}
@@ -0,0 +1,139 @@
+package metricsfile
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// roundTripSeeds are the fuzz corpus: the shapes of input omet reads.
+var roundTripSeeds = []string{
+	sampleMetrics,
+	"plain 1\n",
+	"# HELP escaped Backslash \\\\ and newline \\n\n# TYPE escaped gauge\nescaped{path=\"C:\\\\tmp\",msg=\"say \\\"hi\\\"\\n\"} -0.5\n",
+	"# TYPE rpc_seconds summary\nrpc_seconds{quantile=\"0.5\"} 0.2\nrpc_seconds{quantile=\"0.99\"} NaN\nrpc_seconds_sum 12\nrpc_seconds_count 40\n",
+	"# TYPE depth gaugehistogram\ndepth_bucket{le=\"1\"} 2\ndepth_bucket{le=\"+Inf\"} 3\ndepth_gcount 3\ndepth_gsum 4\n",
+	"# TYPE temp gauge\n# UNIT temp celsius\ntemp{zone=\"a\"} 1e-300\ntemp{zone=\"b\"} +Inf\ntemp{zone=\"c\"} 123456789012345680000\n",
+	"stamped{a=\"1\"} 5 1700000000000\n",
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, seed := range roundTripSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		families, err := parseText(data)
+		if err != nil || !legacyNames(families) {
+			return
+		}
+		if err := CheckRoundTrip(families); err != nil {
+			t.Fatalf("round trip of %q: %v", data, err)
+		}
+	})
+}
+
+// legacyNames reports whether every metric and label name is one the text
+// format can write unquoted. expfmt also reads quoted UTF-8 names, which
+// omet doesn't write.
+func legacyNames(families map[string]*dto.MetricFamily) bool {
+	for name, family := range families {
+		if !isMetricName(name) {
+			return false
+		}
+		for _, metric := range family.Metric {
+			for _, label := range metric.Label {
+				if !isMetricName(label.GetName()) || strings.Contains(label.GetName(), ":") {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func TestRoundTripSeeds(t *testing.T) {
+	for _, seed := range roundTripSeeds {
+		families, err := parseText([]byte(seed))
+		require.NoError(t, err, seed)
+		assert.NoError(t, CheckRoundTrip(families), seed)
+	}
+}
+
+// TestRoundTripProperty writes random families, including the awkward label
+// values, help texts, and floats, and checks they read back unchanged.
+func TestRoundTripProperty(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	texts := []string{"", "plain", `back\slash`, `"quoted"`, "new\nline", "tab\there", "ünïcödé", "{braces}", "trailing ", "a,b=c"}
+	floats := []float64{0, -0.5, 1, 1e-300, 1.7976931348623157e308, math.Pi, 123456789, math.Inf(1), math.Inf(-1), math.NaN()}
+	types := []dto.MetricType{dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_UNTYPED, dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM, dto.MetricType_SUMMARY}
+
+	for i := 0; i < 200; i++ {
+		families := make(map[string]*dto.MetricFamily)
+		for j := 0; j < 1+r.Intn(4); j++ {
+			metricType := types[r.Intn(len(types))]
+			family := &dto.MetricFamily{Name: proto.String(fmt.Sprintf("family_%d", j)), Type: metricType.Enum()}
+			if help := texts[r.Intn(len(texts))]; help != "" {
+				family.Help = proto.String(help)
+			}
+			for k := 0; k < 1+r.Intn(3); k++ {
+				metric := &dto.Metric{Label: []*dto.LabelPair{{Name: proto.String("series"), Value: proto.String(fmt.Sprint(k))}}}
+				if r.Intn(2) == 0 {
+					metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String("text"), Value: proto.String(texts[1+r.Intn(len(texts)-1)])})
+				}
+				value := floats[r.Intn(len(floats))]
+				switch metricType {
+				case dto.MetricType_COUNTER:
+					metric.Counter = &dto.Counter{Value: proto.Float64(math.Abs(value))}
+				case dto.MetricType_GAUGE:
+					metric.Gauge = &dto.Gauge{Value: proto.Float64(value)}
+				case dto.MetricType_UNTYPED:
+					metric.Untyped = &dto.Untyped{Value: proto.Float64(value)}
+				case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+					count := uint64(r.Intn(100))
+					metric.Histogram = &dto.Histogram{
+						SampleCount: proto.Uint64(count),
+						SampleSum:   proto.Float64(value),
+						Bucket: []*dto.Bucket{
+							{UpperBound: proto.Float64(0.5), CumulativeCount: proto.Uint64(count / 2)},
+							{UpperBound: proto.Float64(math.Inf(1)), CumulativeCount: proto.Uint64(count)},
+						},
+					}
+				case dto.MetricType_SUMMARY:
+					metric.Summary = &dto.Summary{
+						SampleCount: proto.Uint64(uint64(r.Intn(100))),
+						SampleSum:   proto.Float64(value),
+						Quantile:    []*dto.Quantile{{Quantile: proto.Float64(0.99), Value: proto.Float64(floats[r.Intn(len(floats))])}},
+					}
+				}
+				family.Metric = append(family.Metric, metric)
+			}
+			families[family.GetName()] = family
+		}
+
+		require.NoError(t, CheckRoundTrip(families), "iteration %d", i)
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	parse := func(text string) map[string]*dto.MetricFamily {
+		families, err := parseText([]byte(text))
+		require.NoError(t, err)
+		return families
+	}
+	base := parse("# TYPE jobs_total counter\njobs_total{job=\"a\"} 1\njobs_total{job=\"b\"} 2\n")
+
+	assert.NoError(t, Equivalent(base, parse("# TYPE jobs_total counter\njobs_total{job=\"b\"} 2\njobs_total{job=\"a\"} 1\n")), "order doesn't matter")
+	assert.ErrorContains(t, Equivalent(base, parse("# TYPE jobs_total counter\njobs_total{job=\"a\"} 1\njobs_total{job=\"b\"} 3\n")), `became {job="b"} 3`)
+	assert.ErrorContains(t, Equivalent(base, parse("# TYPE jobs_total gauge\njobs_total{job=\"a\"} 1\njobs_total{job=\"b\"} 2\n")), "type counter became gauge")
+	assert.ErrorContains(t, Equivalent(base, parse("# TYPE jobs_total counter\njobs_total{job=\"a\"} 1\n")), "is missing")
+	assert.ErrorContains(t, Equivalent(parse("other 1\n"), base), "jobs_total is unexpected")
+	assert.ErrorContains(t, Equivalent(base, parse("# HELP jobs_total Jobs\n# TYPE jobs_total counter\njobs_total{job=\"a\"} 1\njobs_total{job=\"b\"} 2\n")), `help "" became "Jobs"`)
+	assert.ErrorContains(t, Equivalent(base, map[string]*dto.MetricFamily{}), "jobs_total is missing")
+}
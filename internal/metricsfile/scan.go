@@ -0,0 +1,140 @@
+package metricsfile
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// checksumPrefix starts the trailer comment written by RewriteWithChecksum.
+// Text format parsers skip it like any other comment.
+const checksumPrefix = "# omet-crc32 "
+
+// RewriteWithChecksum is like Rewrite but appends a "# omet-crc32" trailer
+// covering everything write produced, so readers that only look at part of
+// the file can still tell it was written completely.
+func (fl *FileLock) RewriteWithChecksum(write func(*os.File) error) error {
+	if err := fl.Rewrite(write); err != nil {
+		return err
+	}
+
+	size, err := fl.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, io.NewSectionReader(fl.file, 0, size)); err != nil {
+		return fmt.Errorf("failed to checksum: %w", err)
+	}
+	_, err = fmt.Fprintf(fl.file, "%s%08x\n", checksumPrefix, hash.Sum32())
+	return err
+}
+
+// AppendChecksum appends the trailer RewriteWithChecksum would write for
+// data, for writers that don't go through a FileLock.
+func AppendChecksum(data []byte) []byte {
+	return fmt.Appendf(data, "%s%08x\n", checksumPrefix, crc32.ChecksumIEEE(data))
+}
+
+// Verify reports whether data ends with a checksum trailer matching the
+// content before it. Files without a trailer, such as pipeline output or
+// files appended to after omet wrote them, are not verified.
+func Verify(data []byte) bool {
+	body := bytes.TrimSuffix(data, []byte("\n"))
+	start := bytes.LastIndexByte(body, '\n') + 1
+	trailer := string(body[start:])
+	if !strings.HasPrefix(trailer, checksumPrefix) {
+		return false
+	}
+
+	var sum uint32
+	if _, err := fmt.Sscanf(trailer[len(checksumPrefix):], "%08x", &sum); err != nil {
+		return false
+	}
+	return crc32.ChecksumIEEE(data[:start]) == sum
+}
+
+// ParsePartial parses only the named families. Unless data carries a valid
+// checksum trailer, the rest of the file could hide corruption a partial
+// read wouldn't notice, so it falls back to parsing everything; partial
+// reports which path was taken.
+func ParsePartial(data []byte, names []string) (families map[string]*dto.MetricFamily, partial bool, err error) {
+	if !Verify(data) {
+		families, err = parseBytes(data)
+		return families, false, err
+	}
+	families, err = parseBytes(filterFamilies(Normalize(data), names))
+	return families, true, err
+}
+
+// filterFamilies keeps the comment and sample lines belonging to the named
+// families. Histogram and summary samples are recognized by their suffixes
+// while their family's comments are the most recent ones seen, the same way
+// the text format parser attributes them.
+func filterFamilies(data []byte, names []string) []byte {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	suffixed := make(map[string]dto.MetricType)
+	current := ""
+
+	var out bytes.Buffer
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i+1], data[i+1:]
+		} else {
+			data = nil
+		}
+
+		if bytes.HasPrefix(line, []byte("#")) {
+			fields := strings.Fields(string(line))
+			if len(fields) >= 3 && (fields[1] == "HELP" || fields[1] == "TYPE") {
+				current = fields[2]
+			}
+			if len(fields) >= 3 && wanted[fields[2]] {
+				switch fields[1] {
+				case "TYPE":
+					if len(fields) >= 4 {
+						if metricType, ok := ParseTypeName(fields[3]); ok {
+							suffixed[fields[2]] = metricType
+						}
+					}
+					out.Write(line)
+				case "HELP", "UNIT":
+					out.Write(line)
+				}
+			}
+			continue
+		}
+
+		name := sampleName(line)
+		if wanted[name] {
+			out.Write(line)
+			continue
+		}
+		for _, suffix := range []string{"_bucket", "_sum", "_count", "_gsum", "_gcount"} {
+			if base, ok := strings.CutSuffix(name, suffix); ok && base == current && ownsSuffix(suffixed[base], suffix) {
+				out.Write(line)
+				break
+			}
+		}
+	}
+	return out.Bytes()
+}
+
+// sampleName returns the metric name at the start of a sample line.
+func sampleName(line []byte) string {
+	line = bytes.TrimLeft(line, " \t")
+	end := bytes.IndexAny(line, "{ \t\n")
+	if end < 0 {
+		end = len(line)
+	}
+	return string(line[:end])
+}
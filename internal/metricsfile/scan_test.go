@@ -0,0 +1,96 @@
+package metricsfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteWithChecksum(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics.prom")
+	lock, err := NewFileLock(filename, time.Second)
+	require.NoError(t, err)
+	defer lock.Close()
+	require.NoError(t, lock.Lock(context.Background()))
+
+	require.NoError(t, lock.RewriteWithChecksum(func(file *os.File) error {
+		_, err := file.WriteString(sampleMetrics)
+		return err
+	}))
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), sampleMetrics))
+	assert.True(t, Verify(data))
+
+	families, err := Parse(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Len(t, families, 3, "the trailer is an ordinary comment to parsers")
+
+	tampered := bytes.Replace(data, []byte("queue_depth 42"), []byte("queue_depth 43"), 1)
+	assert.False(t, Verify(tampered))
+	assert.False(t, Verify(append(data, "extra 1\n"...)), "appending after the trailer drops verification")
+	assert.False(t, Verify([]byte(sampleMetrics)))
+	assert.Equal(t, data, AppendChecksum([]byte(sampleMetrics)), "AppendChecksum writes the same trailer")
+}
+
+func TestParsePartial(t *testing.T) {
+	var data bytes.Buffer
+	data.WriteString(sampleMetrics)
+	data.WriteString("# TYPE latency_seconds_count gauge\nlatency_seconds_count 9\n")
+	fmt.Fprintf(&data, "%s%08x\n", checksumPrefix, crc32.ChecksumIEEE(data.Bytes()))
+
+	families, partial, err := ParsePartial(data.Bytes(), []string{"queue_depth", "latency_seconds"})
+	require.NoError(t, err)
+	assert.True(t, partial)
+	require.Len(t, families, 2)
+	assert.Equal(t, 42.0, families["queue_depth"].Metric[0].GetGauge().GetValue())
+	histogram := families["latency_seconds"].Metric[0].GetHistogram()
+	assert.Equal(t, uint64(5), histogram.GetSampleCount())
+	assert.Len(t, histogram.GetBucket(), 2)
+
+	t.Run("unverified data is parsed in full", func(t *testing.T) {
+		families, partial, err := ParsePartial([]byte(sampleMetrics), []string{"queue_depth"})
+		require.NoError(t, err)
+		assert.False(t, partial)
+		assert.Len(t, families, 3)
+
+		_, _, err = ParsePartial([]byte(sampleMetrics+"garbage {\n"), []string{"queue_depth"})
+		assert.Error(t, err, "corruption elsewhere in an unverified file is still caught")
+	})
+}
+
+func BenchmarkParsePartial(b *testing.B) {
+	var data bytes.Buffer
+	data.WriteString("# TYPE omet_last_write gauge\nomet_last_write 1700000000\n")
+	data.WriteString("# TYPE requests_total counter\n")
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&data, "requests_total{path=\"/p%d\"} %d\n", i, i)
+	}
+	fmt.Fprintf(&data, "%s%08x\n", checksumPrefix, crc32.ChecksumIEEE(data.Bytes()))
+	names := []string{"omet_last_write"}
+
+	b.Run("partial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := ParsePartial(data.Bytes(), names); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := Parse(bytes.NewReader(data.Bytes())); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package metricsfile
+
+import (
+	"bufio"
+	"bytes"
+	"hash/fnv"
+	"io"
+	"strings"
+)
+
+// SplitFamilies streams text-format input and hands every line that matters
+// to the parser to emit, along with a partition in [0, parts) derived from
+// the family the line belongs to. All lines of a family land in the same
+// partition in their original order, so each partition parses on its own
+// into exactly that family's share of the input. Blank lines and comments
+// other than HELP, TYPE, and UNIT are dropped.
+func SplitFamilies(input io.Reader, parts int, emit func(part int, line []byte) error) error {
+	reader := bufio.NewReaderSize(input, 64*1024)
+	types := make(map[string]string) // declared types, for suffix resolution
+	seen := make(map[string]bool)    // family names the parser would know
+
+	// family resolves a name the way the text parser does: an existing
+	// family wins, then the base of a histogram or summary suffix.
+	family := func(name string) string {
+		if seen[name] {
+			return name
+		}
+		for _, suffix := range []string{"_count", "_sum", "_bucket", "_gcount", "_gsum"} {
+			if base, ok := strings.CutSuffix(name, suffix); ok {
+				if t, ok := ParseTypeName(types[base]); ok && ownsSuffix(t, suffix) {
+					return base
+				}
+			}
+		}
+		seen[name] = true
+		return name
+	}
+
+	first := true
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if first {
+				line = bytes.TrimPrefix(line, utf8BOM)
+				first = false
+			}
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+
+			if name, ok := lineFamily(line, family, types); ok {
+				if emitErr := emit(partition(name, parts), append(line, '\n')); emitErr != nil {
+					return emitErr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// lineFamily returns the family a line belongs to, recording TYPE lines.
+func lineFamily(line []byte, family func(string) string, types map[string]string) (string, bool) {
+	trimmed := bytes.TrimLeft(line, " \t")
+	if len(trimmed) == 0 {
+		return "", false
+	}
+	if trimmed[0] != '#' {
+		return family(sampleName(trimmed)), true
+	}
+
+	fields := strings.Fields(string(trimmed[1:]))
+	if len(fields) < 2 {
+		return "", false
+	}
+	switch fields[0] {
+	case "UNIT":
+		return fields[1], true // matched by exact name, see parseUnits
+	case "HELP", "TYPE":
+		name := family(fields[1])
+		if fields[0] == "TYPE" && len(fields) >= 3 && name == fields[1] {
+			types[name] = fields[2]
+		}
+		return name, true
+	}
+	return "", false
+}
+
+func partition(name string, parts int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(parts))
+}
@@ -0,0 +1,46 @@
+package metricsfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSplitFamilies(t *testing.T) {
+	input := "\xEF\xBB\xBF" + strings.ReplaceAll(sampleMetrics, "\n", "\r\n") +
+		"# UNIT latency_seconds seconds\n" +
+		"# a generic comment\n\n" +
+		"# TYPE jobs summary\njobs{quantile=\"0.5\"} 1\njobs_sum 4\njobs_count 2\n" +
+		"untyped_thing{a=\"1\"} 3\n"
+
+	expected, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	const parts = 3
+	buffers := make([]bytes.Buffer, parts)
+	owner := make(map[string]int)
+	require.NoError(t, SplitFamilies(strings.NewReader(input), parts, func(part int, line []byte) error {
+		assert.True(t, bytes.HasSuffix(line, []byte("\n")))
+		assert.False(t, bytes.Contains(line, []byte("\r")))
+		buffers[part].Write(line)
+		return nil
+	}))
+
+	got := 0
+	for part := range buffers {
+		families, err := Parse(&buffers[part])
+		require.NoError(t, err, "partition %d parses on its own", part)
+		for name, family := range families {
+			_, dup := owner[name]
+			assert.False(t, dup, "family %s is split across partitions", name)
+			owner[name] = part
+			assert.True(t, proto.Equal(expected[name], family), "family %s", name)
+			got++
+		}
+	}
+	assert.Equal(t, len(expected), got)
+}
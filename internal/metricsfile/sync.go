@@ -0,0 +1,54 @@
+package metricsfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SyncMode says how much of a rewrite is flushed to stable storage before
+// the write is considered done.
+type SyncMode int
+
+const (
+	SyncNone SyncMode = iota // leave flushing to the kernel
+	SyncData                 // fsync the file
+	SyncFull                 // fsync the file and its directory, so a newly created file's entry survives too
+)
+
+// ParseSyncMode parses "none", "data", or "full".
+func ParseSyncMode(mode string) (SyncMode, error) {
+	switch mode {
+	case "none", "":
+		return SyncNone, nil
+	case "data":
+		return SyncData, nil
+	case "full":
+		return SyncFull, nil
+	}
+	return SyncNone, fmt.Errorf("invalid sync mode %q (supported: none, data, full)", mode)
+}
+
+// Sync flushes the locked file, and with SyncFull its directory, to stable
+// storage.
+func (fl *FileLock) Sync(mode SyncMode) error {
+	if mode == SyncNone {
+		return nil
+	}
+	if err := fl.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", fl.filename, err)
+	}
+	if mode == SyncData {
+		return nil
+	}
+
+	dir, err := os.Open(filepath.Dir(fl.filename))
+	if err != nil {
+		return fmt.Errorf("failed to sync directory of %s: %w", fl.filename, err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory of %s: %w", fl.filename, err)
+	}
+	return nil
+}
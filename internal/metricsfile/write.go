@@ -0,0 +1,118 @@
+package metricsfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Escapes of the text format: HELP text escapes backslashes and newlines,
+// label values double quotes too.
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Write serializes metric families to text format (pure function)
+func Write(families map[string]*dto.MetricFamily, output io.Writer) error {
+	// Convert back to text format
+	for _, family := range families {
+		// Write HELP line
+		if family.Help != nil {
+			fmt.Fprintf(output, "# HELP %s %s\n", family.GetName(), helpEscaper.Replace(family.GetHelp()))
+		}
+
+		// Write TYPE line
+		if family.Type != nil {
+			fmt.Fprintf(output, "# TYPE %s %s\n", family.GetName(), TypeName(family.GetType()))
+		}
+
+		// Write UNIT line; text format parsers treat it as a comment
+		if family.GetUnit() != "" {
+			fmt.Fprintf(output, "# UNIT %s %s\n", family.GetName(), family.GetUnit())
+		}
+
+		// Write metrics
+		for _, metric := range family.Metric {
+			name := family.GetName()
+
+			// Build label string
+			var labelParts []string
+			for _, label := range metric.Label {
+				labelParts = append(labelParts, fmt.Sprintf("%s=\"%s\"", label.GetName(), labelEscaper.Replace(label.GetValue())))
+			}
+
+			var labelStr string
+			if len(labelParts) > 0 {
+				labelStr = "{" + strings.Join(labelParts, ",") + "}"
+			}
+
+			// Write value based on type
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				value := metric.GetCounter().GetValue()
+				fmt.Fprintf(output, "%s%s %g\n", name, labelStr, value)
+			case dto.MetricType_GAUGE:
+				value := metric.GetGauge().GetValue()
+				fmt.Fprintf(output, "%s%s %g\n", name, labelStr, value)
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := metric.GetHistogram()
+
+				// Write histogram buckets
+				for _, bucket := range histogram.GetBucket() {
+					bucketLabelStr := labelStr
+					if len(labelParts) > 0 {
+						bucketLabelStr = fmt.Sprintf("{%s,le=\"%g\"}", strings.Join(labelParts, ","), bucket.GetUpperBound())
+					} else {
+						bucketLabelStr = fmt.Sprintf("{le=\"%g\"}", bucket.GetUpperBound())
+					}
+					fmt.Fprintf(output, "%s_bucket%s %d\n", name, bucketLabelStr, bucket.GetCumulativeCount())
+				}
+
+				// Write count and sum
+				count, sum := "_count", "_sum"
+				if family.GetType() == dto.MetricType_GAUGE_HISTOGRAM {
+					count, sum = "_gcount", "_gsum"
+				}
+				fmt.Fprintf(output, "%s%s%s %d\n", name, count, labelStr, histogram.GetSampleCount())
+				fmt.Fprintf(output, "%s%s%s %g\n", name, sum, labelStr, histogram.GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, q := range summary.GetQuantile() {
+					quantileLabels := append(append([]string(nil), labelParts...), fmt.Sprintf("quantile=\"%g\"", q.GetQuantile()))
+					fmt.Fprintf(output, "%s{%s} %g\n", name, strings.Join(quantileLabels, ","), q.GetValue())
+				}
+				fmt.Fprintf(output, "%s_sum%s %g\n", name, labelStr, summary.GetSampleSum())
+				fmt.Fprintf(output, "%s_count%s %d\n", name, labelStr, summary.GetSampleCount())
+			default:
+				if metric.Untyped != nil {
+					value := metric.GetUntyped().GetValue()
+					fmt.Fprintf(output, "%s%s %g\n", name, labelStr, value)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// crlfWriter converts LF line endings to CRLF for Windows consumers.
+type crlfWriter struct {
+	w io.Writer
+}
+
+// NewCRLFWriter returns a writer that expands every "\n" to "\r\n".
+func NewCRLFWriter(w io.Writer) io.Writer {
+	return &crlfWriter{w: w}
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	converted := bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))
+	if _, err := c.w.Write(converted); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
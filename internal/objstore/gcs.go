@@ -0,0 +1,138 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// GCS is a Google Cloud Storage bucket, accessed through the JSON API.
+type GCS struct {
+	bucket   string
+	endpoint string
+	emulated bool
+
+	mu    sync.Mutex
+	token string
+}
+
+// NewGCS configures a bucket. The access token comes from
+// GOOGLE_OAUTH_ACCESS_TOKEN or, on GCE/GKE/Cloud Run, the metadata server.
+// STORAGE_EMULATOR_HOST points requests at an emulator without auth.
+func NewGCS(bucket string) *GCS {
+	g := &GCS{bucket: bucket, endpoint: "https://storage.googleapis.com"}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		g.endpoint, g.emulated = strings.TrimRight(host, "/"), true
+	}
+	return g
+}
+
+// metadataTokenURL serves tokens for the instance's default service account.
+var metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+func (g *GCS) accessToken(ctx context.Context) (string, error) {
+	if g.emulated {
+		return "", nil
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" {
+		return g.token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no GCS credentials: set GOOGLE_OAUTH_ACCESS_TOKEN or run on GCP (%w)", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server token request: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("metadata server token response: %w", err)
+	}
+	g.token = body.AccessToken
+	return g.token, nil
+}
+
+func (g *GCS) do(ctx context.Context, method, rawURL string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	return httpClient.Do(req)
+}
+
+func (g *GCS) Get(ctx context.Context, key string) ([]byte, string, error) {
+	rawURL := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(key))
+	resp, err := g.do(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", statusError(resp, "GET", key)
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header.Get("X-Goog-Generation"), err
+}
+
+func (g *GCS) Put(ctx context.Context, key string, data []byte) error {
+	return g.put(ctx, key, data, nil)
+}
+
+// PutIf uses ifGenerationMatch; generation 0 means the object must not exist.
+func (g *GCS) PutIf(ctx context.Context, key string, data []byte, version string) error {
+	if version == "" {
+		version = "0"
+	}
+	return g.put(ctx, key, data, &version)
+}
+
+func (g *GCS) put(ctx context.Context, key string, data []byte, generation *string) error {
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	if generation != nil {
+		query.Set("ifGenerationMatch", *generation)
+	}
+	rawURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket), query.Encode())
+	resp, err := g.do(ctx, http.MethodPost, rawURL, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp, "PUT", key)
+	}
+	return nil
+}
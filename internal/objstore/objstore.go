@@ -0,0 +1,77 @@
+// Package objstore reads and writes metrics files kept in object storage
+// (s3:// and gs:// URLs). Object stores have no locks; instead every object
+// carries a version (an S3 ETag or a GCS generation), and writers use
+// conditional puts that fail if someone else wrote in between, then retry
+// from a fresh read.
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotExist is returned by Get for a missing object.
+	ErrNotExist = errors.New("object does not exist")
+	// ErrConflict is returned by PutIf when the object changed since it
+	// was read.
+	ErrConflict = errors.New("object changed since it was read")
+)
+
+// Store is a bucket in an object store.
+type Store interface {
+	// Get returns the object's contents and current version.
+	Get(ctx context.Context, key string) (data []byte, version string, err error)
+	// Put writes the object unconditionally.
+	Put(ctx context.Context, key string, data []byte) error
+	// PutIf writes the object only if its version is still version, or,
+	// for version "", if it still doesn't exist.
+	PutIf(ctx context.Context, key string, data []byte, version string) error
+}
+
+// IsURL reports whether name refers to object storage rather than a file.
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, "s3://") || strings.HasPrefix(name, "gs://")
+}
+
+// Open returns the store and object key for an s3://bucket/key or
+// gs://bucket/key URL. Credentials come from the environment, see NewS3 and
+// NewGCS.
+func Open(rawURL string) (Store, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid object URL %s: %w", rawURL, err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, "", fmt.Errorf("invalid object URL %s: expected %s://bucket/key", rawURL, u.Scheme)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		store, err := NewS3(u.Host)
+		return store, key, err
+	case "gs":
+		return NewGCS(u.Host), key, nil
+	}
+	return nil, "", fmt.Errorf("unsupported object URL scheme %s (supported: s3, gs)", u.Scheme)
+}
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// statusError turns a failed response into an error, mapping the status
+// codes every backend shares.
+func statusError(resp *http.Response, op, key string) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotExist
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return ErrConflict
+	}
+	return fmt.Errorf("%s %s: %s", op, key, resp.Status)
+}
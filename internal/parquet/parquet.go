@@ -0,0 +1,226 @@
+// Package parquet writes small, flat Parquet files: one row group of
+// uncompressed, PLAIN-encoded columns holding strings, doubles, or int64s,
+// each required or optional. That is all a metrics export needs, and it
+// keeps the module free of a full Parquet implementation.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+var magic = []byte("PAR1")
+
+// ColumnType is the physical and logical type of a column.
+type ColumnType int
+
+const (
+	String ColumnType = iota // BYTE_ARRAY annotated as UTF8
+	Double                   // DOUBLE
+	Int64                    // INT64
+)
+
+// Column declares one column of the file.
+type Column struct {
+	Name     string
+	Type     ColumnType
+	Optional bool
+}
+
+// Writer buffers rows and writes them as a single row group.
+type Writer struct {
+	columns []Column
+	data    []bytes.Buffer // PLAIN-encoded non-null values per column
+	defined [][]bool       // per optional column, whether each row has a value
+	rows    int
+}
+
+func NewWriter(columns []Column) *Writer {
+	return &Writer{
+		columns: columns,
+		data:    make([]bytes.Buffer, len(columns)),
+		defined: make([][]bool, len(columns)),
+	}
+}
+
+// Append adds a row with one value per column: a string, float64, or int64
+// matching the column's type, or nil for a missing optional value.
+func (w *Writer) Append(values ...any) error {
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(values), len(w.columns))
+	}
+	for i, column := range w.columns {
+		var ok bool
+		switch values[i].(type) {
+		case nil:
+			if !column.Optional {
+				return fmt.Errorf("column %s is required", column.Name)
+			}
+			ok = true
+		case string:
+			ok = column.Type == String
+		case float64:
+			ok = column.Type == Double
+		case int64:
+			ok = column.Type == Int64
+		}
+		if !ok {
+			return fmt.Errorf("column %s can't hold %T", column.Name, values[i])
+		}
+	}
+
+	for i, column := range w.columns {
+		if column.Optional {
+			w.defined[i] = append(w.defined[i], values[i] != nil)
+		}
+		switch v := values[i].(type) {
+		case string:
+			binary.Write(&w.data[i], binary.LittleEndian, uint32(len(v)))
+			w.data[i].WriteString(v)
+		case float64:
+			binary.Write(&w.data[i], binary.LittleEndian, math.Float64bits(v))
+		case int64:
+			binary.Write(&w.data[i], binary.LittleEndian, v)
+		}
+	}
+	w.rows++
+	return nil
+}
+
+// Thrift enum values from parquet.thrift
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8 = 0
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	pageTypeData      = 0
+)
+
+func (c Column) physicalType() int32 {
+	switch c.Type {
+	case Double:
+		return typeDouble
+	case Int64:
+		return typeInt64
+	default:
+		return typeByteArray
+	}
+}
+
+// WriteTo writes the complete file.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	var file bytes.Buffer
+	file.Write(magic)
+
+	var chunks []thriftStruct
+	var totalSize int64
+	for i, column := range w.columns {
+		var page bytes.Buffer
+		if column.Optional {
+			levels := encodeLevels(w.defined[i])
+			binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+			page.Write(levels)
+		}
+		page.Write(w.data[i].Bytes())
+
+		header := thriftStruct{
+			{1, int32(pageTypeData)},
+			{2, int32(page.Len())},
+			{3, int32(page.Len())},
+			{5, thriftStruct{
+				{1, int32(w.rows)},
+				{2, int32(encodingPlain)},
+				{3, int32(encodingRLE)},
+				{4, int32(encodingRLE)},
+			}},
+		}
+		offset := int64(file.Len())
+		headerBytes := header.encode()
+		file.Write(headerBytes)
+		file.Write(page.Bytes())
+		size := int64(len(headerBytes) + page.Len())
+		totalSize += size
+
+		chunks = append(chunks, thriftStruct{
+			{2, offset},
+			{3, thriftStruct{
+				{1, column.physicalType()},
+				{2, thriftList{elemType: compactI32, items: []any{int32(encodingPlain), int32(encodingRLE)}}},
+				{3, thriftList{elemType: compactBinary, items: []any{column.Name}}},
+				{4, int32(codecUncompressed)},
+				{5, int64(w.rows)},
+				{6, size},
+				{7, size},
+				{9, offset},
+			}},
+		})
+	}
+
+	schema := []any{thriftStruct{
+		{4, "schema"},
+		{5, int32(len(w.columns))},
+	}}
+	for _, column := range w.columns {
+		repetition := int32(repetitionRequired)
+		if column.Optional {
+			repetition = repetitionOptional
+		}
+		element := thriftStruct{
+			{1, column.physicalType()},
+			{3, repetition},
+			{4, column.Name},
+		}
+		if column.Type == String {
+			element = append(element, thriftField{6, int32(convertedUTF8)})
+		}
+		schema = append(schema, element)
+	}
+
+	chunkItems := make([]any, len(chunks))
+	for i, chunk := range chunks {
+		chunkItems[i] = chunk
+	}
+	metadata := thriftStruct{
+		{1, int32(1)},
+		{2, thriftList{elemType: compactStruct, items: schema}},
+		{3, int64(w.rows)},
+		{4, thriftList{elemType: compactStruct, items: []any{thriftStruct{
+			{1, thriftList{elemType: compactStruct, items: chunkItems}},
+			{2, totalSize},
+			{3, int64(w.rows)},
+		}}}},
+		{6, "omet"},
+	}.encode()
+	file.Write(metadata)
+	binary.Write(&file, binary.LittleEndian, uint32(len(metadata)))
+	file.Write(magic)
+
+	n, err := out.Write(file.Bytes())
+	return int64(n), err
+}
+
+// encodeLevels encodes definition levels (0 or 1) with the RLE/bit-packing
+// hybrid at bit width 1, as a single bit-packed run.
+func encodeLevels(defined []bool) []byte {
+	groups := (len(defined) + 7) / 8
+	out := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	packed := make([]byte, groups)
+	for i, ok := range defined {
+		if ok {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return append(out, packed...)
+}
@@ -0,0 +1,150 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compactReader decodes Thrift compact structs into maps keyed by field id,
+// enough to read back what Writer produces.
+type compactReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *compactReader) varint() int64 {
+	v, n := binary.Varint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) value(kind byte) any {
+	switch kind {
+	case compactI32, compactI64:
+		return r.varint()
+	case compactBinary:
+		n := int(r.uvarint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case compactList:
+		header := r.data[r.pos]
+		r.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		items := make([]any, size)
+		for i := range items {
+			items[i] = r.value(header & 0x0f)
+		}
+		return items
+	case compactStruct:
+		return r.structure()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *compactReader) structure() map[int16]any {
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		header := r.data[r.pos]
+		r.pos++
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		fields[id] = r.value(header & 0x0f)
+		last = id
+	}
+}
+
+func TestWriter(t *testing.T) {
+	w := NewWriter([]Column{
+		{Name: "metric", Type: String},
+		{Name: "le", Type: Double, Optional: true},
+		{Name: "timestamp_ms", Type: Int64, Optional: true},
+	})
+	rows := [][]any{
+		{"latency_seconds_bucket", 0.5, nil},
+		{"latency_seconds_bucket", math.Inf(1), int64(1700000000000)},
+		{"up", nil, nil},
+	}
+	for _, row := range rows {
+		require.NoError(t, w.Append(row...))
+	}
+	assert.ErrorContains(t, w.Append(nil, nil, nil), "column metric is required")
+	assert.ErrorContains(t, w.Append("up", "0.5", nil), "column le can't hold string")
+
+	var buf bytes.Buffer
+	_, err := w.WriteTo(&buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	require.Equal(t, "PAR1", string(data[:4]))
+	require.Equal(t, "PAR1", string(data[len(data)-4:]))
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := &compactReader{data: data[len(data)-8-footerLen : len(data)-8]}
+	metadata := footer.structure()
+
+	assert.Equal(t, int64(3), metadata[3], "num_rows")
+	schema := metadata[2].([]any)
+	require.Len(t, schema, 4)
+	assert.Equal(t, int64(3), schema[0].(map[int16]any)[5], "root num_children")
+	assert.Equal(t, "metric", schema[1].(map[int16]any)[4])
+	assert.Equal(t, int64(typeByteArray), schema[1].(map[int16]any)[1])
+	assert.Equal(t, int64(repetitionOptional), schema[2].(map[int16]any)[3])
+
+	chunks := metadata[4].([]any)[0].(map[int16]any)[1].([]any)
+	require.Len(t, chunks, 3)
+
+	// Read each column's single data page back
+	page := func(column int) []byte {
+		meta := chunks[column].(map[int16]any)[3].(map[int16]any)
+		offset := int(meta[9].(int64))
+		reader := &compactReader{data: data, pos: offset}
+		header := reader.structure()
+		size := int(header[3].(int64))
+		assert.Equal(t, int64(3), header[5].(map[int16]any)[1], "page num_values includes nulls")
+		return data[reader.pos : reader.pos+size]
+	}
+
+	metric := page(0)
+	assert.Equal(t, uint32(len("latency_seconds_bucket")), binary.LittleEndian.Uint32(metric))
+	assert.Equal(t, "latency_seconds_bucket", string(metric[4:26]))
+
+	le := page(1)
+	levelsLen := int(binary.LittleEndian.Uint32(le))
+	assert.Equal(t, []byte{1<<1 | 1, 0b011}, le[4:4+levelsLen], "one bit-packed group: rows 0 and 1 defined")
+	values := le[4+levelsLen:]
+	require.Len(t, values, 16)
+	assert.Equal(t, 0.5, math.Float64frombits(binary.LittleEndian.Uint64(values)))
+	assert.True(t, math.IsInf(math.Float64frombits(binary.LittleEndian.Uint64(values[8:])), 1))
+
+	timestamps := page(2)
+	levelsLen = int(binary.LittleEndian.Uint32(timestamps))
+	assert.Equal(t, byte(0b010), timestamps[4+levelsLen-1])
+	assert.Equal(t, int64(1700000000000), int64(binary.LittleEndian.Uint64(timestamps[4+levelsLen:])))
+}
+
+func TestThriftFieldIDs(t *testing.T) {
+	// Gaps over 15 fall back to the long field header form
+	encoded := thriftStruct{{1, int32(7)}, {20, "x"}}.encode()
+	decoded := (&compactReader{data: encoded}).structure()
+	assert.Equal(t, map[int16]any{1: int64(7), 20: "x"}, decoded)
+}
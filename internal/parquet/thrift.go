@@ -0,0 +1,93 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Compact protocol type codes
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// thriftField is a struct field; value is an int32, int64, string,
+// thriftList, or thriftStruct.
+type thriftField struct {
+	id    int16
+	value any
+}
+
+// thriftStruct lists fields in increasing id order.
+type thriftStruct []thriftField
+
+type thriftList struct {
+	elemType byte
+	items    []any
+}
+
+// encode serializes the struct with the Thrift compact protocol.
+func (s thriftStruct) encode() []byte {
+	return s.append(nil)
+}
+
+func (s thriftStruct) append(out []byte) []byte {
+	var last int16
+	for _, field := range s {
+		kind := compactType(field.value)
+		if delta := field.id - last; delta > 0 && delta <= 15 {
+			out = append(out, byte(delta)<<4|kind)
+		} else {
+			out = append(out, kind)
+			out = binary.AppendVarint(out, int64(field.id))
+		}
+		last = field.id
+		out = appendValue(out, field.value)
+	}
+	return append(out, 0) // stop
+}
+
+func compactType(value any) byte {
+	switch value.(type) {
+	case int32:
+		return compactI32
+	case int64:
+		return compactI64
+	case string:
+		return compactBinary
+	case thriftList:
+		return compactList
+	case thriftStruct:
+		return compactStruct
+	}
+	panic(fmt.Sprintf("parquet: unsupported thrift value %T", value))
+}
+
+func appendValue(out []byte, value any) []byte {
+	switch v := value.(type) {
+	case int32:
+		return binary.AppendVarint(out, int64(v))
+	case int64:
+		return binary.AppendVarint(out, v)
+	case string:
+		out = binary.AppendUvarint(out, uint64(len(v)))
+		return append(out, v...)
+	case thriftList:
+		if len(v.items) < 15 {
+			out = append(out, byte(len(v.items))<<4|v.elemType)
+		} else {
+			out = append(out, 0xf0|v.elemType)
+			out = binary.AppendUvarint(out, uint64(len(v.items)))
+		}
+		for _, item := range v.items {
+			out = appendValue(out, item)
+		}
+		return out
+	case thriftStruct:
+		return v.append(out)
+	}
+	panic(fmt.Sprintf("parquet: unsupported thrift value %T", value))
+}
@@ -0,0 +1,135 @@
+// Package remotewrite encodes metric families as a Prometheus remote-write
+// 1.0 request: a snappy-compressed WriteRequest protobuf holding one sample
+// per series. The messages are small enough to encode by hand, which keeps
+// the module free of the Prometheus server's generated types.
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Label is a name and value of a time series, including __name__.
+type Label struct {
+	Name, Value string
+}
+
+// Series is a time series with the single sample a request sends for it.
+type Series struct {
+	Labels []Label // sorted by name
+	Value  float64
+}
+
+// Flatten turns families into the series Prometheus would scrape from
+// them: histograms become their _bucket, _sum, and _count series, and
+// summaries their quantiles, _sum, and _count. Series are ordered by
+// family name, then as they appear in the family.
+func Flatten(families map[string]*dto.MetricFamily) []Series {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var series []Series
+	for _, name := range names {
+		family := families[name]
+		for _, metric := range family.GetMetric() {
+			add := func(suffix string, value float64, extra ...Label) {
+				labels := append([]Label{{"__name__", name + suffix}}, extra...)
+				for _, label := range metric.GetLabel() {
+					labels = append(labels, Label{label.GetName(), label.GetValue()})
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				series = append(series, Series{Labels: labels, Value: value})
+			}
+			switch {
+			case metric.Histogram != nil:
+				for _, bucket := range metric.Histogram.GetBucket() {
+					add("_bucket", float64(bucket.GetCumulativeCount()), Label{"le", formatFloat(bucket.GetUpperBound())})
+				}
+				add("_sum", metric.Histogram.GetSampleSum())
+				add("_count", float64(metric.Histogram.GetSampleCount()))
+			case metric.Summary != nil:
+				for _, quantile := range metric.Summary.GetQuantile() {
+					add("", quantile.GetValue(), Label{"quantile", formatFloat(quantile.GetQuantile())})
+				}
+				add("_sum", metric.Summary.GetSampleSum())
+				add("_count", float64(metric.Summary.GetSampleCount()))
+			case metric.Counter != nil:
+				add("", metric.Counter.GetValue())
+			case metric.Gauge != nil:
+				add("", metric.Gauge.GetValue())
+			case metric.Untyped != nil:
+				add("", metric.Untyped.GetValue())
+			}
+		}
+	}
+	return series
+}
+
+// Encode returns the compressed WriteRequest body for series, each with a
+// sample at timestamp.
+func Encode(series []Series, timestamp time.Time) []byte {
+	var request []byte
+	for _, s := range series {
+		var ts []byte
+		for _, label := range s.Labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label.Name)
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label.Value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp.UnixMilli()))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return snappyEncode(request)
+}
+
+// snappyEncode compresses src as a snappy block made only of literals.
+// That doesn't make it any smaller, but it's valid snappy, which is all
+// remote-write receivers require, and needs no compression library.
+func snappyEncode(src []byte) []byte {
+	dst := protowire.AppendVarint(nil, uint64(len(src))) // uncompressed length
+	for len(src) > 0 {
+		n := min(len(src), 1<<16)
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 1<<8:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
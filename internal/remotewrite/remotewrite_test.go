@@ -0,0 +1,128 @@
+package remotewrite
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// snappyDecode decodes the literal-only blocks snappyEncode writes.
+func snappyDecode(t *testing.T, src []byte) []byte {
+	length, n := protowire.ConsumeVarint(src)
+	require.Greater(t, n, 0)
+	src = src[n:]
+	var dst []byte
+	for len(src) > 0 {
+		tag := src[0]
+		require.Zero(t, tag&3, "only literals are expected")
+		size, src2 := int(tag>>2)+1, src[1:]
+		switch tag >> 2 {
+		case 60:
+			size, src2 = int(src[1])+1, src[2:]
+		case 61:
+			size, src2 = int(src[1])|int(src[2])<<8+1, src[3:]
+		}
+		dst = append(dst, src2[:size]...)
+		src = src2[size:]
+	}
+	require.Equal(t, int(length), len(dst))
+	return dst
+}
+
+// fields decodes a protobuf message into its length-delimited and fixed64
+// or varint fields, by field number.
+func fields(t *testing.T, msg []byte) map[protowire.Number][][]byte {
+	out := make(map[protowire.Number][][]byte)
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		require.Greater(t, n, 0)
+		msg = msg[n:]
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		require.Greater(t, n, 0)
+		value := msg[:n]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(msg)
+		}
+		out[num] = append(out[num], value)
+		msg = msg[n:]
+	}
+	return out
+}
+
+func TestFlatten(t *testing.T) {
+	name, le, inf := "latency_seconds", 0.5, math.Inf(1)
+	count, total := uint64(2), uint64(3)
+	families := map[string]*dto.MetricFamily{
+		"jobs_total": {
+			Name: stringPtr("jobs_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{
+				Label:   []*dto.LabelPair{{Name: stringPtr("job"), Value: stringPtr("backup")}},
+				Counter: &dto.Counter{Value: float64Ptr(7)},
+			}},
+		},
+		name: {
+			Name: &name,
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+				SampleCount: &total,
+				SampleSum:   float64Ptr(1.5),
+				Bucket:      []*dto.Bucket{{UpperBound: &le, CumulativeCount: &count}, {UpperBound: &inf, CumulativeCount: &total}},
+			}}},
+		},
+	}
+
+	series := Flatten(families)
+	require.Len(t, series, 5)
+	assert.Equal(t, Series{Labels: []Label{{"__name__", "jobs_total"}, {"job", "backup"}}, Value: 7}, series[0])
+	assert.Equal(t, Series{Labels: []Label{{"__name__", "latency_seconds_bucket"}, {"le", "0.5"}}, Value: 2}, series[1])
+	assert.Equal(t, Series{Labels: []Label{{"__name__", "latency_seconds_bucket"}, {"le", "+Inf"}}, Value: 3}, series[2])
+	assert.Equal(t, Series{Labels: []Label{{"__name__", "latency_seconds_sum"}}, Value: 1.5}, series[3])
+	assert.Equal(t, Series{Labels: []Label{{"__name__", "latency_seconds_count"}}, Value: 3}, series[4])
+}
+
+func TestEncode(t *testing.T) {
+	series := []Series{
+		{Labels: []Label{{"__name__", "jobs_total"}, {"job", "backup"}}, Value: 7},
+		{Labels: []Label{{"__name__", "queue_depth"}}, Value: 0.25},
+	}
+	now := time.UnixMilli(1700000000123)
+
+	request := fields(t, snappyDecode(t, Encode(series, now)))
+	require.Len(t, request[1], 2)
+
+	ts := fields(t, request[1][0])
+	require.Len(t, ts[1], 2)
+	label := fields(t, ts[1][1])
+	assert.Equal(t, "job", string(label[1][0]))
+	assert.Equal(t, "backup", string(label[2][0]))
+
+	sample := fields(t, ts[2][0])
+	bits, _ := protowire.ConsumeFixed64(sample[1][0])
+	assert.Equal(t, 7.0, math.Float64frombits(bits))
+	millis, _ := protowire.ConsumeVarint(sample[2][0])
+	assert.Equal(t, uint64(1700000000123), millis)
+
+	ts = fields(t, request[1][1])
+	sample = fields(t, ts[2][0])
+	bits, _ = protowire.ConsumeFixed64(sample[1][0])
+	assert.Equal(t, 0.25, math.Float64frombits(bits))
+}
+
+func TestSnappyEncodeLongInput(t *testing.T) {
+	src := make([]byte, 200000)
+	for i := range src {
+		src[i] = byte(i)
+	}
+	assert.Equal(t, src, snappyDecode(t, snappyEncode(src)))
+	assert.Empty(t, snappyDecode(t, snappyEncode(nil)))
+}
+
+func stringPtr(s string) *string { return &s }
+
+func float64Ptr(f float64) *float64 { return &f }
@@ -0,0 +1,131 @@
+// Package schema describes the metric families a metrics file is expected
+// to contain, loaded from a YAML declaration.
+package schema
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is a set of declared metric families.
+//
+//	families:
+//	  - name: backups_total
+//	    type: counter
+//	    help: Total number of backups
+//	    merge: sum
+//	    series:
+//	      - {job: backup}
+//	      - {job: restore}
+type Schema struct {
+	Families []Family `yaml:"families"`
+}
+
+// Family declares one metric family and the label sets known up front.
+type Family struct {
+	Name    string              `yaml:"name"`
+	Type    string              `yaml:"type"`
+	Help    string              `yaml:"help"`
+	Unit    string              `yaml:"unit"`
+	Buckets []float64           `yaml:"buckets"`
+	Series  []map[string]string `yaml:"series"`
+	Merge   string              `yaml:"merge"` // how omet merge and ingest combine its series
+}
+
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// IsValidMetricName reports whether name is a legal Prometheus metric name.
+func IsValidMetricName(name string) bool {
+	return metricNameRE.MatchString(name)
+}
+
+// Load reads and validates a schema file.
+func Load(filename string) (*Schema, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a YAML schema.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate checks names, types, and label sets of every declared family.
+func (s *Schema) Validate() error {
+	seen := make(map[string]bool)
+	for i, family := range s.Families {
+		if !IsValidMetricName(family.Name) {
+			return fmt.Errorf("family %d: invalid metric name %q", i, family.Name)
+		}
+		if seen[family.Name] {
+			return fmt.Errorf("family %s declared more than once", family.Name)
+		}
+		seen[family.Name] = true
+
+		metricType, err := family.MetricType()
+		if err != nil {
+			return err
+		}
+		histogram := metricType == dto.MetricType_HISTOGRAM || metricType == dto.MetricType_GAUGE_HISTOGRAM
+		if len(family.Buckets) > 0 && !histogram {
+			return fmt.Errorf("family %s: buckets are only valid for histograms", family.Name)
+		}
+		if !sort.Float64sAreSorted(family.Buckets) {
+			return fmt.Errorf("family %s: buckets must be in increasing order", family.Name)
+		}
+
+		for _, labels := range family.Series {
+			for name := range labels {
+				if !labelNameRE.MatchString(name) || name == "le" && histogram {
+					return fmt.Errorf("family %s: invalid label name %q", family.Name, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MetricType maps the declared type name to its protobuf enum.
+func (f Family) MetricType() (dto.MetricType, error) {
+	metricType, err := ParseType(f.Type)
+	if err != nil {
+		return 0, fmt.Errorf("family %s: %w", f.Name, err)
+	}
+	return metricType, nil
+}
+
+// ParseType maps a type name as written in TYPE lines to its protobuf enum.
+// An empty name means untyped.
+func ParseType(name string) (dto.MetricType, error) {
+	switch name {
+	case "counter":
+		return dto.MetricType_COUNTER, nil
+	case "gauge":
+		return dto.MetricType_GAUGE, nil
+	case "histogram":
+		return dto.MetricType_HISTOGRAM, nil
+	case "gaugehistogram":
+		return dto.MetricType_GAUGE_HISTOGRAM, nil
+	case "untyped", "":
+		return dto.MetricType_UNTYPED, nil
+	default:
+		return 0, fmt.Errorf("unsupported type %q (supported: counter, gauge, histogram, gaugehistogram, untyped)", name)
+	}
+}
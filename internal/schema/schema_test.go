@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(`
+families:
+  - name: backups_total
+    type: counter
+    help: Total number of backups
+    series:
+      - {job: backup}
+      - {job: restore}
+  - name: backup_duration_seconds
+    type: histogram
+    unit: seconds
+    buckets: [1, 5, 30]
+`))
+	require.NoError(t, err)
+	require.Len(t, s.Families, 2)
+
+	assert.Equal(t, "backups_total", s.Families[0].Name)
+	assert.Len(t, s.Families[0].Series, 2)
+	assert.Equal(t, "restore", s.Families[0].Series[1]["job"])
+
+	metricType, err := s.Families[1].MetricType()
+	require.NoError(t, err)
+	assert.Equal(t, dto.MetricType_HISTOGRAM, metricType)
+	assert.Equal(t, []float64{1, 5, 30}, s.Families[1].Buckets)
+	assert.Equal(t, "seconds", s.Families[1].Unit)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"invalid name", "families: [{name: 1bad, type: gauge}]", "invalid metric name"},
+		{"duplicate", "families: [{name: up, type: gauge}, {name: up, type: gauge}]", "more than once"},
+		{"unknown type", "families: [{name: up, type: summary}]", "unsupported type"},
+		{"buckets on gauge", "families: [{name: up, type: gauge, buckets: [1]}]", "only valid for histograms"},
+		{"unsorted buckets", "families: [{name: d, type: histogram, buckets: [5, 1]}]", "increasing order"},
+		{"le label", "families: [{name: d, type: histogram, series: [{le: x}]}]", "invalid label name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.yaml))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Package selector implements PromQL-style label selectors such as
+// {job="backup",env=~"prod|staging"}.
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// LabelMatcher matches a single label against a value, using the same
+// operators as PromQL selectors (=, !=, =~, !~).
+type LabelMatcher struct {
+	Name  string
+	Op    string
+	Value string
+	re    *regexp.Regexp
+}
+
+// Selector is a set of label matchers that must all match a series.
+type Selector []LabelMatcher
+
+// Parse parses selectors like `job="backup"` or
+// `{job="backup",env=~"prod|staging"}`.
+func Parse(input string) (Selector, error) {
+	s := strings.TrimSpace(input)
+	if strings.HasPrefix(s, "{") {
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("invalid selector %s: missing closing brace", input)
+		}
+		s = s[1 : len(s)-1]
+	}
+
+	var selector Selector
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			break
+		}
+
+		// Label name
+		end := strings.IndexFunc(s, func(r rune) bool {
+			return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+		})
+		if end <= 0 {
+			return nil, fmt.Errorf("invalid selector %s: expected label name", input)
+		}
+		name := s[:end]
+		s = strings.TrimLeft(s[end:], " \t")
+
+		// Operator
+		var op string
+		for _, candidate := range []string{"=~", "!~", "!=", "="} {
+			if strings.HasPrefix(s, candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("invalid selector %s: expected operator after %s", input, name)
+		}
+		s = strings.TrimLeft(s[len(op):], " \t")
+
+		// Quoted value
+		if !strings.HasPrefix(s, `"`) {
+			return nil, fmt.Errorf("invalid selector %s: value for %s must be quoted", input, name)
+		}
+		closing := 1
+		for closing < len(s) && s[closing] != '"' {
+			if s[closing] == '\\' {
+				closing++
+			}
+			closing++
+		}
+		if closing >= len(s) {
+			return nil, fmt.Errorf("invalid selector %s: unterminated value for %s", input, name)
+		}
+		value, err := strconv.Unquote(s[:closing+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %s: %w", input, err)
+		}
+		s = s[closing+1:]
+
+		matcher := LabelMatcher{Name: name, Op: op, Value: value}
+		if op == "=~" || op == "!~" {
+			matcher.re, err = regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex for %s: %w", name, err)
+			}
+		}
+		selector = append(selector, matcher)
+	}
+
+	return selector, nil
+}
+
+// Matches reports whether the label pairs satisfy every matcher. Missing
+// labels are treated as empty, as in PromQL.
+func (s Selector) Matches(labels []*dto.LabelPair) bool {
+	for _, matcher := range s {
+		value := ""
+		for _, label := range labels {
+			if label.GetName() == matcher.Name {
+				value = label.GetValue()
+				break
+			}
+		}
+
+		var ok bool
+		switch matcher.Op {
+		case "=":
+			ok = value == matcher.Value
+		case "!=":
+			ok = value != matcher.Value
+		case "=~":
+			ok = matcher.re.MatchString(value)
+		case "!~":
+			ok = !matcher.re.MatchString(value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	var parts []string
+	for _, matcher := range s {
+		parts = append(parts, fmt.Sprintf("%s%s%q", matcher.Name, matcher.Op, matcher.Value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,103 @@
+package selector
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    Selector
+		expectError bool
+	}{
+		{
+			name:     "empty selector",
+			input:    "",
+			expected: nil,
+		},
+		{
+			name:     "single equality",
+			input:    `job="backup"`,
+			expected: Selector{{Name: "job", Op: "=", Value: "backup"}},
+		},
+		{
+			name:  "braces and multiple matchers",
+			input: `{job="backup", env!="dev"}`,
+			expected: Selector{
+				{Name: "job", Op: "=", Value: "backup"},
+				{Name: "env", Op: "!=", Value: "dev"},
+			},
+		},
+		{
+			name:     "escaped quote in value",
+			input:    `path="a\"b"`,
+			expected: Selector{{Name: "path", Op: "=", Value: `a"b`}},
+		},
+		{
+			name:        "unquoted value",
+			input:       `job=backup`,
+			expectError: true,
+		},
+		{
+			name:        "missing operator",
+			input:       `job"backup"`,
+			expectError: true,
+		},
+		{
+			name:        "invalid regex",
+			input:       `job=~"("`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := Parse(tt.input)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, selector, len(tt.expected))
+			for i := range tt.expected {
+				assert.Equal(t, tt.expected[i].Name, selector[i].Name)
+				assert.Equal(t, tt.expected[i].Op, selector[i].Op)
+				assert.Equal(t, tt.expected[i].Value, selector[i].Value)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	labels := []*dto.LabelPair{
+		{Name: proto.String("job"), Value: proto.String("backup")},
+		{Name: proto.String("env"), Value: proto.String("prod")},
+	}
+
+	tests := []struct {
+		selector string
+		expected bool
+	}{
+		{`job="backup"`, true},
+		{`job="restore"`, false},
+		{`job!="restore"`, true},
+		{`env=~"prod|staging"`, true},
+		{`env!~"prod.*"`, false},
+		{`missing=""`, true},
+		{`job="backup",env="dev"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			selector, err := Parse(tt.selector)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, selector.Matches(labels))
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// journalSuffix names the file next to a metrics file that records its
+// recent in-place operations. It doesn't end in .prom, so the textfile
+// collector ignores it.
+const journalSuffix = ".journal"
+
+// Operations kept per file unless --journal-size says otherwise
+const defaultJournalSize = 20
+
+// journalEntry records one operation on one series, with enough of the
+// series' previous state to put it back.
+type journalEntry struct {
+	Time      int64             `json:"time"`
+	Operation string            `json:"operation"`
+	Metric    string            `json:"metric"`
+	Type      string            `json:"type"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Old       string            `json:"old,omitempty"`    // absent if the series was created
+	New       string            `json:"new"`              // empty if the series was deleted
+	Before    json.RawMessage   `json:"before,omitempty"` // the series before the operation
+}
+
+// changedLabels returns the labels of the series an operation modifies: the
+// destination for copy, the request's own series otherwise.
+func changedLabels(req *request) map[string]string {
+	if req.operation != "copy" {
+		return req.labels
+	}
+	labels := make(map[string]string, len(req.labels)+len(req.destination))
+	for key, value := range req.labels {
+		labels[key] = value
+	}
+	for key, value := range req.destination {
+		labels[key] = value
+	}
+	return labels
+}
+
+// findSeries returns the series with exactly these labels, or nil.
+func findSeries(families map[string]*dto.MetricFamily, name string, labels map[string]string) *dto.Metric {
+	family, exists := families[name]
+	if !exists {
+		return nil
+	}
+	for _, metric := range family.Metric {
+		if labelsMatch(metric.Label, labels) {
+			return metric
+		}
+	}
+	return nil
+}
+
+// seriesSnapshot is a series as it was before an operation.
+type seriesSnapshot struct {
+	metric   *dto.Metric
+	value    float64
+	typeName string
+}
+
+// snapshotSeries copies the series an operation is about to change, so the
+// journal can record what it looked like before. It returns nil for a
+// series that doesn't exist yet.
+func snapshotSeries(families map[string]*dto.MetricFamily, req *request) *seriesSnapshot {
+	labels := changedLabels(req)
+	metric := findSeries(families, req.metricName, labels)
+	if metric == nil {
+		return nil
+	}
+	value, _ := seriesValue(families, req.metricName, labels)
+	return &seriesSnapshot{metric: proto.Clone(metric).(*dto.Metric), value: value, typeName: typeName(families[req.metricName])}
+}
+
+// newJournalEntry describes the change from before to the series' current
+// state, or returns nil if the operation left it untouched.
+func newJournalEntry(families map[string]*dto.MetricFamily, req *request, before *seriesSnapshot) (*journalEntry, error) {
+	labels := changedLabels(req)
+	after := findSeries(families, req.metricName, labels)
+	if (after == nil && before == nil) || (after != nil && before != nil && proto.Equal(before.metric, after)) {
+		return nil, nil
+	}
+
+	entry := &journalEntry{
+		Time:      timeProvider.Now().Unix(),
+		Operation: req.operation,
+		Metric:    req.metricName,
+		Labels:    labels,
+	}
+	if after != nil {
+		newValue, _ := seriesValue(families, req.metricName, labels)
+		entry.Type = typeName(families[req.metricName])
+		entry.New = strconv.FormatFloat(newValue, 'g', -1, 64)
+	} else {
+		// Deleted; undo puts it back
+		entry.Type = before.typeName
+	}
+	if before != nil {
+		data, err := protojson.Marshal(before.metric)
+		if err != nil {
+			return nil, err
+		}
+		entry.Old = strconv.FormatFloat(before.value, 'g', -1, 64)
+		entry.Before = data
+	}
+	return entry, nil
+}
+
+func readJournal(filename string) ([]journalEntry, error) {
+	file, err := os.Open(filename + journalSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("corrupt journal %s: %w", filename+journalSuffix, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// writeJournal replaces the journal with the newest size entries. Callers
+// hold the metrics file's lock, which also guards its journal.
+func writeJournal(filename string, entries []journalEntry, size int) error {
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp := filename + journalSuffix + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename+journalSuffix)
+}
+
+// appendJournal records entries in filename's journal, keeping the newest
+// size entries.
+func appendJournal(filename string, size int, entries ...journalEntry) error {
+	existing, err := readJournal(filename)
+	if err != nil {
+		return err
+	}
+	return writeJournal(filename, append(existing, entries...), size)
+}
+
+func undoCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "undo",
+		Usage:     "Reverse the last operations recorded in a file's journal",
+		ArgsUsage: "[n]",
+		Description: `In-place operations are recorded in a journal next to the metrics file
+(metrics.prom.journal, the last 20 by default; see --journal-size). undo
+puts back the series the last n operations (default 1) changed, newest
+first, and removes them from the journal.
+
+A series that changed since its journaled operation, for example through a
+merge or another tool, is not touched unless --force is given.
+
+Examples:
+  omet undo -f /var/lib/node_exporter/app.prom
+  omet undo -f app.prom 3`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Metrics file to undo operations in",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Undo even if a series changed since its journaled operation",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for file lock",
+			},
+		},
+		Action: runUndo,
+	}
+}
+
+func runUndo(ctx *cli.Context) error {
+	count := 1
+	if ctx.NArg() > 1 {
+		return fmt.Errorf("undo takes at most one argument")
+	}
+	if ctx.NArg() == 1 {
+		n, err := strconv.Atoi(ctx.Args().First())
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid count %q: expected a positive number", ctx.Args().First())
+		}
+		count = n
+	}
+
+	filename := ctx.String("file")
+	lock, err := metricsfile.NewFileLock(filename, ctx.Duration("lock-timeout"))
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+	defer lock.Close()
+	if err := lock.Lock(context.Background()); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	entries, err := readJournal(filename)
+	if err != nil {
+		return err
+	}
+	if len(entries) < count {
+		return fmt.Errorf("journal for %s has only %d operations", filename, len(entries))
+	}
+
+	lock.File().Seek(0, 0)
+	families, err := metricsfile.Parse(lock.File())
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	undone := entries[len(entries)-count:]
+	var report []string
+	for i := len(undone) - 1; i >= 0; i-- {
+		if err := undoEntry(families, &undone[i], ctx.Bool("force")); err != nil {
+			return err
+		}
+		report = append(report, formatUndo(&undone[i]))
+	}
+
+	err = lock.RewriteWithChecksum(func(file *os.File) error {
+		return writeMetricsWithSelfMonitoring(families, file)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	if err := writeJournal(filename, entries[:len(entries)-count], len(entries)); err != nil {
+		return fmt.Errorf("failed to update journal: %w", err)
+	}
+
+	fmt.Println(strings.Join(report, "\n"))
+	return nil
+}
+
+// undoEntry restores the series entry changed to its state before the
+// operation, removing it if the operation created it.
+func undoEntry(families map[string]*dto.MetricFamily, entry *journalEntry, force bool) error {
+	series := formatSeries(entry.Metric, entry.Labels)
+	current, exists := seriesValue(families, entry.Metric, entry.Labels)
+	if entry.New == "" {
+		if !force && exists {
+			return fmt.Errorf("%s was recreated since %s; use --force to undo anyway", series, entry.Operation)
+		}
+	} else if !force && (!exists || strconv.FormatFloat(current, 'g', -1, 64) != entry.New) {
+		return fmt.Errorf("%s changed since %s (expected %s); use --force to undo anyway", series, entry.Operation, entry.New)
+	}
+
+	if entry.Before == nil {
+		if family, ok := families[entry.Metric]; ok {
+			removeSeries(family, entry.Labels)
+			if len(family.Metric) == 0 {
+				delete(families, entry.Metric)
+			}
+		}
+		return nil
+	}
+
+	before := &dto.Metric{}
+	if err := protojson.Unmarshal(entry.Before, before); err != nil {
+		return fmt.Errorf("corrupt journal entry for %s: %w", series, err)
+	}
+	metricType, ok := metricsfile.ParseTypeName(entry.Type)
+	if !ok {
+		return fmt.Errorf("corrupt journal entry for %s: unknown type %q", series, entry.Type)
+	}
+	family, err := getOrCreateFamily(families, entry.Metric, metricType)
+	if err != nil {
+		return err
+	}
+	metric := findOrCreateMetric(family, entry.Labels)
+	proto.Reset(metric)
+	proto.Merge(metric, before)
+	return nil
+}
+
+func removeSeries(family *dto.MetricFamily, labels map[string]string) {
+	kept := family.Metric[:0]
+	for _, metric := range family.Metric {
+		if !labelsMatch(metric.Label, labels) {
+			kept = append(kept, metric)
+		}
+	}
+	family.Metric = kept
+}
+
+func formatUndo(entry *journalEntry) string {
+	old, current := entry.Old, entry.New
+	if old == "" {
+		old = "(removed)"
+	}
+	if current == "" {
+		current = "(deleted)"
+	}
+	return fmt.Sprintf("undid %s %s: %s -> %s", entry.Operation, formatSeries(entry.Metric, entry.Labels), current, old)
+}
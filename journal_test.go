@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndo(t *testing.T) {
+	run := func(t *testing.T, args ...string) error {
+		return createTestApp().Run(append([]string{"omet"}, args...))
+	}
+	read := func(t *testing.T, filename string) string {
+		content, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	t.Run("reverses the last operations newest first", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total{job=\"a\"} 41\n")
+
+		require.NoError(t, run(t, "-i", "-f", testFile, "-l", "job=a", "jobs_total", "inc"))
+		require.NoError(t, run(t, "-i", "-f", testFile, "-l", "job=b", "jobs_total", "inc", "5"))
+		require.NoError(t, run(t, "-i", "-f", testFile, "-l", "mode=fast", "queue_depth", "set", "0"))
+		assert.Contains(t, read(t, testFile), `jobs_total{job="a"} 42`)
+
+		entries, err := readJournal(testFile)
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		assert.Equal(t, "41", entries[0].Old)
+		assert.Equal(t, "42", entries[0].New)
+		assert.Empty(t, entries[1].Old, "created series have no old value")
+
+		output := captureOutput(t, func() {
+			require.NoError(t, run(t, "undo", "-f", testFile, "2"))
+		})
+		assert.Contains(t, output, `undid set queue_depth{mode="fast"}: 0 -> (removed)`)
+		assert.Contains(t, output, `undid inc jobs_total{job="b"}: 5 -> (removed)`)
+
+		content := read(t, testFile)
+		assert.Contains(t, content, `jobs_total{job="a"} 42`)
+		assert.NotContains(t, content, `job="b"`)
+		assert.NotContains(t, content, "queue_depth")
+
+		require.NoError(t, run(t, "undo", "-f", testFile))
+		assert.Contains(t, read(t, testFile), `jobs_total{job="a"} 41`)
+
+		err = run(t, "undo", "-f", testFile)
+		assert.ErrorContains(t, err, "has only 0 operations")
+	})
+
+	t.Run("refuses series changed since the operation", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE temp gauge\ntemp 20\n")
+
+		require.NoError(t, run(t, "-i", "-f", testFile, "temp", "set", "0"))
+		require.NoError(t, run(t, "-i", "-f", testFile, "--journal-size", "0", "temp", "set", "25"))
+
+		err := run(t, "undo", "-f", testFile)
+		assert.ErrorContains(t, err, "temp changed since set (expected 0)")
+
+		captureOutput(t, func() {
+			require.NoError(t, run(t, "undo", "-f", testFile, "--force"))
+		})
+		assert.Contains(t, read(t, testFile), "temp 20")
+	})
+
+	t.Run("journal keeps the newest entries", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		for range 4 {
+			require.NoError(t, run(t, "-i", "-f", testFile, "--journal-size", "2", "runs_total", "inc"))
+		}
+		entries, err := readJournal(testFile)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "4", entries[1].New)
+	})
+}
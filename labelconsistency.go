@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// parseLabelConsistency validates the --label-consistency mode.
+func parseLabelConsistency(mode string) error {
+	switch mode {
+	case "off", "warn", "refuse", "fill":
+		return nil
+	default:
+		return fmt.Errorf("invalid --label-consistency mode: %s (supported: off, warn, refuse, fill)", mode)
+	}
+}
+
+// familyLabelNames is the sorted union of the label names of family's series.
+func familyLabelNames(family *dto.MetricFamily) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, metric := range family.GetMetric() {
+		for _, label := range metric.Label {
+			if !seen[label.GetName()] {
+				seen[label.GetName()] = true
+				names = append(names, label.GetName())
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// labelNames is the sorted label names of labels.
+func labelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkLabelConsistency returns an error if a new series with labels
+// would carry other label names than the existing series of family.
+func checkLabelConsistency(name string, family *dto.MetricFamily, labels map[string]string) error {
+	if len(family.GetMetric()) == 0 {
+		return nil
+	}
+	have, want := labelNames(labels), familyLabelNames(family)
+	if strings.Join(have, ",") == strings.Join(want, ",") {
+		return nil
+	}
+	return fmt.Errorf("%s has labels [%s], but the other series of %s have [%s]",
+		formatSeries(name, labels), strings.Join(have, " "), name, strings.Join(want, " "))
+}
+
+// fillLabels returns labels with every other label name of family added
+// with an empty value, which Prometheus treats as the label being absent.
+func fillLabels(family *dto.MetricFamily, labels map[string]string) map[string]string {
+	filled := make(map[string]string, len(labels))
+	for name, value := range labels {
+		filled[name] = value
+	}
+	for _, name := range familyLabelNames(family) {
+		if _, ok := filled[name]; !ok {
+			filled[name] = ""
+		}
+	}
+	return filled
+}
+
+// fillFamilyLabels gives every series of family every label name used in
+// it, adding the missing ones with empty values.
+func fillFamilyLabels(family *dto.MetricFamily) {
+	names := familyLabelNames(family)
+	for _, metric := range family.GetMetric() {
+		if len(metric.Label) == len(names) {
+			continue
+		}
+		have := make(map[string]bool, len(metric.Label))
+		for _, label := range metric.Label {
+			have[label.GetName()] = true
+		}
+		for _, name := range names {
+			if !have[name] {
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: stringPtr(name), Value: stringPtr("")})
+			}
+		}
+	}
+}
+
+// inconsistentFamilies lists, sorted, the families whose series don't all
+// carry the same label names.
+func inconsistentFamilies(families map[string]*dto.MetricFamily) []string {
+	var names []string
+	for name, family := range families {
+		want := len(familyLabelNames(family))
+		for _, metric := range family.Metric {
+			if len(metric.Label) != want {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelConsistency(t *testing.T) {
+	const initial = "# TYPE jobs_total counter\njobs_total{job=\"a\",queue=\"fast\"} 1\n"
+	run := func(testFile, mode string, args ...string) error {
+		base := []string{"omet", "-i", "-q", "-f", testFile, "--label-consistency", mode}
+		return createTestApp().Run(append(base, args...))
+	}
+
+	t.Run("off allows mixed label names", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		require.NoError(t, run(testFile, "off", "-l", "job=b", "jobs_total", "inc"))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"jobs_total"}, inconsistentFamilies(families))
+	})
+
+	t.Run("refuse leaves the file's series alone", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		err := run(testFile, "refuse", "-l", "job=b", "jobs_total", "inc")
+		assert.ErrorContains(t, err, "the other series of jobs_total have [job queue]")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.Len(t, families["jobs_total"].Metric, 1)
+
+		// Existing series and consistent new ones are still updated
+		require.NoError(t, run(testFile, "refuse", "-l", "job=b", "-l", "queue=slow", "jobs_total", "inc"))
+	})
+
+	t.Run("fill pads new and existing series", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		require.NoError(t, run(testFile, "fill", "-l", "job=b", "jobs_total", "inc"))
+		require.NoError(t, run(testFile, "fill", "-l", "job=a", "-l", "queue=fast", "-l", "shard=1", "jobs_total", "inc"))
+		// An update without the padded labels matches the padded series
+		require.NoError(t, run(testFile, "fill", "-l", "job=b", "jobs_total", "inc"))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.Empty(t, inconsistentFamilies(families))
+		assert.Len(t, families["jobs_total"].Metric, 3)
+		value, _ := seriesValue(families, "jobs_total", map[string]string{"job": "b", "queue": "", "shard": ""})
+		assert.Equal(t, 2.0, value)
+	})
+
+	t.Run("doctor warns about mixed label names", func(t *testing.T) {
+		testFile := createTempFile(t, initial+"jobs_total{job=\"b\"} 1\n")
+		findings := findingsByCheck(diagnose(testFile, 0, nil))
+		require.Len(t, findings["labels"], 1)
+		assert.Contains(t, findings["labels"][0], "warn: series of jobs_total don't all carry the same label names")
+	})
+
+	t.Run("rejects an invalid mode", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		assert.ErrorContains(t, run(testFile, "sometimes", "jobs_total", "inc"), "invalid --label-consistency")
+	})
+}
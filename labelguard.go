@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// suspiciousLabel is a label whose value looks unique per run, the most
+// common cause of cardinality blowups in textfile metrics.
+type suspiciousLabel struct {
+	name   string
+	reason string
+}
+
+var (
+	uuidRE     = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+	hexIDRE    = regexp.MustCompile(`^(0x)?[0-9a-fA-F]{16,}$`)
+	numericRE  = regexp.MustCompile(`^[0-9]{8,}$`)
+	datetimeRE = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}`)
+)
+
+// Unix timestamps between 2001 and 2100, in seconds or milliseconds
+const (
+	minEpochSeconds = 1e9
+	maxEpochSeconds = 4102444800
+)
+
+// classifyLabelValue returns why a label value looks unique per run, or ""
+// if it looks like a normal bounded value. Date-only values such as
+// 2024-03-09 are allowed, since they rotate slowly.
+func classifyLabelValue(value string) string {
+	switch {
+	case uuidRE.MatchString(value):
+		return "uuid"
+	case datetimeRE.MatchString(value):
+		return "timestamp"
+	case numericRE.MatchString(value):
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			if n >= minEpochSeconds && n < maxEpochSeconds || n >= minEpochSeconds*1000 && n < maxEpochSeconds*1000 {
+				return "timestamp"
+			}
+		}
+		return "unique_id"
+	case hexIDRE.MatchString(value):
+		return "unique_id"
+	}
+	return ""
+}
+
+// findSuspiciousLabels checks every label value, in label name order.
+func findSuspiciousLabels(labels map[string]string) []suspiciousLabel {
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var found []suspiciousLabel
+	for _, name := range names {
+		if reason := classifyLabelValue(labels[name]); reason != "" {
+			found = append(found, suspiciousLabel{name: name, reason: reason})
+		}
+	}
+	return found
+}
+
+func (s suspiciousLabel) String() string {
+	return fmt.Sprintf("label %s looks like a %s", s.name, s.reason)
+}
+
+// addSuspiciousLabelMetrics counts suspicious labels by label name and reason.
+func addSuspiciousLabelMetrics(families map[string]*dto.MetricFamily, found []suspiciousLabel) {
+	if len(found) == 0 {
+		return
+	}
+
+	family, err := getOrCreateFamily(families, "omet_suspicious_label_total", dto.MetricType_COUNTER)
+	if err != nil {
+		return
+	}
+	family.Help = stringPtr("Total number of label values that looked like timestamps or unique IDs")
+
+	for _, s := range found {
+		metric := findOrCreateMetric(family, map[string]string{"label": s.name, "reason": s.reason})
+		metric.Counter = &dto.Counter{Value: float64Ptr(metric.GetCounter().GetValue() + 1)}
+	}
+}
+
+// parseLabelGuard validates the --suspicious-labels mode.
+func parseLabelGuard(mode string) error {
+	switch mode {
+	case "warn", "refuse", "off":
+		return nil
+	default:
+		return fmt.Errorf("invalid --suspicious-labels mode: %s (supported: warn, refuse, off)", mode)
+	}
+}
+
+// checkLabelPolicy returns a violation for every --require-label missing
+// from labels and every --forbid-label present in them.
+func checkLabelPolicy(labels map[string]string, required, forbidden []string) []error {
+	var violations []error
+	for _, name := range required {
+		if _, ok := labels[name]; !ok {
+			violations = append(violations, fmt.Errorf("refusing series without label %s (required by --require-label)", name))
+		}
+	}
+	for _, name := range forbidden {
+		if _, ok := labels[name]; ok {
+			violations = append(violations, fmt.Errorf("refusing series with label %s (forbidden by --forbid-label)", name))
+		}
+	}
+	return violations
+}
+
+// seriesLimit caps how many series one invocation may create, across all
+// its targets (--max-new-series-per-run).
+type seriesLimit struct {
+	max     int
+	created map[string]bool // file and series of each creation allowed so far
+}
+
+func newSeriesLimit(max int) *seriesLimit {
+	return &seriesLimit{max: max, created: make(map[string]bool)}
+}
+
+// allow reports whether series may be created in filename, counting it
+// against the limit. Asking again for the same series doesn't count twice.
+// A nil limit allows everything.
+func (l *seriesLimit) allow(filename, series string) bool {
+	if l == nil {
+		return true
+	}
+	key := filename + "\x00" + series
+	if l.created[key] {
+		return true
+	}
+	if len(l.created) >= l.max {
+		return false
+	}
+	l.created[key] = true
+	return true
+}
+
+// forget drops the creations counted for filename, for an object storage
+// update that starts over from a fresh read: what the earlier attempt
+// created may exist by now, created by the writer it lost to.
+func (l *seriesLimit) forget(filename string) {
+	if l == nil {
+		return
+	}
+	for key := range l.created {
+		if strings.HasPrefix(key, filename+"\x00") {
+			delete(l.created, key)
+		}
+	}
+}
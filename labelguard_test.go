@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyLabelValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"backup", ""},
+		{"prod", ""},
+		{"2024-03-09", ""},
+		{"v1.2.3", ""},
+		{"12345", ""},
+		{"550e8400-e29b-41d4-a716-446655440000", "uuid"},
+		{"1710000000", "timestamp"},
+		{"1710000000123", "timestamp"},
+		{"2024-03-09T14:05:00Z", "timestamp"},
+		{"2024-03-09 14:05", "timestamp"},
+		{"98765432109876543210", "unique_id"},
+		{"deadbeefcafebabe1234", "unique_id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.expected, classifyLabelValue(tt.value))
+		})
+	}
+}
+
+func TestSuspiciousLabels(t *testing.T) {
+	t.Run("warn applies the operation and counts the label", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "run=550e8400-e29b-41d4-a716-446655440000", "jobs_total", "inc"})
+		require.NoError(t, err)
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.Contains(t, families, "jobs_total")
+
+		value, ok := seriesValue(families, "omet_suspicious_label_total", map[string]string{"label": "run", "reason": "uuid"})
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, value)
+	})
+
+	t.Run("refuse skips the operation", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--suspicious-labels", "refuse", "-l", "started=1710000000", "jobs_total", "inc", "5"})
+		assert.ErrorContains(t, err, "label started looks like a timestamp")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.NotContains(t, families, "jobs_total")
+		value, _ := seriesValue(families, "omet_errors_total", map[string]string{"type": "suspicious_label"})
+		assert.Equal(t, 1.0, value)
+	})
+
+	t.Run("off disables the check", func(t *testing.T) {
+		families := make(map[string]*dto.MetricFamily)
+		addSuspiciousLabelMetrics(families, nil)
+		assert.Empty(t, families)
+
+		testFile := createTempFile(t, "")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--suspicious-labels", "off", "-l", "started=1710000000", "jobs_total", "inc"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "omet_suspicious_label_total")
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--suspicious-labels", "maybe", "jobs_total", "inc"})
+		assert.ErrorContains(t, err, "invalid --suspicious-labels mode")
+	})
+}
+
+func TestLabelPolicy(t *testing.T) {
+	t.Run("required and forbidden labels", func(t *testing.T) {
+		labels := map[string]string{"env": "prod", "pod_ip": "10.0.0.1"}
+		assert.Empty(t, checkLabelPolicy(labels, []string{"env"}, []string{"instance"}))
+
+		violations := checkLabelPolicy(labels, []string{"env", "team"}, []string{"pod_ip"})
+		require.Len(t, violations, 2)
+		assert.ErrorContains(t, violations[0], "without label team")
+		assert.ErrorContains(t, violations[1], "with label pod_ip")
+	})
+
+	t.Run("violations refuse the write and are counted", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--forbid-label", "pod_ip", "-l", "pod_ip=10.0.0.1", "jobs_total", "inc", "5"})
+		assert.ErrorContains(t, err, "forbidden by --forbid-label")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.NotContains(t, families, "jobs_total")
+		value, _ := seriesValue(families, "omet_errors_total", map[string]string{"type": "label_policy"})
+		assert.Equal(t, 1.0, value)
+	})
+
+	t.Run("copy is checked against the destination", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total{env=\"prod\"} 1\n")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--require-label", "env", "-l", "env=prod", "jobs_total", "copy", "env=canary"})
+		require.NoError(t, err)
+
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--forbid-label", "host", "-l", "env=prod", "jobs_total", "copy", "host=a"})
+		assert.ErrorContains(t, err, "with label host")
+	})
+}
+
+func TestMaxNewSeriesPerRun(t *testing.T) {
+	existing := createTempFile(t, "# TYPE jobs_total counter\njobs_total{env=\"prod\"} 1\n")
+	first := createTempFile(t, "")
+	second := createTempFile(t, "")
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", existing, "-f", first, "-f", second, "--max-new-series-per-run", "1", "-l", "env=prod", "jobs_total", "inc"})
+	assert.ErrorContains(t, err, "--max-new-series-per-run=1 reached")
+
+	// Updating an existing series doesn't count against the limit
+	families, err := parseMetrics(mustOpen(t, existing))
+	require.NoError(t, err)
+	value, _ := seriesValue(families, "jobs_total", map[string]string{"env": "prod"})
+	assert.Equal(t, 2.0, value)
+
+	// Targets are processed in argument order, so the first file gets the
+	// one allowed creation
+	families, err = parseMetrics(mustOpen(t, first))
+	require.NoError(t, err)
+	_, exists := seriesValue(families, "jobs_total", map[string]string{"env": "prod"})
+	assert.True(t, exists)
+
+	families, err = parseMetrics(mustOpen(t, second))
+	require.NoError(t, err)
+	_, exists = seriesValue(families, "jobs_total", map[string]string{"env": "prod"})
+	assert.False(t, exists)
+	value, _ = seriesValue(families, "omet_errors_total", map[string]string{"type": "series_limit"})
+	assert.Equal(t, 1.0, value)
+
+	limit := newSeriesLimit(0)
+	assert.False(t, limit.allow("a.prom", "x"), "a limit of 0 only allows updates")
+	var unlimited *seriesLimit
+	assert.True(t, unlimited.allow("a.prom", "x"))
+}
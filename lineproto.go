@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"omet/internal/schema"
+	"omet/internal/selector"
+
+	"github.com/urfave/cli/v2"
+)
+
+// lineProtocolInput reports whether stdin can carry line-protocol updates:
+// it isn't a terminal and isn't being read as the metrics file.
+func lineProtocolInput(ctx *cli.Context) bool {
+	if slices.Contains(ctx.StringSlice("file"), "-") {
+		return false
+	}
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice == 0
+}
+
+// runLineProtocol applies the updates read from input, one per line:
+//
+//	queue_depth{queue="a"} set 17
+//	jobs_total inc
+//	latency_seconds{route="/"} observe 0.12 0.3
+//
+// Lines are applied in order, under a single lock per file. Blank lines and
+// lines starting with # are skipped. A batch with an invalid line is
+// rejected as a whole.
+func runLineProtocol(ctx *cli.Context, input io.Reader, errorCollector *ErrorCollector, verbose bool) error {
+	if ctx.String("from") != "" || ctx.String("value-from") != "" || ctx.String("value-cmd") != "" {
+		return fmt.Errorf("--from, --value-from, and --value-cmd can't be combined with line-protocol input")
+	}
+	if ctx.IsSet("match") {
+		return fmt.Errorf("--match can't be combined with line-protocol input")
+	}
+	valueTransforms, err := parseTransforms(*ctx.Generic("transform").(*transformSpecs))
+	if err != nil {
+		errorCollector.AddError(err, "invalid_args")
+	}
+	window, err := parseWindow(ctx.String("window"), ctx.Int("window-keep"))
+	if err != nil {
+		errorCollector.AddError(err, "invalid_args")
+	}
+
+	// -l labels apply to every line; labels on a line take precedence
+	baseLabels, err := parseLabels(ctx.StringSlice("label"))
+	if err == nil {
+		err = expandLabelTemplates(baseLabels)
+	}
+	if err != nil {
+		errorCollector.AddError(err, "invalid_args")
+	}
+
+	scopeName, scopeValue, err := parseScopeLabel(ctx.String("scope-label"))
+	if err != nil {
+		errorCollector.AddError(err, "invalid_args")
+	}
+
+	req := &request{operation: "batch", batch: []*request{}, scopeName: scopeName, scopeValue: scopeValue, replace: ctx.Bool("replace"), verbose: verbose}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, 1024*1024)
+	lines := 0
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if lines++; lines == 1 {
+			if first, _, _ := strings.Cut(line, " "); isBareNumber(first) {
+				return fmt.Errorf("stdin holds a value, not updates; give a metric name and operation")
+			}
+		}
+
+		u, err := parseUpdateLine(line, func(value float64) float64 {
+			return valueTransforms.apply(value * ctx.Float64("scale"))
+		})
+		if err != nil {
+			errorCollector.AddError(fmt.Errorf("line %d: %w", lineNo, err), "invalid_args")
+			continue
+		}
+		if namespace := ctx.String("namespace"); namespace != "" && !strings.HasPrefix(u.metricName, namespace) {
+			u.metricName = namespace + u.metricName
+		}
+		if !schema.IsValidMetricName(u.metricName) {
+			errorCollector.AddError(fmt.Errorf("line %d: invalid metric name: %s", lineNo, u.metricName), "invalid_name")
+			continue
+		}
+		for name, value := range baseLabels {
+			if _, ok := u.labels[name]; !ok {
+				u.labels[name] = value
+			}
+		}
+		u.line, u.metricType, u.alpha, u.verbose = lineNo, ctx.String("type"), ctx.Float64("alpha"), verbose
+		u.window = window
+		u.suspicious = guardLabels(ctx, u.labels, errorCollector)
+		if scopeName != "" {
+			u.labels[scopeName] = scopeValue
+		}
+		req.suspicious = append(req.suspicious, u.suspicious...)
+		req.batch = append(req.batch, u)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read updates from stdin: %w", err)
+	}
+	if lines == 0 {
+		return fmt.Errorf("no updates on stdin")
+	}
+
+	if verbose {
+		log.Printf("Read %d updates from stdin", len(req.batch))
+	}
+	return runRequest(ctx, req, errorCollector, verbose)
+}
+
+// parseUpdateLine parses `name{labels} operation [value...]`, passing given
+// values through convert.
+func parseUpdateLine(line string, convert func(float64) float64) (*request, error) {
+	name, rest := line, ""
+	if end := strings.IndexAny(line, "{ \t"); end >= 0 {
+		name, rest = line[:end], line[end:]
+	}
+	labels := make(map[string]string)
+	if strings.HasPrefix(rest, "{") {
+		end := closingBrace(rest)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated labels in %q", line)
+		}
+		sel, err := selector.Parse(rest[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		for _, matcher := range sel {
+			if matcher.Op != "=" {
+				return nil, fmt.Errorf("label %s: only = is allowed in updates", matcher.Name)
+			}
+			labels[matcher.Name] = matcher.Value
+		}
+		rest = rest[end+1:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing operation for %s", name)
+	}
+	operation, args := fields[0], fields[1:]
+	if operation == "copy" || operation == "rename" {
+		return nil, fmt.Errorf("%s isn't supported in updates", operation)
+	}
+
+	u := &request{metricName: name, operation: operation, labels: labels}
+	switch {
+	case operation == "observe-buckets":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s observe-buckets requires bucket counts", name)
+		}
+		buckets, err := parseBucketCounts(args[0])
+		if err != nil {
+			return nil, err
+		}
+		u.buckets, u.values = buckets, []float64{0}
+	case len(args) == 0:
+		value, ok := defaultOperationValues[operation]
+		if !ok {
+			return nil, fmt.Errorf("%s %s requires a value", name, operation)
+		}
+		u.values = []float64{value}
+	case len(args) > 1 && operation != "observe":
+		return nil, fmt.Errorf("only observe accepts multiple values, got %d", len(args))
+	default:
+		for _, arg := range args {
+			value, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", arg)
+			}
+			u.values = append(u.values, convert(value))
+		}
+	}
+	return u, nil
+}
+
+// closingBrace returns the index of the brace closing s's label set,
+// skipping braces inside quoted values, or -1.
+func closingBrace(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == '}':
+			return i
+		}
+	}
+	return -1
+}
+
+func isBareNumber(token string) bool {
+	_, err := strconv.ParseFloat(token, 64)
+	return err == nil
+}
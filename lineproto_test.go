@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpdateLine(t *testing.T) {
+	double := func(v float64) float64 { return v * 2 }
+
+	u, err := parseUpdateLine(`queue_depth{queue="a b}",env="prod"} set 17`, double)
+	require.NoError(t, err)
+	assert.Equal(t, "queue_depth", u.metricName)
+	assert.Equal(t, "set", u.operation)
+	assert.Equal(t, map[string]string{"queue": "a b}", "env": "prod"}, u.labels)
+	assert.Equal(t, []float64{34}, u.values)
+
+	u, err = parseUpdateLine("jobs_total inc", double)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1}, u.values, "defaults aren't converted")
+
+	u, err = parseUpdateLine(`latency_seconds{route="/"} observe 0.1 0.2`, double)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.2, 0.4}, u.values)
+
+	for _, line := range []string{
+		"queue_depth",
+		"queue_depth set",
+		"queue_depth set x",
+		"queue_depth set 1 2",
+		`queue_depth{queue="a" set 1`,
+		`queue_depth{queue=~"a"} set 1`,
+		`queue_depth copy env=canary`,
+	} {
+		_, err := parseUpdateLine(line, double)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	t.Run("applies every line under one lock", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total{job=\"a\",host=\"h1\"} 4\n")
+		defer mockStdin(t, `# updates from the exporter
+queue_depth{queue="a"} set 17
+queue_depth{queue="b"} set 3
+
+jobs_total{job="a"} inc
+jobs_total{job="a"} inc 2
+`)()
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "host=h1", "--journal-size", "10"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, _ := seriesValue(families, "queue_depth", map[string]string{"queue": "a", "host": "h1"})
+		assert.Equal(t, 17.0, value)
+		value, _ = seriesValue(families, "queue_depth", map[string]string{"queue": "b", "host": "h1"})
+		assert.Equal(t, 3.0, value)
+		assert.Contains(t, string(content), `jobs_total{job="a",host="h1"} 7`)
+		assert.Contains(t, string(content), `omet_operations_by_type_total{operation="batch"} 1`)
+
+		entries, err := readJournal(testFile)
+		require.NoError(t, err)
+		require.Len(t, entries, 4)
+		assert.Equal(t, "5", entries[2].New)
+		assert.Equal(t, "7", entries[3].New)
+
+		// Each entry undoes one line, so the last two undo in order
+		require.NoError(t, createTestApp().Run([]string{"omet", "undo", "-f", testFile, "2"}))
+		content, err = os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `jobs_total{job="a",host="h1"} 4`)
+	})
+
+	t.Run("an invalid line rejects the batch", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		defer mockStdin(t, "queue_depth set 1\nbad-name set 2\n")()
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile})
+		assert.ErrorContains(t, err, "line 2: invalid metric name")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "queue_depth")
+		assert.Contains(t, string(content), `omet_errors_total{type="invalid_name"} 1`)
+	})
+
+	t.Run("porcelain reports each line", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		defer mockStdin(t, "a set 1\nb set 2\n")()
+
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--porcelain"}))
+		})
+		assert.Equal(t, "ok\tset\ta\t-\t1\nok\tset\tb\t-\t2\n", output)
+	})
+
+	t.Run("a bare value still needs a metric name", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		defer mockStdin(t, "42\n")()
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile})
+		assert.ErrorContains(t, err, "give a metric name and operation")
+	})
+}
+
+func TestValidateOnly(t *testing.T) {
+	original := "# TYPE jobs_total counter\njobs_total 4\n"
+
+	t.Run("reports every problem without writing", func(t *testing.T) {
+		testFile := createTempFile(t, original)
+		defer mockStdin(t, "jobs_total inc\nqueue_depth set 2\nbad-name set 3\nqueue_depth observe 1\n")()
+
+		var err error
+		output := captureOutput(t, func() {
+			err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--validate-only"})
+		})
+		assert.ErrorContains(t, err, "validation failed: 2 problems")
+		assert.Contains(t, output, "line 3: invalid metric name: bad-name")
+		assert.Contains(t, output, "line 4: failed to apply operation")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, original, string(content))
+	})
+
+	t.Run("passes valid updates against a missing file", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		require.NoError(t, os.Remove(testFile))
+		defer mockStdin(t, "jobs_total inc\nqueue_depth set 2\n")()
+
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--validate-only"}))
+		})
+		assert.Contains(t, output, "2 updates valid")
+		assert.NoFileExists(t, testFile)
+	})
+
+	t.Run("checks a single operation", func(t *testing.T) {
+		testFile := createTempFile(t, original)
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--validate-only", "jobs_total", "observe", "1"})
+		assert.ErrorContains(t, err, "validation failed")
+	})
+}
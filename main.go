@@ -2,20 +2,27 @@ package main
 
 import (
 	"bufio"
-	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
+	"omet/internal/metricsfile"
+	"omet/internal/objstore"
+	"omet/internal/schema"
+	"omet/internal/selector"
+
 	dto "github.com/prometheus/client_model/go"
-	"github.com/prometheus/common/expfmt"
 	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
 )
 
 // TimeProvider allows injecting time for testing
@@ -29,80 +36,36 @@ func (r RealTimeProvider) Now() time.Time {
 	return time.Now()
 }
 
-// Global time provider (can be overridden in tests)
-var timeProvider TimeProvider = RealTimeProvider{}
-
-// FileLock represents a file lock with timeout
-type FileLock struct {
-	file    *os.File
-	locked  bool
-	timeout time.Duration
+// FixedTimeProvider always returns the same time, for --now
+type FixedTimeProvider struct {
+	Time time.Time
 }
 
-func NewFileLock(filename string, timeout time.Duration) (*FileLock, error) {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file for locking: %w", err)
-	}
-	
-	return &FileLock{
-		file:    file,
-		timeout: timeout,
-	}, nil
+func (f FixedTimeProvider) Now() time.Time {
+	return f.Time
 }
 
-func (fl *FileLock) Lock(ctx context.Context) error {
-	if fl.locked {
-		return fmt.Errorf("already locked")
-	}
-	
-	// Create a context with timeout
-	lockCtx, cancel := context.WithTimeout(ctx, fl.timeout)
-	defer cancel()
-	
-	// Try to acquire lock with timeout
-	done := make(chan error, 1)
-	go func() {
-		err := syscall.Flock(int(fl.file.Fd()), syscall.LOCK_EX)
-		done <- err
-	}()
-	
-	select {
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("failed to acquire lock: %w", err)
-		}
-		fl.locked = true
-		return nil
-	case <-lockCtx.Done():
-		return fmt.Errorf("lock timeout after %v", fl.timeout)
-	}
-}
+// Global time provider (can be overridden in tests)
+var timeProvider TimeProvider = RealTimeProvider{}
 
-func (fl *FileLock) Unlock() error {
-	if !fl.locked {
-		return nil
+// parseNow parses a --now value: RFC 3339, or Unix seconds (fractions
+// allowed).
+func parseNow(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
 	}
-	
-	err := syscall.Flock(int(fl.file.Fd()), syscall.LOCK_UN)
+	seconds, err := strconv.ParseFloat(value, 64)
 	if err != nil {
-		return fmt.Errorf("failed to release lock: %w", err)
+		return time.Time{}, fmt.Errorf("invalid --now %q: expected RFC 3339 or Unix seconds", value)
 	}
-	
-	fl.locked = false
-	return nil
-}
-
-func (fl *FileLock) Close() error {
-	if fl.locked {
-		fl.Unlock()
-	}
-	return fl.file.Close()
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)), nil
 }
 
 // ErrorCollector collects errors during operation for metrics
 type ErrorCollector struct {
-	errors []ErrorInfo
+	errors  []ErrorInfo
+	traceID string
 }
 
 type ErrorInfo struct {
@@ -118,6 +81,34 @@ func (ec *ErrorCollector) HasErrors() bool {
 	return len(ec.errors) > 0
 }
 
+// HasType reports whether an error of the given type was collected.
+func (ec *ErrorCollector) HasType(errorType string) bool {
+	for _, errorInfo := range ec.errors {
+		if errorInfo.errorType == errorType {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns an independent copy, so per-file errors can be added on top
+// of errors shared by the whole invocation.
+func (ec *ErrorCollector) clone() *ErrorCollector {
+	return &ErrorCollector{
+		errors:  append([]ErrorInfo(nil), ec.errors...),
+		traceID: ec.traceID,
+	}
+}
+
+// messages returns the collected errors as strings.
+func (ec *ErrorCollector) messages() []string {
+	var messages []string
+	for _, errorInfo := range ec.errors {
+		messages = append(messages, errorInfo.err.Error())
+	}
+	return messages
+}
+
 func (ec *ErrorCollector) FirstError() error {
 	if len(ec.errors) == 0 {
 		return nil
@@ -128,12 +119,40 @@ func (ec *ErrorCollector) FirstError() error {
 // Standard histogram buckets for response times (in seconds)
 var defaultHistogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
 
+// Values used by operations that don't require one (instead of reading stdin)
+var defaultOperationValues = map[string]float64{
+	"inc":    1, // Default increment
+	"window-inc": 1,
+	"ensure": 0, // Series start at zero
+	"reset":  0, // Family operations take no value
+	"delete": 0,
+}
+
+// Smoothing factor for avg when --alpha isn't given
+const defaultAlpha = 0.3
+
 // Lock wait histogram buckets (in seconds) - focused on sub-second to few-second waits
 var lockWaitHistogramBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
 
 
 func main() {
-	app := &cli.App{
+	if err := newApp().Run(os.Args); err != nil {
+		var verifyErr *verifyError
+		if errors.As(err, &verifyErr) {
+			log.Print(err)
+			os.Exit(exitVerifyFailed)
+		}
+		var readOnlyErr *readOnlyError
+		if errors.As(err, &readOnlyErr) {
+			log.Print(err)
+			os.Exit(exitReadOnly)
+		}
+		log.Fatal(err)
+	}
+}
+
+func newApp() *cli.App {
+	return &cli.App{
 		Name:  "omet",
 		Usage: "OpenMetrics manipulation tool",
 		Description: `A tool for reading, modifying, and writing Prometheus/OpenMetrics format data.
@@ -145,19 +164,101 @@ Examples:
 
   # Explicit value  
   omet -f metrics.txt -l region=us-east request_count inc 1
-  omet -f metrics.txt -l queue=processing queue_depth set 42`,
+  omet -f metrics.txt -l queue=processing queue_depth set 42
+  omet -i -f metrics.txt request_seconds observe 0.12 0.34 0.56
+  omet -i -f metrics.txt request_seconds observe-buckets '0.1:42,0.5:90,+Inf:100,sum:37.2'
+
+  # Follow an external lifetime counter (handles source resets)
+  omet -i -f metrics.txt api_requests_total inc-to 123456
+
+  # Lifetime counter plus errors_window{window="2024-03-09"} for today and yesterday
+  omet -i -f metrics.txt errors_total window-inc
+
+  # Adjust a gauge by a delta without reading it first
+  omet -i -f metrics.txt free_slots add -3
+
+  # Turn a gauge written in bytes into megabytes
+  omet -i -f metrics.txt --divide backup_size_megabytes scale 1e6
+
+  # Track a daily peak (set only if higher)
+  omet -i -f metrics.txt memory_peak_bytes set-max 1048576
+
+  # Smoothed load average across runs
+  cut -d' ' -f1 /proc/loadavg | omet -i -f metrics.txt --alpha 0.2 load_smoothed avg
+
+  # Derived gauge from other series in the same file
+  omet -i -f metrics.txt --from 'disk_free_bytes{mount="/"} / disk_total_bytes{mount="/"}' disk_free_ratio set
+
+  # Clone a series to new labels
+  omet -i -f metrics.txt -l env=staging http_requests_total copy env=canary
+  omet -i -f metrics.txt -l env=prod --to-label env=staging http_requests_total copy
+
+  # Move a family, with its help text and every series, to a new name
+  omet -i -f metrics.txt backup_duration rename backup_duration_seconds
+
+  # Register a series at zero without touching it if present
+  omet -i -f metrics.txt --type counter -l job=restore backups_total ensure
+
+  # Value from a file, scaled
+  omet -i -f metrics.txt --value-from /sys/class/thermal/thermal_zone0/temp --scale 0.001 cpu_temp_celsius set
+
+  # Read the value from a command's output
+  omet -i -f metrics.txt --value-cmd 'df --output=avail / | tail -1' root_available_kbytes set
+
+  # Round a computed ratio to two decimals and keep it within [0, 1]
+  echo 1.0042 | omet -i -f metrics.txt --transform round:2 --transform clamp:0,1 cache_hit_ratio set
+
+  # Several updates from stdin, one per line, under a single lock
+  printf 'queue_depth{queue="a"} set 17\njobs_total inc\n' | omet -i -f metrics.txt
+
+  # Update a per-job file and an aggregate file together
+  omet -i -f job.prom -f aggregate.prom -l job=backup backups_total inc`,
 
 		Flags: []cli.Flag{
-			&cli.StringFlag{
+			&cli.StringSliceFlag{
 				Name:    "file",
 				Aliases: []string{"f"},
-				Usage:   "Input metrics file (default: stdin)",
-				Value:   "-",
+				Usage:   "Input metrics file or s3:// or gs:// URL (default: stdin); repeat with -i to update several files",
+				Value:   cli.NewStringSlice("-"),
 			},
 			&cli.StringSliceFlag{
 				Name:    "label",
 				Aliases: []string{"l"},
-				Usage:   "Add label in KEY=VALUE format (can be repeated)",
+				Usage:   "Add label in KEY=VALUE format (can be repeated); values may use {{date \"2006-01-02\"}}, {{strftime \"%Y-%m\"}}, {{hostname}}, {{env \"NAME\"}}",
+			},
+			&cli.StringFlag{
+				Name:  "match",
+				Usage: "Apply the operation to every existing series of the metric matching this selector, e.g. 'status=~\"5..\"'",
+			},
+			&cli.BoolFlag{
+				Name:  "create-if-missing",
+				Usage: "With --match, create the series given by -l and the selector's = matchers when none match",
+			},
+			&cli.StringFlag{
+				Name:  "scope-label",
+				Usage: "Add label KEY=VALUE identifying this run, and delete series whose KEY has another value (for jobs that replace all their metrics each run)",
+			},
+			&cli.BoolFlag{
+				Name:  "replace",
+				Usage: "Delete the series of each updated family that this run doesn't update, so only the given ones remain",
+			},
+			&cli.StringSliceFlag{
+				Name:  "require-label",
+				Usage: "Refuse to write series missing this label (can be repeated)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "forbid-label",
+				Usage: "Refuse to write series carrying this label (can be repeated)",
+			},
+			&cli.StringFlag{
+				Name:  "label-consistency",
+				Value: "off",
+				Usage: "What to do with a new series whose label names differ from the rest of its family: off, warn, refuse, or fill (add missing labels with empty values)",
+			},
+			&cli.StringFlag{
+				Name:  "suspicious-labels",
+				Value: "warn",
+				Usage: "What to do with label values that look like timestamps, UUIDs, or unique IDs: warn, refuse, or off",
 			},
 			&cli.BoolFlag{
 				Name:    "verbose",
@@ -173,207 +274,777 @@ Examples:
 				Name:  "no-lock",
 				Usage: "Skip file locking (dangerous!)",
 			},
+			&cli.BoolFlag{
+				Name:  "fair-lock",
+				Usage: "Queue for the file lock in arrival order with other --fair-lock writers",
+			},
+			&cli.StringFlag{
+				Name:  "splay",
+				Usage: "Before taking the lock, sleep a delay picked from this range by hostname (e.g. 0-30s), spreading out fleets run from the same cron minute",
+			},
+			&cli.StringFlag{
+				Name:  "sync",
+				Value: "none",
+				Usage: "After an in-place write, fsync nothing (none), the file (data), or the file and its directory (full)",
+			},
+			&cli.BoolFlag{
+				Name:  "mkdirs",
+				Usage: "With -i, create missing directories above --file",
+			},
+			&cli.StringFlag{
+				Name:  "mkdirs-mode",
+				Value: "0755",
+				Usage: "Octal mode of directories created by --mkdirs",
+			},
+			&cli.StringFlag{
+				Name:  "mkdirs-owner",
+				Usage: "Owner of directories created by --mkdirs, as USER[:GROUP] (default: unchanged)",
+			},
 			&cli.BoolFlag{
 				Name:    "in-place",
 				Aliases: []string{"i"},
 				Usage:   "Edit file in-place (default: write to stdout)",
 			},
+			&cli.BoolFlag{
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Usage:   "Suppress all output except errors (including metrics on stdout)",
+			},
+			&cli.BoolFlag{
+				Name:  "porcelain",
+				Usage: "Print a single tab-separated status line (status, operation, series, old value, new value) instead of metrics on stdout",
+			},
+			&cli.BoolFlag{
+				Name:  "print-result",
+				Usage: "Print the resulting value instead of metrics on stdout (\"created <series>\" goes to stderr for new series)",
+			},
+			&cli.StringFlag{
+				Name:  "audit-log",
+				Usage: "Append a JSON record of every in-place operation (time, user, pid, argv, series, before/after) to this file",
+			},
+			&cli.StringFlag{
+				Name:  "post-write-cmd",
+				Usage: "Shell command to run after each successful in-place write, with OMET_FILE, OMET_METRICS, and other OMET_* variables set",
+			},
+			&cli.StringFlag{
+				Name:  "notify-url",
+				Usage: "POST a JSON summary of the run (series, old and new values, errors) to this URL",
+			},
+			&cli.StringFlag{
+				Name:  "notify-threshold",
+				Usage: "Only notify when the series crosses this threshold, e.g. '>=100' or '<0.5'",
+			},
+			&cli.StringSliceFlag{
+				Name:  "sink",
+				Usage: "Also send the results to this output (can be repeated): stdout, file=PATH, pushgateway=URL, remote-write=URL, or http=URL",
+			},
+			&cli.BoolFlag{
+				Name:  "history",
+				Usage: "Record the resulting value of each in-place update for 'omet spark'",
+			},
+			&cli.IntFlag{
+				Name:  "history-size",
+				Value: defaultHistorySize,
+				Usage: "Values to keep in the file's history (0 disables it)",
+			},
+			&cli.IntFlag{
+				Name:  "journal-size",
+				Value: defaultJournalSize,
+				Usage: "Operations to keep in the file's journal for 'omet undo' (0 disables the journal)",
+			},
+			&cli.BoolFlag{
+				Name:  "tombstone",
+				Usage: "Keep series removed by delete for 'omet restore' instead of discarding them",
+			},
+			&cli.DurationFlag{
+				Name:  "tombstone-retention",
+				Value: defaultTombstoneRetention,
+				Usage: "How long series deleted with --tombstone can be restored",
+			},
+			&cli.BoolFlag{
+				Name:  "mark-stale",
+				Usage: "Have delete record each deleted series in a <metric>_stale gauge, set to the time of deletion",
+			},
+			&cli.BoolFlag{
+				Name:  "verify-write",
+				Usage: "Re-read the file after an in-place write and restore the previous contents if the update isn't there",
+			},
+			&cli.BoolFlag{
+				Name:  "strict-roundtrip",
+				Usage: "Check that the output would parse back to the same metrics before writing it, and abort otherwise",
+			},
+			&cli.BoolFlag{
+				Name:  "repair-histograms",
+				Usage: "Fix histograms read with unsorted or decreasing buckets, or a +Inf bucket that disagrees with _count",
+			},
+			&cli.BoolFlag{
+				Name:  "validate-only",
+				Usage: "Check the operations against each file's current contents (names, types, values) and report problems without writing anything",
+			},
+			&cli.StringFlag{
+				Name:  "encrypt-key-file",
+				Usage: "Read and write the metrics file encrypted with the AES-256 key in this file (raw, hex, or base64)",
+			},
+			&cli.BoolFlag{
+				Name:  "encrypt-plaintext",
+				Usage: "With --encrypt-key-file, accept a plaintext file and encrypt it on write, to convert existing files",
+			},
+			&cli.BoolFlag{
+				Name:  "crlf",
+				Usage: "Write CRLF line endings (input BOMs and CRLFs are always accepted)",
+			},
+			&cli.StringFlag{
+				Name:  "namespace",
+				Usage: "Prefix for the metric name (e.g. myteam_), added unless the name already starts with it",
+			},
+			&cli.StringFlag{
+				Name:  "type",
+				Usage: "Type of a family created by ensure (counter, gauge, histogram, gaugehistogram, untyped; default: existing type or gauge) or observe and observe-buckets (histogram or gaugehistogram; default: existing type or histogram)",
+			},
+			&cli.Float64Flag{
+				Name:  "alpha",
+				Value: defaultAlpha,
+				Usage: "Smoothing factor for avg in (0, 1]; higher values follow new samples more closely",
+			},
+			&cli.StringFlag{
+				Name:  "deadband",
+				Usage: "Skip a set, and the rewrite, when it would move the gauge by no more than this, e.g. 5% or 0.5",
+			},
+			&cli.StringFlag{
+				Name:  "window",
+				Value: "day",
+				Usage: "Window of the per-window series kept by window-inc: hour or day, in local time",
+			},
+			&cli.IntFlag{
+				Name:  "window-keep",
+				Value: 2,
+				Usage: "Number of windows window-inc keeps, counting the current one",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "Compute the value from other series in the same file, e.g. 'disk_free_bytes / disk_total_bytes'",
+			},
+			&cli.StringFlag{
+				Name:  "value-from",
+				Usage: "Read the value from the first line of this file (e.g. a sysfs or procfs entry)",
+			},
+			&cli.StringFlag{
+				Name:  "value-cmd",
+				Usage: "Run this shell command and read the value from the first line of its output (e.g. 'df --output=avail / | tail -1')",
+			},
+			&cli.Float64Flag{
+				Name:  "scale",
+				Value: 1,
+				Usage: "Multiply the supplied value by this factor (e.g. 0.001 for millidegrees)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "to-label",
+				Usage: "Label to override in copy's destination series, as name=value (can be repeated)",
+			},
+			&cli.BoolFlag{
+				Name:  "force-merge",
+				Usage: "Let rename merge into an existing family of the same type, the renamed series winning",
+			},
+			&cli.BoolFlag{
+				Name:  "divide",
+				Usage: "Make scale divide the current value by its factor instead of multiplying",
+			},
+			&cli.GenericFlag{
+				Name:  "transform",
+				Value: &transformSpecs{},
+				Usage: "Transform the supplied value after --scale: abs, ceil, floor, round[:N], or clamp:MIN,MAX (can be repeated, applied in order)",
+			},
+			&cli.IntFlag{
+				Name:  "max-new-series-per-run",
+				Usage: "Create at most this many new series per invocation; further creations are rejected and counted as errors (default: no limit)",
+			},
+			&cli.Int64Flag{
+				Name:  "max-input-bytes",
+				Value: 256 << 20,
+				Usage: "Refuse to read input larger than this many bytes (0 = no limit)",
+			},
+			&cli.StringFlag{
+				Name:    "now",
+				EnvVars: []string{"OMET_FAKE_NOW"},
+				Usage:   "Pretend the current time is this (RFC 3339 or Unix seconds), for reproducible output",
+			},
+			&cli.StringFlag{
+				Name:   "cpuprofile",
+				Usage:  "Write a CPU profile to this file",
+				Hidden: true,
+			},
+			&cli.StringFlag{
+				Name:   "memprofile",
+				Usage:  "Write a heap profile to this file on exit",
+				Hidden: true,
+			},
+		},
+
+		ArgsUsage: "<metric_name> <operation> [value...]",
+
+		Commands: []*cli.Command{
+			benchCommand(),
+			stressCommand(),
+			backfillCommand(),
+			initCommand(),
+			statCommand(),
+			mergeCommand(),
+			syncCommand(),
+			replaceGroupCommand(),
+			ingestCommand(),
+			everyCommand(),
+			undoCommand(),
+			restoreCommand(),
+			grepCommand(),
+			showCommand(),
+			tuiCommand(),
+			sparkCommand(),
+			exportCommand(),
+			convertCommand(),
+			docsCommand(),
+			doctorCommand(),
+		},
+
+		Before: func(ctx *cli.Context) error {
+			if namesMetric(ctx) {
+				// "omet -f m.prom stat set 1" updates the metric stat, not
+				// the stat subcommand
+				ctx.Command.Subcommands = nil
+			}
+			if value := ctx.String("now"); value != "" {
+				now, err := parseNow(value)
+				if err != nil {
+					return err
+				}
+				previous := timeProvider
+				timeProvider = FixedTimeProvider{Time: now}
+				restoreTime = func() { timeProvider = previous }
+			}
+
+			stop, err := startProfiling(ctx.String("cpuprofile"), ctx.String("memprofile"))
+			if err != nil {
+				return err
+			}
+			stopProfiling = stop
+			return nil
 		},
 
-		ArgsUsage: "<metric_name> <operation> [value]",
+		After: func(ctx *cli.Context) error {
+			if restoreTime != nil {
+				restoreTime()
+				restoreTime = nil
+			}
+			if stopProfiling == nil {
+				return nil
+			}
+			defer func() { stopProfiling = nil }()
+			return stopProfiling()
+		},
 
 		Action: runOmet,
 	}
+}
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
-	}
+// operationNames are the operations runOmet accepts after a metric name.
+var operationNames = map[string]bool{
+	"inc": true, "inc-to": true, "window-inc": true, "set": true, "add": true, "sub": true,
+	"set-max": true, "set-if-greater": true, "set-min": true, "set-if-less": true,
+	"scale": true, "avg": true, "observe": true, "observe-buckets": true, "ensure": true,
+	"copy": true, "rename": true, "reset": true, "delete": true,
 }
 
+// namesMetric reports whether the arguments are an update of a metric that
+// happens to share its name with a subcommand (or help's "h"): flags were
+// given to omet itself, which subcommands don't read, and an operation
+// follows the name.
+func namesMetric(ctx *cli.Context) bool {
+	return ctx.NumFlags() > 0 && ctx.NArg() >= 2 && operationNames[ctx.Args().Get(1)]
+}
+
+// stopProfiling finishes any profiling started by the app's Before hook
+var stopProfiling func() error
+
+// restoreTime undoes --now once the app has run
+var restoreTime func()
+
 func runOmet(ctx *cli.Context) error {
-	errorCollector := &ErrorCollector{}
-	var lockWaitTime time.Duration
+	traceID := newTraceID()
+	errorCollector := &ErrorCollector{traceID: traceID}
+	verbose := ctx.Bool("verbose") && !ctx.Bool("quiet")
+
+	// Logs always go to stderr so they never mix with metrics on stdout,
+	// tagged with the run's trace ID for correlation with error metrics
+	log.SetOutput(os.Stderr)
+	log.SetPrefix(fmt.Sprintf("omet[%s] ", traceID))
 	
-	// Validate arguments
+	// Validate arguments; without any, stdin may carry line-protocol updates
 	if ctx.NArg() < 2 {
+		if ctx.NArg() == 0 && lineProtocolInput(ctx) {
+			return runLineProtocol(ctx, os.Stdin, errorCollector, verbose)
+		}
 		return cli.ShowAppHelp(ctx)
 	}
 
-	metricName := ctx.Args().Get(0)
-	operation := ctx.Args().Get(1)
-
-	// Parse labels
-	labels, err := parseLabels(ctx.StringSlice("label"))
-	if err != nil {
-		errorCollector.AddError(err, "invalid_args")
-		if ctx.Bool("verbose") {
-			log.Printf("Label parsing error: %v", err)
+	metricName := ctx.Args().Get(0)
+	operation := ctx.Args().Get(1)
+
+	// Apply the namespace prefix, unless the name already carries it; family
+	// regular expressions are taken as given
+	regexPattern := familyOperations[operation] && strings.HasPrefix(metricName, "~")
+	if namespace := ctx.String("namespace"); namespace != "" && !strings.HasPrefix(metricName, namespace) && !regexPattern {
+		metricName = namespace + metricName
+	}
+	if familyOperations[operation] {
+		// Family operations take a glob or regular expression of metric names
+		if _, err := familyMatcher(metricName); err != nil {
+			errorCollector.AddError(fmt.Errorf("invalid metric name pattern %q: %w", metricName, err), "invalid_name")
+		}
+	} else if !schema.IsValidMetricName(metricName) {
+		errorCollector.AddError(fmt.Errorf("invalid metric name: %s", metricName), "invalid_name")
+	}
+
+	// Parse labels
+	labels, err := parseLabels(ctx.StringSlice("label"))
+	if err == nil {
+		err = expandLabelTemplates(labels)
+	}
+	if err != nil {
+		errorCollector.AddError(err, "invalid_args")
+		if verbose {
+			log.Printf("Label parsing error: %v", err)
+		}
+	}
+
+	suspicious := guardLabels(ctx, labels, errorCollector)
+
+	// The scope label is unique per run by design; stale values are cleaned up
+	// instead of guarded against
+	scopeName, scopeValue, err := parseScopeLabel(ctx.String("scope-label"))
+	if err != nil {
+		errorCollector.AddError(err, "invalid_args")
+	} else if scopeName != "" && labels != nil {
+		labels[scopeName] = scopeValue
+	}
+
+	// --match selects the series to update from each file's contents
+	var match selector.Selector
+	if input := ctx.String("match"); input != "" {
+		if match, err = selector.Parse(input); err != nil {
+			errorCollector.AddError(fmt.Errorf("invalid --match: %w", err), "invalid_args")
+		}
+	} else if ctx.Bool("create-if-missing") {
+		errorCollector.AddError(fmt.Errorf("--create-if-missing requires --match"), "invalid_args")
+	}
+
+	if verbose {
+		log.Printf("Metric: %s, Operation: %s, Labels: %v", metricName, operation, labels)
+	}
+
+	valueTransforms, err := parseTransforms(*ctx.Generic("transform").(*transformSpecs))
+	if err != nil {
+		errorCollector.AddError(err, "invalid_args")
+	}
+	window, err := parseWindow(ctx.String("window"), ctx.Int("window-keep"))
+	if err != nil {
+		errorCollector.AddError(err, "invalid_args")
+	}
+	if ctx.Int("history-size") < 0 {
+		errorCollector.AddError(fmt.Errorf("invalid --history-size %d: must be 0 (no history) or more", ctx.Int("history-size")), "invalid_args")
+	}
+	if ctx.String("type") != "" && operation != "ensure" && operation != "observe" && operation != "observe-buckets" {
+		errorCollector.AddError(fmt.Errorf("--type only applies to ensure, observe, and observe-buckets, not %s", operation), "invalid_args")
+	}
+
+	// Determine value
+	var value float64
+	var extraValues []float64
+	var expr expression
+	var destination map[string]string
+	var buckets *bucketCounts
+	var valueCmd *valueCommand
+	var newName string
+	valueFrom := ctx.String("value-from")
+	if ctx.String("value-cmd") != "" && valueFrom != "" {
+		errorCollector.AddError(fmt.Errorf("--value-cmd can't be combined with --value-from"), "invalid_args")
+	}
+	if operation == "copy" {
+		// copy takes destination labels instead of a value, as an
+		// argument or --to-label
+		toLabels := ctx.StringSlice("to-label")
+		switch {
+		case len(toLabels) > 0 && ctx.NArg() != 2:
+			errorCollector.AddError(fmt.Errorf("give copy's destination labels either as an argument or with --to-label, not both"), "invalid_args")
+		case len(toLabels) > 0:
+			if destination, err = parseLabels(toLabels); err != nil {
+				errorCollector.AddError(err, "invalid_args")
+			}
+		case ctx.NArg() != 3:
+			errorCollector.AddError(fmt.Errorf("copy requires destination labels, e.g. env=canary, '{env=\"canary\"}', or --to-label env=canary"), "invalid_args")
+		default:
+			if destination, err = parseDestinationLabels(ctx.Args().Get(2)); err != nil {
+				errorCollector.AddError(err, "invalid_args")
+			}
+		}
+	} else if operation == "rename" {
+		// rename takes the new family name instead of a value
+		if ctx.NArg() != 3 {
+			errorCollector.AddError(fmt.Errorf("rename requires the new metric name, e.g. omet old_name rename new_name"), "invalid_args")
+		} else {
+			newName = ctx.Args().Get(2)
+			if namespace := ctx.String("namespace"); namespace != "" && !strings.HasPrefix(newName, namespace) {
+				newName = namespace + newName
+			}
+			if !schema.IsValidMetricName(newName) {
+				errorCollector.AddError(fmt.Errorf("invalid metric name: %s", newName), "invalid_name")
+			}
+		}
+		if len(labels) > 0 || ctx.String("match") != "" {
+			errorCollector.AddError(fmt.Errorf("rename moves a whole family; -l and --match can't narrow it"), "invalid_args")
+		}
+	} else if operation == "observe-buckets" {
+		// observe-buckets takes pre-aggregated counts instead of a value
+		if ctx.NArg() != 3 {
+			errorCollector.AddError(fmt.Errorf("observe-buckets requires bucket counts, e.g. '0.1:42,0.5:90,+Inf:100,sum:37.2'"), "invalid_args")
+		} else if buckets, err = parseBucketCounts(ctx.Args().Get(2)); err != nil {
+			errorCollector.AddError(err, "invalid_args")
+		}
+	} else if from := ctx.String("from"); from != "" {
+		// Value computed from other series when applied to each file
+		if ctx.NArg() >= 3 || valueFrom != "" || ctx.String("value-cmd") != "" {
+			errorCollector.AddError(fmt.Errorf("--from can't be combined with a value argument, --value-from, or --value-cmd"), "invalid_args")
+		}
+		expr, err = parseExpression(from)
+		if err != nil {
+			errorCollector.AddError(err, "invalid_args")
+		}
+	} else if valueFrom != "" {
+		// Value read directly from a file, e.g. sysfs or procfs
+		if ctx.NArg() >= 3 {
+			errorCollector.AddError(fmt.Errorf("--value-from can't be combined with a value argument"), "invalid_args")
+		}
+		val, err := readValueFromFile(valueFrom)
+		if err != nil {
+			errorCollector.AddError(fmt.Errorf("failed to read value from %s: %w", valueFrom, err), "io_error")
+		} else {
+			value = valueTransforms.apply(val * ctx.Float64("scale"))
+		}
+	} else if command := ctx.String("value-cmd"); command != "" {
+		// Value printed by a command, timed for the self-monitoring metrics
+		if ctx.NArg() >= 3 {
+			errorCollector.AddError(fmt.Errorf("--value-cmd can't be combined with a value argument"), "invalid_args")
+		}
+		if verbose {
+			log.Printf("Running value command: %s", command)
+		}
+		val, run, err := runValueCommand(command)
+		valueCmd = run
+		if err != nil {
+			errorCollector.AddError(err, "io_error")
+		} else {
+			value = valueTransforms.apply(val * ctx.Float64("scale"))
+		}
+	} else if ctx.NArg() >= 3 {
+		// Value(s) provided as arguments; observe accepts several
+		args := ctx.Args().Slice()[2:]
+		if len(args) > 1 && operation != "observe" {
+			errorCollector.AddError(fmt.Errorf("only observe accepts multiple values, got %d", len(args)), "invalid_args")
+			args = args[:1]
+		}
+		for i, arg := range args {
+			val, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				errorCollector.AddError(fmt.Errorf("invalid value '%s': %w", arg, err), "invalid_args")
+				continue
+			}
+			if i == 0 {
+				value = valueTransforms.apply(val * ctx.Float64("scale"))
+			} else {
+				extraValues = append(extraValues, valueTransforms.apply(val*ctx.Float64("scale")))
+			}
+		}
+	} else {
+		// Read value from stdin or use default
+		if defaultValue, ok := defaultOperationValues[operation]; ok {
+			value = defaultValue
+		} else {
+			val, err := readValueFromStdin()
+			if err != nil {
+				errorCollector.AddError(fmt.Errorf("failed to read value from stdin: %w", err), "io_error")
+				value = 0 // Use default value
+			} else {
+				value = valueTransforms.apply(val * ctx.Float64("scale"))
+			}
+		}
+	}
+
+	if verbose {
+		log.Printf("Using value: %g", value)
+		if len(extraValues) > 0 {
+			log.Printf("Additional observations: %v", extraValues)
+		}
+	}
+
+	req := &request{
+		metricName:  metricName,
+		operation:   operation,
+		labels:      labels,
+		values:      append([]float64{value}, extraValues...),
+		metricType:  ctx.String("type"),
+		alpha:       ctx.Float64("alpha"),
+		window:      window,
+		expression:  expr,
+		scale:       ctx.Float64("scale"),
+		transforms:  valueTransforms,
+		suspicious:  suspicious,
+		destination: destination,
+		buckets:     buckets,
+		match:       match,
+		createIfMissing: ctx.Bool("create-if-missing"),
+		scopeName:   scopeName,
+		scopeValue:  scopeValue,
+		replace:     ctx.Bool("replace"),
+		newName:     newName,
+		forceMerge:  ctx.Bool("force-merge"),
+		divide:      ctx.Bool("divide"),
+		valueCmd:    valueCmd,
+		verbose:     verbose,
+	}
+	return runRequest(ctx, req, errorCollector, verbose)
+}
+
+// guardLabels warns about or refuses label values that look unique per run,
+// as --suspicious-labels says.
+func guardLabels(ctx *cli.Context, labels map[string]string, errorCollector *ErrorCollector) []suspiciousLabel {
+	labelGuard := ctx.String("suspicious-labels")
+	if err := parseLabelGuard(labelGuard); err != nil {
+		errorCollector.AddError(err, "invalid_args")
+		return nil
+	}
+	if labelGuard == "off" {
+		return nil
+	}
+	suspicious := findSuspiciousLabels(labels)
+	for _, s := range suspicious {
+		if labelGuard == "refuse" {
+			errorCollector.AddError(fmt.Errorf("refusing %s (use --suspicious-labels=warn or off to allow)", s), "suspicious_label")
+		} else if !ctx.Bool("quiet") {
+			log.Printf("WARN: %s, which can explode series cardinality", s)
+		}
+	}
+	return suspicious
+}
+
+// runRequest applies req, a single operation or a line-protocol batch, to
+// every --file target.
+func runRequest(ctx *cli.Context, req *request, errorCollector *ErrorCollector, verbose bool) error {
+	if input := ctx.String("deadband"); input != "" {
+		var err error
+		if req.deadband, err = parseDeadband(input); err != nil {
+			return err
+		}
+	}
+	req.labelConsistency = ctx.String("label-consistency")
+	if err := parseLabelConsistency(req.labelConsistency); err != nil {
+		return err
+	}
+	sinks, err := parseSinks(ctx.StringSlice("sink"))
+	if err != nil {
+		return err
+	}
+	req.sinks = sinks
+
+	// Resolve targets; several files are only supported in-place, since
+	// their outputs can't be combined on stdout
+	filenames := ctx.StringSlice("file")
+	// Without the lock, --in-place only reads the file, as it always has
+	inPlace := ctx.Bool("in-place") && !ctx.Bool("no-lock")
+	if len(filenames) > 1 {
+		if !inPlace {
+			return fmt.Errorf("multiple --file targets require --in-place, without --no-lock")
+		}
+		for _, filename := range filenames {
+			if filename == "-" {
+				return fmt.Errorf("stdin (-) can't be combined with other --file targets")
+			}
+		}
+	}
+
+	notifyThreshold, err := parseThreshold(ctx.String("notify-threshold"))
+	if err != nil {
+		return err
+	}
+
+	encryptKey, err := loadEncryptKey(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.Bool("encrypt-plaintext") && encryptKey == nil {
+		return fmt.Errorf("--encrypt-plaintext requires --encrypt-key-file")
+	}
+
+	// Tombstones are kept next to a local file, like the journal
+	if ctx.Bool("tombstone") {
+		local := inPlace && encryptKey == nil && len(filenames) > 0
+		for _, filename := range filenames {
+			local = local && filename != "-" && !objstore.IsURL(filename)
+		}
+		if !local {
+			return fmt.Errorf("--tombstone requires --in-place on local, unencrypted files")
+		}
+	}
+
+	// The textfile collector reads .prom files with the Prometheus text
+	// parser, which has no gauge histograms and would drop the whole file
+	if metricType, ok := metricsfile.ParseTypeName(ctx.String("type")); ok && metricType == dto.MetricType_GAUGE_HISTOGRAM {
+		for _, filename := range filenames {
+			if strings.HasSuffix(filename, ".prom") {
+				return fmt.Errorf("can't write a gauge histogram to %s: the textfile collector rejects gaugehistogram in .prom files, dropping every metric in them", filename)
+			}
+		}
+	}
+
+	req.encryptKey = encryptKey
+	req.encryptPlaintext = ctx.Bool("encrypt-plaintext")
+	if req.sync, err = metricsfile.ParseSyncMode(ctx.String("sync")); err != nil {
+		return err
+	}
+	if ctx.IsSet("max-new-series-per-run") {
+		req.newSeries = newSeriesLimit(ctx.Int("max-new-series-per-run"))
+	}
+
+	// Label policies apply to the series being written, which for copy is
+	// the destination
+	for _, u := range req.updates() {
+		for _, err := range checkLabelPolicy(changedLabels(u), ctx.StringSlice("require-label"), ctx.StringSlice("forbid-label")) {
+			errorCollector.AddError(err, "label_policy")
 		}
 	}
 
-	if ctx.Bool("verbose") {
-		log.Printf("Metric: %s, Operation: %s, Labels: %v", metricName, operation, labels)
-	}
+	// Validation reads each file without locking or writing it
+	validateOnly := ctx.Bool("validate-only")
 
-	// Determine value
-	var value float64
-	if ctx.NArg() >= 3 {
-		// Value provided as argument
-		val, err := strconv.ParseFloat(ctx.Args().Get(2), 64)
+	if spec := ctx.String("splay"); spec != "" && !validateOnly {
+		min, max, err := parseSplay(spec)
 		if err != nil {
-			errorCollector.AddError(fmt.Errorf("invalid value '%s': %w", ctx.Args().Get(2), err), "invalid_args")
-			value = 0 // Use default value
-		} else {
-			value = val
+			return err
 		}
-	} else {
-		// Read value from stdin or use default
-		if operation == "inc" {
-			value = 1.0 // Default increment
-		} else {
-			val, err := readValueFromStdin()
-			if err != nil {
-				errorCollector.AddError(fmt.Errorf("failed to read value from stdin: %w", err), "io_error")
-				value = 0 // Use default value
-			} else {
-				value = val
-			}
+		host, _ := os.Hostname()
+		delay := splayDelay(host, min, max)
+		if verbose {
+			log.Printf("Splaying %s before taking the lock", delay)
 		}
+		time.Sleep(delay)
 	}
 
-	if ctx.Bool("verbose") {
-		log.Printf("Using value: %g", value)
+	if ctx.Bool("mkdirs") && inPlace && !validateOnly {
+		if err := provisionDirs(ctx, filenames); err != nil {
+			return err
+		}
 	}
 
-	// Determine if we should use file locking and in-place editing
-	filename := ctx.String("file")
-	inPlace := ctx.Bool("in-place")
-	useLocking := inPlace && filename != "-" && !ctx.Bool("no-lock")
-	
-	var families map[string]*dto.MetricFamily
-	var inputSize int64
-	var lock *FileLock
-	
-	if useLocking {
-		// Use file locking approach
-		lockTimeout := ctx.Duration("lock-timeout")
-		
-		if ctx.Bool("verbose") {
-			log.Printf("Acquiring lock on %s (timeout: %v)", filename, lockTimeout)
-		}
-		
-		lock, err = NewFileLock(filename, lockTimeout)
-		if err != nil {
-			errorCollector.AddError(fmt.Errorf("failed to create file lock: %w", err), "io_error")
-			families = make(map[string]*dto.MetricFamily)
+	targets := openTargets(filenames, inPlace && !validateOnly, ctx.Bool("fair-lock"), ctx.Duration("lock-timeout"), errorCollector, verbose)
+	defer closeTargets(targets)
+	for _, t := range targets {
+		t.validateOnly = validateOnly
+	}
+
+	var firstErr error
+	for _, t := range targets {
+		var err error
+		if t.remote != nil {
+			err = processRemoteTarget(ctx, t, req, errorCollector)
 		} else {
-			defer lock.Close()
-			
-			// Measure lock wait time
-			lockStart := time.Now()
-			err = lock.Lock(context.Background())
-			lockWaitTime = time.Since(lockStart)
-			
-			if err != nil {
-				errorCollector.AddError(fmt.Errorf("failed to acquire lock: %w", err), "lock_error")
-				families = make(map[string]*dto.MetricFamily)
-			} else {
-				defer lock.Unlock()
-				
-				if ctx.Bool("verbose") {
-					log.Printf("Lock acquired in %v", lockWaitTime)
-				}
-				
-				// Read and parse the locked file
-				lock.file.Seek(0, 0) // Reset to beginning
-				if stat, err := lock.file.Stat(); err == nil {
-					inputSize = stat.Size()
-				}
-				
-				parsedFamilies, err := parseMetrics(lock.file)
-				if err != nil {
-					errorCollector.AddError(fmt.Errorf("failed to parse metrics: %w", err), "parse_error")
-					families = make(map[string]*dto.MetricFamily)
-				} else {
-					families = parsedFamilies
-				}
-			}
+			err = processTarget(ctx, t, req)
 		}
-	} else {
-		// Use stdin/no-lock logic
-		var input io.Reader
-		if filename == "-" {
-			input = os.Stdin
-		} else {
-			file, err := os.Open(filename)
-			if err != nil {
-				errorCollector.AddError(fmt.Errorf("failed to open file %s: %w", filename, err), "io_error")
-				families = make(map[string]*dto.MetricFamily) // Start with empty metrics
-			} else {
-				defer file.Close()
-				// Get file size for metrics
-				if stat, err := file.Stat(); err == nil {
-					inputSize = stat.Size()
-				}
-				input = file
-			}
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
+	}
 
-		// Parse existing metrics (best effort)
-		if input != nil {
-			parsedFamilies, err := parseMetrics(input)
-			if err != nil {
-				errorCollector.AddError(fmt.Errorf("failed to parse metrics: %w", err), "parse_error")
-				families = make(map[string]*dto.MetricFamily) // Start with empty metrics
-			} else {
-				families = parsedFamilies
+	if url := ctx.String("notify-url"); url != "" && !validateOnly {
+		if n := newNotification(req, targets, notifyThreshold, errorCollector.traceID); n != nil {
+			if err := sendNotification(url, n); err != nil {
+				log.Printf("WARN: failed to send notification: %v", err)
+			} else if verbose {
+				log.Printf("Sent notification to %s", url)
 			}
 		}
+	}
 
-		if families == nil {
-			families = make(map[string]*dto.MetricFamily)
-		}
+	return firstErr
+}
+
+// newTraceID returns a short random ID identifying a single omet run.
+func newTraceID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", uint32(time.Now().UnixNano()))
 	}
+	return hex.EncodeToString(b)
+}
 
-	if ctx.Bool("verbose") {
-		log.Printf("Parsed %d metric families", len(families))
+// seriesValue returns the current value of a series: the value of a counter,
+// gauge, or untyped series, or the sample count of a histogram.
+func seriesValue(families map[string]*dto.MetricFamily, name string, labels map[string]string) (float64, bool) {
+	family, exists := families[name]
+	if !exists {
+		return 0, false
 	}
 
-	// Apply the operation (best effort)
-	if !errorCollector.HasErrors() || (labels != nil && value != 0) {
-		err = applyOperation(families, metricName, operation, labels, value)
-		if err != nil {
-			errorCollector.AddError(fmt.Errorf("failed to apply operation: %w", err), "operation_error")
+	for _, metric := range family.Metric {
+		if !labelsMatch(metric.Label, labels) {
+			continue
+		}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			return metric.GetCounter().GetValue(), true
+		case dto.MetricType_GAUGE:
+			return metric.GetGauge().GetValue(), true
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			return float64(metric.GetHistogram().GetSampleCount()), true
+		default:
+			return metric.GetUntyped().GetValue(), true
 		}
 	}
 
+	return 0, false
+}
 
-	// Always try to write metrics (including error metrics)
-	addErrorMetrics(families, errorCollector)
-	addOperationalMetrics(families, operation, inputSize, lockWaitTime, errorCollector)
-	
-	// Write output based on mode
-	if useLocking && lock != nil && lock.locked {
-		// In-place mode: write back to the locked file
-		lock.file.Seek(0, 0)
-		lock.file.Truncate(0)
-		err = writeMetricsWithSelfMonitoring(families, lock.file)
-	} else {
-		// Default mode: write to stdout (enables pipelines)
-		err = writeMetricsWithSelfMonitoring(families, os.Stdout)
+// formatSeries renders a series as name{key="value",...} with sorted labels.
+func formatSeries(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
 	}
-	
-	if err != nil {
-		// This is a critical error - we can't write output
-		return fmt.Errorf("failed to write metrics: %w", err)
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	// Return first error for exit code, but after writing metrics
-	if errorCollector.HasErrors() {
-		return errorCollector.FirstError()
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%q", key, labels[key]))
 	}
+	return name + "{" + strings.Join(parts, ",") + "}"
+}
 
-	return nil
+// formatPorcelain renders the stable --porcelain status line. Missing values
+// are printed as "-".
+func formatPorcelain(ok bool, operation, series string, oldValue float64, hadOld bool, newValue float64, hasNew bool) string {
+	status := "ok"
+	if !ok {
+		status = "error"
+	}
+
+	format := func(value float64, present bool) string {
+		if !present {
+			return "-"
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+
+	return strings.Join([]string{status, operation, series, format(oldValue, hadOld), format(newValue, hasNew)}, "\t")
 }
 
 func parseLabels(labelStrings []string) (map[string]string, error) {
@@ -391,7 +1062,23 @@ func parseLabels(labelStrings []string) (map[string]string, error) {
 }
 
 func readValueFromStdin() (float64, error) {
-	scanner := bufio.NewScanner(os.Stdin)
+	return readValue(os.Stdin)
+}
+
+// readValueFromFile reads a value from the first line of a file, such as
+// /sys/class/thermal/thermal_zone0/temp.
+func readValueFromFile(path string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return readValue(file)
+}
+
+func readValue(r io.Reader) (float64, error) {
+	scanner := bufio.NewScanner(r)
 	if !scanner.Scan() {
 		return 0, fmt.Errorf("no input available")
 	}
@@ -409,25 +1096,54 @@ func readValueFromStdin() (float64, error) {
 	return val, nil
 }
 
-func parseMetrics(input io.Reader) (map[string]*dto.MetricFamily, error) {
-	parser := expfmt.TextParser{}
-	families, err := parser.TextToMetricFamilies(input)
+// loadMetrics parses existing metrics, refusing inputs over maxBytes (0 for
+// no limit). Failures are recorded on the collector and yield empty families
+// so the run can still report them; tooLarge reports a refused input.
+func loadMetrics(input io.Reader, size, maxBytes int64, errorCollector *ErrorCollector) (families map[string]*dto.MetricFamily, tooLarge bool) {
+	if maxBytes > 0 && size > maxBytes {
+		errorCollector.AddError(fmt.Errorf("input is %d bytes, exceeding --max-input-bytes=%d", size, maxBytes), "io_error")
+		return make(map[string]*dto.MetricFamily), true
+	}
+
+	families, err := metricsfile.ParseLimited(input, maxBytes)
+	if errors.Is(err, metricsfile.ErrInputTooLarge) {
+		errorCollector.AddError(fmt.Errorf("%w (--max-input-bytes=%d)", err, maxBytes), "io_error")
+		return make(map[string]*dto.MetricFamily), true
+	}
 	if err != nil {
-		return nil, err
+		errorCollector.AddError(fmt.Errorf("failed to parse metrics: %w", err), "parse_error")
+		return make(map[string]*dto.MetricFamily), false
 	}
-	return families, nil
+
+	return families, false
+}
+
+func parseMetrics(input io.Reader) (map[string]*dto.MetricFamily, error) {
+	return metricsfile.Parse(input)
 }
 
 func applyOperation(families map[string]*dto.MetricFamily, metricName, operation string, labels map[string]string, value float64) error {
 	switch operation {
 	case "inc":
 		return incrementCounter(families, metricName, labels, value)
+	case "inc-to":
+		return incrementCounterTo(families, metricName, labels, value)
 	case "set":
 		return setGauge(families, metricName, labels, value)
 	case "observe":
 		return observeHistogram(families, metricName, labels, value)
+	case "add":
+		return addGauge(families, metricName, labels, value)
+	case "sub":
+		return addGauge(families, metricName, labels, -value)
+	case "set-max", "set-if-greater":
+		return setGaugeIf(families, metricName, labels, value, func(current float64) bool { return value > current })
+	case "set-min", "set-if-less":
+		return setGaugeIf(families, metricName, labels, value, func(current float64) bool { return value < current })
+	case "ensure":
+		return ensureSeries(families, metricName, "", labels, value)
 	default:
-		return fmt.Errorf("unknown operation: %s (supported: inc, set, observe)", operation)
+		return fmt.Errorf("unknown operation: %s (supported: inc, inc-to, window-inc, set, add, sub, set-max (set-if-greater), set-min (set-if-less), scale, avg, observe, observe-buckets, ensure, copy, rename, reset, delete)", operation)
 	}
 }
 
@@ -461,8 +1177,256 @@ func setGauge(families map[string]*dto.MetricFamily, name string, labels map[str
 	return nil
 }
 
+// addGauge adjusts a gauge by delta. A new series starts at zero, so it
+// ends up at delta.
+func addGauge(families map[string]*dto.MetricFamily, name string, labels map[string]string, delta float64) error {
+	family, err := getOrCreateFamily(families, name, dto.MetricType_GAUGE)
+	if err != nil {
+		return err
+	}
+
+	metric := findOrCreateMetric(family, labels)
+	metric.Gauge = &dto.Gauge{Value: float64Ptr(metric.GetGauge().GetValue() + delta)}
+
+	return nil
+}
+
+// scaleSeries multiplies an existing counter or gauge by factor, or
+// divides it by factor with divide, e.g. to turn bytes into megabytes.
+func scaleSeries(families map[string]*dto.MetricFamily, name string, labels map[string]string, factor float64, divide bool) error {
+	metric := findSeries(families, name, labels)
+	if metric == nil {
+		return fmt.Errorf("series %s not found", formatSeries(name, labels))
+	}
+	if divide {
+		if factor == 0 {
+			return fmt.Errorf("can't divide %s by zero", formatSeries(name, labels))
+		}
+		factor = 1 / factor
+	}
+
+	switch families[name].GetType() {
+	case dto.MetricType_COUNTER:
+		if factor < 0 {
+			return fmt.Errorf("can't scale counter %s by a negative factor", name)
+		}
+		metric.Counter = &dto.Counter{Value: float64Ptr(metric.GetCounter().GetValue() * factor)}
+	case dto.MetricType_GAUGE:
+		metric.Gauge = &dto.Gauge{Value: float64Ptr(metric.GetGauge().GetValue() * factor)}
+	case dto.MetricType_UNTYPED:
+		metric.Untyped = &dto.Untyped{Value: float64Ptr(metric.GetUntyped().GetValue() * factor)}
+	default:
+		return fmt.Errorf("scale needs a counter or gauge, but %s is a %s", name, typeName(families[name]))
+	}
+	return nil
+}
+
+// incToSourceFamily remembers the last absolute value seen by inc-to for each
+// counter series, so source resets can be told apart from normal growth. Its
+// series carry the counter's labels plus incToMetricLabel naming the counter;
+// that label is reserved, so it can't overwrite one of the series' own.
+const (
+	incToSourceFamily = "omet_inc_to_last_value"
+	incToMetricLabel  = "omet_inc_to_metric"
+)
+
+// incrementCounterTo follows an external monotonic counter given as an
+// absolute value. The counter grows by the source's increase since the last
+// call; when the source goes backwards it was reset, and its new value is
+// counted as growth since the reset. The first call sets the counter to
+// max(current, absolute).
+func incrementCounterTo(families map[string]*dto.MetricFamily, name string, labels map[string]string, absolute float64) error {
+	if absolute < 0 {
+		return fmt.Errorf("inc-to requires a non-negative value, got %g", absolute)
+	}
+	if _, ok := labels[incToMetricLabel]; ok {
+		return fmt.Errorf("inc-to can't update a series with a %s label: it is reserved for %s", incToMetricLabel, incToSourceFamily)
+	}
+
+	family, err := getOrCreateFamily(families, name, dto.MetricType_COUNTER)
+	if err != nil {
+		return err
+	}
+	sourceFamily, err := getOrCreateFamily(families, incToSourceFamily, dto.MetricType_GAUGE)
+	if err != nil {
+		return err
+	}
+	sourceFamily.Help = stringPtr("Last absolute source value seen by inc-to, per counter series")
+
+	sourceLabels := make(map[string]string, len(labels)+1)
+	for key, value := range labels {
+		sourceLabels[key] = value
+	}
+	sourceLabels[incToMetricLabel] = name
+
+	metric := findOrCreateMetric(family, labels)
+	current := metric.GetCounter().GetValue()
+
+	source := findOrCreateMetric(sourceFamily, sourceLabels)
+	switch {
+	case source.Gauge == nil:
+		current = math.Max(current, absolute)
+	case absolute >= source.Gauge.GetValue():
+		current += absolute - source.Gauge.GetValue()
+	default:
+		// Source reset: everything since the reset is new growth
+		current += absolute
+	}
+
+	metric.Counter = &dto.Counter{Value: float64Ptr(current)}
+	source.Gauge = &dto.Gauge{Value: float64Ptr(absolute)}
+
+	return nil
+}
+
+// parseDestinationLabels parses copy's destination, either KEY=VALUE pairs
+// separated by commas or a selector of equality matchers like {env="canary"}.
+func parseDestinationLabels(input string) (map[string]string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(input), "{") {
+		return parseLabels(strings.Split(input, ","))
+	}
+
+	sel, err := selector.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(sel))
+	for _, matcher := range sel {
+		if matcher.Op != "=" {
+			return nil, fmt.Errorf("copy destination %s: only = matchers are allowed", input)
+		}
+		labels[matcher.Name] = matcher.Value
+	}
+	return labels, nil
+}
+
+// copySeries clones the value of the series with exactly the source labels
+// to the series whose labels are the source labels with overrides applied.
+// An existing destination series is overwritten.
+func copySeries(families map[string]*dto.MetricFamily, name string, source, overrides map[string]string) error {
+	family, exists := families[name]
+	if !exists {
+		return fmt.Errorf("metric %s not found", name)
+	}
+
+	var sourceMetric *dto.Metric
+	for _, metric := range family.Metric {
+		if labelsMatch(metric.Label, source) {
+			sourceMetric = metric
+			break
+		}
+	}
+	if sourceMetric == nil {
+		return fmt.Errorf("series %s not found", formatSeries(name, source))
+	}
+
+	destination := make(map[string]string, len(source)+len(overrides))
+	for key, value := range source {
+		destination[key] = value
+	}
+	for key, value := range overrides {
+		destination[key] = value
+	}
+	if labelsMatch(sourceMetric.Label, destination) {
+		return fmt.Errorf("copy destination %s is the source series", formatSeries(name, destination))
+	}
+
+	metric := findOrCreateMetric(family, destination)
+	clone := proto.Clone(sourceMetric).(*dto.Metric)
+	clone.Label = metric.Label
+	proto.Reset(metric)
+	proto.Merge(metric, clone)
+
+	return nil
+}
+
+// setGaugeIf sets a gauge when it doesn't exist yet or when replace reports
+// that the new value should win over the current one (e.g. a new peak).
+func setGaugeIf(families map[string]*dto.MetricFamily, name string, labels map[string]string, value float64, replace func(current float64) bool) error {
+	family, err := getOrCreateFamily(families, name, dto.MetricType_GAUGE)
+	if err != nil {
+		return err
+	}
+
+	metric := findOrCreateMetric(family, labels)
+	if metric.Gauge == nil || replace(metric.Gauge.GetValue()) {
+		metric.Gauge = &dto.Gauge{Value: float64Ptr(value)}
+	}
+
+	return nil
+}
+
+// averageGauge folds a sample into an exponentially weighted moving average:
+// new = current + alpha*(value-current). A new series starts at the sample.
+func averageGauge(families map[string]*dto.MetricFamily, name string, labels map[string]string, value, alpha float64) error {
+	if alpha <= 0 || alpha > 1 {
+		return fmt.Errorf("alpha must be in (0, 1], got %g", alpha)
+	}
+
+	family, err := getOrCreateFamily(families, name, dto.MetricType_GAUGE)
+	if err != nil {
+		return err
+	}
+
+	metric := findOrCreateMetric(family, labels)
+	if metric.Gauge != nil {
+		current := metric.Gauge.GetValue()
+		value = current + alpha*(value-current)
+	}
+	metric.Gauge = &dto.Gauge{Value: float64Ptr(value)}
+
+	return nil
+}
+
+// ensureSeries creates a series with an initial value if it is absent and
+// leaves an existing series untouched. typeName selects the type of a new
+// family; it defaults to the existing family's type, or gauge.
+func ensureSeries(families map[string]*dto.MetricFamily, name, typeName string, labels map[string]string, value float64) error {
+	metricType := dto.MetricType_GAUGE
+	if family, exists := families[name]; exists {
+		metricType = family.GetType()
+	}
+	if typeName != "" {
+		var err error
+		if metricType, err = schema.ParseType(typeName); err != nil {
+			return err
+		}
+	}
+
+	family, err := getOrCreateFamily(families, name, metricType)
+	if err != nil {
+		return err
+	}
+
+	for _, metric := range family.Metric {
+		if labelsMatch(metric.Label, labels) {
+			return nil
+		}
+	}
+
+	metric := findOrCreateMetric(family, labels)
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		if value < 0 {
+			return fmt.Errorf("counter %s can't start at a negative value", name)
+		}
+		metric.Counter = &dto.Counter{Value: float64Ptr(value)}
+	case dto.MetricType_GAUGE:
+		metric.Gauge = &dto.Gauge{Value: float64Ptr(value)}
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		if value != 0 {
+			return fmt.Errorf("histogram %s can only be ensured empty", name)
+		}
+		metric.Histogram = createHistogram(familyBucketLayout(family, defaultHistogramBuckets))
+	default:
+		metric.Untyped = &dto.Untyped{Value: float64Ptr(value)}
+	}
+	return nil
+}
+
 func createMetricFamily(name string, metricType dto.MetricType) *dto.MetricFamily {
-	typeStr := strings.ToLower(metricType.String())
+	// Spelled out, so a gauge histogram reads "Gauge histogram metric ..."
+	typeStr := strings.ToLower(strings.ReplaceAll(metricType.String(), "_", " "))
 	// Capitalize first letter manually for consistency
 	if len(typeStr) > 0 {
 		typeStr = strings.ToUpper(typeStr[:1]) + typeStr[1:]
@@ -476,6 +1440,10 @@ func createMetricFamily(name string, metricType dto.MetricType) *dto.MetricFamil
 }
 
 func validateMetricType(family *dto.MetricFamily, expectedType dto.MetricType, metricName string) error {
+	// Histogram operations apply to gauge histograms too
+	if expectedType == dto.MetricType_HISTOGRAM && family.GetType() == dto.MetricType_GAUGE_HISTOGRAM {
+		return nil
+	}
 	if family.GetType() != expectedType {
 		return fmt.Errorf("metric %s is not a %s (type: %s)",
 			metricName,
@@ -511,9 +1479,9 @@ func observeHistogramWithBuckets(families map[string]*dto.MetricFamily, name str
 
 	metric := findOrCreateMetric(family, labels)
 
-	// Initialize histogram if it doesn't exist
+	// Initialize histogram if it doesn't exist, like the family's others
 	if metric.Histogram == nil {
-		metric.Histogram = createHistogram(buckets)
+		metric.Histogram = createHistogram(familyBucketLayout(family, buckets))
 	}
 
 	// Update sample count and sum
@@ -534,6 +1502,38 @@ func observeHistogramWithBuckets(families map[string]*dto.MetricFamily, name str
 	return nil
 }
 
+// familyBucketLayout returns the finite bucket bounds most series of a
+// histogram family share, so a new series matches the ones already in the
+// file, whichever tool wrote them. A family of +Inf-only histograms has an
+// empty layout. Without any bucketed series, it returns fallback.
+func familyBucketLayout(family *dto.MetricFamily, fallback []float64) []float64 {
+	layouts := make(map[string][]float64)
+	counts := make(map[string]int)
+	best := ""
+	for _, metric := range family.GetMetric() {
+		if len(metric.GetHistogram().GetBucket()) == 0 {
+			continue
+		}
+		bounds := []float64{}
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			if !math.IsInf(bucket.GetUpperBound(), 1) {
+				bounds = append(bounds, bucket.GetUpperBound())
+			}
+		}
+		sort.Float64s(bounds)
+		key := fmt.Sprint(bounds)
+		layouts[key] = bounds
+		counts[key]++
+		if _, seen := layouts[best]; !seen || counts[key] > counts[best] {
+			best = key
+		}
+	}
+	if layout, ok := layouts[best]; ok {
+		return layout
+	}
+	return fallback
+}
+
 func createHistogram(buckets []float64) *dto.Histogram {
 	var histogramBuckets []*dto.Bucket
 
@@ -607,68 +1607,7 @@ func createLabelPairs(labels map[string]string) []*dto.LabelPair {
 
 // writeMetrics serializes metric families to text format (pure function)
 func writeMetrics(families map[string]*dto.MetricFamily, output io.Writer) error {
-	// Convert back to text format
-	for _, family := range families {
-		// Write HELP line
-		if family.Help != nil {
-			fmt.Fprintf(output, "# HELP %s %s\n", family.GetName(), family.GetHelp())
-		}
-
-		// Write TYPE line
-		if family.Type != nil {
-			fmt.Fprintf(output, "# TYPE %s %s\n", family.GetName(), strings.ToLower(family.GetType().String()))
-		}
-
-		// Write metrics
-		for _, metric := range family.Metric {
-			name := family.GetName()
-
-			// Build label string
-			var labelParts []string
-			for _, label := range metric.Label {
-				labelParts = append(labelParts, fmt.Sprintf("%s=\"%s\"", label.GetName(), label.GetValue()))
-			}
-
-			var labelStr string
-			if len(labelParts) > 0 {
-				labelStr = "{" + strings.Join(labelParts, ",") + "}"
-			}
-
-			// Write value based on type
-			switch family.GetType() {
-			case dto.MetricType_COUNTER:
-				value := metric.GetCounter().GetValue()
-				fmt.Fprintf(output, "%s%s %g\n", name, labelStr, value)
-			case dto.MetricType_GAUGE:
-				value := metric.GetGauge().GetValue()
-				fmt.Fprintf(output, "%s%s %g\n", name, labelStr, value)
-			case dto.MetricType_HISTOGRAM:
-				histogram := metric.GetHistogram()
-
-				// Write histogram buckets
-				for _, bucket := range histogram.GetBucket() {
-					bucketLabelStr := labelStr
-					if len(labelParts) > 0 {
-						bucketLabelStr = fmt.Sprintf("{%s,le=\"%g\"}", strings.Join(labelParts, ","), bucket.GetUpperBound())
-					} else {
-						bucketLabelStr = fmt.Sprintf("{le=\"%g\"}", bucket.GetUpperBound())
-					}
-					fmt.Fprintf(output, "%s_bucket%s %d\n", name, bucketLabelStr, bucket.GetCumulativeCount())
-				}
-
-				// Write count and sum
-				fmt.Fprintf(output, "%s_count%s %d\n", name, labelStr, histogram.GetSampleCount())
-				fmt.Fprintf(output, "%s_sum%s %g\n", name, labelStr, histogram.GetSampleSum())
-			default:
-				if metric.Untyped != nil {
-					value := metric.GetUntyped().GetValue()
-					fmt.Fprintf(output, "%s%s %g\n", name, labelStr, value)
-				}
-			}
-		}
-	}
-
-	return nil
+	return metricsfile.Write(families, output)
 }
 
 // writeMetricsWithSelfMonitoring adds self-monitoring metrics and writes output
@@ -681,6 +1620,14 @@ func stringPtr(s string) *string {
 	return &s
 }
 
+// valuePtr returns a pointer to value, or nil if it doesn't exist.
+func valuePtr(value float64, exists bool) *float64 {
+	if !exists {
+		return nil
+	}
+	return &value
+}
+
 func float64Ptr(f float64) *float64 {
 	return &f
 }
@@ -730,8 +1677,13 @@ func addErrorMetrics(families map[string]*dto.MetricFamily, errorCollector *Erro
 		return // Can't add error metrics if we can't create the family
 	}
 
-	// Set custom help text (override the generic one)
-	errorsFamily.Help = stringPtr("Total number of OMET errors by type")
+	// Set custom help text (override the generic one), pointing at the
+	// run that last recorded errors so they can be found in the logs
+	if errorCollector.traceID != "" {
+		errorsFamily.Help = stringPtr(fmt.Sprintf("Total number of OMET errors by type (last error trace: %s)", errorCollector.traceID))
+	} else {
+		errorsFamily.Help = stringPtr("Total number of OMET errors by type")
+	}
 
 	// Count errors by type
 	errorCounts := make(map[string]int)
@@ -823,3 +1775,40 @@ func addOperationalMetrics(families map[string]*dto.MetricFamily, operation stri
 		}
 	}
 }
+
+// addLockQueueMetrics records how many writers were queued ahead of this run
+// when it asked for the lock with --fair-lock.
+func addLockQueueMetrics(families map[string]*dto.MetricFamily, queue *metricsfile.QueueStats) {
+	if queue == nil {
+		return
+	}
+	family, err := getOrCreateFamily(families, "omet_lock_queue_depth", dto.MetricType_GAUGE)
+	if err != nil {
+		return
+	}
+	family.Help = stringPtr("Writers queued ahead of the last --fair-lock run when it requested the lock")
+	metric := findOrCreateMetric(family, map[string]string{})
+	metric.Gauge = &dto.Gauge{Value: float64Ptr(float64(queue.Ahead))}
+}
+
+// addLockTimeoutMetrics counts lock timeouts by the command holding the lock,
+// so contention can be traced to the process causing it.
+func addLockTimeoutMetrics(families map[string]*dto.MetricFamily, timeout *metricsfile.TimeoutError) {
+	if timeout == nil {
+		return
+	}
+	family, err := getOrCreateFamily(families, "omet_lock_timeout_total", dto.MetricType_COUNTER)
+	if err != nil {
+		return
+	}
+	family.Help = stringPtr("Lock acquisitions that timed out, by the command holding the lock (unknown where it can't be found)")
+	comm := "unknown"
+	if timeout.Holder != nil && timeout.Holder.Comm != "" {
+		comm = timeout.Holder.Comm
+	}
+	metric := findOrCreateMetric(family, map[string]string{"holder_comm": comm})
+	if metric.Counter == nil {
+		metric.Counter = &dto.Counter{Value: float64Ptr(0)}
+	}
+	metric.Counter.Value = float64Ptr(metric.Counter.GetValue() + 1)
+}
@@ -3,9 +3,15 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"omet/internal/metricsfile"
+
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -1328,3 +1334,793 @@ func TestSelfMonitoringMetrics(t *testing.T) {
 		assert.Contains(t, output, "omet_last_write", "should include last write timestamp")
 	})
 }
+
+func TestQuietAndPorcelainOutput(t *testing.T) {
+	testContent := `# HELP requests_total Total requests
+# TYPE requests_total counter
+requests_total{env="prod"} 5
+`
+
+	t.Run("quiet suppresses metrics on stdout", func(t *testing.T) {
+		testFile := createTempFile(t, testContent)
+		output := captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "-q", "-f", testFile, "-l", "env=prod", "requests_total", "inc", "2"})
+			assert.NoError(t, err)
+		})
+		assert.Empty(t, output)
+	})
+
+	t.Run("porcelain prints old and new values", func(t *testing.T) {
+		testFile := createTempFile(t, testContent)
+		output := captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "--porcelain", "-f", testFile, "-l", "env=prod", "requests_total", "inc", "2"})
+			assert.NoError(t, err)
+		})
+		assert.Equal(t, "ok\tinc\trequests_total{env=\"prod\"}\t5\t7\n", output)
+	})
+
+	t.Run("porcelain marks created series and errors", func(t *testing.T) {
+		testFile := createTempFile(t, testContent)
+		output := captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "--porcelain", "-f", testFile, "queue_depth", "set", "3"})
+			assert.NoError(t, err)
+		})
+		assert.Equal(t, "ok\tset\tqueue_depth\t-\t3\n", output)
+
+		output = captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "--porcelain", "-f", testFile, "-l", "env=prod", "requests_total", "set", "3"})
+			assert.Error(t, err)
+		})
+		assert.Equal(t, "error\tset\trequests_total{env=\"prod\"}\t5\t5\n", output)
+	})
+}
+
+func TestFormatSeries(t *testing.T) {
+	assert.Equal(t, "up", formatSeries("up", nil))
+	assert.Equal(t, `up{a="1",b="x\"y"}`, formatSeries("up", map[string]string{"b": `x"y`, "a": "1"}))
+}
+
+func TestTraceID(t *testing.T) {
+	id := newTraceID()
+	assert.Len(t, id, 8)
+	assert.NotEqual(t, id, newTraceID())
+
+	t.Run("error metrics reference the trace ID", func(t *testing.T) {
+		families := make(map[string]*dto.MetricFamily)
+		collector := &ErrorCollector{traceID: "deadbeef"}
+		collector.AddError(fmt.Errorf("boom"), "io_error")
+
+		addErrorMetrics(families, collector)
+
+		assert.Equal(t, "Total number of OMET errors by type (last error trace: deadbeef)", families["omet_errors_total"].GetHelp())
+	})
+
+	t.Run("verbose logs go to stderr with the trace ID", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE up gauge\nup 1\n")
+
+		oldStderr := os.Stderr
+		r, w, err := os.Pipe()
+		require.NoError(t, err)
+		os.Stderr = w
+
+		output := captureOutput(t, func() {
+			assert.NoError(t, createTestApp().Run([]string{"omet", "-v", "-f", testFile, "up", "set", "0"}))
+		})
+
+		w.Close()
+		os.Stderr = oldStderr
+		log.SetOutput(os.Stderr)
+		var stderr bytes.Buffer
+		stderr.ReadFrom(r)
+
+		assert.NotContains(t, output, "Metric: up")
+		assert.Regexp(t, `omet\[[0-9a-f]{8}\] .*Metric: up`, stderr.String())
+	})
+}
+
+func TestMaxInputBytes(t *testing.T) {
+	testContent := "# TYPE up gauge\nup 1\n"
+
+	t.Run("oversized input is refused with an io_error metric", func(t *testing.T) {
+		testFile := createTempFile(t, testContent)
+		output := captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "--max-input-bytes", "10", "-f", testFile, "queue_depth", "set", "1"})
+			assert.ErrorContains(t, err, "exceeding --max-input-bytes=10")
+		})
+		assert.Contains(t, output, `omet_errors_total{type="io_error"} 1`)
+		assert.NotContains(t, output, "up 1", "oversized input should not be parsed")
+	})
+
+	t.Run("oversized stdin is refused", func(t *testing.T) {
+		cleanup := mockStdin(t, testContent)
+		defer cleanup()
+
+		output := captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "--max-input-bytes", "10", "queue_depth", "set", "1"})
+			assert.ErrorContains(t, err, "input too large")
+		})
+		assert.Contains(t, output, `omet_errors_total{type="io_error"} 1`)
+	})
+
+	t.Run("in-place mode leaves an oversized file untouched", func(t *testing.T) {
+		testFile := createTempFile(t, testContent)
+		err := createTestApp().Run([]string{"omet", "-i", "--max-input-bytes", "10", "-f", testFile, "queue_depth", "set", "1"})
+		assert.Error(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, testContent, string(content))
+	})
+
+	t.Run("input within the limit is read", func(t *testing.T) {
+		testFile := createTempFile(t, testContent)
+		output := captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "--max-input-bytes", "1000", "-f", testFile, "queue_depth", "set", "1"})
+			assert.NoError(t, err)
+		})
+		assert.Contains(t, output, "up 1")
+	})
+}
+
+func TestFairLock(t *testing.T) {
+	testFile := createTempFile(t, "# TYPE up gauge\nup 1\n")
+
+	err := createTestApp().Run([]string{"omet", "--fair-lock", "-i", "-f", testFile, "up", "set", "0"})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "omet_lock_queue_depth 0")
+
+	queue, err := os.ReadFile(testFile + ".lockq")
+	require.NoError(t, err)
+	assert.Empty(t, queue, "the writer leaves the queue once it holds the lock")
+}
+
+func TestAddLockTimeoutMetrics(t *testing.T) {
+	families := make(map[string]*dto.MetricFamily)
+	addLockTimeoutMetrics(families, nil)
+	assert.Empty(t, families)
+
+	addLockTimeoutMetrics(families, &metricsfile.TimeoutError{Timeout: time.Second, Holder: &metricsfile.Holder{PID: 42, Comm: "backup.sh"}})
+	addLockTimeoutMetrics(families, &metricsfile.TimeoutError{Timeout: time.Second, Holder: &metricsfile.Holder{PID: 43, Comm: "backup.sh"}})
+	addLockTimeoutMetrics(families, &metricsfile.TimeoutError{Timeout: time.Second})
+
+	family := families["omet_lock_timeout_total"]
+	require.NotNil(t, family)
+	assert.Equal(t, 2.0, findSeries(families, family.GetName(), map[string]string{"holder_comm": "backup.sh"}).GetCounter().GetValue())
+	assert.Equal(t, 1.0, findSeries(families, family.GetName(), map[string]string{"holder_comm": "unknown"}).GetCounter().GetValue())
+}
+
+func TestCRLFOutput(t *testing.T) {
+	testFile := createTempFile(t, "\xEF\xBB\xBF# TYPE up gauge\r\nup 1\r\n")
+
+	output := captureOutput(t, func() {
+		err := createTestApp().Run([]string{"omet", "--crlf", "-f", testFile, "up", "set", "0"})
+		assert.NoError(t, err)
+	})
+
+	assert.Contains(t, output, "up 0\r\n")
+	assert.NotContains(t, strings.ReplaceAll(output, "\r\n", ""), "\n", "every line should end in CRLF")
+}
+
+func TestMultipleFileTargets(t *testing.T) {
+	t.Run("in-place fan-out updates every file", func(t *testing.T) {
+		jobFile := createTempFile(t, "# TYPE backups_total counter\nbackups_total{job=\"backup\"} 3\n")
+		aggregateFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", jobFile, "-f", aggregateFile, "-l", "job=backup", "backups_total", "inc"})
+		require.NoError(t, err)
+
+		jobContent, err := os.ReadFile(jobFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(jobContent), `backups_total{job="backup"} 4`)
+
+		aggregateContent, err := os.ReadFile(aggregateFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(aggregateContent), `backups_total{job="backup"} 1`)
+		assert.Contains(t, string(aggregateContent), "omet_last_write")
+	})
+
+	t.Run("an error in one file does not stop the others", func(t *testing.T) {
+		gaugeFile := createTempFile(t, "# TYPE backups_total gauge\nbackups_total 3\n")
+		counterFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", gaugeFile, "-f", counterFile, "backups_total", "inc"})
+		assert.Error(t, err)
+
+		counterContent, readErr := os.ReadFile(counterFile)
+		require.NoError(t, readErr)
+		assert.Contains(t, string(counterContent), "backups_total 1")
+
+		gaugeContent, readErr := os.ReadFile(gaugeFile)
+		require.NoError(t, readErr)
+		assert.Contains(t, string(gaugeContent), `omet_errors_total{type="operation_error"} 1`)
+		assert.NotContains(t, string(counterContent), "omet_errors_total", "errors are recorded per file")
+	})
+
+	t.Run("multiple files require in-place mode", func(t *testing.T) {
+		first := createTempFile(t, "")
+		second := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-f", first, "-f", second, "up", "set", "1"})
+		assert.ErrorContains(t, err, "require --in-place")
+
+		err = createTestApp().Run([]string{"omet", "-i", "-f", first, "-f", "-", "up", "set", "1"})
+		assert.ErrorContains(t, err, "can't be combined")
+	})
+
+	t.Run("in-place without locking writes to stdout", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE up gauge\nup 1\n")
+
+		output := captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "-i", "--no-lock", "-f", testFile, "up", "set", "0"})
+			require.NoError(t, err)
+		})
+		assert.Contains(t, output, "up 0")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, "# TYPE up gauge\nup 1\n", string(content), "the file is left alone")
+	})
+}
+
+func TestOpenTargetsLockOrder(t *testing.T) {
+	dir := t.TempDir()
+	b := dir + "/b.prom"
+	a := dir + "/a.prom"
+
+	targets := openTargets([]string{b, a, b}, true, false, time.Second, &ErrorCollector{}, false)
+	defer closeTargets(targets)
+
+	require.Len(t, targets, 2, "duplicate targets are collapsed")
+	assert.Equal(t, b, targets[0].filename, "targets are processed in command-line order")
+	for _, target := range targets {
+		assert.True(t, target.writable())
+		assert.True(t, target.lock.Locked())
+	}
+}
+
+func TestValueFrom(t *testing.T) {
+	t.Run("reads and scales a sysfs-style value", func(t *testing.T) {
+		valueFile := createTempFile(t, "48250\n")
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--value-from", valueFile, "--scale", "0.001", "cpu_temp_celsius", "set"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "cpu_temp_celsius 48.25")
+	})
+
+	t.Run("scale applies to explicit values", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--scale", "0.5", "queue_depth", "set", "10"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "queue_depth 5")
+	})
+
+	t.Run("missing file is an io error", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--value-from", "/nonexistent/value", "queue_depth", "set"})
+		assert.ErrorContains(t, err, "failed to read value from /nonexistent/value")
+
+		content, readErr := os.ReadFile(testFile)
+		require.NoError(t, readErr)
+		assert.Contains(t, string(content), `omet_errors_total{type="io_error"} 1`)
+	})
+
+	t.Run("conflicts with a value argument", func(t *testing.T) {
+		valueFile := createTempFile(t, "1\n")
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--value-from", valueFile, "queue_depth", "set", "2"})
+		assert.ErrorContains(t, err, "can't be combined")
+	})
+}
+
+func TestObserveMultipleValues(t *testing.T) {
+	t.Run("each value is observed once", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "request_seconds", "observe", "0.12", "0.34", "0.56"})
+		require.NoError(t, err)
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		histogram := families["request_seconds"].Metric[0].GetHistogram()
+		assert.Equal(t, uint64(3), histogram.GetSampleCount())
+		assert.InDelta(t, 1.02, histogram.GetSampleSum(), 1e-9)
+	})
+
+	t.Run("other operations reject multiple values", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "queue_depth", "set", "1", "2"})
+		assert.ErrorContains(t, err, "only observe accepts multiple values")
+	})
+}
+
+func mustOpen(t *testing.T, filename string) *os.File {
+	file, err := os.Open(filename)
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestEnsureSeries(t *testing.T) {
+	t.Run("creates absent series at zero", func(t *testing.T) {
+		families := make(map[string]*dto.MetricFamily)
+		require.NoError(t, ensureSeries(families, "backups_total", "counter", map[string]string{"job": "restore"}, 0))
+
+		family := families["backups_total"]
+		require.NotNil(t, family)
+		assert.Equal(t, dto.MetricType_COUNTER, family.GetType())
+		assert.Equal(t, 0.0, family.Metric[0].GetCounter().GetValue())
+	})
+
+	t.Run("leaves existing series untouched", func(t *testing.T) {
+		families := make(map[string]*dto.MetricFamily)
+		require.NoError(t, setGauge(families, "queue_depth", nil, 42))
+		require.NoError(t, ensureSeries(families, "queue_depth", "", nil, 0))
+
+		assert.Len(t, families["queue_depth"].Metric, 1)
+		assert.Equal(t, 42.0, families["queue_depth"].Metric[0].GetGauge().GetValue())
+	})
+
+	t.Run("uses the existing family type", func(t *testing.T) {
+		families := make(map[string]*dto.MetricFamily)
+		require.NoError(t, incrementCounter(families, "backups_total", map[string]string{"job": "backup"}, 3))
+		require.NoError(t, ensureSeries(families, "backups_total", "", map[string]string{"job": "restore"}, 0))
+
+		assert.Len(t, families["backups_total"].Metric, 2)
+		assert.Error(t, ensureSeries(families, "backups_total", "gauge", nil, 0), "type mismatch is rejected")
+	})
+
+	t.Run("histograms start empty", func(t *testing.T) {
+		families := make(map[string]*dto.MetricFamily)
+		require.NoError(t, ensureSeries(families, "latency_seconds", "histogram", nil, 0))
+		assert.Equal(t, uint64(0), families["latency_seconds"].Metric[0].GetHistogram().GetSampleCount())
+
+		assert.Error(t, ensureSeries(families, "other_seconds", "histogram", nil, 1))
+	})
+
+	t.Run("cli defaults to zero without reading stdin", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "counter", "-l", "job=restore", "backups_total", "ensure"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "# TYPE backups_total counter")
+		assert.Contains(t, string(content), `backups_total{job="restore"} 0`)
+	})
+}
+
+func TestSetMaxMin(t *testing.T) {
+	families := make(map[string]*dto.MetricFamily)
+
+	require.NoError(t, applyOperation(families, "memory_peak_bytes", "set-max", nil, 100))
+	require.NoError(t, applyOperation(families, "memory_peak_bytes", "set-max", nil, 50))
+	require.NoError(t, applyOperation(families, "memory_peak_bytes", "set-max", nil, 150))
+	assert.Equal(t, 150.0, families["memory_peak_bytes"].Metric[0].GetGauge().GetValue())
+
+	require.NoError(t, applyOperation(families, "disk_free_min_bytes", "set-min", nil, 100))
+	require.NoError(t, applyOperation(families, "disk_free_min_bytes", "set-min", nil, 150))
+	require.NoError(t, applyOperation(families, "disk_free_min_bytes", "set-min", nil, 20))
+	assert.Equal(t, 20.0, families["disk_free_min_bytes"].Metric[0].GetGauge().GetValue())
+
+	require.NoError(t, incrementCounter(families, "requests_total", nil, 1))
+	assert.Error(t, applyOperation(families, "requests_total", "set-max", nil, 5), "only gauges can be clamped")
+}
+
+func TestSetIfGreaterLess(t *testing.T) {
+	families := make(map[string]*dto.MetricFamily)
+	value := func(name string) float64 { return families[name].Metric[0].GetGauge().GetValue() }
+
+	require.NoError(t, applyOperation(families, "queue_high_water", "set-if-greater", nil, 100))
+	require.NoError(t, applyOperation(families, "queue_high_water", "set-if-greater", nil, 120))
+	assert.Equal(t, 120.0, value("queue_high_water"), "greater values overwrite")
+	require.NoError(t, applyOperation(families, "queue_high_water", "set-if-greater", nil, 80))
+	assert.Equal(t, 120.0, value("queue_high_water"), "lesser values are ignored")
+
+	require.NoError(t, applyOperation(families, "queue_low_water", "set-if-less", nil, 10))
+	require.NoError(t, applyOperation(families, "queue_low_water", "set-if-less", nil, 3))
+	assert.Equal(t, 3.0, value("queue_low_water"), "lesser values overwrite")
+	require.NoError(t, applyOperation(families, "queue_low_water", "set-if-less", nil, 7))
+	assert.Equal(t, 3.0, value("queue_low_water"), "greater values are ignored")
+
+	require.NoError(t, incrementCounter(families, "requests_total", nil, 1))
+	assert.Error(t, applyOperation(families, "requests_total", "set-if-greater", nil, 5))
+}
+
+func TestAddSub(t *testing.T) {
+	families := make(map[string]*dto.MetricFamily)
+
+	require.NoError(t, applyOperation(families, "free_slots", "add", nil, 10))
+	require.NoError(t, applyOperation(families, "free_slots", "add", nil, -3))
+	require.NoError(t, applyOperation(families, "free_slots", "sub", nil, 2))
+	assert.Equal(t, 5.0, families["free_slots"].Metric[0].GetGauge().GetValue())
+
+	require.NoError(t, incrementCounter(families, "requests_total", nil, 1))
+	assert.Error(t, applyOperation(families, "requests_total", "add", nil, 5), "only gauges can be adjusted")
+
+	// The delta is applied to the value under the lock, in place
+	testFile := createTempFile(t, "# TYPE free_slots gauge\nfree_slots 8\n")
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "free_slots", "add", "-3"}))
+	content, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "free_slots 5")
+}
+
+func TestAverageGauge(t *testing.T) {
+	families := make(map[string]*dto.MetricFamily)
+
+	require.NoError(t, averageGauge(families, "load_smoothed", nil, 10, 0.5))
+	assert.Equal(t, 10.0, families["load_smoothed"].Metric[0].GetGauge().GetValue(), "first sample seeds the average")
+
+	require.NoError(t, averageGauge(families, "load_smoothed", nil, 20, 0.5))
+	assert.Equal(t, 15.0, families["load_smoothed"].Metric[0].GetGauge().GetValue())
+
+	require.NoError(t, averageGauge(families, "load_smoothed", nil, 5, 1))
+	assert.Equal(t, 5.0, families["load_smoothed"].Metric[0].GetGauge().GetValue(), "alpha 1 follows the sample")
+
+	assert.Error(t, averageGauge(families, "load_smoothed", nil, 5, 0))
+	assert.Error(t, averageGauge(families, "load_smoothed", nil, 5, 1.5))
+
+	t.Run("cli uses --alpha", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE load_smoothed gauge\nload_smoothed 1\n")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--alpha", "0.25", "load_smoothed", "avg", "5"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "load_smoothed 2\n")
+	})
+
+	t.Run("batch and --match updates use --alpha", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE load_smoothed gauge\nload_smoothed{cpu=\"0\"} 1\nload_smoothed{cpu=\"1\"} 1\n")
+		defer mockStdin(t, "load_smoothed{cpu=\"0\"} avg 5\n")()
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--alpha", "0.5"})
+		require.NoError(t, err)
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--alpha", "0.5", "--match", `cpu="1"`, "load_smoothed", "avg", "9"})
+		require.NoError(t, err)
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, _ := seriesValue(families, "load_smoothed", map[string]string{"cpu": "0"})
+		assert.Equal(t, 3.0, value)
+		value, _ = seriesValue(families, "load_smoothed", map[string]string{"cpu": "1"})
+		assert.Equal(t, 5.0, value)
+	})
+}
+
+func TestIncrementCounterTo(t *testing.T) {
+	families := make(map[string]*dto.MetricFamily)
+	labels := map[string]string{"api": "billing"}
+	value := func() float64 {
+		v, _ := seriesValue(families, "api_requests_total", labels)
+		return v
+	}
+
+	require.NoError(t, incrementCounterTo(families, "api_requests_total", labels, 100))
+	assert.Equal(t, 100.0, value(), "first call adopts the absolute value")
+
+	require.NoError(t, incrementCounterTo(families, "api_requests_total", labels, 150))
+	assert.Equal(t, 150.0, value())
+
+	require.NoError(t, incrementCounterTo(families, "api_requests_total", labels, 150))
+	assert.Equal(t, 150.0, value(), "unchanged source adds nothing")
+
+	require.NoError(t, incrementCounterTo(families, "api_requests_total", labels, 20))
+	assert.Equal(t, 170.0, value(), "a source reset counts its new value as growth")
+
+	require.NoError(t, incrementCounterTo(families, "api_requests_total", labels, 30))
+	assert.Equal(t, 180.0, value())
+
+	source, ok := seriesValue(families, incToSourceFamily, map[string]string{"api": "billing", incToMetricLabel: "api_requests_total"})
+	assert.True(t, ok)
+	assert.Equal(t, 30.0, source)
+
+	t.Run("a metric label doesn't clash with the source series", func(t *testing.T) {
+		families := make(map[string]*dto.MetricFamily)
+		cpu := map[string]string{"metric": "cpu"}
+		mem := map[string]string{"metric": "mem"}
+		require.NoError(t, incrementCounterTo(families, "samples_total", cpu, 100))
+		require.NoError(t, incrementCounterTo(families, "samples_total", mem, 10))
+		require.NoError(t, incrementCounterTo(families, "samples_total", cpu, 110))
+		require.NoError(t, incrementCounterTo(families, "samples_total", mem, 15))
+
+		v, _ := seriesValue(families, "samples_total", cpu)
+		assert.Equal(t, 110.0, v)
+		v, _ = seriesValue(families, "samples_total", mem)
+		assert.Equal(t, 15.0, v)
+	})
+
+	t.Run("the reserved label is rejected", func(t *testing.T) {
+		err := incrementCounterTo(make(map[string]*dto.MetricFamily), "jobs_total", map[string]string{incToMetricLabel: "x"}, 1)
+		assert.ErrorContains(t, err, "reserved")
+	})
+
+	t.Run("existing counter ahead of the source is kept", func(t *testing.T) {
+		families := make(map[string]*dto.MetricFamily)
+		require.NoError(t, incrementCounter(families, "jobs_total", nil, 500))
+		require.NoError(t, incrementCounterTo(families, "jobs_total", nil, 100))
+
+		v, _ := seriesValue(families, "jobs_total", nil)
+		assert.Equal(t, 500.0, v)
+	})
+
+	assert.Error(t, incrementCounterTo(families, "api_requests_total", labels, -1))
+}
+
+func TestPrintResult(t *testing.T) {
+	testFile := createTempFile(t, "# TYPE requests_total counter\nrequests_total 999999\n")
+
+	output := captureOutput(t, func() {
+		err := createTestApp().Run([]string{"omet", "-i", "--print-result", "-f", testFile, "requests_total", "inc"})
+		assert.NoError(t, err)
+	})
+	assert.Equal(t, "1000000\n", output, "plain integer output for shell comparisons")
+
+	output = captureOutput(t, func() {
+		err := createTestApp().Run([]string{"omet", "--print-result", "-f", testFile, "queue_depth", "set", "0.5"})
+		assert.NoError(t, err)
+	})
+	assert.Equal(t, "0.5\n", output, "metrics are not written to stdout")
+
+	output = captureOutput(t, func() {
+		err := createTestApp().Run([]string{"omet", "--print-result", "-f", testFile, "requests_total", "set", "1"})
+		assert.Error(t, err)
+	})
+	assert.Empty(t, output, "nothing is printed when the operation fails")
+}
+
+func TestCopySeries(t *testing.T) {
+	testContent := `# TYPE http_requests_total counter
+http_requests_total{env="staging",code="200"} 42
+# TYPE latency_seconds histogram
+latency_seconds_bucket{env="staging",le="1"} 2
+latency_seconds_bucket{env="staging",le="+Inf"} 3
+latency_seconds_sum{env="staging"} 4.5
+latency_seconds_count{env="staging"} 3
+`
+
+	t.Run("clones a counter to new labels", func(t *testing.T) {
+		testFile := createTempFile(t, testContent)
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "env=staging", "-l", "code=200", "http_requests_total", "copy", "env=canary"})
+		require.NoError(t, err)
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, ok := seriesValue(families, "http_requests_total", map[string]string{"env": "canary", "code": "200"})
+		assert.True(t, ok)
+		assert.Equal(t, 42.0, value)
+		value, _ = seriesValue(families, "http_requests_total", map[string]string{"env": "staging", "code": "200"})
+		assert.Equal(t, 42.0, value, "source is unchanged")
+	})
+
+	t.Run("clones histograms with a selector destination", func(t *testing.T) {
+		families, err := parseMetrics(strings.NewReader(testContent))
+		require.NoError(t, err)
+
+		destination, err := parseDestinationLabels(`{env="canary"}`)
+		require.NoError(t, err)
+		require.NoError(t, copySeries(families, "latency_seconds", map[string]string{"env": "staging"}, destination))
+
+		require.Len(t, families["latency_seconds"].Metric, 2)
+		copied := families["latency_seconds"].Metric[1]
+		assert.Equal(t, "canary", copied.Label[0].GetValue())
+		assert.Equal(t, uint64(3), copied.GetHistogram().GetSampleCount())
+
+		// The copy is independent of the source
+		copied.Histogram.SampleCount = uint64Ptr(10)
+		assert.Equal(t, uint64(3), families["latency_seconds"].Metric[0].GetHistogram().GetSampleCount())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		families, err := parseMetrics(strings.NewReader(testContent))
+		require.NoError(t, err)
+
+		assert.ErrorContains(t, copySeries(families, "missing_total", nil, map[string]string{"env": "x"}), "not found")
+		assert.ErrorContains(t, copySeries(families, "latency_seconds", map[string]string{"env": "prod"}, map[string]string{"env": "x"}), "not found")
+		assert.ErrorContains(t, copySeries(families, "latency_seconds", map[string]string{"env": "staging"}, map[string]string{"env": "staging"}), "is the source")
+
+		_, err = parseDestinationLabels(`{env!="canary"}`)
+		assert.Error(t, err)
+		_, err = parseDestinationLabels(`canary`)
+		assert.Error(t, err)
+
+		testFile := createTempFile(t, testContent)
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "http_requests_total", "copy"})
+		assert.ErrorContains(t, err, "copy requires destination labels")
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--to-label", "env=canary", "http_requests_total", "copy", "env=canary"})
+		assert.ErrorContains(t, err, "not both")
+	})
+
+	t.Run("takes the destination from --to-label", func(t *testing.T) {
+		testFile := createTempFile(t, testContent)
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "env=staging", "-l", "code=200", "--to-label", "env=canary", "--to-label", "code=201", "http_requests_total", "copy"})
+		require.NoError(t, err)
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, ok := seriesValue(families, "http_requests_total", map[string]string{"env": "canary", "code": "201"})
+		assert.True(t, ok)
+		assert.Equal(t, 42.0, value)
+	})
+}
+
+func TestNamespace(t *testing.T) {
+	testFile := createTempFile(t, "")
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--namespace", "myteam_", "-l", "job=backup", "backups_total", "inc", "2"})
+	require.NoError(t, err)
+	err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--namespace", "myteam_", "-l", "job=backup", "myteam_backups_total", "inc"})
+	require.NoError(t, err, "names already carrying the prefix are not prefixed twice")
+
+	families, err := parseMetrics(mustOpen(t, testFile))
+	require.NoError(t, err)
+	assert.NotContains(t, families, "backups_total")
+	value, _ := seriesValue(families, "myteam_backups_total", map[string]string{"job": "backup"})
+	assert.Equal(t, 3.0, value)
+
+	err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--namespace", "my-team_", "-l", "job=backup", "backups_total", "inc", "2"})
+	assert.ErrorContains(t, err, "invalid metric name: my-team_backups_total")
+
+	content, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "my-team_", "invalid names are never written")
+	assert.Contains(t, string(content), `omet_errors_total{type="invalid_name"} 1`)
+}
+
+func TestNowFlag(t *testing.T) {
+	testFile := createTempFile(t, "")
+
+	require.NoError(t, createTestApp().Run([]string{"omet", "--now", "1700000000", "-i", "-f", testFile, "jobs_total", "inc"}))
+	families, err := parseMetrics(mustOpen(t, testFile))
+	require.NoError(t, err)
+	value, _ := seriesValue(families, "omet_last_write", nil)
+	assert.Equal(t, 1700000000.0, value)
+	assert.Equal(t, RealTimeProvider{}, timeProvider, "the real clock is restored after the run")
+
+	t.Setenv("OMET_FAKE_NOW", "2024-03-09T14:00:00Z")
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "jobs_total", "inc"}))
+	families, err = parseMetrics(mustOpen(t, testFile))
+	require.NoError(t, err)
+	value, _ = seriesValue(families, "omet_last_write", nil)
+	assert.Equal(t, float64(time.Date(2024, 3, 9, 14, 0, 0, 0, time.UTC).Unix()), value)
+
+	now, err := parseNow("1700000000.5")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1700000000500), now.UnixMilli())
+
+	err = createTestApp().Run([]string{"omet", "--now", "yesterday", "-i", "-f", testFile, "jobs_total", "inc"})
+	assert.ErrorContains(t, err, "invalid --now")
+}
+
+func TestSyncFlag(t *testing.T) {
+	testFile := createTempFile(t, "")
+
+	for _, mode := range []string{"none", "data", "full"} {
+		require.NoError(t, createTestApp().Run([]string{"omet", "--sync", mode, "-i", "-f", testFile, "jobs_total", "inc"}), mode)
+	}
+	content, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "jobs_total 3")
+
+	err = createTestApp().Run([]string{"omet", "--sync", "always", "-i", "-f", testFile, "jobs_total", "inc"})
+	assert.ErrorContains(t, err, `invalid sync mode "always"`)
+}
+
+func TestInputFormatDetection(t *testing.T) {
+	testFile := createTempFile(t, `[{"name": "jobs_total", "type": "COUNTER", "metrics": [{"labels": {"job": "a"}, "value": "4"}]}]`)
+
+	output := captureOutput(t, func() {
+		require.NoError(t, createTestApp().Run([]string{"omet", "-f", testFile, "-l", "job=a", "jobs_total", "inc"}))
+	})
+	assert.Contains(t, output, "# TYPE jobs_total counter")
+	assert.Contains(t, output, `jobs_total{job="a"} 5`)
+}
+
+func TestScaleSeries(t *testing.T) {
+	families := make(map[string]*dto.MetricFamily)
+	require.NoError(t, setGauge(families, "backup_size", nil, 5e6))
+	require.NoError(t, incrementCounter(families, "transferred_total", map[string]string{"dir": "in"}, 2048))
+
+	require.NoError(t, scaleSeries(families, "backup_size", nil, 1e6, true))
+	assert.Equal(t, 5.0, families["backup_size"].Metric[0].GetGauge().GetValue())
+	require.NoError(t, scaleSeries(families, "transferred_total", map[string]string{"dir": "in"}, 0.5, false))
+	assert.Equal(t, 1024.0, families["transferred_total"].Metric[0].GetCounter().GetValue())
+
+	assert.ErrorContains(t, scaleSeries(families, "backup_size", nil, 0, true), "divide")
+	assert.ErrorContains(t, scaleSeries(families, "transferred_total", map[string]string{"dir": "in"}, -1, false), "negative")
+	assert.ErrorContains(t, scaleSeries(families, "transferred_total", nil, 2, false), "not found")
+	require.NoError(t, observeHistogram(families, "latency_seconds", nil, 0.1))
+	assert.ErrorContains(t, scaleSeries(families, "latency_seconds", nil, 2, false), "counter or gauge")
+
+	// --divide applies to the scale operation in place
+	testFile := createTempFile(t, "# TYPE backup_size gauge\nbackup_size{job=\"db\"} 2.5e+09\n")
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--divide", "-l", "job=db", "backup_size", "scale", "1e6"}))
+	families, err := parseMetrics(mustOpen(t, testFile))
+	require.NoError(t, err)
+	value, _ := seriesValue(families, "backup_size", map[string]string{"job": "db"})
+	assert.Equal(t, 2500.0, value)
+}
+
+func TestObserveMixedBucketLayouts(t *testing.T) {
+	const content = `# TYPE upload_bytes histogram
+upload_bytes_bucket{site="a",le="1000"} 1
+upload_bytes_bucket{site="a",le="1e+06"} 3
+upload_bytes_bucket{site="a",le="+Inf"} 4
+upload_bytes_count{site="a"} 4
+upload_bytes_sum{site="a"} 2.5e+06
+# TYPE jobs_seconds histogram
+jobs_seconds_bucket{le="+Inf"} 2
+jobs_seconds_count 2
+jobs_seconds_sum 9
+`
+	testFile := createTempFile(t, content)
+	observe := func(name, label, value string) {
+		args := []string{"omet", "-i", "-f", testFile}
+		if label != "" {
+			args = append(args, "-l", label)
+		}
+		require.NoError(t, createTestApp().Run(append(args, name, "observe", value)))
+	}
+	bounds := func(families map[string]*dto.MetricFamily, name string, labels map[string]string) map[float64]uint64 {
+		metric := findSeries(families, name, labels)
+		require.NotNil(t, metric)
+		counts := make(map[float64]uint64)
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			counts[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+		}
+		return counts
+	}
+
+	observe("upload_bytes", "site=a", "5000")
+	observe("upload_bytes", "site=b", "500")
+	observe("jobs_seconds", "", "3")
+	observe("jobs_seconds", "queue=slow", "3")
+
+	families, err := parseMetrics(mustOpen(t, testFile))
+	require.NoError(t, err)
+	inf := math.Inf(1)
+	assert.Equal(t, map[float64]uint64{1000: 1, 1e6: 4, inf: 5}, bounds(families, "upload_bytes", map[string]string{"site": "a"}))
+	assert.Equal(t, map[float64]uint64{1000: 1, 1e6: 1, inf: 1}, bounds(families, "upload_bytes", map[string]string{"site": "b"}), "a new series takes the family's layout")
+	assert.Equal(t, map[float64]uint64{inf: 3}, bounds(families, "jobs_seconds", nil))
+	assert.Equal(t, map[float64]uint64{inf: 1}, bounds(families, "jobs_seconds", map[string]string{"queue": "slow"}), "+Inf-only stays +Inf-only")
+
+	// A family whose series disagree gets the layout most of them share
+	family := createTestHistogramFamily("mixed_seconds", []float64{1, 2}, []uint64{0, 0}, 0, 0)["mixed_seconds"]
+	family.Metric = append(family.Metric,
+		&dto.Metric{Histogram: createHistogram([]float64{5})},
+		&dto.Metric{Histogram: createHistogram([]float64{5})})
+	assert.Equal(t, []float64{5}, familyBucketLayout(family, defaultHistogramBuckets))
+	assert.Equal(t, defaultHistogramBuckets, familyBucketLayout(&dto.MetricFamily{}, defaultHistogramBuckets))
+}
+
+func TestMetricNamedLikeSubcommand(t *testing.T) {
+	for _, name := range []string{"stat", "h"} {
+		testFile := createTempFile(t, "")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, name, "set", "2"})
+		require.NoError(t, err, name)
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, ok := seriesValue(families, name, nil)
+		assert.True(t, ok, "%s should be updated as a metric", name)
+		assert.Equal(t, 2.0, value)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// familyOperations act on every series of the families whose names match
+// the metric argument, a glob like 'backup_*' or a regular expression after
+// a ~ like '~backup_(db|web)_.*', rather than on one series.
+var familyOperations = map[string]bool{
+	"reset":  true,
+	"delete": true,
+}
+
+// familyMatcher compiles a family operation's name pattern. Regular
+// expressions must match the whole name, as in PromQL.
+func familyMatcher(pattern string) (func(name string) bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, "~"); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}, nil
+}
+
+// resetSeries zeroes a series in place, keeping its type and, for
+// histograms, its bucket layout.
+func resetSeries(families map[string]*dto.MetricFamily, name string, labels map[string]string) error {
+	metric := findSeries(families, name, labels)
+	if metric == nil {
+		return fmt.Errorf("series %s not found", formatSeries(name, labels))
+	}
+	switch {
+	case metric.Counter != nil:
+		metric.Counter = &dto.Counter{Value: float64Ptr(0)}
+	case metric.Gauge != nil:
+		metric.Gauge = &dto.Gauge{Value: float64Ptr(0)}
+	case metric.Untyped != nil:
+		metric.Untyped = &dto.Untyped{Value: float64Ptr(0)}
+	case metric.Histogram != nil:
+		metric.Histogram.SampleCount = uint64Ptr(0)
+		metric.Histogram.SampleSum = float64Ptr(0)
+		for _, bucket := range metric.Histogram.Bucket {
+			bucket.CumulativeCount = uint64Ptr(0)
+		}
+	case metric.Summary != nil:
+		metric.Summary.SampleCount = uint64Ptr(0)
+		metric.Summary.SampleSum = float64Ptr(0)
+		metric.Summary.Quantile = nil
+	}
+	return nil
+}
+
+// deleteSeries removes a series, and its family once it has none left.
+func deleteSeries(families map[string]*dto.MetricFamily, name string, labels map[string]string) error {
+	if findSeries(families, name, labels) == nil {
+		return fmt.Errorf("series %s not found", formatSeries(name, labels))
+	}
+	family := families[name]
+	removeSeries(family, labels)
+	if len(family.Metric) == 0 {
+		delete(families, name)
+	}
+	return nil
+}
+
+// renameFamily moves a family, with its type, help, unit, and every series,
+// to newName. An existing family of that name is an error unless
+// forceMerge, which merges into it if the types agree, the renamed series
+// replacing any with the same labels.
+func renameFamily(families map[string]*dto.MetricFamily, name, newName string, forceMerge bool) error {
+	family, exists := families[name]
+	if !exists {
+		return fmt.Errorf("metric %s not found", name)
+	}
+	if newName == name {
+		return fmt.Errorf("%s is already named %s", name, newName)
+	}
+
+	existing, exists := families[newName]
+	switch {
+	case !exists:
+		family.Name = stringPtr(newName)
+		families[newName] = family
+	case !forceMerge:
+		return fmt.Errorf("metric %s already exists (use --force-merge to merge into it)", newName)
+	case existing.GetType() != family.GetType():
+		return fmt.Errorf("can't merge %s into %s: %s is a %s, %s a %s", name, newName, name, typeName(family), newName, typeName(existing))
+	default:
+		for _, metric := range family.Metric {
+			removeSeries(existing, labelMap(metric))
+			existing.Metric = append(existing.Metric, metric)
+		}
+		if existing.GetHelp() == "" {
+			existing.Help = family.Help
+		}
+		if existing.GetUnit() == "" {
+			existing.Unit = family.Unit
+		}
+	}
+	delete(families, name)
+	return nil
+}
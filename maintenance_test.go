@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFamilyOperations(t *testing.T) {
+	const content = `# TYPE backup_size_bytes gauge
+backup_size_bytes{job="db"} 1024
+backup_size_bytes{job="web"} 512
+# TYPE backup_runs_total counter
+backup_runs_total{job="db"} 7
+# TYPE backup_duration_seconds histogram
+backup_duration_seconds_bucket{le="1"} 2
+backup_duration_seconds_bucket{le="+Inf"} 3
+backup_duration_seconds_sum 4.5
+backup_duration_seconds_count 3
+# TYPE queue_depth gauge
+queue_depth 17
+`
+	read := func(t *testing.T, filename string) string {
+		data, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("reset zeroes every series of matching families", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "backup_*", "reset"}))
+
+		data := read(t, testFile)
+		assert.Contains(t, data, `backup_size_bytes{job="db"} 0`)
+		assert.Contains(t, data, `backup_size_bytes{job="web"} 0`)
+		assert.Contains(t, data, `backup_runs_total{job="db"} 0`)
+		assert.Contains(t, data, `backup_duration_seconds_bucket{le="1"} 0`)
+		assert.Contains(t, data, `backup_duration_seconds_count 0`)
+		assert.Contains(t, data, "queue_depth 17")
+	})
+
+	t.Run("delete removes series and emptied families", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "job=db", "backup_*", "delete"}))
+
+		data := read(t, testFile)
+		assert.NotContains(t, data, `job="db"`)
+		assert.NotContains(t, data, "backup_runs_total")
+		assert.Contains(t, data, `backup_size_bytes{job="web"} 512`)
+		assert.Contains(t, data, "backup_duration_seconds_count 3", "-l labels narrow the series")
+
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "undo", "-f", testFile, "2"}))
+		})
+		assert.Contains(t, output, `undid delete backup_runs_total{job="db"}: (deleted) -> 7`)
+		data = read(t, testFile)
+		assert.Contains(t, data, `backup_size_bytes{job="db"} 1024`)
+		assert.Contains(t, data, "# TYPE backup_runs_total counter")
+		assert.Contains(t, data, `backup_runs_total{job="db"} 7`)
+	})
+
+	t.Run("regular expression patterns", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--namespace", "app_", "~backup_(size|runs)_.*", "reset"}))
+
+		data := read(t, testFile)
+		assert.Contains(t, data, `backup_size_bytes{job="db"} 0`)
+		assert.Contains(t, data, `backup_runs_total{job="db"} 0`)
+		assert.Contains(t, data, "backup_duration_seconds_count 3")
+		assert.Contains(t, data, "queue_depth 17")
+
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "~queue", "delete"}))
+		assert.Contains(t, read(t, testFile), "queue_depth 17", "the whole name must match")
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		assert.Error(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "backup_[", "delete"}))
+		assert.Error(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "~backup_(", "delete"}))
+		assert.Error(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "backup_*", "set", "1"}))
+		assert.Contains(t, read(t, testFile), `backup_size_bytes{job="db"} 1024`)
+	})
+}
+
+func TestRenameFamily(t *testing.T) {
+	const content = `# HELP backup_duration How long backups took
+# TYPE backup_duration gauge
+backup_duration{job="db"} 12
+backup_duration{job="web"} 3
+# TYPE backup_duration_seconds gauge
+backup_duration_seconds{job="db"} 10
+backup_duration_seconds{job="mail"} 7
+`
+	rename := func(testFile string, args ...string) error {
+		base := []string{"omet", "-i", "-f", testFile}
+		return createTestApp().Run(append(append(base, args...), "backup_duration", "rename", "backup_duration_seconds"))
+	}
+
+	t.Run("refuses an existing destination", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		assert.ErrorContains(t, rename(testFile), "already exists (use --force-merge")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.Contains(t, families, "backup_duration")
+	})
+
+	t.Run("moves type, help, and series", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "backup_duration_seconds", "rename", "backup_seconds"}))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.NotContains(t, families, "backup_duration_seconds")
+		require.Contains(t, families, "backup_seconds")
+		assert.Equal(t, "gauge", typeName(families["backup_seconds"]))
+		assert.Len(t, families["backup_seconds"].Metric, 2)
+	})
+
+	t.Run("merges with --force-merge", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		require.NoError(t, rename(testFile, "--force-merge"))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.NotContains(t, families, "backup_duration")
+		family := families["backup_duration_seconds"]
+		assert.Equal(t, "How long backups took", family.GetHelp())
+		assert.Len(t, family.Metric, 3)
+		value, _ := seriesValue(families, "backup_duration_seconds", map[string]string{"job": "db"})
+		assert.Equal(t, 12.0, value, "the renamed series wins")
+	})
+
+	t.Run("refuses to merge different types", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE a_total counter\na_total 1\n# TYPE b gauge\nb 2\n")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--force-merge", "a_total", "rename", "b"})
+		assert.ErrorContains(t, err, "a_total is a counter, b a gauge")
+	})
+
+	t.Run("refuses labels", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		assert.ErrorContains(t, rename(testFile, "-l", "job=db"), "rename moves a whole family")
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// expandMatch turns a --match or family operation into a batch applying the
+// operation to every existing series the selector and -l labels select, in
+// the families the metric name (a pattern for family operations) matches.
+// Without matches it creates the series given by -l and the selector's
+// equality matchers if --create-if-missing is set, and does nothing
+// otherwise.
+func (req *request) expandMatch(families map[string]*dto.MetricFamily) *request {
+	if req.match == nil && !familyOperations[req.operation] {
+		return req
+	}
+
+	names := []string{req.metricName}
+	if familyOperations[req.operation] {
+		names = nil
+		matches, err := familyMatcher(req.metricName)
+		for name := range families {
+			if err == nil && matches(name) { // invalid patterns were reported by runOmet
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	expanded := *req
+	expanded.batch = []*request{}
+	for _, name := range names {
+		for _, metric := range families[name].GetMetric() {
+			if !req.match.Matches(metric.Label) || !hasLabels(metric.Label, req.labels) {
+				continue
+			}
+			u := *req
+			u.metricName, u.match = name, nil
+			u.labels = make(map[string]string, len(metric.Label))
+			for _, label := range metric.Label {
+				u.labels[label.GetName()] = label.GetValue()
+			}
+			expanded.batch = append(expanded.batch, &u)
+		}
+	}
+
+	if len(expanded.batch) == 0 {
+		if !req.createIfMissing || familyOperations[req.operation] {
+			if req.verbose {
+				log.Printf("No series of %s match %s", req.metricName, req.match)
+			}
+			return &expanded
+		}
+		u := *req
+		u.match, u.labels = nil, maps.Clone(req.labels)
+		if u.labels == nil {
+			u.labels = make(map[string]string)
+		}
+		for _, matcher := range req.match {
+			if matcher.Op == "=" {
+				u.labels[matcher.Name] = matcher.Value
+			}
+		}
+		expanded.batch = append(expanded.batch, &u)
+	}
+	return &expanded
+}
+
+// hasLabels reports whether the label pairs include every one of labels.
+func hasLabels(pairs []*dto.LabelPair, labels map[string]string) bool {
+	for name, value := range labels {
+		found := false
+		for _, pair := range pairs {
+			if pair.GetName() == name {
+				found = pair.GetValue() == value
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
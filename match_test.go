@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	const content = `# TYPE http_errors_total counter
+http_errors_total{status="500",route="/"} 3
+http_errors_total{status="503",route="/api"} 1
+http_errors_total{status="404",route="/"} 7
+`
+
+	t.Run("updates every matching series", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--match", `status=~"5.."`, "http_errors_total", "inc", "2"})
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `http_errors_total{status="500",route="/"} 5`)
+		assert.Contains(t, string(data), `http_errors_total{status="503",route="/api"} 3`)
+		assert.Contains(t, string(data), `http_errors_total{status="404",route="/"} 7`)
+	})
+
+	t.Run("-l labels narrow the match", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--match", `{status=~"5.."}`, "-l", "route=/", "http_errors_total", "inc"})
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `http_errors_total{status="500",route="/"} 4`)
+		assert.Contains(t, string(data), `http_errors_total{status="503",route="/api"} 1`)
+	})
+
+	t.Run("nothing matches", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--match", `status="502"`, "http_errors_total", "inc"})
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), `status="502"`)
+
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--match", `status="502"`, "--create-if-missing", "-l", "route=/", "http_errors_total", "inc"})
+		require.NoError(t, err)
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, ok := seriesValue(families, "http_errors_total", map[string]string{"route": "/", "status": "502"})
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, value)
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--match", `status=~"("`, "http_errors_total", "inc"})
+		assert.Error(t, err)
+
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--create-if-missing", "http_errors_total", "inc"})
+		assert.Error(t, err)
+	})
+}
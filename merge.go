@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"omet/internal/metricsfile"
+	"omet/internal/objstore"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// Policies for families whose TYPE or HELP differ between merged files
+const (
+	conflictError   = "error"   // fail the merge
+	conflictFirst   = "first"   // keep the first file's definition
+	conflictLast    = "last"    // take the last file's definition
+	conflictUntyped = "untyped" // keep both, demoting type conflicts to untyped
+)
+
+func mergeCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "merge",
+		Usage:     "Merge several metrics files into one",
+		ArgsUsage: "<file>...",
+		Description: `Combines families from every input file, in order. A series present in
+several files takes its value from the last one, unless the policies say
+otherwise: counters can be summed or take the maximum, gauges summed,
+averaged, or take the maximum or minimum, and histograms merged bucket by
+bucket. A --schema file can set a family's policy with its merge field.
+
+When a family's TYPE or HELP differs between files, --conflict decides:
+  error    fail the merge (default)
+  first    keep the first file's TYPE/HELP; series of another type are dropped
+  last     take the last file's TYPE/HELP; series of another type are dropped
+  untyped  keep every series, turning counter/gauge type conflicts into untyped
+
+With --memory-budget, inputs whose parsed form would exceed the budget are
+split by family into temporary files and merged one partition at a time.
+The result is the same; --verbose reports the peak heap either way.
+
+Examples:
+  omet merge a.prom b.prom > all.prom
+  omet merge --conflict last -o /var/lib/node_exporter/all.prom /run/app/*.prom`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "conflict",
+				Usage: "Policy for conflicting TYPE or HELP: error, first, last, or untyped",
+				Value: conflictError,
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Write the result to this file, under its lock, or to an s3:// or gs:// URL (default: stdout)",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for the output file lock",
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "Parse up to this many input files in parallel (default: number of CPUs)",
+			},
+			&cli.Int64Flag{
+				Name:  "memory-budget",
+				Usage: "Approximate memory limit in bytes; larger merges spill to temporary files (0 = no limit)",
+			},
+			&cli.StringFlag{
+				Name:  "encrypt-key-file",
+				Usage: "Decrypt the inputs with the AES-256 key in this file, and encrypt --output with it",
+			},
+		}, mergeStrategyFlags("replace", "replace", "replace")...),
+		Action: runMerge,
+	}
+}
+
+func runMerge(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return fmt.Errorf("merge requires at least one input file")
+	}
+	policy := ctx.String("conflict")
+	if err := validateConflictPolicy(policy); err != nil {
+		return err
+	}
+	strategies, err := loadMergeStrategies(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := loadEncryptKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	filenames := ctx.Args().Slice()
+	peak := &peakMemory{enabled: ctx.Bool("verbose")}
+	defer peak.report()
+
+	size, err := inputSize(filenames)
+	if err != nil {
+		return err
+	}
+	if parts := spillPartitions(size, ctx.Int64("memory-budget")); parts > 0 {
+		if key != nil {
+			return fmt.Errorf("inputs exceed --memory-budget, but encrypted inputs can't be spilled to disk in plain text")
+		}
+		if ctx.Bool("verbose") {
+			log.Printf("Inputs total %d bytes, over --memory-budget; merging via %d on-disk partitions", size, parts)
+		}
+		return mergeSpilled(filenames, parts, policy, strategies, func(result io.Reader) error {
+			return writeMergeOutput(ctx, nil, func(w io.Writer) error {
+				_, err := io.Copy(w, result)
+				return err
+			})
+		}, peak)
+	}
+
+	// Parse in parallel, then merge in argument order so the result doesn't
+	// depend on which file finished parsing first
+	parsed, err := metricsfile.ParseFiles(filenames, ctx.Int("jobs"), key)
+	if err != nil {
+		return err
+	}
+	peak.sample()
+
+	merged := make(map[string]*dto.MetricFamily)
+	for i, families := range parsed {
+		filename := filenames[i]
+		if err := mergeFamilies(merged, families, policy, strategies); err != nil {
+			return fmt.Errorf("failed to merge %s: %w", filename, err)
+		}
+	}
+	peak.sample()
+
+	return writeMergeOutput(ctx, key, func(w io.Writer) error {
+		return writeMetrics(merged, w)
+	})
+}
+
+// writeMergeOutput sends the merge result to stdout or, with --output, into
+// that file under its lock. Object storage outputs are replaced outright.
+// With a key, --output is encrypted like the files omet updates in place;
+// stdout stays plaintext.
+func writeMergeOutput(ctx *cli.Context, key []byte, write func(io.Writer) error) error {
+	output := ctx.String("output")
+	if output == "" {
+		return write(os.Stdout)
+	}
+	if key != nil {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return err
+		}
+		sealed, err := metricsfile.Encrypt(key, metricsfile.AppendChecksum(buf.Bytes()))
+		if err != nil {
+			return err
+		}
+		write = func(w io.Writer) error {
+			_, err := w.Write(sealed)
+			return err
+		}
+	}
+	if objstore.IsURL(output) {
+		return writeRemote(output, write)
+	}
+
+	lock, err := metricsfile.NewFileLock(output, ctx.Duration("lock-timeout"))
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+	defer lock.Close()
+
+	if err := lock.Lock(context.Background()); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if key != nil {
+		// The checksum is already sealed inside
+		return lock.Rewrite(func(file *os.File) error {
+			return write(file)
+		})
+	}
+	return lock.RewriteWithChecksum(func(file *os.File) error {
+		return write(file)
+	})
+}
+
+func validateConflictPolicy(policy string) error {
+	switch policy {
+	case conflictError, conflictFirst, conflictLast, conflictUntyped:
+		return nil
+	default:
+		return fmt.Errorf("invalid --conflict policy: %s (supported: error, first, last, untyped)", policy)
+	}
+}
+
+// mergeFamilies merges src into dst. Series with the same labels are
+// combined by strategies, replaced by src's if it's nil; TYPE and HELP
+// conflicts are resolved by policy.
+func mergeFamilies(dst, src map[string]*dto.MetricFamily, policy string, strategies *mergeStrategies) error {
+	for name, family := range src {
+		existing, exists := dst[name]
+		if !exists {
+			dst[name] = family
+			continue
+		}
+
+		typeConflict := existing.GetType() != family.GetType()
+		helpConflict := existing.GetHelp() != family.GetHelp()
+
+		switch {
+		case !typeConflict && !helpConflict:
+		case policy == conflictError:
+			if typeConflict {
+				return fmt.Errorf("family %s has conflicting types: %s vs %s", name, typeName(existing), typeName(family))
+			}
+			return fmt.Errorf("family %s has conflicting help: %q vs %q", name, existing.GetHelp(), family.GetHelp())
+		case policy == conflictFirst:
+			if typeConflict {
+				continue // Drop series that don't fit the first definition
+			}
+		case policy == conflictLast:
+			if typeConflict {
+				dst[name] = family
+				continue
+			}
+			existing.Help = family.Help
+		case policy == conflictUntyped:
+			if typeConflict {
+				if err := demoteToUntyped(existing); err != nil {
+					return err
+				}
+				if err := demoteToUntyped(family); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, metric := range family.Metric {
+			if err := strategies.mergeSeries(existing, metric); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replaceSeries adds metric to the family, replacing a series with the same
+// labels.
+func replaceSeries(family *dto.MetricFamily, metric *dto.Metric) {
+	for i, existing := range family.Metric {
+		if sameLabels(existing.Label, metric.Label) {
+			family.Metric[i] = metric
+			return
+		}
+	}
+	family.Metric = append(family.Metric, metric)
+}
+
+func sameLabels(a, b []*dto.LabelPair) bool {
+	labels := make(map[string]string, len(b))
+	for _, label := range b {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labelsMatch(a, labels)
+}
+
+// demoteToUntyped converts a counter or gauge family to untyped, keeping
+// values. Histograms and summaries have no single value and can't be demoted.
+func demoteToUntyped(family *dto.MetricFamily) error {
+	switch family.GetType() {
+	case dto.MetricType_UNTYPED:
+		return nil
+	case dto.MetricType_COUNTER, dto.MetricType_GAUGE:
+	default:
+		return fmt.Errorf("family %s is a %s and can't be merged as untyped", family.GetName(), typeName(family))
+	}
+
+	for _, metric := range family.Metric {
+		var value float64
+		if metric.Counter != nil {
+			value = metric.GetCounter().GetValue()
+		} else {
+			value = metric.GetGauge().GetValue()
+		}
+		metric.Counter, metric.Gauge = nil, nil
+		metric.Untyped = &dto.Untyped{Value: float64Ptr(value)}
+	}
+	untyped := dto.MetricType_UNTYPED
+	family.Type = &untyped
+	return nil
+}
+
+func typeName(family *dto.MetricFamily) string {
+	return metricsfile.TypeName(family.GetType())
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func parseTestFamilies(t *testing.T, content string) map[string]*dto.MetricFamily {
+	families, err := parseMetrics(strings.NewReader(content))
+	require.NoError(t, err)
+	return families
+}
+
+func TestMergeFamilies(t *testing.T) {
+	first := `# HELP jobs Jobs run
+# TYPE jobs counter
+jobs{host="a"} 1
+`
+	sameDefinition := `# HELP jobs Jobs run
+# TYPE jobs counter
+jobs{host="a"} 5
+jobs{host="b"} 2
+`
+	otherType := `# HELP jobs Jobs run
+# TYPE jobs gauge
+jobs{host="c"} 3
+`
+	otherHelp := `# HELP jobs Jobs completed
+# TYPE jobs counter
+jobs{host="b"} 2
+`
+
+	t.Run("series from later files replace earlier ones", func(t *testing.T) {
+		merged := parseTestFamilies(t, first)
+		require.NoError(t, mergeFamilies(merged, parseTestFamilies(t, sameDefinition), conflictError, nil))
+
+		require.Len(t, merged["jobs"].Metric, 2)
+		value, _ := seriesValue(merged, "jobs", map[string]string{"host": "a"})
+		assert.Equal(t, 5.0, value)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		merged := parseTestFamilies(t, first)
+		assert.ErrorContains(t, mergeFamilies(merged, parseTestFamilies(t, otherType), conflictError, nil), "conflicting types: counter vs gauge")
+
+		merged = parseTestFamilies(t, first)
+		assert.ErrorContains(t, mergeFamilies(merged, parseTestFamilies(t, otherHelp), conflictError, nil), "conflicting help")
+	})
+
+	t.Run("first", func(t *testing.T) {
+		merged := parseTestFamilies(t, first)
+		require.NoError(t, mergeFamilies(merged, parseTestFamilies(t, otherType), conflictFirst, nil))
+		assert.Equal(t, dto.MetricType_COUNTER, merged["jobs"].GetType())
+		assert.Len(t, merged["jobs"].Metric, 1)
+
+		require.NoError(t, mergeFamilies(merged, parseTestFamilies(t, otherHelp), conflictFirst, nil))
+		assert.Equal(t, "Jobs run", merged["jobs"].GetHelp())
+		assert.Len(t, merged["jobs"].Metric, 2)
+	})
+
+	t.Run("last", func(t *testing.T) {
+		merged := parseTestFamilies(t, first)
+		require.NoError(t, mergeFamilies(merged, parseTestFamilies(t, otherHelp), conflictLast, nil))
+		assert.Equal(t, "Jobs completed", merged["jobs"].GetHelp())
+		assert.Len(t, merged["jobs"].Metric, 2)
+
+		require.NoError(t, mergeFamilies(merged, parseTestFamilies(t, otherType), conflictLast, nil))
+		assert.Equal(t, dto.MetricType_GAUGE, merged["jobs"].GetType())
+		assert.Len(t, merged["jobs"].Metric, 1)
+	})
+
+	t.Run("untyped", func(t *testing.T) {
+		merged := parseTestFamilies(t, first)
+		require.NoError(t, mergeFamilies(merged, parseTestFamilies(t, otherType), conflictUntyped, nil))
+		assert.Equal(t, dto.MetricType_UNTYPED, merged["jobs"].GetType())
+		assert.Len(t, merged["jobs"].Metric, 2)
+		value, _ := seriesValue(merged, "jobs", map[string]string{"host": "c"})
+		assert.Equal(t, 3.0, value)
+
+		histogram := "# TYPE jobs histogram\njobs_bucket{le=\"+Inf\"} 1\njobs_sum 1\njobs_count 1\n"
+		assert.ErrorContains(t, mergeFamilies(merged, parseTestFamilies(t, histogram), conflictUntyped, nil), "can't be merged as untyped")
+	})
+}
+
+func TestMergeCommand(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.prom")
+	b := filepath.Join(dir, "b.prom")
+	out := filepath.Join(dir, "all.prom")
+	require.NoError(t, os.WriteFile(a, []byte("# TYPE up gauge\nup{job=\"a\"} 1\n"), 0644))
+	require.NoError(t, os.WriteFile(b, []byte("# TYPE up counter\nup{job=\"b\"} 0\n"), 0644))
+
+	err := createTestApp().Run([]string{"omet", "merge", a, b})
+	assert.ErrorContains(t, err, "conflicting types")
+
+	err = createTestApp().Run([]string{"omet", "merge", "--conflict", "bogus", a, b})
+	assert.ErrorContains(t, err, "invalid --conflict policy")
+
+	err = createTestApp().Run([]string{"omet", "merge", "--conflict", "untyped", "-o", out, a, b})
+	require.NoError(t, err)
+	content, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "# TYPE up untyped")
+	assert.Contains(t, string(content), `up{job="a"} 1`)
+	assert.Contains(t, string(content), `up{job="b"} 0`)
+
+	output := captureOutput(t, func() {
+		require.NoError(t, createTestApp().Run([]string{"omet", "merge", "--conflict", "first", a, b}))
+	})
+	assert.Contains(t, output, `up{job="a"} 1`)
+	assert.NotContains(t, output, `job="b"`)
+}
+
+func TestMergeParallelIsDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	var args []string
+	for i := 0; i < 12; i++ {
+		filename := filepath.Join(dir, fmt.Sprintf("%02d.prom", i))
+		require.NoError(t, os.WriteFile(filename, []byte(fmt.Sprintf("# TYPE version gauge\nversion %d\n", i)), 0644))
+		args = append(args, filename)
+	}
+
+	for _, jobs := range []string{"1", "4"} {
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run(append([]string{"omet", "merge", "-j", jobs}, args...)))
+		})
+		assert.Contains(t, output, "version 11", "the last file wins regardless of parse order")
+	}
+}
+
+func TestMergeSpilled(t *testing.T) {
+	dir := t.TempDir()
+	var filenames []string
+	for i := 0; i < 3; i++ {
+		var content strings.Builder
+		for f := 0; f < 20; f++ {
+			fmt.Fprintf(&content, "# HELP family_%d Family %d\n# TYPE family_%d gauge\n", f, f, f)
+			fmt.Fprintf(&content, "family_%d{file=\"%d\"} %d\nfamily_%d{shared=\"yes\"} %d\n", f, i, i, f, i)
+		}
+		content.WriteString("# TYPE latency histogram\nlatency_bucket{le=\"1\"} 1\nlatency_bucket{le=\"+Inf\"} 2\nlatency_sum 3\nlatency_count 2\n")
+		filename := filepath.Join(dir, fmt.Sprintf("%d.prom", i))
+		require.NoError(t, os.WriteFile(filename, []byte(content.String()), 0644))
+		filenames = append(filenames, filename)
+	}
+
+	inMemory := captureOutput(t, func() {
+		require.NoError(t, createTestApp().Run(append([]string{"omet", "merge"}, filenames...)))
+	})
+
+	var spilled bytes.Buffer
+	require.NoError(t, mergeSpilled(filenames, 4, conflictError, nil, func(r io.Reader) error {
+		_, err := io.Copy(&spilled, r)
+		return err
+	}, nil))
+
+	expected := parseTestFamilies(t, inMemory)
+	got := parseTestFamilies(t, spilled.String())
+	require.Len(t, got, len(expected))
+	for name, family := range expected {
+		assert.True(t, proto.Equal(family, got[name]), "family %s", name)
+	}
+	assert.Len(t, got["family_0"].Metric, 4, "three per-file series plus one shared series")
+
+	t.Run("budget triggers spilling", func(t *testing.T) {
+		out := filepath.Join(dir, "all.prom")
+		require.NoError(t, createTestApp().Run(append([]string{"omet", "merge", "--memory-budget", "1024", "-o", out}, filenames...)))
+		families, err := metricsfile.ParseFile(out)
+		require.NoError(t, err)
+		assert.Len(t, families, len(expected))
+
+		assert.Equal(t, 0, spillPartitions(1000, 0))
+		assert.Equal(t, 0, spillPartitions(1000, 4000))
+		assert.Equal(t, 8, spillPartitions(1000, 1000))
+		assert.Equal(t, maxSpillPartitions, spillPartitions(1<<40, 1))
+	})
+
+	t.Run("conflicts still fail and leave no output", func(t *testing.T) {
+		bad := filepath.Join(dir, "bad.prom")
+		require.NoError(t, os.WriteFile(bad, []byte("# TYPE family_3 counter\nfamily_3 1\n"), 0644))
+		wrote := false
+		err := mergeSpilled(append(filenames, bad), 4, conflictError, nil, func(io.Reader) error {
+			wrote = true
+			return nil
+		}, nil)
+		assert.ErrorContains(t, err, "bad.prom")
+		assert.False(t, wrote)
+	})
+}
+
+func TestMergeStrategies(t *testing.T) {
+	dir := t.TempDir()
+	var filenames []string
+	for i, content := range []string{
+		"# TYPE jobs_total counter\njobs_total 1\n# TYPE load gauge\nload 1\n# TYPE peak gauge\npeak 5\n",
+		"# TYPE jobs_total counter\njobs_total 2\n# TYPE load gauge\nload 2\n# TYPE peak gauge\npeak 9\n",
+		"# TYPE jobs_total counter\njobs_total 4\n# TYPE load gauge\nload 6\n# TYPE peak gauge\npeak 7\n",
+	} {
+		filename := filepath.Join(dir, fmt.Sprintf("%d.prom", i))
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+		filenames = append(filenames, filename)
+	}
+	schemaFile := filepath.Join(dir, "schema.yaml")
+	require.NoError(t, os.WriteFile(schemaFile, []byte("families:\n  - name: peak\n    type: gauge\n    merge: max\n"), 0644))
+
+	output := captureOutput(t, func() {
+		args := []string{"omet", "merge", "--counter-policy", "sum", "--gauge-policy", "average", "--schema", schemaFile}
+		require.NoError(t, createTestApp().Run(append(args, filenames...)))
+	})
+	merged := parseTestFamilies(t, output)
+	for name, want := range map[string]float64{"jobs_total": 7, "load": 3, "peak": 9} {
+		value, _ := seriesValue(merged, name, map[string]string{})
+		assert.Equal(t, want, value, name)
+	}
+
+	t.Run("invalid strategies", func(t *testing.T) {
+		assert.Error(t, createTestApp().Run(append([]string{"omet", "merge", "--counter-policy", "average"}, filenames...)))
+
+		require.NoError(t, os.WriteFile(schemaFile, []byte("families:\n  - name: jobs_total\n    type: counter\n    merge: min\n"), 0644))
+		err := createTestApp().Run(append([]string{"omet", "merge", "--schema", schemaFile}, filenames...))
+		assert.ErrorContains(t, err, "invalid merge min for a counter")
+	})
+}
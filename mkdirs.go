@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"omet/internal/objstore"
+
+	"github.com/urfave/cli/v2"
+)
+
+// dirProvisioning is how --mkdirs creates missing directories above in-place
+// targets. A uid or gid of -1 leaves that owner unchanged.
+type dirProvisioning struct {
+	mode     os.FileMode
+	uid, gid int
+}
+
+// provisionDirs creates the missing directories above local --file targets
+// for --mkdirs, so the first run on a fresh host doesn't fail.
+func provisionDirs(ctx *cli.Context, filenames []string) error {
+	p, err := parseDirProvisioning(ctx.String("mkdirs-mode"), ctx.String("mkdirs-owner"))
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if filename == "-" || objstore.IsURL(filename) {
+			continue
+		}
+		created, err := p.create(filepath.Dir(filename))
+		if err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", filename, err)
+		}
+		if ctx.Bool("verbose") {
+			for _, dir := range created {
+				log.Printf("Created directory %s", dir)
+			}
+		}
+	}
+	return nil
+}
+
+// parseDirProvisioning parses --mkdirs-mode, an octal mode such as 0750, and
+// --mkdirs-owner, "USER[:GROUP]" by name or number.
+func parseDirProvisioning(mode, owner string) (*dirProvisioning, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return nil, fmt.Errorf("invalid --mkdirs-mode %q: expected an octal mode such as 0755", mode)
+	}
+	p := &dirProvisioning{mode: os.FileMode(perm), uid: -1, gid: -1}
+	if owner == "" {
+		return p, nil
+	}
+
+	userName, groupName, _ := strings.Cut(owner, ":")
+	if userName != "" {
+		if p.uid, err = lookupID(userName, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return nil, fmt.Errorf("invalid --mkdirs-owner %q: %w", owner, err)
+		}
+	}
+	if groupName != "" {
+		if p.gid, err = lookupID(groupName, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return nil, fmt.Errorf("invalid --mkdirs-owner %q: %w", owner, err)
+		}
+	}
+	return p, nil
+}
+
+// lookupID resolves a numeric ID as is and a name with lookup.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// create makes dir and any missing parents with the configured mode and
+// owner, returning the directories it created, outermost first. Existing
+// directories are left alone, including ones a concurrent run just made.
+func (p *dirProvisioning) create(dir string) ([]string, error) {
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	var created []string
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		if err := os.Mkdir(d, p.mode); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				continue
+			}
+			return created, err
+		}
+		created = append(created, d)
+
+		// Mkdir's mode is filtered by the umask
+		if err := os.Chmod(d, p.mode); err != nil {
+			return created, err
+		}
+		if p.uid != -1 || p.gid != -1 {
+			if err := os.Lchown(d, p.uid, p.gid); err != nil {
+				return created, err
+			}
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMkdirs(t *testing.T) {
+	t.Run("creates the directory chain", func(t *testing.T) {
+		root := t.TempDir()
+		testFile := filepath.Join(root, "a", "b", "metrics.prom")
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--mkdirs", "--mkdirs-mode", "0750", "jobs_total", "inc"}))
+
+		for _, dir := range []string{filepath.Join(root, "a"), filepath.Join(root, "a", "b")} {
+			info, err := os.Stat(dir)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0750), info.Mode().Perm(), dir)
+		}
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "jobs_total 1")
+	})
+
+	t.Run("leaves existing directories alone", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.Chmod(root, 0700))
+		testFile := filepath.Join(root, "metrics.prom")
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--mkdirs", "jobs_total", "inc"}))
+
+		info, err := os.Stat(root)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	})
+
+	t.Run("fails without --mkdirs", func(t *testing.T) {
+		testFile := filepath.Join(t.TempDir(), "missing", "metrics.prom")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "jobs_total", "inc"})
+		assert.Error(t, err)
+	})
+}
+
+func TestParseDirProvisioning(t *testing.T) {
+	p, err := parseDirProvisioning("0755", "")
+	require.NoError(t, err)
+	assert.Equal(t, &dirProvisioning{mode: 0755, uid: -1, gid: -1}, p)
+
+	uid, gid := os.Getuid(), os.Getgid()
+	p, err = parseDirProvisioning("750", strconv.Itoa(uid)+":"+strconv.Itoa(gid))
+	require.NoError(t, err)
+	assert.Equal(t, &dirProvisioning{mode: 0750, uid: uid, gid: gid}, p)
+
+	p, err = parseDirProvisioning("0755", ":"+strconv.Itoa(gid))
+	require.NoError(t, err)
+	assert.Equal(t, -1, p.uid)
+
+	for _, mode := range []string{"rwx", "0888", "01777", ""} {
+		_, err := parseDirProvisioning(mode, "")
+		assert.Error(t, err, mode)
+	}
+	_, err = parseDirProvisioning("0755", "no-such-user-omet")
+	assert.ErrorContains(t, err, "invalid --mkdirs-owner")
+}
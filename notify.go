@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notifyTimeout bounds the --notify-url request, so an unreachable
+// endpoint can't hold up the job that ran omet.
+const notifyTimeout = 10 * time.Second
+
+// threshold is a --notify-threshold condition such as ">=100".
+type threshold struct {
+	op    string
+	value float64
+}
+
+// parseThreshold parses OP VALUE, where OP is one of >, >=, <, <=. An empty
+// spec yields nil, meaning every run notifies.
+func parseThreshold(spec string) (*threshold, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	spec = strings.TrimSpace(spec)
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if rest, ok := strings.CutPrefix(spec, op); ok {
+			value, err := strconv.ParseFloat(strings.TrimSpace(rest), 64)
+			if err != nil {
+				break
+			}
+			return &threshold{op: op, value: value}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid --notify-threshold %q: expected OP VALUE with OP one of >, >=, <, <=", spec)
+}
+
+func (th *threshold) String() string {
+	return th.op + strconv.FormatFloat(th.value, 'g', -1, 64)
+}
+
+// holds reports whether value meets the condition; a missing series never
+// does.
+func (th *threshold) holds(value *float64) bool {
+	if value == nil {
+		return false
+	}
+	switch th.op {
+	case ">":
+		return *value > th.value
+	case ">=":
+		return *value >= th.value
+	case "<":
+		return *value < th.value
+	default:
+		return *value <= th.value
+	}
+}
+
+// crossed reports whether the run moved the series to the other side of
+// the threshold, in either direction.
+func (th *threshold) crossed(t *target) bool {
+	return th.holds(t.oldValue) != th.holds(t.newValue)
+}
+
+// notification is the JSON body POSTed to --notify-url.
+type notification struct {
+	Time      string            `json:"time"`
+	Host      string            `json:"host"`
+	TraceID   string            `json:"trace_id"`
+	Operation string            `json:"operation"`
+	Metric    string            `json:"metric"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Threshold string            `json:"threshold,omitempty"`
+	Targets   []notifiedTarget  `json:"targets"`
+}
+
+type notifiedTarget struct {
+	File         string   `json:"file"`
+	Old          *string  `json:"old"` // null if the series didn't exist
+	New          *string  `json:"new"`
+	ThresholdMet *bool    `json:"threshold_met,omitempty"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// newNotification summarizes the run, or returns nil when a threshold is
+// set and no target crossed it.
+func newNotification(req *request, targets []*target, th *threshold, traceID string) *notification {
+	host, _ := os.Hostname()
+	n := &notification{
+		Time:      timeProvider.Now().UTC().Format(time.RFC3339Nano),
+		Host:      host,
+		TraceID:   traceID,
+		Operation: req.operation,
+		Metric:    req.metricName,
+		Labels:    changedLabels(req),
+	}
+	crossed := false
+	for _, t := range targets {
+		target := notifiedTarget{
+			File:   t.filename,
+			Old:    formatValuePtr(t.oldValue),
+			New:    formatValuePtr(t.newValue),
+			Errors: t.errors.messages(),
+		}
+		if th != nil {
+			met := th.holds(t.newValue)
+			target.ThresholdMet = &met
+			crossed = crossed || th.crossed(t)
+		}
+		n.Targets = append(n.Targets, target)
+	}
+	if th != nil {
+		if !crossed {
+			return nil
+		}
+		n.Threshold = th.String()
+	}
+	return n
+}
+
+func formatValuePtr(value *float64) *string {
+	if value == nil {
+		return nil
+	}
+	return stringPtr(strconv.FormatFloat(*value, 'g', -1, 64))
+}
+
+// sendNotification POSTs n as JSON to url.
+func sendNotification(url string, n *notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThreshold(t *testing.T) {
+	th, err := parseThreshold(">= 100")
+	require.NoError(t, err)
+	assert.Equal(t, ">=100", th.String())
+	assert.True(t, th.holds(float64Ptr(100)))
+	assert.False(t, th.holds(float64Ptr(99)))
+	assert.False(t, th.holds(nil))
+
+	th, err = parseThreshold("<0.5")
+	require.NoError(t, err)
+	assert.True(t, th.holds(float64Ptr(0.4)))
+
+	th, err = parseThreshold("")
+	require.NoError(t, err)
+	assert.Nil(t, th)
+
+	for _, spec := range []string{"100", "=100", ">abc"} {
+		_, err := parseThreshold(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNotifyURL(t *testing.T) {
+	var received []notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		received = append(received, n)
+	}))
+	defer server.Close()
+
+	testFile := createTempFile(t, "# TYPE queue_depth gauge\nqueue_depth{queue=\"orders\"} 90\n")
+	run := func(args ...string) error {
+		return createTestApp().Run(append([]string{"omet", "--notify-url", server.URL, "-i", "-f", testFile, "-l", "queue=orders"}, args...))
+	}
+
+	require.NoError(t, run("queue_depth", "set", "95"))
+	require.Len(t, received, 1, "without a threshold every run notifies")
+	n := received[0]
+	assert.Equal(t, "set", n.Operation)
+	assert.Equal(t, "queue_depth", n.Metric)
+	assert.Equal(t, map[string]string{"queue": "orders"}, n.Labels)
+	require.Len(t, n.Targets, 1)
+	assert.Equal(t, testFile, n.Targets[0].File)
+	assert.Equal(t, "90", *n.Targets[0].Old)
+	assert.Equal(t, "95", *n.Targets[0].New)
+	assert.Nil(t, n.Targets[0].ThresholdMet)
+
+	received = nil
+	require.NoError(t, run("--notify-threshold", ">=100", "queue_depth", "set", "99"))
+	assert.Empty(t, received, "below the threshold")
+	require.NoError(t, run("--notify-threshold", ">=100", "queue_depth", "set", "120"))
+	require.Len(t, received, 1, "crossed upwards")
+	assert.Equal(t, ">=100", received[0].Threshold)
+	assert.True(t, *received[0].Targets[0].ThresholdMet)
+	require.NoError(t, run("--notify-threshold", ">=100", "queue_depth", "set", "130"))
+	assert.Len(t, received, 1, "still above, no new notification")
+	require.NoError(t, run("--notify-threshold", ">=100", "queue_depth", "set", "10"))
+	require.Len(t, received, 2, "crossed back down")
+	assert.False(t, *received[1].Targets[0].ThresholdMet)
+
+	t.Run("errors are reported", func(t *testing.T) {
+		received = nil
+		require.Error(t, run("queue_depth", "inc"))
+		require.Len(t, received, 1)
+		assert.NotEmpty(t, received[0].Targets[0].Errors)
+	})
+
+	t.Run("unreachable endpoint doesn't fail the run", func(t *testing.T) {
+		require.NoError(t, createTestApp().Run([]string{"omet", "--notify-url", "http://127.0.0.1:1/hook", "-i", "-f", testFile, "-l", "queue=orders", "queue_depth", "set", "1"}))
+	})
+}
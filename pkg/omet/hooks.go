@@ -0,0 +1,63 @@
+package omet
+
+import "fmt"
+
+// Operation is an Inc, Set, or Observe as seen by hooks.
+type Operation struct {
+	Kind   string // "inc", "set", or "observe"
+	Name   string
+	Labels map[string]string
+	Value  float64 // the delta, the new value, or the observation
+}
+
+// Hook runs around every Inc, Set, and Observe, so programs embedding omet
+// can enforce their own policies. Either function may be nil.
+type Hook struct {
+	// Before runs first and may rewrite the operation, for instance to add
+	// or normalize labels. An error rejects the operation, which is then
+	// reported to the caller without being applied.
+	Before func(op *Operation) error
+
+	// After runs last, with the operation as applied and its error,
+	// including rejections by Before hooks.
+	After func(op Operation, err error)
+}
+
+// Use registers hooks, which run in order of registration.
+func (m *Metrics) Use(hooks ...Hook) {
+	m.hooks = append(m.hooks, hooks...)
+}
+
+// run applies op with apply, surrounded by the registered hooks.
+func (m *Metrics) run(op Operation, apply func(op Operation) error) error {
+	if len(m.hooks) == 0 {
+		return apply(op)
+	}
+
+	// Before hooks may change the labels, which belong to the caller
+	labels := make(map[string]string, len(op.Labels))
+	for k, v := range op.Labels {
+		labels[k] = v
+	}
+	op.Labels = labels
+
+	var err error
+	for _, hook := range m.hooks {
+		if hook.Before == nil {
+			continue
+		}
+		if err = hook.Before(&op); err != nil {
+			err = fmt.Errorf("%s %s rejected: %w", op.Kind, op.Name, err)
+			break
+		}
+	}
+	if err == nil {
+		err = apply(op)
+	}
+	for _, hook := range m.hooks {
+		if hook.After != nil {
+			hook.After(op, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package omet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	t.Run("before hooks rewrite operations", func(t *testing.T) {
+		m := New()
+		m.Use(Hook{Before: func(op *Operation) error {
+			op.Labels["team"] = "payments"
+			return nil
+		}})
+
+		labels := map[string]string{"job": "a"}
+		require.NoError(t, m.Inc("jobs_total", labels, 2))
+		assert.Equal(t, map[string]string{"job": "a"}, labels, "the caller's labels are left alone")
+
+		series, err := m.Lookup(`jobs_total{team="payments"}`)
+		require.NoError(t, err)
+		require.Len(t, series, 1)
+		assert.Equal(t, 2.0, series[0].Value())
+	})
+
+	t.Run("before hooks reject operations", func(t *testing.T) {
+		m := New()
+		var audited []string
+		m.Use(
+			Hook{Before: func(op *Operation) error {
+				if op.Labels["env"] == "" {
+					return errors.New("env label required")
+				}
+				return nil
+			}},
+			Hook{
+				Before: func(op *Operation) error {
+					t.Error("later hooks don't run after a rejection")
+					return nil
+				},
+				After: func(op Operation, err error) {
+					audited = append(audited, op.Kind+" "+op.Name+" "+errString(err))
+				},
+			},
+		)
+
+		err := m.Set("queue_depth", nil, 4)
+		assert.ErrorContains(t, err, "set queue_depth rejected: env label required")
+		_, exists := m.Family("queue_depth")
+		assert.False(t, exists)
+		assert.Equal(t, []string{"set queue_depth set queue_depth rejected: env label required"}, audited)
+	})
+
+	t.Run("after hooks see the outcome", func(t *testing.T) {
+		m := parseTest(t)
+		var results []error
+		m.Use(Hook{After: func(op Operation, err error) { results = append(results, err) }})
+
+		require.NoError(t, m.Observe("backup_duration_seconds", nil, 0.5))
+		assert.Error(t, m.Inc("queue_depth", nil, 1))
+		require.Len(t, results, 2)
+		assert.NoError(t, results[0])
+		assert.ErrorContains(t, results[1], "not a counter")
+	})
+}
+
+func TestStoreHooks(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.prom")
+	require.NoError(t, os.WriteFile(filename, nil, 0644))
+
+	var seen []Operation
+	store := NewStore(filename, StoreOptions{Hooks: []Hook{{
+		Before: func(op *Operation) error {
+			if strings.HasPrefix(op.Name, "debug_") {
+				return errors.New("debug metrics aren't exported")
+			}
+			return nil
+		},
+		After: func(op Operation, err error) {
+			if err == nil {
+				seen = append(seen, op)
+			}
+		},
+	}}})
+
+	require.NoError(t, store.Inc("jobs_total", nil, 1))
+	require.NoError(t, store.Inc("jobs_total", nil, 2))
+	store.Set("debug_cache_size", nil, 10)
+	assert.ErrorContains(t, store.Close(), "debug metrics aren't exported")
+
+	require.Len(t, seen, 1, "self-monitoring metrics bypass the hooks")
+	assert.Equal(t, Operation{Kind: "inc", Name: "jobs_total", Labels: map[string]string{}, Value: 3}, seen[0])
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "jobs_total 3")
+	assert.NotContains(t, string(content), "debug_cache_size")
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
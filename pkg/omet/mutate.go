@@ -0,0 +1,145 @@
+package omet
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultBuckets are the histogram buckets used when none are given, the
+// same as the omet command's.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// New returns an empty set of metrics.
+func New() *Metrics {
+	return &Metrics{families: make(map[string]*dto.MetricFamily)}
+}
+
+// Inc adds delta to a counter series, creating it at zero if needed.
+func (m *Metrics) Inc(name string, labels map[string]string, delta float64) error {
+	return m.run(Operation{Kind: "inc", Name: name, Labels: labels, Value: delta}, func(op Operation) error {
+		if op.Value < 0 {
+			return fmt.Errorf("counter %s can't be decreased (delta %g)", op.Name, op.Value)
+		}
+		metric, err := m.series(op.Name, op.Labels, dto.MetricType_COUNTER)
+		if err != nil {
+			return err
+		}
+		metric.Counter = &dto.Counter{Value: proto.Float64(metric.GetCounter().GetValue() + op.Value)}
+		return nil
+	})
+}
+
+// Set sets a gauge series.
+func (m *Metrics) Set(name string, labels map[string]string, value float64) error {
+	return m.run(Operation{Kind: "set", Name: name, Labels: labels, Value: value}, func(op Operation) error {
+		metric, err := m.series(op.Name, op.Labels, dto.MetricType_GAUGE)
+		if err != nil {
+			return err
+		}
+		metric.Gauge = &dto.Gauge{Value: proto.Float64(op.Value)}
+		return nil
+	})
+}
+
+// Observe adds an observation to a histogram series. A new series gets the
+// given buckets, or DefaultBuckets if none are given; an existing series
+// keeps its own.
+func (m *Metrics) Observe(name string, labels map[string]string, value float64, buckets ...float64) error {
+	return m.run(Operation{Kind: "observe", Name: name, Labels: labels, Value: value}, func(op Operation) error {
+		metric, err := m.series(op.Name, op.Labels, dto.MetricType_HISTOGRAM)
+		if err != nil {
+			return err
+		}
+
+		if metric.Histogram == nil {
+			if len(buckets) == 0 {
+				buckets = DefaultBuckets
+			}
+			metric.Histogram = newHistogram(buckets)
+		}
+
+		histogram := metric.Histogram
+		histogram.SampleCount = proto.Uint64(histogram.GetSampleCount() + 1)
+		histogram.SampleSum = proto.Float64(histogram.GetSampleSum() + op.Value)
+		for _, bucket := range histogram.Bucket {
+			if op.Value <= bucket.GetUpperBound() {
+				bucket.CumulativeCount = proto.Uint64(bucket.GetCumulativeCount() + 1)
+			}
+		}
+		return nil
+	})
+}
+
+func newHistogram(buckets []float64) *dto.Histogram {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	if len(bounds) == 0 || !math.IsInf(bounds[len(bounds)-1], 1) {
+		bounds = append(bounds, math.Inf(1))
+	}
+
+	histogram := &dto.Histogram{SampleCount: proto.Uint64(0), SampleSum: proto.Float64(0)}
+	for _, bound := range bounds {
+		histogram.Bucket = append(histogram.Bucket, &dto.Bucket{
+			UpperBound:      proto.Float64(bound),
+			CumulativeCount: proto.Uint64(0),
+		})
+	}
+	return histogram
+}
+
+// series finds or creates the series with exactly the given labels in a
+// family of the expected type.
+func (m *Metrics) series(name string, labels map[string]string, metricType dto.MetricType) (*dto.Metric, error) {
+	family, exists := m.families[name]
+	if !exists {
+		typeName := typeString(metricType)
+		family = &dto.MetricFamily{
+			Name: proto.String(name),
+			Help: proto.String(fmt.Sprintf("%s metric %s", strings.ToUpper(typeName[:1])+typeName[1:], name)),
+			Type: metricType.Enum(),
+		}
+		m.families[name] = family
+	}
+	if family.GetType() != metricType {
+		return nil, fmt.Errorf("metric %s is not a %s (type: %s)", name, typeString(metricType), typeString(family.GetType()))
+	}
+
+	for _, metric := range family.Metric {
+		if hasLabels(metric, labels) {
+			return metric, nil
+		}
+	}
+
+	metric := &dto.Metric{}
+	names := make([]string, 0, len(labels))
+	for key := range labels {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(key), Value: proto.String(labels[key])})
+	}
+	family.Metric = append(family.Metric, metric)
+	return metric, nil
+}
+
+func hasLabels(metric *dto.Metric, labels map[string]string) bool {
+	if len(metric.Label) != len(labels) {
+		return false
+	}
+	for _, label := range metric.Label {
+		if value, ok := labels[label.GetName()]; !ok || value != label.GetValue() {
+			return false
+		}
+	}
+	return true
+}
+
+func typeString(metricType dto.MetricType) string {
+	return strings.ToLower(metricType.String())
+}
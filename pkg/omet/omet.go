@@ -0,0 +1,261 @@
+// Package omet is a small library for reading, inspecting, and rewriting
+// Prometheus text-format metrics files, built on the same parser and writer
+// as the omet command. It hides the protobuf types behind Family and Series
+// so tooling such as pruners or exporters doesn't depend on them.
+package omet
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"omet/internal/metricsfile"
+	"omet/internal/selector"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Type is a metric family type as written in TYPE lines.
+type Type string
+
+const (
+	Counter   Type = "counter"
+	Gauge     Type = "gauge"
+	Histogram Type = "histogram"
+	Summary   Type = "summary"
+	Untyped   Type = "untyped"
+)
+
+// Metrics is a parsed set of metric families.
+type Metrics struct {
+	families map[string]*dto.MetricFamily
+	hooks    []Hook
+}
+
+// Parse reads metrics in Prometheus text format.
+func Parse(r io.Reader) (*Metrics, error) {
+	families, err := metricsfile.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Metrics{families: families}, nil
+}
+
+// ParseFile reads a metrics file without locking it.
+func ParseFile(filename string) (*Metrics, error) {
+	families, err := metricsfile.ParseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Metrics{families: families}, nil
+}
+
+// Write serializes the metrics in Prometheus text format.
+func (m *Metrics) Write(w io.Writer) error {
+	return metricsfile.Write(m.families, w)
+}
+
+// Family returns the family with the given name.
+func (m *Metrics) Family(name string) (Family, bool) {
+	family, ok := m.families[name]
+	return Family{family: family}, ok
+}
+
+// ForEachFamily calls fn for every family in name order. A non-nil error
+// from fn stops the iteration and is returned.
+func (m *Metrics) ForEachFamily(fn func(Family) error) error {
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := fn(Family{family: m.families[name]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachSeries calls fn for every series, families in name order and
+// series in file order. A non-nil error from fn stops the iteration and is
+// returned.
+func (m *Metrics) ForEachSeries(fn func(Series) error) error {
+	return m.ForEachFamily(func(f Family) error {
+		return f.ForEachSeries(fn)
+	})
+}
+
+// Find returns the series of a family matching a PromQL-style selector such
+// as `{job="backup",env=~"prod|staging"}`. An empty selector matches all
+// series of the family.
+func (m *Metrics) Find(name, sel string) ([]Series, error) {
+	matchers, err := selector.Parse(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	family, ok := m.families[name]
+	if !ok {
+		return nil, nil
+	}
+
+	var found []Series
+	for _, metric := range family.Metric {
+		if matchers.Matches(metric.Label) {
+			found = append(found, Series{family: family, metric: metric})
+		}
+	}
+	return found, nil
+}
+
+// Lookup finds series by a single query like `backups_total{job="backup"}`.
+func (m *Metrics) Lookup(query string) ([]Series, error) {
+	name, sel := query, ""
+	if i := strings.IndexByte(query, '{'); i >= 0 {
+		name, sel = query[:i], query[i:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("invalid query %q: missing metric name", query)
+	}
+	return m.Find(name, sel)
+}
+
+// Remove deletes every series for which drop returns true, dropping families
+// left empty, and returns the number of series removed.
+func (m *Metrics) Remove(drop func(Series) bool) int {
+	removed := 0
+	for name, family := range m.families {
+		kept := family.Metric[:0]
+		for _, metric := range family.Metric {
+			if drop(Series{family: family, metric: metric}) {
+				removed++
+				continue
+			}
+			kept = append(kept, metric)
+		}
+		family.Metric = kept
+		if len(kept) == 0 {
+			delete(m.families, name)
+		}
+	}
+	return removed
+}
+
+// Family is a named group of series sharing a type and help text.
+type Family struct {
+	family *dto.MetricFamily
+}
+
+func (f Family) Name() string { return f.family.GetName() }
+func (f Family) Help() string { return f.family.GetHelp() }
+func (f Family) Unit() string { return f.family.GetUnit() }
+func (f Family) Len() int     { return len(f.family.Metric) }
+
+func (f Family) Type() Type {
+	return Type(typeString(f.family.GetType()))
+}
+
+// ForEachSeries calls fn for every series of the family in file order.
+func (f Family) ForEachSeries(fn func(Series) error) error {
+	for _, metric := range f.family.Metric {
+		if err := fn(Series{family: f.family, metric: metric}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Series is one labeled time series of a family.
+type Series struct {
+	family *dto.MetricFamily
+	metric *dto.Metric
+}
+
+// Family returns the family the series belongs to.
+func (s Series) Family() Family { return Family{family: s.family} }
+
+// Name returns the family name.
+func (s Series) Name() string { return s.family.GetName() }
+
+// Labels returns a copy of the series' labels.
+func (s Series) Labels() map[string]string {
+	labels := make(map[string]string, len(s.metric.Label))
+	for _, label := range s.metric.Label {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}
+
+// Label returns the value of one label, or "" if it isn't set.
+func (s Series) Label(name string) string {
+	for _, label := range s.metric.Label {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+// Value returns the value of a counter, gauge, or untyped series, and NaN
+// for histograms and summaries, which have no single value.
+func (s Series) Value() float64 {
+	switch s.family.GetType() {
+	case dto.MetricType_COUNTER:
+		return s.metric.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		return s.metric.GetGauge().GetValue()
+	case dto.MetricType_UNTYPED:
+		return s.metric.GetUntyped().GetValue()
+	default:
+		return math.NaN()
+	}
+}
+
+// Count returns the sample count of a histogram or summary series.
+func (s Series) Count() uint64 {
+	if s.metric.Histogram != nil {
+		return s.metric.GetHistogram().GetSampleCount()
+	}
+	return s.metric.GetSummary().GetSampleCount()
+}
+
+// Sum returns the sample sum of a histogram or summary series.
+func (s Series) Sum() float64 {
+	if s.metric.Histogram != nil {
+		return s.metric.GetHistogram().GetSampleSum()
+	}
+	return s.metric.GetSummary().GetSampleSum()
+}
+
+// Bucket is a cumulative histogram bucket.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Buckets returns the cumulative buckets of a histogram series.
+func (s Series) Buckets() []Bucket {
+	var buckets []Bucket
+	for _, bucket := range s.metric.GetHistogram().GetBucket() {
+		buckets = append(buckets, Bucket{UpperBound: bucket.GetUpperBound(), Count: bucket.GetCumulativeCount()})
+	}
+	return buckets
+}
+
+// String renders the series as name{labels} with labels sorted by name.
+func (s Series) String() string {
+	var parts []string
+	for _, label := range s.metric.Label {
+		parts = append(parts, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+	}
+	sort.Strings(parts)
+	if len(parts) == 0 {
+		return s.Name()
+	}
+	return s.Name() + "{" + strings.Join(parts, ",") + "}"
+}
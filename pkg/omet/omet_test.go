@@ -0,0 +1,146 @@
+package omet
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMetrics = `# HELP backups_total Total backups
+# TYPE backups_total counter
+backups_total{job="backup",env="prod"} 7
+backups_total{job="restore",env="prod"} 2
+backups_total{job="backup",env="dev"} 1
+# TYPE backup_duration_seconds histogram
+backup_duration_seconds_bucket{le="1"} 1
+backup_duration_seconds_bucket{le="+Inf"} 3
+backup_duration_seconds_sum 12.5
+backup_duration_seconds_count 3
+# TYPE queue_depth gauge
+queue_depth 4
+`
+
+func parseTest(t *testing.T) *Metrics {
+	m, err := Parse(strings.NewReader(testMetrics))
+	require.NoError(t, err)
+	return m
+}
+
+func TestForEach(t *testing.T) {
+	m := parseTest(t)
+
+	var families []string
+	require.NoError(t, m.ForEachFamily(func(f Family) error {
+		families = append(families, string(f.Type())+":"+f.Name())
+		return nil
+	}))
+	assert.Equal(t, []string{"histogram:backup_duration_seconds", "counter:backups_total", "gauge:queue_depth"}, families)
+
+	var series []string
+	require.NoError(t, m.ForEachSeries(func(s Series) error {
+		series = append(series, s.String())
+		return nil
+	}))
+	assert.Equal(t, []string{
+		"backup_duration_seconds",
+		`backups_total{env="prod",job="backup"}`,
+		`backups_total{env="prod",job="restore"}`,
+		`backups_total{env="dev",job="backup"}`,
+		"queue_depth",
+	}, series)
+
+	stop := errors.New("stop")
+	visited := 0
+	err := m.ForEachSeries(func(Series) error {
+		visited++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, visited)
+}
+
+func TestFindAndLookup(t *testing.T) {
+	m := parseTest(t)
+
+	found, err := m.Find("backups_total", `{job="backup"}`)
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "prod", found[0].Label("env"))
+	assert.Equal(t, 7.0, found[0].Value())
+
+	found, err = m.Lookup(`backups_total{env="prod",job=~"re.*"}`)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, map[string]string{"job": "restore", "env": "prod"}, found[0].Labels())
+
+	found, err = m.Lookup("backup_duration_seconds")
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.True(t, math.IsNaN(found[0].Value()))
+	assert.Equal(t, uint64(3), found[0].Count())
+	assert.Equal(t, 12.5, found[0].Sum())
+	assert.Equal(t, []Bucket{{1, 1}, {math.Inf(1), 3}}, found[0].Buckets())
+
+	found, err = m.Lookup("missing_metric")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	_, err = m.Lookup(`{job="backup"}`)
+	assert.Error(t, err)
+	_, err = m.Find("backups_total", `job=backup`)
+	assert.Error(t, err)
+
+	family, ok := m.Family("backups_total")
+	require.True(t, ok)
+	assert.Equal(t, "Total backups", family.Help())
+	assert.Equal(t, 3, family.Len())
+}
+
+func TestRemove(t *testing.T) {
+	m := parseTest(t)
+
+	removed := m.Remove(func(s Series) bool {
+		return s.Label("env") == "dev" || s.Name() == "queue_depth"
+	})
+	assert.Equal(t, 2, removed)
+
+	_, ok := m.Family("queue_depth")
+	assert.False(t, ok, "empty families are dropped")
+
+	var buf bytes.Buffer
+	require.NoError(t, m.Write(&buf))
+	assert.NotContains(t, buf.String(), `env="dev"`)
+	assert.Contains(t, buf.String(), `backups_total{job="backup",env="prod"} 7`)
+}
+
+func TestMutate(t *testing.T) {
+	m := parseTest(t)
+
+	require.NoError(t, m.Inc("backups_total", map[string]string{"job": "backup", "env": "prod"}, 3))
+	require.NoError(t, m.Set("queue_depth", nil, 9))
+	require.NoError(t, m.Observe("backup_duration_seconds", nil, 0.5))
+	require.NoError(t, m.Observe("new_seconds", nil, 2, 1, 5))
+
+	assert.Error(t, m.Inc("backups_total", nil, -1))
+	assert.ErrorContains(t, m.Set("backups_total", nil, 1), "is not a gauge")
+
+	found, _ := m.Lookup(`backups_total{job="backup",env="prod"}`)
+	assert.Equal(t, 10.0, found[0].Value())
+	found, _ = m.Lookup("queue_depth")
+	assert.Equal(t, 9.0, found[0].Value())
+	found, _ = m.Lookup("backup_duration_seconds")
+	assert.Equal(t, []Bucket{{1, 2}, {math.Inf(1), 4}}, found[0].Buckets(), "existing buckets are kept")
+	found, _ = m.Lookup("new_seconds")
+	assert.Equal(t, []Bucket{{1, 0}, {5, 1}, {math.Inf(1), 1}}, found[0].Buckets())
+
+	fresh := New()
+	require.NoError(t, fresh.Inc("jobs_total", nil, 1))
+	var buf bytes.Buffer
+	require.NoError(t, fresh.Write(&buf))
+	assert.Contains(t, buf.String(), "# TYPE jobs_total counter\njobs_total 1\n")
+}
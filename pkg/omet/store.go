@@ -0,0 +1,327 @@
+package omet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"omet/internal/metricsfile"
+)
+
+// StoreOptions configures a Store.
+type StoreOptions struct {
+	// FlushInterval flushes pending updates periodically; 0 flushes only on
+	// Flush and Close.
+	FlushInterval time.Duration
+
+	// FlushMaxOps triggers a flush once this many updates arrived since the
+	// last one, so a burst doesn't wait out the whole interval; 0 disables
+	// it. It isn't a cap: the flush writes everything pending by then.
+	FlushMaxOps int
+
+	// LockTimeout bounds how long a flush waits for the file lock
+	// (default 30s).
+	LockTimeout time.Duration
+
+	// Buckets are used for histograms the store creates (default
+	// DefaultBuckets).
+	Buckets []float64
+
+	// OnError receives errors from periodic flushes, which have no caller
+	// to return them to.
+	OnError func(error)
+
+	// Hooks run around each update as it is applied to the file during a
+	// flush, after increments to a series are summed. Rejected updates are
+	// dropped and reported by Flush.
+	Hooks []Hook
+}
+
+// storeShards is the number of independently locked shards pending updates
+// are spread over, so updates to different families don't contend.
+const storeShards = 32
+
+// Store accumulates updates in memory and applies them to a metrics file
+// under its lock when flushed. Updates are merged into the file's current
+// contents rather than overwriting it, so other writers such as the omet
+// command can share the file. A Store is safe for concurrent use; pending
+// updates are sharded by family name so goroutines updating different
+// families don't serialize on one mutex.
+type Store struct {
+	filename string
+	opts     StoreOptions
+	shards   [storeShards]shard
+	ops      atomic.Int64 // updates since the last flush
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// shard holds the pending updates for the families hashed to it.
+type shard struct {
+	mu      sync.Mutex
+	pending []*update
+	index   map[string]*update // coalesces Inc and Set per series
+}
+
+type update struct {
+	op     string
+	name   string
+	labels map[string]string
+	value  float64
+	values []float64 // observations
+}
+
+// NewStore returns a store for filename and starts its flush loop if
+// FlushInterval or FlushMaxOps is set. Call Close to stop it and flush
+// what's left.
+func NewStore(filename string, opts StoreOptions) *Store {
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = 30 * time.Second
+	}
+	s := &Store{
+		filename: filename,
+		opts:     opts,
+	}
+	for i := range s.shards {
+		s.shards[i].index = make(map[string]*update)
+	}
+
+	if opts.FlushInterval > 0 || opts.FlushMaxOps > 0 {
+		s.kick = make(chan struct{}, 1)
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.loop()
+	}
+	return s
+}
+
+func (s *Store) loop() {
+	defer close(s.done)
+	var tick <-chan time.Time
+	if s.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(s.opts.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-s.kick:
+		case <-s.stop:
+			return
+		}
+		if err := s.Flush(); err != nil && s.opts.OnError != nil {
+			s.opts.OnError(err)
+		}
+	}
+}
+
+// counted records an update and wakes the flush loop once FlushMaxOps
+// updates arrived since the last flush. Every update past the threshold
+// tries again, so a burst that arrives while a flush is running gets one
+// of its own right after.
+func (s *Store) counted() {
+	if s.opts.FlushMaxOps > 0 && s.ops.Add(1) >= int64(s.opts.FlushMaxOps) {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Inc adds delta to a counter.
+func (s *Store) Inc(name string, labels map[string]string, delta float64) error {
+	if delta < 0 {
+		return fmt.Errorf("counter %s can't be decreased (delta %g)", name, delta)
+	}
+	key := seriesKey(name, labels)
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.coalesce("inc", key, name, labels).value += delta
+	s.counted()
+	return nil
+}
+
+// Set sets a gauge; only the last value before a flush is written.
+func (s *Store) Set(name string, labels map[string]string, value float64) {
+	key := seriesKey(name, labels)
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.coalesce("set", key, name, labels).value = value
+	s.counted()
+}
+
+// Observe adds an observation to a histogram.
+func (s *Store) Observe(name string, labels map[string]string, value float64) {
+	key := seriesKey(name, labels)
+	sh := s.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u := sh.coalesce("observe", key, name, labels)
+	u.values = append(u.values, value)
+	s.counted()
+}
+
+// shard returns the shard holding updates for a family.
+func (s *Store) shard(name string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return &s.shards[h.Sum32()%storeShards]
+}
+
+// coalesce returns the pending update for an operation on the series with
+// the given key. The caller must hold the shard's lock; the key is built
+// before taking it to keep the critical section short.
+func (sh *shard) coalesce(op, key, name string, labels map[string]string) *update {
+	key = op + "\x00" + key
+	if u, ok := sh.index[key]; ok {
+		return u
+	}
+
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	u := &update{op: op, name: name, labels: copied}
+	sh.pending = append(sh.pending, u)
+	sh.index[key] = u
+	return u
+}
+
+func seriesKey(name string, labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for k, v := range labels {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return name + "{" + strings.Join(parts, "\x00") + "}"
+}
+
+// Flush applies pending updates to the file under its lock. Updates that
+// don't fit the file, such as an Inc on a gauge, are dropped and reported
+// in the returned error; the rest are still written. If the file can't be
+// read or written, the updates are kept for the next flush, whose hooks
+// see them again.
+func (s *Store) Flush() error {
+	s.ops.Store(0)
+	var pending []*update
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		pending = append(pending, sh.pending...)
+		sh.pending = nil
+		sh.index = make(map[string]*update)
+		sh.mu.Unlock()
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	lock, err := metricsfile.NewFileLock(s.filename, s.opts.LockTimeout)
+	if err != nil {
+		s.requeue(pending)
+		return err
+	}
+	defer lock.Close()
+
+	if err := lock.Lock(context.Background()); err != nil {
+		s.requeue(pending)
+		return err
+	}
+
+	families, err := metricsfile.Parse(lock.File())
+	if err != nil {
+		s.requeue(pending)
+		return fmt.Errorf("refusing to rewrite unparseable %s: %w", s.filename, err)
+	}
+	m := &Metrics{families: families, hooks: s.opts.Hooks}
+
+	var errs []error
+	var applied []*update
+	for _, u := range pending {
+		var err error
+		switch u.op {
+		case "inc":
+			err = m.Inc(u.name, u.labels, u.value)
+		case "set":
+			err = m.Set(u.name, u.labels, u.value)
+		case "observe":
+			for _, value := range u.values {
+				if err = m.Observe(u.name, u.labels, value, s.opts.Buckets...); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		applied = append(applied, u)
+	}
+
+	// Keep OMET's self-monitoring metrics current for omet-healthcheck
+	m.hooks = nil
+	m.Set("omet_last_write", nil, float64(time.Now().Unix()))
+	m.Inc("omet_modifications_total", nil, 1)
+
+	if err := lock.RewriteWithChecksum(func(file *os.File) error { return m.Write(file) }); err != nil {
+		s.requeue(applied)
+		return fmt.Errorf("failed to write %s: %w", s.filename, err)
+	}
+	return errors.Join(errs...)
+}
+
+// requeue puts updates back in front of anything added since, after a flush
+// that couldn't read or write the file.
+func (s *Store) requeue(pending []*update) {
+	byShard := make(map[*shard][]*update)
+	for _, u := range pending {
+		sh := s.shard(u.name)
+		byShard[sh] = append(byShard[sh], u)
+	}
+
+	for sh, failed := range byShard {
+		sh.mu.Lock()
+		newer := sh.pending
+		sh.pending = nil
+		sh.index = make(map[string]*update)
+		for _, u := range append(failed, newer...) {
+			merged := sh.coalesce(u.op, seriesKey(u.name, u.labels), u.name, u.labels)
+			switch u.op {
+			case "inc":
+				merged.value += u.value
+			case "set":
+				merged.value = u.value
+			case "observe":
+				merged.values = append(merged.values, u.values...)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Close stops the flush loop and flushes pending updates.
+func (s *Store) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	return s.Flush()
+}
@@ -0,0 +1,233 @@
+package omet
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreFlush(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("# TYPE jobs_total counter\njobs_total{job=\"a\"} 10\n# TYPE other gauge\nother 1\n"), 0644))
+
+	store := NewStore(filename, StoreOptions{Buckets: []float64{1, 5}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, store.Inc("jobs_total", map[string]string{"job": "a"}, 1))
+		}()
+	}
+	wg.Wait()
+	store.Set("queue_depth", nil, 3)
+	store.Set("queue_depth", nil, 7)
+	store.Observe("job_seconds", nil, 0.5)
+	store.Observe("job_seconds", nil, 3)
+	assert.Error(t, store.Inc("jobs_total", nil, -1))
+
+	require.NoError(t, store.Flush())
+
+	m, err := ParseFile(filename)
+	require.NoError(t, err)
+
+	found, err := m.Lookup(`jobs_total{job="a"}`)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, 20.0, found[0].Value(), "increments are merged into the file's value")
+
+	found, _ = m.Lookup("queue_depth")
+	assert.Equal(t, 7.0, found[0].Value(), "last set wins")
+
+	found, _ = m.Lookup("job_seconds")
+	assert.Equal(t, uint64(2), found[0].Count())
+	assert.Len(t, found[0].Buckets(), 3, "configured buckets plus +Inf")
+
+	found, _ = m.Lookup("other")
+	assert.Len(t, found, 1, "series the store doesn't know are kept")
+
+	found, _ = m.Lookup("omet_last_write")
+	assert.Len(t, found, 1)
+
+	t.Run("flush with nothing pending leaves the file alone", func(t *testing.T) {
+		before, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		require.NoError(t, store.Flush())
+		after, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("type mismatches are reported, other updates still land", func(t *testing.T) {
+		store.Set("jobs_total", nil, 1)
+		store.Set("queue_depth", nil, 9)
+		assert.ErrorContains(t, store.Flush(), "is not a gauge")
+
+		m, err := ParseFile(filename)
+		require.NoError(t, err)
+		found, _ := m.Lookup("queue_depth")
+		assert.Equal(t, 9.0, found[0].Value())
+	})
+}
+
+func TestStoreRequeuesOnFailure(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("not a metrics file {\n"), 0644))
+
+	store := NewStore(filename, StoreOptions{})
+	require.NoError(t, store.Inc("jobs_total", nil, 2))
+	assert.ErrorContains(t, store.Flush(), "unparseable")
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "not a metrics file {\n", string(content), "unparseable files are never rewritten")
+
+	require.NoError(t, os.WriteFile(filename, nil, 0644))
+	require.NoError(t, store.Inc("jobs_total", nil, 3))
+	require.NoError(t, store.Flush())
+
+	m, err := ParseFile(filename)
+	require.NoError(t, err)
+	found, _ := m.Lookup("jobs_total")
+	require.Len(t, found, 1)
+	assert.Equal(t, 5.0, found[0].Value(), "updates from the failed flush are kept")
+}
+
+func TestStoreRequeuesOnWriteFailure(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.prom")
+	require.NoError(t, os.WriteFile(filename, []byte("# TYPE queue_depth gauge\nqueue_depth 1\n"), 0644))
+
+	// A file size limit makes the file unwritable even for root, which
+	// ignores permissions; Go ignores SIGXFSZ, so writes fail with EFBIG
+	var limit syscall.Rlimit
+	require.NoError(t, syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit))
+	restore := func() { require.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit)) }
+	require.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: 16, Max: limit.Max}))
+	defer restore()
+
+	store := NewStore(filename, StoreOptions{})
+	require.NoError(t, store.Inc("jobs_total", nil, 2))
+	require.NoError(t, store.Inc("queue_depth", nil, 1)) // rejected: queue_depth is a gauge
+	err := store.Flush()
+	restore()
+	assert.ErrorContains(t, err, "failed to write")
+
+	require.NoError(t, os.WriteFile(filename, nil, 0644))
+	require.NoError(t, store.Inc("jobs_total", nil, 3))
+	require.NoError(t, store.Flush(), "rejected updates aren't retried")
+
+	m, err := ParseFile(filename)
+	require.NoError(t, err)
+	found, _ := m.Lookup("jobs_total")
+	require.Len(t, found, 1)
+	assert.Equal(t, 5.0, found[0].Value(), "updates from the failed write are kept")
+}
+
+func TestStoreFlushInterval(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.prom")
+	store := NewStore(filename, StoreOptions{FlushInterval: 10 * time.Millisecond})
+	defer store.Close()
+
+	store.Set("up", nil, 1)
+	assert.Eventually(t, func() bool {
+		m, err := ParseFile(filename)
+		if err != nil {
+			return false
+		}
+		found, _ := m.Lookup("up")
+		return len(found) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	store.Set("up", nil, 0)
+	require.NoError(t, store.Close())
+	m, err := ParseFile(filename)
+	require.NoError(t, err)
+	found, _ := m.Lookup("up")
+	assert.Equal(t, 0.0, found[0].Value(), "close flushes what's left")
+}
+
+func TestStoreConcurrentFamilies(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.prom")
+	store := NewStore(filename, StoreOptions{})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			name := fmt.Sprintf("family_%d_total", g%10)
+			for i := 0; i < 100; i++ {
+				require.NoError(t, store.Inc(name, nil, 1))
+			}
+		}(g)
+	}
+	wg.Wait()
+	require.NoError(t, store.Flush())
+
+	m, err := ParseFile(filename)
+	require.NoError(t, err)
+	for f := 0; f < 10; f++ {
+		found, _ := m.Lookup(fmt.Sprintf("family_%d_total", f))
+		require.Len(t, found, 1)
+		assert.Equal(t, 500.0, found[0].Value())
+	}
+}
+
+// BenchmarkStoreInc measures concurrent increments spread over a varying
+// number of families. Run with -cpu=1,4,8 to see how throughput scales
+// once updates land in different shards.
+func BenchmarkStoreInc(b *testing.B) {
+	for _, families := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("families=%d", families), func(b *testing.B) {
+			store := NewStore(filepath.Join(b.TempDir(), "app.prom"), StoreOptions{})
+			names := make([]string, families)
+			for i := range names {
+				names[i] = fmt.Sprintf("family_%d_total", i)
+			}
+			labels := map[string]string{"job": "bench"}
+
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				name := names[int(next.Add(1))%families]
+				for pb.Next() {
+					if err := store.Inc(name, labels, 1); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestStoreFlushMaxOps(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.prom")
+	store := NewStore(filename, StoreOptions{FlushMaxOps: 5})
+	defer store.Close()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, store.Inc("jobs_total", nil, 1))
+	}
+	time.Sleep(20 * time.Millisecond)
+	_, err := os.Stat(filename)
+	assert.True(t, os.IsNotExist(err), "no flush below the threshold")
+
+	require.NoError(t, store.Inc("jobs_total", nil, 1))
+	assert.Eventually(t, func() bool {
+		m, err := ParseFile(filename)
+		if err != nil {
+			return false
+		}
+		found, _ := m.Lookup("jobs_total")
+		return len(found) == 1 && found[0].Value() == 5
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// exitReadOnly is the exit status when a target was on a read-only
+// filesystem and its metrics went to stdout instead.
+const exitReadOnly = 4
+
+// readOnlyError marks a target whose write was diverted to stdout. Like
+// verifyError, it isn't a cli.ExitCoder so the run finishes normally.
+type readOnlyError struct {
+	filename string
+}
+
+func (e *readOnlyError) Error() string {
+	return fmt.Sprintf("%s is on a read-only filesystem; metrics were written to stdout", e.filename)
+}
+
+// readOnlyFS reports whether filename, or its directory if it doesn't exist
+// yet, is on a read-only filesystem, as during an emergency remount. It's a
+// variable so tests can simulate one.
+var readOnlyFS = func(filename string) bool {
+	path := filename
+	if _, err := os.Stat(path); err != nil {
+		path = filepath.Dir(filename)
+	}
+	const wOK = 2 // W_OK, which the syscall package doesn't export
+	return errors.Is(syscall.Access(path, wOK), syscall.EROFS)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// simulateReadOnly makes readOnlyFS report filenames as read-only until the
+// test ends.
+func simulateReadOnly(t *testing.T, filenames ...string) {
+	original := readOnlyFS
+	t.Cleanup(func() { readOnlyFS = original })
+	readOnlyFS = func(filename string) bool {
+		for _, f := range filenames {
+			if f == filename {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestReadOnlyFilesystem(t *testing.T) {
+	t.Run("writes to stdout instead", func(t *testing.T) {
+		testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total 5\n")
+		simulateReadOnly(t, testFile)
+
+		var err error
+		output := captureOutput(t, func() {
+			err = createTestApp().Run([]string{"omet", "-i", "-q", "-f", testFile, "jobs_total", "inc"})
+		})
+		var readOnlyErr *readOnlyError
+		require.True(t, errors.As(err, &readOnlyErr), "got %v", err)
+		assert.Equal(t, testFile, readOnlyErr.filename)
+		assert.Contains(t, output, "jobs_total 6")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, "# TYPE jobs_total counter\njobs_total 5\n", string(content))
+	})
+
+	t.Run("a missing file starts empty", func(t *testing.T) {
+		testFile := filepath.Join(t.TempDir(), "metrics.prom")
+		simulateReadOnly(t, testFile)
+
+		var err error
+		output := captureOutput(t, func() {
+			err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "jobs_total", "inc"})
+		})
+		assert.ErrorContains(t, err, "read-only filesystem")
+		assert.Contains(t, output, "jobs_total 1")
+		assert.NoFileExists(t, testFile)
+	})
+
+	t.Run("other files are still written", func(t *testing.T) {
+		readOnly := createTempFile(t, "")
+		writable := createTempFile(t, "")
+		simulateReadOnly(t, readOnly)
+
+		captureOutput(t, func() {
+			err := createTestApp().Run([]string{"omet", "-i", "-f", readOnly, "-f", writable, "jobs_total", "inc"})
+			assert.Error(t, err)
+		})
+		content, err := os.ReadFile(writable)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "jobs_total 1")
+	})
+}
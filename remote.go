@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"omet/internal/metricsfile"
+	"omet/internal/objstore"
+
+	"github.com/urfave/cli/v2"
+)
+
+// remoteObject is a target kept in object storage. Instead of a lock it
+// remembers the version it read, and the write is a conditional put
+// against that version.
+type remoteObject struct {
+	store   objstore.Store
+	key     string
+	version string // "" when the object didn't exist
+	loaded  bool
+}
+
+// get fetches the object, treating a missing one as empty.
+func (r *remoteObject) get() ([]byte, error) {
+	data, version, err := r.store.Get(context.Background(), r.key)
+	if errors.Is(err, objstore.ErrNotExist) {
+		data, version, err = nil, "", nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.version, r.loaded = version, true
+	return data, nil
+}
+
+// put writes data back if the object is unchanged since get.
+func (r *remoteObject) put(data []byte) error {
+	return r.store.PutIf(context.Background(), r.key, data, r.version)
+}
+
+// processRemoteTarget runs processTarget until its conditional put
+// succeeds, starting over from a fresh read each time another writer got
+// there first. It gives up after --lock-timeout.
+func processRemoteTarget(ctx *cli.Context, t *target, req *request, argErrors *ErrorCollector) error {
+	deadline := time.Now().Add(ctx.Duration("lock-timeout"))
+	for attempt := 1; ; attempt++ {
+		// Each attempt counts its own new series
+		req.newSeries.forget(t.filename)
+		err := processTarget(ctx, t, req)
+		if !errors.Is(err, objstore.ErrConflict) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gave up on %s after %d attempts: %w", t.filename, attempt, err)
+		}
+		if req.verbose {
+			log.Printf("%s changed while updating, retrying (attempt %d)", t.filename, attempt+1)
+		}
+		time.Sleep(min(time.Duration(attempt)*50*time.Millisecond, time.Second))
+		t.errors = argErrors.clone()
+	}
+}
+
+// writeRemote writes data unconditionally, for outputs that replace the
+// object outright such as merge --output.
+func writeRemote(rawURL string, write func(io.Writer) error) error {
+	store, key, err := objstore.Open(rawURL)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	return store.Put(context.Background(), key, metricsfile.AppendChecksum(buf.Bytes()))
+}
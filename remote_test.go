@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"omet/internal/metricsfile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGCS serves a single-bucket subset of the GCS JSON API. interfere is
+// called before each upload is checked, to simulate a concurrent writer.
+type fakeGCS struct {
+	mu         sync.Mutex
+	data       map[string]string
+	generation map[string]int
+	interfere  func(f *fakeGCS, key string)
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method == http.MethodGet {
+		key := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")
+		data, ok := f.data[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Goog-Generation", strconv.Itoa(f.generation[key]))
+		io.WriteString(w, data)
+		return
+	}
+
+	key := r.URL.Query().Get("name")
+	if f.interfere != nil {
+		f.interfere(f, key)
+	}
+	if match := r.URL.Query().Get("ifGenerationMatch"); match != "" && match != strconv.Itoa(f.generation[key]) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.data[key] = string(body)
+	f.generation[key]++
+}
+
+func TestRemoteTarget(t *testing.T) {
+	fake := &fakeGCS{data: map[string]string{}, generation: map[string]int{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", server.URL)
+
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", "gs://bucket/jobs.prom", "jobs_total", "inc"}))
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", "gs://bucket/jobs.prom", "jobs_total", "inc"}))
+	assert.Contains(t, fake.data["jobs.prom"], "jobs_total 2\n")
+	assert.True(t, metricsfile.Verify([]byte(fake.data["jobs.prom"])), "objects get a checksum trailer")
+
+	t.Run("retries from a fresh read on conflict", func(t *testing.T) {
+		// Another writer bumps the counter just before our first upload
+		fake.interfere = func(f *fakeGCS, key string) {
+			f.data[key] = "# TYPE jobs_total counter\njobs_total 10\n"
+			f.generation[key]++
+			f.interfere = nil
+		}
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", "gs://bucket/jobs.prom", "jobs_total", "inc"}))
+		assert.Contains(t, fake.data["jobs.prom"], "jobs_total 11\n")
+	})
+
+	t.Run("a retry doesn't count new series twice", func(t *testing.T) {
+		// The first attempt creates the series, but so did the writer it lost
+		// to, so only b.prom's series counts against the limit
+		fake.interfere = func(f *fakeGCS, key string) {
+			f.data[key] = "# TYPE retried_total counter\nretried_total 10\n"
+			f.generation[key]++
+			f.interfere = nil
+		}
+		require.NoError(t, createTestApp().Run([]string{"omet", "--max-new-series-per-run", "1", "-i",
+			"-f", "gs://bucket/a.prom", "-f", "gs://bucket/b.prom", "retried_total", "inc"}))
+		assert.Contains(t, fake.data["a.prom"], "retried_total 11\n")
+		assert.Contains(t, fake.data["b.prom"], "retried_total 1\n")
+	})
+
+	t.Run("gives up after lock timeout", func(t *testing.T) {
+		fake.interfere = func(f *fakeGCS, key string) { f.generation[key]++ }
+		defer func() { fake.interfere = nil }()
+		err := createTestApp().Run([]string{"omet", "--lock-timeout", "100ms", "-i", "-f", "gs://bucket/jobs.prom", "jobs_total", "inc"})
+		assert.ErrorContains(t, err, "gave up on gs://bucket/jobs.prom")
+	})
+
+	t.Run("merge output", func(t *testing.T) {
+		input := createTempFile(t, "# TYPE up gauge\nup 1\n")
+		require.NoError(t, createTestApp().Run([]string{"omet", "merge", "-o", "gs://bucket/merged.prom", input}))
+		assert.Contains(t, fake.data["merged.prom"], "up 1\n")
+	})
+}
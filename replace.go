@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// withReplace returns req with a delete appended for every series of the
+// families it updates that it doesn't update itself, so that with
+// --replace only the series given in this run remain.
+func (req *request) withReplace(families map[string]*dto.MetricFamily) *request {
+	if !req.replace {
+		return req
+	}
+
+	updates := req.updates()
+	kept := make(map[string]bool)
+	var names []string
+	for _, u := range updates {
+		if !slices.Contains(names, u.metricName) {
+			names = append(names, u.metricName)
+		}
+		kept[formatSeries(u.metricName, changedLabels(u))] = true
+	}
+	sort.Strings(names)
+
+	var stale []*request
+	for _, name := range names {
+		for _, metric := range families[name].GetMetric() {
+			labels := labelMap(metric)
+			if !kept[formatSeries(name, labels)] {
+				stale = append(stale, &request{metricName: name, operation: "delete", labels: labels, values: []float64{0}, verbose: req.verbose})
+			}
+		}
+	}
+	if len(stale) == 0 {
+		return req
+	}
+
+	expanded := *req
+	expanded.batch = append(slices.Clone(updates), stale...)
+	return &expanded
+}
+
+func replaceGroupCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "replace-group",
+		Usage: "Replace every series of a label group with an exposition read from stdin",
+		Description: `Works like a Pushgateway push: every series in the file carrying all the
+-l grouping labels is removed, across all families, and the series read
+from stdin are added with the grouping labels set, under one lock. Families
+left without series are dropped. Series on stdin may omit the grouping
+labels but not give them other values.
+
+When a family's TYPE or HELP differs from the file's, --conflict decides as
+for omet merge.
+
+Examples:
+  omet replace-group -f /var/lib/node_exporter/jobs.prom -l job=backup < backup.prom
+  ./collect.sh | omet replace-group -f jobs.prom -l job=collect -l host=db1`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Metrics file to replace the group in, created if missing",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:     "label",
+				Aliases:  []string{"l"},
+				Usage:    "Grouping label in KEY=VALUE format (can be repeated)",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "conflict",
+				Usage: "Policy for conflicting TYPE or HELP: error, first, last, or untyped",
+				Value: conflictError,
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for file lock",
+			},
+		},
+		Action: runReplaceGroup,
+	}
+}
+
+func runReplaceGroup(ctx *cli.Context) error {
+	group, err := parseLabels(ctx.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+	policy := ctx.String("conflict")
+	if err := validateConflictPolicy(policy); err != nil {
+		return err
+	}
+
+	pushed, err := metricsfile.Parse(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to parse stdin: %w", err)
+	}
+	added := 0
+	for name, family := range pushed {
+		for _, metric := range family.Metric {
+			if err := addGroupLabels(metric, group); err != nil {
+				return fmt.Errorf("%s: %w", formatSeries(name, labelMap(metric)), err)
+			}
+			added++
+		}
+	}
+
+	filename := ctx.String("file")
+	lock, err := metricsfile.NewFileLock(filename, ctx.Duration("lock-timeout"))
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+	defer lock.Close()
+	if err := lock.Lock(context.Background()); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	families, err := metricsfile.Parse(lock.File())
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	removed := removeGroup(families, group)
+	if err := mergeFamilies(families, pushed, policy, nil); err != nil {
+		return err
+	}
+
+	if err := lock.RewriteWithChecksum(func(file *os.File) error {
+		return writeMetrics(families, file)
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("%s: removed %d series, added %d\n", formatSeries("", group), removed, added)
+	return nil
+}
+
+// addGroupLabels sets the grouping labels on a pushed series, refusing ones
+// it already has with another value.
+func addGroupLabels(metric *dto.Metric, group map[string]string) error {
+	names := make([]string, 0, len(group))
+	for name := range group {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		have := false
+		for _, label := range metric.Label {
+			if label.GetName() == name {
+				if label.GetValue() != group[name] {
+					return fmt.Errorf("label %s=%q conflicts with grouping label %s=%q", name, label.GetValue(), name, group[name])
+				}
+				have = true
+			}
+		}
+		if !have {
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: stringPtr(name), Value: stringPtr(group[name])})
+		}
+	}
+	return nil
+}
+
+// removeGroup deletes every series carrying all the grouping labels, and
+// families left without series, returning how many series it removed.
+func removeGroup(families map[string]*dto.MetricFamily, group map[string]string) int {
+	removed := 0
+	for name, family := range families {
+		kept := family.Metric[:0]
+		for _, metric := range family.Metric {
+			if hasLabels(metric.Label, group) {
+				removed++
+			} else {
+				kept = append(kept, metric)
+			}
+		}
+		family.Metric = kept
+		if len(kept) == 0 {
+			delete(families, name)
+		}
+	}
+	return removed
+}
+
+func labelMap(metric *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(metric.Label))
+	for _, label := range metric.Label {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplace(t *testing.T) {
+	const content = `# TYPE disk_free_bytes gauge
+disk_free_bytes{mount="/"} 100
+disk_free_bytes{mount="/old"} 50
+disk_free_bytes 7
+# TYPE up gauge
+up 1
+`
+
+	t.Run("single update", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--replace", "-l", "mount=/", "disk_free_bytes", "set", "90"}))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		require.Len(t, families["disk_free_bytes"].Metric, 1)
+		value, _ := seriesValue(families, "disk_free_bytes", map[string]string{"mount": "/"})
+		assert.Equal(t, 90.0, value)
+		assert.Contains(t, families, "up", "other families are left alone")
+	})
+
+	t.Run("line protocol", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		defer mockStdin(t, "disk_free_bytes{mount=\"/\"} set 80\ndisk_free_bytes{mount=\"/data\"} set 300\n")()
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--replace"}))
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.Len(t, families["disk_free_bytes"].Metric, 2)
+		_, ok := seriesValue(families, "disk_free_bytes", map[string]string{"mount": "/old"})
+		assert.False(t, ok)
+		_, ok = seriesValue(families, "disk_free_bytes", map[string]string{})
+		assert.False(t, ok)
+	})
+}
+
+func TestReplaceGroup(t *testing.T) {
+	const content = `# TYPE backup_size_bytes gauge
+backup_size_bytes{job="backup",db="a"} 100
+backup_size_bytes{job="backup",db="b"} 200
+backup_size_bytes{job="other",db="a"} 5
+# TYPE backup_stale_total counter
+backup_stale_total{job="backup"} 3
+`
+
+	t.Run("replaces the group across families", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		defer mockStdin(t, "# TYPE backup_size_bytes gauge\nbackup_size_bytes{db=\"a\"} 150\n# TYPE backup_runs_total counter\nbackup_runs_total 1\n")()
+
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "replace-group", "-f", testFile, "-l", "job=backup"}))
+		})
+		assert.Equal(t, "{job=\"backup\"}: removed 3 series, added 2\n", output)
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.Len(t, families["backup_size_bytes"].Metric, 2)
+		value, _ := seriesValue(families, "backup_size_bytes", map[string]string{"job": "backup", "db": "a"})
+		assert.Equal(t, 150.0, value)
+		value, _ = seriesValue(families, "backup_size_bytes", map[string]string{"job": "other", "db": "a"})
+		assert.Equal(t, 5.0, value)
+		value, _ = seriesValue(families, "backup_runs_total", map[string]string{"job": "backup"})
+		assert.Equal(t, 1.0, value)
+		assert.NotContains(t, families, "backup_stale_total")
+	})
+
+	t.Run("refuses conflicting grouping labels", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		defer mockStdin(t, "backup_runs_total{job=\"other\"} 1\n")()
+
+		err := createTestApp().Run([]string{"omet", "replace-group", "-f", testFile, "-l", "job=backup"})
+		assert.ErrorContains(t, err, "conflicts with grouping label")
+	})
+
+	t.Run("refuses type conflicts", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		defer mockStdin(t, "# TYPE backup_size_bytes counter\nbackup_size_bytes 1\n")()
+
+		err := createTestApp().Run([]string{"omet", "replace-group", "-f", testFile, "-l", "job=backup"})
+		assert.ErrorContains(t, err, "conflicting types")
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// parseScopeLabel parses --scope-label KEY=VALUE, expanding templates in
+// the value. An empty spec yields an empty name.
+func parseScopeLabel(spec string) (name, value string, err error) {
+	if spec == "" {
+		return "", "", nil
+	}
+	labels, err := parseLabels([]string{spec})
+	if err == nil {
+		err = expandLabelTemplates(labels)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("invalid --scope-label: %w", err)
+	}
+	for name, value := range labels {
+		return name, value, nil
+	}
+	return "", "", nil
+}
+
+// withScopeCleanup returns req with a delete appended for every series in
+// families that carries the --scope-label name with a value from another
+// run.
+func (req *request) withScopeCleanup(families map[string]*dto.MetricFamily) *request {
+	if req.scopeName == "" {
+		return req
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var stale []*request
+	for _, name := range names {
+		for _, metric := range families[name].Metric {
+			labels := make(map[string]string, len(metric.Label))
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if value, ok := labels[req.scopeName]; ok && value != req.scopeValue {
+				stale = append(stale, &request{metricName: name, operation: "delete", labels: labels, values: []float64{0}, verbose: req.verbose})
+			}
+		}
+	}
+	if len(stale) == 0 {
+		return req
+	}
+
+	expanded := *req
+	expanded.batch = append(slices.Clone(req.updates()), stale...)
+	return &expanded
+}
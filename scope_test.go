@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopeLabel(t *testing.T) {
+	testFile := createTempFile(t, `# TYPE report_rows gauge
+report_rows{run_id="r1",table="a"} 10
+report_rows{run_id="r1",table="b"} 20
+# TYPE report_errors_total counter
+report_errors_total{run_id="r1"} 2
+# TYPE up gauge
+up 1
+`)
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--scope-label", "run_id=r2", "-l", "table=a", "report_rows", "set", "11"})
+	require.NoError(t, err)
+
+	families, err := parseMetrics(mustOpen(t, testFile))
+	require.NoError(t, err)
+	value, ok := seriesValue(families, "report_rows", map[string]string{"run_id": "r2", "table": "a"})
+	assert.True(t, ok)
+	assert.Equal(t, 11.0, value)
+	assert.Len(t, families["report_rows"].Metric, 1, "series of the previous run are deleted")
+	assert.NotContains(t, families, "report_errors_total")
+	assert.Contains(t, families, "up", "series without the label are kept")
+
+	t.Run("applies to line protocol", func(t *testing.T) {
+		defer mockStdin(t, "report_rows{table=\"b\"} set 21\n")()
+		require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--scope-label", "run_id=r3"}))
+
+		data, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(data), "report_rows{"))
+		assert.NotContains(t, string(data), `run_id="r2"`)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--scope-label", "run_id", "up", "set", "1"})
+		assert.Error(t, err)
+	})
+}
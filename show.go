@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// ANSI styles used by show
+const (
+	ansiReset   = "\033[0m"
+	ansiBold    = "\033[1m"
+	ansiDim     = "\033[2m"
+	ansiRed     = "\033[31m"
+	ansiGreen   = "\033[32m"
+	ansiYellow  = "\033[33m"
+	ansiMagenta = "\033[35m"
+	ansiCyan    = "\033[36m"
+)
+
+var typeColors = map[dto.MetricType]string{
+	dto.MetricType_COUNTER:         ansiGreen,
+	dto.MetricType_GAUGE:           ansiCyan,
+	dto.MetricType_HISTOGRAM:       ansiMagenta,
+	dto.MetricType_GAUGE_HISTOGRAM: ansiMagenta,
+	dto.MetricType_SUMMARY:         ansiYellow,
+	dto.MetricType_UNTYPED:         ansiDim,
+}
+
+func showCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "show",
+		Usage: "Pretty-print a metrics file for reading in a terminal",
+		Description: `Prints families sorted by name with a type badge and HELP text, followed
+by their series with aligned, humanized values (1.2M instead of 1234567).
+Histograms and summaries show their count and sum. Use the exposition
+format (omet grep, or the file itself) when exact values matter.
+
+Colors are used when stdout is a terminal and NO_COLOR isn't set.
+
+Examples:
+  omet show -f /var/lib/node_exporter/app.prom
+  omet show -f app.prom --color always | less -R`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "Metrics file to show (default: stdin)",
+				Value:   "-",
+			},
+			&cli.StringFlag{
+				Name:  "color",
+				Usage: "When to use colors: auto, always, or never",
+				Value: "auto",
+			},
+			&cli.BoolFlag{
+				Name:  "exact",
+				Usage: "Print exact values instead of humanized ones",
+			},
+			encryptKeyFlag(),
+		},
+		Action: runShow,
+	}
+}
+
+func runShow(ctx *cli.Context) error {
+	var color bool
+	switch ctx.String("color") {
+	case "auto":
+		color = os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	case "always":
+		color = true
+	case "never":
+	default:
+		return fmt.Errorf("invalid --color: %s (supported: auto, always, never)", ctx.String("color"))
+	}
+
+	key, err := loadEncryptKey(ctx)
+	if err != nil {
+		return err
+	}
+	filename := ctx.String("file")
+	var input io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", filename, err)
+		}
+		defer file.Close()
+		input = file
+	}
+	families, err := parseSealed(input, key)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	format := humanizeValue
+	if ctx.Bool("exact") {
+		format = func(value float64) string {
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+	}
+	printFamilies(os.Stdout, families, color, format)
+	return nil
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printFamilies renders families for humans: a header per family, then one
+// line per series with values aligned in a column.
+func printFamilies(w io.Writer, families map[string]*dto.MetricFamily, color bool, format func(float64) string) {
+	style := func(code, text string) string {
+		if !color {
+			return text
+		}
+		return code + text + ansiReset
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		family := families[name]
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		badge := fmt.Sprintf("%-9s", typeName(family))
+		header := style(typeColors[family.GetType()], badge) + " " + style(ansiBold, name)
+		if unit := family.GetUnit(); unit != "" {
+			header += " (" + unit + ")"
+		}
+		if help := family.GetHelp(); help != "" {
+			header += "  " + style(ansiDim, help)
+		}
+		fmt.Fprintln(w, header)
+
+		rows := make([][2]string, len(family.Metric))
+		width := 0
+		for j, metric := range family.Metric {
+			rows[j] = [2]string{labelString(metric.Label), seriesSummary(family.GetType(), metric, format)}
+			width = max(width, len(rows[j][0]))
+		}
+		sort.Slice(rows, func(a, b int) bool { return rows[a][0] < rows[b][0] })
+
+		for _, row := range rows {
+			value := row[1]
+			if value == "NaN" || value == "+Inf" || value == "-Inf" {
+				value = style(ansiRed, value)
+			}
+			fmt.Fprintf(w, "  %-*s  %s\n", width, row[0], value)
+		}
+	}
+}
+
+// labelString renders labels as {a="1",b="2"} in name order, or {} for none.
+func labelString(labels []*dto.LabelPair) string {
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+	}
+	sort.Strings(parts)
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// seriesSummary is the value column for one series.
+func seriesSummary(metricType dto.MetricType, metric *dto.Metric, format func(float64) string) string {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return format(metric.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return format(metric.GetGauge().GetValue())
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h := metric.GetHistogram()
+		return fmt.Sprintf("count=%s sum=%s", format(float64(h.GetSampleCount())), format(h.GetSampleSum()))
+	case dto.MetricType_SUMMARY:
+		s := metric.GetSummary()
+		parts := []string{"count=" + format(float64(s.GetSampleCount())), "sum=" + format(s.GetSampleSum())}
+		for _, q := range s.GetQuantile() {
+			parts = append(parts, fmt.Sprintf("q%g=%s", q.GetQuantile(), format(q.GetValue())))
+		}
+		return strings.Join(parts, " ")
+	default:
+		return format(metric.GetUntyped().GetValue())
+	}
+}
+
+// humanizeValue shortens large values with K/M/G/T suffixes and small ones
+// to four significant digits.
+func humanizeValue(value float64) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+
+	abs := math.Abs(value)
+	if abs < 1000 {
+		return strconv.FormatFloat(value, 'g', 4, 64)
+	}
+	if abs >= 1e15 {
+		return strconv.FormatFloat(value, 'g', 3, 64)
+	}
+	for _, unit := range []struct {
+		size   float64
+		suffix string
+	}{{1e12, "T"}, {1e9, "G"}, {1e6, "M"}, {1e3, "K"}} {
+		if abs >= unit.size {
+			scaled := strconv.FormatFloat(value/unit.size, 'f', 1, 64)
+			return strings.TrimSuffix(scaled, ".0") + unit.suffix
+		}
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHumanizeValue(t *testing.T) {
+	for value, want := range map[float64]string{
+		0:          "0",
+		0.123456:   "0.1235",
+		42:         "42",
+		999:        "999",
+		1000:       "1K",
+		1234567:    "1.2M",
+		-2500:      "-2.5K",
+		3.2e9:      "3.2G",
+		7e12:       "7T",
+		1e18:       "1e+18",
+		math.NaN(): "NaN",
+	} {
+		assert.Equal(t, want, humanizeValue(value), "humanizeValue(%g)", value)
+	}
+	assert.Equal(t, "+Inf", humanizeValue(math.Inf(1)))
+}
+
+func TestShow(t *testing.T) {
+	families, err := parseMetrics(strings.NewReader(`# HELP requests_total Requests served
+# TYPE requests_total counter
+requests_total{code="200",method="GET"} 1234567
+requests_total{code="500",method="GET"} 3
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 4.5
+latency_seconds_count 3
+`))
+	require.NoError(t, err)
+
+	var plain bytes.Buffer
+	printFamilies(&plain, families, false, humanizeValue)
+	assert.Equal(t, `histogram latency_seconds
+  {}  count=3 sum=4.5
+
+counter   requests_total  Requests served
+  {code="200",method="GET"}  1.2M
+  {code="500",method="GET"}  3
+`, plain.String())
+
+	var colored bytes.Buffer
+	printFamilies(&colored, families, true, humanizeValue)
+	assert.Contains(t, colored.String(), ansiGreen+"counter  "+ansiReset)
+
+	output := captureOutput(t, func() {
+		testFile := createTempFile(t, "# TYPE up gauge\nup 1234567\n")
+		require.NoError(t, createTestApp().Run([]string{"omet", "show", "-f", testFile, "--exact"}))
+	})
+	assert.Contains(t, output, "1234567")
+	assert.NotContains(t, output, "\033[", "no colors when stdout isn't a terminal")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"omet/internal/metricsfile"
+	"omet/internal/remotewrite"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sinkTimeout bounds each request a network sink makes, as notifyTimeout
+// does for --notify-url.
+const sinkTimeout = 10 * time.Second
+
+// sink is an extra output given with --sink. It receives each target's
+// metrics after they were written: all of them, and the families the run
+// changed, which is what network sinks send upstream.
+type sink interface {
+	send(all, changed map[string]*dto.MetricFamily, now time.Time) error
+	String() string
+}
+
+// parseSink parses a --sink spec: stdout, or KIND=DEST for the file,
+// pushgateway, remote-write, and http sinks.
+func parseSink(spec string) (sink, error) {
+	if spec == "stdout" {
+		return stdoutSink{}, nil
+	}
+	kind, dest, ok := strings.Cut(spec, "=")
+	if !ok || dest == "" {
+		return nil, fmt.Errorf("invalid --sink %q: expected stdout, file=PATH, pushgateway=URL, remote-write=URL, or http=URL", spec)
+	}
+	switch kind {
+	case "file":
+		return fileSink{path: dest}, nil
+	case "pushgateway", "remote-write", "http":
+		if !isHTTPURL(dest) {
+			return nil, fmt.Errorf("invalid --sink %q: %s needs an http:// or https:// URL", spec, kind)
+		}
+		if kind == "pushgateway" && !strings.Contains(dest, "/metrics/job/") {
+			return nil, fmt.Errorf("invalid --sink %q: a Pushgateway URL must name the group, e.g. http://host:9091/metrics/job/NAME", spec)
+		}
+		return httpSink{kind: kind, url: dest}, nil
+	default:
+		return nil, fmt.Errorf("invalid --sink %q: unknown kind %q (supported: stdout, file, pushgateway, remote-write, http)", spec, kind)
+	}
+}
+
+// parseSinks parses every --sink flag.
+func parseSinks(specs []string) ([]sink, error) {
+	var sinks []sink
+	for _, spec := range specs {
+		s, err := parseSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// stdoutSink writes all metrics to stdout, for in-place runs that should
+// also feed a pipeline.
+type stdoutSink struct{}
+
+func (stdoutSink) send(all, _ map[string]*dto.MetricFamily, _ time.Time) error {
+	return metricsfile.Write(all, os.Stdout)
+}
+
+func (stdoutSink) String() string { return "stdout" }
+
+// fileSink keeps a copy of all metrics in another file, replaced through a
+// rename so readers never see it half-written.
+type fileSink struct {
+	path string
+}
+
+func (s fileSink) send(all, _ map[string]*dto.MetricFamily, _ time.Time) error {
+	var buf bytes.Buffer
+	if err := metricsfile.Write(all, &buf); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s fileSink) String() string { return "file=" + s.path }
+
+// httpSink POSTs the changed families: in the text format to a Pushgateway
+// group or any other endpoint, or as a remote-write request.
+type httpSink struct {
+	kind string // pushgateway, remote-write, or http
+	url  string
+}
+
+func (s httpSink) send(_, changed map[string]*dto.MetricFamily, now time.Time) error {
+	if len(changed) == 0 {
+		return nil
+	}
+	if s.kind == "pushgateway" {
+		for name, family := range changed {
+			if family.GetType() == dto.MetricType_GAUGE_HISTOGRAM {
+				return fmt.Errorf("the Pushgateway can't parse gauge histograms such as %s", name)
+			}
+		}
+	}
+	var body bytes.Buffer
+	contentType := "text/plain; version=0.0.4"
+	if s.kind == "remote-write" {
+		body.Write(remotewrite.Encode(remotewrite.Flatten(changed), now))
+		contentType = "application/x-protobuf"
+	} else if err := metricsfile.Write(changed, &body); err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	if s.kind == "remote-write" {
+		httpReq.Header.Set("Content-Encoding", "snappy")
+		httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	}
+	client := &http.Client{Timeout: sinkTimeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s httpSink) String() string { return s.kind + "=" + s.url }
+
+// changedFamilies returns the families of families that updates changed,
+// whole, since a Pushgateway POST replaces every series of each family it
+// carries. Families the run deleted are left out.
+func changedFamilies(families map[string]*dto.MetricFamily, updates []*request) map[string]*dto.MetricFamily {
+	changed := make(map[string]*dto.MetricFamily)
+	for _, u := range updates {
+		name := u.metricName
+		if u.operation == "rename" {
+			name = u.newName
+		}
+		if family, ok := families[name]; ok && len(family.GetMetric()) > 0 {
+			changed[name] = family
+		}
+	}
+	return changed
+}
+
+// sendToSinks sends a target's metrics to every --sink. Each sink is tried
+// even if an earlier one failed; the failures are returned together.
+func sendToSinks(sinks []sink, families map[string]*dto.MetricFamily, updates []*request, verbose bool) error {
+	changed := changedFamilies(families, updates)
+	now := timeProvider.Now()
+	var failed []string
+	for _, s := range sinks {
+		if err := s.send(families, changed, now); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", s, err))
+			continue
+		}
+		if verbose {
+			log.Printf("Sent metrics to sink %s", s)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send to sinks: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSink(t *testing.T) {
+	for _, spec := range []string{"stdout", "file=/tmp/copy.prom", "pushgateway=http://gw:9091/metrics/job/backup", "remote-write=https://prom/api/v1/write", "http=http://collector/ingest"} {
+		s, err := parseSink(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, spec, s.String())
+	}
+
+	for _, spec := range []string{"", "file", "file=", "kafka=broker:9092", "http=collector/ingest", "pushgateway=http://gw:9091/"} {
+		_, err := parseSink(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	type received struct {
+		path, contentType, encoding, body string
+	}
+	var requests []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests = append(requests, received{r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"), string(body)})
+	}))
+	defer server.Close()
+
+	testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total{job=\"backup\"} 4\n# TYPE queue_depth gauge\nqueue_depth 12\n")
+	copyFile := filepath.Join(t.TempDir(), "copy.prom")
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "job=backup",
+		"--sink", "pushgateway=" + server.URL + "/metrics/job/backup",
+		"--sink", "remote-write=" + server.URL + "/api/v1/write",
+		"--sink", "file=" + copyFile,
+		"jobs_total", "inc"})
+	require.NoError(t, err)
+
+	// The file sink gets everything
+	copied, err := parseMetrics(mustOpen(t, copyFile))
+	require.NoError(t, err)
+	value, _ := seriesValue(copied, "jobs_total", map[string]string{"job": "backup"})
+	assert.Equal(t, 5.0, value)
+	value, _ = seriesValue(copied, "queue_depth", nil)
+	assert.Equal(t, 12.0, value)
+
+	// Network sinks get only the changed family
+	require.Len(t, requests, 2)
+	assert.Equal(t, "/metrics/job/backup", requests[0].path)
+	assert.Equal(t, "text/plain; version=0.0.4", requests[0].contentType)
+	assert.Contains(t, requests[0].body, "jobs_total{job=\"backup\"} 5")
+	assert.NotContains(t, requests[0].body, "queue_depth")
+
+	assert.Equal(t, "/api/v1/write", requests[1].path)
+	assert.Equal(t, "application/x-protobuf", requests[1].contentType)
+	assert.Equal(t, "snappy", requests[1].encoding)
+	assert.Contains(t, requests[1].body, "jobs_total")
+	assert.NotContains(t, requests[1].body, "queue_depth")
+}
+
+func TestPushgatewaySinkRefusesGaugeHistograms(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer server.Close()
+
+	testFile := createTempFile(t, "")
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--type", "gaugehistogram",
+		"--sink", "pushgateway=" + server.URL + "/metrics/job/queue",
+		"queue_age_seconds", "observe-buckets", "+Inf:1"})
+	assert.ErrorContains(t, err, "can't parse gauge histograms such as queue_age_seconds")
+	assert.Zero(t, calls)
+}
+
+func TestSinkFailure(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	testFile := createTempFile(t, "# TYPE queue_depth gauge\nqueue_depth 12\n")
+	copyFile := filepath.Join(t.TempDir(), "copy.prom")
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile,
+		"--sink", "http=" + server.URL, "--sink", "file=" + copyFile,
+		"queue_depth", "set", "20"})
+	assert.ErrorContains(t, err, "503")
+	assert.Equal(t, 1, calls)
+
+	// The file was written, and later sinks were still tried
+	families, err := parseMetrics(mustOpen(t, testFile))
+	require.NoError(t, err)
+	value, _ := seriesValue(families, "queue_depth", nil)
+	assert.Equal(t, 20.0, value)
+	assert.FileExists(t, copyFile)
+}
+
+func TestSinksSkippedOnFailedOperation(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer server.Close()
+
+	testFile := createTempFile(t, "# TYPE jobs_total counter\njobs_total 4\n")
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--sink", "http=" + server.URL, "jobs_total", "set", "1"})
+	assert.Error(t, err)
+	assert.Zero(t, calls)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// parsedSizeFactor estimates parsed memory from text size: protobuf
+// structs, pointers, and maps take several times the bytes of the text.
+const parsedSizeFactor = 4
+
+// maxSpillPartitions bounds the temporary files a spilled merge keeps open.
+const maxSpillPartitions = 256
+
+// spillPartitions returns how many partitions a merge of inputs totalling
+// size bytes needs to stay within budget, or 0 if it fits in memory.
+func spillPartitions(size, budget int64) int {
+	estimate := size * parsedSizeFactor
+	if budget <= 0 || estimate <= budget {
+		return 0
+	}
+	// Twice the minimum, since families don't hash into equal shares
+	parts := int((2*estimate + budget - 1) / budget)
+	return min(parts, maxSpillPartitions)
+}
+
+// inputSize sums the sizes of the input files.
+func inputSize(filenames []string) (int64, error) {
+	var total int64
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %w", filename, err)
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// segment is one input file's share of a partition file.
+type segment struct {
+	start, end int64
+}
+
+// mergeSpilled merges files whose parsed form wouldn't fit in memory. Each
+// input is streamed into partition files by family, then the partitions are
+// merged one at a time, in argument order within each, into a temporary
+// result that is copied to output once everything merged cleanly. Peak
+// memory is roughly one partition's worth of families.
+func mergeSpilled(filenames []string, parts int, policy string, strategies *mergeStrategies, output func(io.Reader) error, peak *peakMemory) error {
+	dir, err := os.MkdirTemp("", "omet-merge-")
+	if err != nil {
+		return fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := make([]*os.File, parts)
+	writers := make([]*bufio.Writer, parts)
+	sizes := make([]int64, parts)
+	for i := range files {
+		if files[i], err = os.Create(fmt.Sprintf("%s/part-%03d", dir, i)); err != nil {
+			return fmt.Errorf("failed to create spill file: %w", err)
+		}
+		defer files[i].Close()
+		writers[i] = bufio.NewWriter(files[i])
+	}
+
+	// Pass 1: stream every input into the partitions, noting where each
+	// input's lines start and end in every partition file
+	segments := make([][]segment, parts)
+	for _, filename := range filenames {
+		starts := append([]int64(nil), sizes...)
+		if err := splitFile(filename, parts, func(part int, line []byte) error {
+			sizes[part] += int64(len(line))
+			_, err := writers[part].Write(line)
+			return err
+		}); err != nil {
+			return err
+		}
+		for part := range segments {
+			segments[part] = append(segments[part], segment{starts[part], sizes[part]})
+		}
+		peak.sample()
+	}
+	for _, w := range writers {
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to write spill file: %w", err)
+		}
+	}
+
+	result, err := os.Create(dir + "/result")
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %w", err)
+	}
+	defer result.Close()
+	resultWriter := bufio.NewWriter(result)
+
+	// Pass 2: merge one partition at a time
+	for part, file := range files {
+		merged := make(map[string]*dto.MetricFamily)
+		for i, seg := range segments[part] {
+			if seg.start == seg.end {
+				continue
+			}
+			families, err := metricsfile.Parse(io.NewSectionReader(file, seg.start, seg.end-seg.start))
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", filenames[i], err)
+			}
+			if err := mergeFamilies(merged, families, policy, strategies); err != nil {
+				return fmt.Errorf("failed to merge %s: %w", filenames[i], err)
+			}
+		}
+		peak.sample()
+		if err := writeMetrics(merged, resultWriter); err != nil {
+			return err
+		}
+	}
+	if err := resultWriter.Flush(); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+
+	if _, err := result.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return output(result)
+}
+
+func splitFile(filename string, parts int, emit func(int, []byte) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	if err := metricsfile.SplitFamilies(file, parts, emit); err != nil {
+		return fmt.Errorf("failed to split %s: %w", filename, err)
+	}
+	return nil
+}
+
+// peakMemory tracks the largest heap seen at sample points.
+type peakMemory struct {
+	enabled bool
+	heap    uint64
+}
+
+func (p *peakMemory) sample() {
+	if p == nil || !p.enabled {
+		return
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	p.heap = max(p.heap, stats.HeapAlloc)
+}
+
+func (p *peakMemory) report() {
+	if p != nil && p.enabled {
+		log.Printf("Peak heap during merge: %.1f MiB", float64(p.heap)/(1<<20))
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// parseSplay parses a --splay range, "MIN-MAX" or just "MAX" for 0-MAX.
+func parseSplay(spec string) (time.Duration, time.Duration, error) {
+	low, high, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		low, high = "0s", spec
+	}
+	if low == "0" {
+		low = "0s"
+	}
+	min, err := time.ParseDuration(low)
+	if err == nil {
+		var max time.Duration
+		if max, err = time.ParseDuration(high); err == nil && min >= 0 && max >= min {
+			return min, max, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid --splay %q: expected a range such as 0-30s or 5s-1m", spec)
+}
+
+// splayDelay picks a delay in [min, max) from host, so each host waits the
+// same amount on every run while a fleet spreads evenly across the range.
+func splayDelay(host string, min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(host))
+	return min + time.Duration(hash.Sum64()%uint64(max-min))
+}
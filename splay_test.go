@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSplay(t *testing.T) {
+	min, max, err := parseSplay("0-30s")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), min)
+	assert.Equal(t, 30*time.Second, max)
+
+	min, max, err = parseSplay("5s-1m")
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, min)
+	assert.Equal(t, time.Minute, max)
+
+	_, max, err = parseSplay("30s")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, max)
+
+	for _, spec := range []string{"", "soon", "30s-5s", "0-", "-5s-1s"} {
+		_, _, err := parseSplay(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestSplayDelay(t *testing.T) {
+	assert.Equal(t, splayDelay("web-1", 0, 30*time.Second), splayDelay("web-1", 0, 30*time.Second), "deterministic per host")
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		delay := splayDelay(fmt.Sprintf("web-%d", i), 5*time.Second, 30*time.Second)
+		assert.GreaterOrEqual(t, delay, 5*time.Second)
+		assert.Less(t, delay, 30*time.Second)
+		seen[delay] = true
+	}
+	assert.Greater(t, len(seen), 90, "hosts spread across the range")
+
+	assert.Equal(t, 5*time.Second, splayDelay("web-1", 5*time.Second, 5*time.Second))
+}
+
+func TestSplayFlag(t *testing.T) {
+	testFile := createTempFile(t, "")
+
+	require.NoError(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--splay", "0-10ms", "jobs_total", "inc"}))
+	content, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "jobs_total 1")
+
+	assert.ErrorContains(t, createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--splay", "soon", "jobs_total", "inc"}), "invalid --splay")
+}
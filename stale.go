@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// staleSuffix names the family delete --mark-stale records deleted series
+// in: backup_age_seconds_stale for backup_age_seconds.
+const staleSuffix = "_stale"
+
+// staleHelp is the help of a marker family, which is how omet tells its
+// markers from a family that merely ends in _stale.
+func staleHelp(name string) string {
+	return fmt.Sprintf("Unix time %s series were deleted, set by delete --mark-stale", name)
+}
+
+// isStaleMarker reports whether family holds omet's stale markers.
+func isStaleMarker(family *dto.MetricFamily) bool {
+	base, ok := strings.CutSuffix(family.GetName(), staleSuffix)
+	return ok && family.GetHelp() == staleHelp(base)
+}
+
+// markStale records that the series of family with labels was deleted at
+// now, so consumers can tell a deleted series from one a failed run simply
+// didn't write. Deleting a marker doesn't mark it in turn.
+func markStale(families map[string]*dto.MetricFamily, family *dto.MetricFamily, labels map[string]string, now time.Time) error {
+	if isStaleMarker(family) {
+		return nil
+	}
+	name := family.GetName() + staleSuffix
+	markers, exists := families[name]
+	if exists && !isStaleMarker(markers) {
+		return fmt.Errorf("%s already exists and isn't a stale marker", name)
+	}
+	if !exists {
+		markers = createMetricFamily(name, dto.MetricType_GAUGE)
+		markers.Help = stringPtr(staleHelp(family.GetName()))
+		families[name] = markers
+	}
+	metric := findOrCreateMetric(markers, labels)
+	metric.Gauge = &dto.Gauge{Value: float64Ptr(float64(now.Unix()))}
+	return nil
+}
+
+// clearStale drops the marker of a series that has been written again.
+func clearStale(families map[string]*dto.MetricFamily, name string, labels map[string]string) {
+	markers, exists := families[name+staleSuffix]
+	if !exists || !isStaleMarker(markers) {
+		return
+	}
+	removeSeries(markers, labels)
+	if len(markers.Metric) == 0 {
+		delete(families, markers.GetName())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkStale(t *testing.T) {
+	const initial = "# TYPE backup_age_seconds gauge\nbackup_age_seconds{job=\"old\"} 10\nbackup_age_seconds{job=\"new\"} 20\n"
+	run := func(t *testing.T, args ...string) {
+		t.Helper()
+		require.NoError(t, createTestApp().Run(append([]string{"omet", "--now", "1700000000", "-i"}, args...)))
+	}
+
+	t.Run("marks deleted series until they return", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		run(t, "-f", testFile, "--mark-stale", "-l", "job=old", "backup_age_seconds", "delete")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		_, exists := seriesValue(families, "backup_age_seconds", map[string]string{"job": "old"})
+		assert.False(t, exists)
+		value, exists := seriesValue(families, "backup_age_seconds_stale", map[string]string{"job": "old"})
+		assert.True(t, exists)
+		assert.Equal(t, 1700000000.0, value)
+
+		// Deleting the markers doesn't mark them
+		run(t, "-f", testFile, "--mark-stale", "-l", "job=old", "backup_age_seconds_stale", "delete")
+		families, err = parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.NotContains(t, families, "backup_age_seconds_stale")
+		assert.NotContains(t, families, "backup_age_seconds_stale_stale")
+	})
+
+	t.Run("clears the marker when the series is written again", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		run(t, "-f", testFile, "--mark-stale", "-l", "job=old", "backup_age_seconds", "delete")
+		run(t, "-f", testFile, "-l", "job=old", "backup_age_seconds", "set", "5")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.NotContains(t, families, "backup_age_seconds_stale")
+	})
+
+	t.Run("leaves other _stale families alone", func(t *testing.T) {
+		testFile := createTempFile(t, initial+"# TYPE backup_age_seconds_stale counter\nbackup_age_seconds_stale{job=\"old\"} 3\n")
+		run(t, "-f", testFile, "-l", "job=old", "backup_age_seconds", "set", "5")
+		run(t, "-f", testFile, "--mark-stale", "-l", "job=new", "backup_age_seconds", "delete")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `backup_age_seconds_stale{job="old"} 3`)
+		assert.NotContains(t, string(content), `backup_age_seconds_stale{job="new"}`)
+	})
+
+	t.Run("without the flag deletes leave no marker", func(t *testing.T) {
+		testFile := createTempFile(t, initial)
+		run(t, "-f", testFile, "-l", "job=old", "backup_age_seconds", "delete")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		assert.NotContains(t, families, "backup_age_seconds_stale")
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+func statCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "stat",
+		Usage: "Summarize a metrics file",
+		Description: `Prints the file size, family, series, and sample counts, the oldest and
+newest OMET self-metric timestamps (such as omet_last_write), and how
+long the file took to parse.
+
+Examples:
+  omet stat -f /var/lib/node_exporter/app.prom
+  omet stat -f app.prom --json | jq .series`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "Metrics file to summarize (default: stdin)",
+				Value:   "-",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the summary as JSON",
+			},
+			encryptKeyFlag(),
+		},
+		Action: runStat,
+	}
+}
+
+// fileStats is a quick health snapshot of a metrics file.
+type fileStats struct {
+	File            string         `json:"file"`
+	SizeBytes       int64          `json:"size_bytes"`
+	Families        int            `json:"families"`
+	Series          int            `json:"series"`
+	Samples         int            `json:"samples"`
+	FamiliesByType  map[string]int `json:"families_by_type"`
+	OldestTimestamp *int64         `json:"oldest_self_metric_timestamp,omitempty"`
+	NewestTimestamp *int64         `json:"newest_self_metric_timestamp,omitempty"`
+	ParseSeconds    float64        `json:"parse_duration_seconds"`
+}
+
+func runStat(ctx *cli.Context) error {
+	key, err := loadEncryptKey(ctx)
+	if err != nil {
+		return err
+	}
+	filename := ctx.String("file")
+
+	var input io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", filename, err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	data, err := io.ReadAll(input)
+	if err == nil {
+		data, err = metricsfile.Unseal(key, data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+
+	start := time.Now()
+	families, err := metricsfile.Parse(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	stats := collectStats(families)
+	stats.File = filename
+	stats.SizeBytes = int64(len(data))
+	stats.ParseSeconds = time.Since(start).Seconds()
+
+	if ctx.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+
+	printStats(os.Stdout, stats)
+	return nil
+}
+
+// collectStats counts families, series, and exposed samples (a histogram
+// series exposes one sample per bucket plus _sum and _count).
+func collectStats(families map[string]*dto.MetricFamily) *fileStats {
+	stats := &fileStats{
+		Families:       len(families),
+		FamiliesByType: make(map[string]int),
+	}
+
+	for name, family := range families {
+		stats.FamiliesByType[typeName(family)]++
+		stats.Series += len(family.Metric)
+
+		for _, metric := range family.Metric {
+			switch family.GetType() {
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				stats.Samples += len(metric.GetHistogram().GetBucket()) + 2
+			case dto.MetricType_SUMMARY:
+				stats.Samples += len(metric.GetSummary().GetQuantile()) + 2
+			default:
+				stats.Samples++
+			}
+
+			if isSelfTimestamp(name, family) {
+				timestamp := int64(metric.GetGauge().GetValue())
+				if stats.OldestTimestamp == nil || timestamp < *stats.OldestTimestamp {
+					stats.OldestTimestamp = &timestamp
+				}
+				if stats.NewestTimestamp == nil || timestamp > *stats.NewestTimestamp {
+					stats.NewestTimestamp = &timestamp
+				}
+			}
+		}
+	}
+
+	return stats
+}
+
+// isSelfTimestamp reports whether a family is an OMET gauge holding a Unix
+// timestamp, such as omet_last_write or omet_healthcheck_last_run.
+func isSelfTimestamp(name string, family *dto.MetricFamily) bool {
+	return strings.HasPrefix(name, "omet_") &&
+		family.GetType() == dto.MetricType_GAUGE &&
+		(strings.Contains(name, "_last_write") || strings.Contains(name, "_last_run") || strings.HasSuffix(name, "_timestamp_seconds"))
+}
+
+func printStats(w io.Writer, stats *fileStats) {
+	fmt.Fprintf(w, "File:      %s\n", stats.File)
+	fmt.Fprintf(w, "Size:      %d bytes\n", stats.SizeBytes)
+	fmt.Fprintf(w, "Families:  %d", stats.Families)
+	if len(stats.FamiliesByType) > 0 {
+		var parts []string
+		for _, metricType := range []string{"counter", "gauge", "histogram", "summary", "untyped"} {
+			if count := stats.FamiliesByType[metricType]; count > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", count, metricType))
+			}
+		}
+		fmt.Fprintf(w, " (%s)", strings.Join(parts, ", "))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Series:    %d\n", stats.Series)
+	fmt.Fprintf(w, "Samples:   %d\n", stats.Samples)
+
+	formatTimestamp := func(timestamp *int64) string {
+		if timestamp == nil {
+			return "-"
+		}
+		t := time.Unix(*timestamp, 0).UTC()
+		return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), timeProvider.Now().Sub(t).Round(time.Second))
+	}
+	fmt.Fprintf(w, "Oldest:    %s\n", formatTimestamp(stats.OldestTimestamp))
+	fmt.Fprintf(w, "Newest:    %s\n", formatTimestamp(stats.NewestTimestamp))
+	fmt.Fprintf(w, "Parse:     %v\n", time.Duration(stats.ParseSeconds*float64(time.Second)).Round(time.Microsecond))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statMetrics = `# TYPE requests_total counter
+requests_total{code="200"} 10
+requests_total{code="500"} 1
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="1"} 1
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 3
+latency_seconds_count 2
+# TYPE omet_last_write gauge
+omet_last_write 1700000100
+# TYPE omet_healthcheck_last_run gauge
+omet_healthcheck_last_run 1700000000
+`
+
+func TestCollectStats(t *testing.T) {
+	families, err := parseMetrics(strings.NewReader(statMetrics))
+	require.NoError(t, err)
+
+	stats := collectStats(families)
+	assert.Equal(t, 4, stats.Families)
+	assert.Equal(t, 5, stats.Series)
+	assert.Equal(t, 2+4+1+1, stats.Samples)
+	assert.Equal(t, map[string]int{"counter": 1, "histogram": 1, "gauge": 2}, stats.FamiliesByType)
+	require.NotNil(t, stats.OldestTimestamp)
+	assert.Equal(t, int64(1700000000), *stats.OldestTimestamp)
+	assert.Equal(t, int64(1700000100), *stats.NewestTimestamp)
+}
+
+func TestStatCommand(t *testing.T) {
+	setupMockTime(t, time.Unix(1700000160, 0))
+	testFile := createTempFile(t, statMetrics)
+
+	output := captureOutput(t, func() {
+		err := createTestApp().Run([]string{"omet", "stat", "-f", testFile})
+		require.NoError(t, err)
+	})
+	assert.Contains(t, output, "Families:  4 (1 counter, 2 gauge, 1 histogram)")
+	assert.Contains(t, output, "Series:    5")
+	assert.Contains(t, output, "Newest:    2023-11-14T22:15:00Z (1m0s ago)")
+
+	output = captureOutput(t, func() {
+		err := createTestApp().Run([]string{"omet", "stat", "-f", testFile, "--json"})
+		require.NoError(t, err)
+	})
+	var stats fileStats
+	require.NoError(t, json.Unmarshal([]byte(output), &stats))
+	assert.Equal(t, int64(len(statMetrics)), stats.SizeBytes)
+	assert.Equal(t, 8, stats.Samples)
+	assert.Equal(t, testFile, stats.File)
+
+	err := createTestApp().Run([]string{"omet", "stat", "-f", "/nonexistent.prom"})
+	assert.Error(t, err)
+}
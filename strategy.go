@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"omet/internal/schema"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// mergeStrategies say how a series combines with an existing series with
+// the same labels, by family type, with per-family overrides from a schema.
+// A nil *mergeStrategies replaces existing series.
+type mergeStrategies struct {
+	counter   string            // sum, max, or replace
+	gauge     string            // replace, sum, max, min, or average; also used for untyped
+	histogram string            // merge (bucket-wise sum) or replace
+	families  map[string]string // family name to strategy, from the schema's merge fields
+
+	// Values averaged into each series so far, so average stays a true mean
+	// over several inputs
+	averaged map[*dto.Metric]int
+}
+
+// strategiesByType lists the strategies valid for each family type.
+var strategiesByType = map[dto.MetricType][]string{
+	dto.MetricType_COUNTER:   {"sum", "max", "replace"},
+	dto.MetricType_GAUGE:     {"replace", "sum", "max", "min", "average"},
+	dto.MetricType_UNTYPED:   {"replace", "sum", "max", "min", "average"},
+	dto.MetricType_HISTOGRAM: {"merge", "replace"},
+	// Gauge histograms are snapshots, replaced unless the schema says otherwise
+	dto.MetricType_GAUGE_HISTOGRAM: {"replace", "merge"},
+	dto.MetricType_SUMMARY:         {"replace"},
+}
+
+// mergeStrategyFlags are the flags selecting strategies, with the command's
+// defaults.
+func mergeStrategyFlags(counter, gauge, histogram string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "counter-policy",
+			Value: counter,
+			Usage: "How counters combine with existing series: sum, max, or replace",
+		},
+		&cli.StringFlag{
+			Name:  "gauge-policy",
+			Value: gauge,
+			Usage: "How gauges and untyped series combine with existing series: replace, sum, max, min, or average",
+		},
+		&cli.StringFlag{
+			Name:  "histogram-policy",
+			Value: histogram,
+			Usage: "How histograms combine with existing series: merge (bucket-wise sum) or replace",
+		},
+		&cli.StringFlag{
+			Name:  "schema",
+			Usage: "Schema file whose families' merge fields override the policies per family",
+		},
+	}
+}
+
+// loadMergeStrategies reads the strategy flags and, with --schema, the
+// per-family overrides.
+func loadMergeStrategies(ctx *cli.Context) (*mergeStrategies, error) {
+	s := &mergeStrategies{
+		counter:   ctx.String("counter-policy"),
+		gauge:     ctx.String("gauge-policy"),
+		histogram: ctx.String("histogram-policy"),
+		families:  make(map[string]string),
+	}
+	for flag, metricType := range map[string]dto.MetricType{
+		"counter-policy":   dto.MetricType_COUNTER,
+		"gauge-policy":     dto.MetricType_GAUGE,
+		"histogram-policy": dto.MetricType_HISTOGRAM,
+	} {
+		if valid := strategiesByType[metricType]; !slices.Contains(valid, ctx.String(flag)) {
+			return nil, fmt.Errorf("invalid --%s %s (supported: %s)", flag, ctx.String(flag), strings.Join(valid, ", "))
+		}
+	}
+
+	if filename := ctx.String("schema"); filename != "" {
+		declared, err := schema.Load(filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, family := range declared.Families {
+			if family.Merge == "" {
+				continue
+			}
+			metricType, _ := family.MetricType()
+			if valid := strategiesByType[metricType]; !slices.Contains(valid, family.Merge) {
+				return nil, fmt.Errorf("family %s: invalid merge %s for a %s (supported: %s)", family.Name, family.Merge, family.Type, strings.Join(valid, ", "))
+			}
+			s.families[family.Name] = family.Merge
+		}
+	}
+	return s, nil
+}
+
+// strategy returns the strategy for a family.
+func (s *mergeStrategies) strategy(name string, metricType dto.MetricType) string {
+	if s == nil {
+		return "replace"
+	}
+	if strategy, ok := s.families[name]; ok {
+		return strategy
+	}
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return s.counter
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		return s.gauge
+	case dto.MetricType_HISTOGRAM:
+		return s.histogram
+	}
+	return "replace"
+}
+
+// mergeSeries adds metric to family, combining it with an existing series
+// with the same labels by the family's strategy.
+func (s *mergeStrategies) mergeSeries(family *dto.MetricFamily, metric *dto.Metric) error {
+	current := findSameSeries(family, metric)
+	if current == nil {
+		family.Metric = append(family.Metric, metric)
+		return nil
+	}
+
+	metricType := family.GetType()
+	strategy := s.strategy(family.GetName(), metricType)
+	if strategy == "replace" || !slices.Contains(strategiesByType[metricType], strategy) {
+		replaceSeries(family, metric)
+		return nil
+	}
+	if metricType == dto.MetricType_HISTOGRAM || metricType == dto.MetricType_GAUGE_HISTOGRAM {
+		if err := mergeHistogram(current.Histogram, metric.Histogram); err != nil {
+			return fmt.Errorf("%s: %w", formatSeries(family.GetName(), labelMap(metric)), err)
+		}
+		return nil
+	}
+
+	have, add := simpleValue(current), simpleValue(metric)
+	var combined float64
+	switch strategy {
+	case "sum":
+		combined = have + add
+	case "max":
+		combined = math.Max(have, add)
+	case "min":
+		combined = math.Min(have, add)
+	case "average":
+		if s.averaged == nil {
+			s.averaged = make(map[*dto.Metric]int)
+		}
+		n := max(s.averaged[current], 1) // at least the existing value
+		combined = (have*float64(n) + add) / float64(n+1)
+		s.averaged[current] = n + 1
+	}
+
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		current.Counter = &dto.Counter{Value: float64Ptr(combined)}
+	case dto.MetricType_GAUGE:
+		current.Gauge = &dto.Gauge{Value: float64Ptr(combined)}
+	default:
+		current.Untyped = &dto.Untyped{Value: float64Ptr(combined)}
+	}
+	return nil
+}
+
+// simpleValue returns the value of a counter, gauge, or untyped series.
+func simpleValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.Counter != nil:
+		return metric.Counter.GetValue()
+	case metric.Gauge != nil:
+		return metric.Gauge.GetValue()
+	}
+	return metric.GetUntyped().GetValue()
+}
+
+// mergeHistogram adds src's observations to dst. Both need the same bucket
+// layout.
+func mergeHistogram(dst, src *dto.Histogram) error {
+	if len(dst.Bucket) != len(src.Bucket) {
+		return fmt.Errorf("can't merge histograms with %d and %d buckets", len(dst.Bucket), len(src.Bucket))
+	}
+	for i, bucket := range src.Bucket {
+		if dst.Bucket[i].GetUpperBound() != bucket.GetUpperBound() {
+			return fmt.Errorf("can't merge histograms with different buckets (le=%g vs le=%g)", dst.Bucket[i].GetUpperBound(), bucket.GetUpperBound())
+		}
+	}
+	for i, bucket := range src.Bucket {
+		dst.Bucket[i].CumulativeCount = uint64Ptr(dst.Bucket[i].GetCumulativeCount() + bucket.GetCumulativeCount())
+	}
+	dst.SampleCount = uint64Ptr(dst.GetSampleCount() + src.GetSampleCount())
+	dst.SampleSum = float64Ptr(dst.GetSampleSum() + src.GetSampleSum())
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	"github.com/urfave/cli/v2"
+)
+
+func stressCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "stress",
+		Usage: "Hammer a file with concurrent updates and check none were lost",
+		Description: `Starts --workers concurrent workers that each run --ops random in-place
+updates against a fresh file: counter increments, gauge sets, and
+histogram observations. Once they are done, the file must parse, carry a
+valid checksum, and hold exactly the totals the workers wrote. Lost or
+torn updates mean the file's locks can't be trusted on that filesystem.
+
+Each update runs as a separate omet process, as cron jobs would, so
+locking is exercised across processes the way NFS and overlayfs see it.
+--in-process runs workers as goroutines instead, which is faster but
+weaker: NFS implements flock with per-process locks.
+
+The file must not exist yet. It is removed afterwards, along with its
+journal, history, and fair-lock queue files, unless --keep.
+
+Example:
+  omet stress -f /mnt/nfs/metrics/stress.prom --workers 16 --ops 200`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "File to create on the filesystem under test",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:  "workers",
+				Usage: "Number of concurrent workers",
+				Value: 8,
+			},
+			&cli.IntFlag{
+				Name:  "ops",
+				Usage: "Number of updates each worker runs",
+				Value: 100,
+			},
+			&cli.BoolFlag{
+				Name:  "in-process",
+				Usage: "Run workers as goroutines instead of omet processes",
+			},
+			&cli.BoolFlag{
+				Name:  "keep",
+				Usage: "Keep the file afterwards for inspection",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long each update waits for the file lock",
+			},
+		},
+		Action: runStress,
+	}
+}
+
+// removeStressFiles removes the stress file and the sidecars its writers
+// left next to it.
+func removeStressFiles(filename string) {
+	for _, suffix := range []string{"", journalSuffix, historySuffix, tombstoneSuffix} {
+		os.Remove(filename + suffix)
+	}
+	metricsfile.RemoveQueue(filename)
+}
+
+// stressTotals is what the workers wrote, to check the file against.
+type stressTotals struct {
+	workerOps    []float64 // stress_worker_ops_total by worker
+	sharedOps    float64   // stress_shared_ops_total
+	observations uint64    // stress_latency_seconds count
+}
+
+func runStress(ctx *cli.Context) error {
+	filename := ctx.String("file")
+	workers, ops := ctx.Int("workers"), ctx.Int("ops")
+	if workers <= 0 || ops <= 0 {
+		return fmt.Errorf("--workers and --ops must be positive")
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("%s already exists; stress needs a file of its own", filename)
+	}
+	if !ctx.Bool("keep") {
+		defer removeStressFiles(filename)
+	}
+
+	run := stressProcess
+	if ctx.Bool("in-process") {
+		run = stressInProcess
+	}
+	common := []string{"-i", "-q", "--lock-timeout", ctx.Duration("lock-timeout").String(), "-f", filename}
+
+	start := time.Now()
+	totals := &stressTotals{workerOps: make([]float64, workers)}
+	var mu sync.Mutex
+	var failures []error
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(w)))
+			worker := strconv.Itoa(w)
+			for i := 0; i < ops; i++ {
+				var args []string
+				var count func()
+				switch r.Intn(4) {
+				case 0:
+					n := float64(1 + r.Intn(5))
+					args = []string{"-l", "worker=" + worker, "stress_worker_ops_total", "inc", strconv.FormatFloat(n, 'f', -1, 64)}
+					count = func() { totals.workerOps[w] += n }
+				case 1:
+					args = []string{"stress_shared_ops_total", "inc"}
+					count = func() { totals.sharedOps++ }
+				case 2:
+					args = []string{"stress_last_worker", "set", worker}
+					count = func() {}
+				default:
+					args = []string{"stress_latency_seconds", "observe", strconv.FormatFloat(r.Float64(), 'f', 3, 64)}
+					count = func() { totals.observations++ }
+				}
+
+				err := run(append(append([]string(nil), common...), args...))
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, fmt.Errorf("worker %d: %s: %w", w, args, err))
+				} else {
+					count()
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	output := ctx.App.Writer
+	fmt.Fprintf(output, "%d workers x %d updates in %v (%.0f updates/s)\n", workers, ops, elapsed.Round(time.Millisecond), float64(workers*ops)/elapsed.Seconds())
+	if len(failures) > 0 {
+		fmt.Fprintf(output, "%d updates failed\n", len(failures))
+		return errors.Join(failures...)
+	}
+	if err := verifyStress(filename, totals, workers); err != nil {
+		return fmt.Errorf("FAIL: %w", err)
+	}
+	fmt.Fprintln(output, "OK: no updates lost, file intact")
+	return nil
+}
+
+// stressProcess runs one update as a child omet process.
+func stressProcess(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command(executable, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+// stressInProcess runs one update in this process.
+func stressInProcess(args []string) error {
+	app := newApp()
+	app.Writer, app.ErrWriter = io.Discard, io.Discard
+	return app.Run(append([]string{"omet"}, args...))
+}
+
+// verifyStress checks the file against what the workers wrote.
+func verifyStress(filename string, totals *stressTotals, workers int) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if !metricsfile.Verify(data) {
+		return fmt.Errorf("%s: checksum trailer missing or wrong", filename)
+	}
+	families, err := parseMetrics(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s no longer parses: %w", filename, err)
+	}
+
+	check := func(name string, labels map[string]string, want float64) error {
+		got, _ := seriesValue(families, name, labels)
+		if got != want {
+			return fmt.Errorf("%s is %g, expected %g: updates were lost", formatSeries(name, labels), got, want)
+		}
+		return nil
+	}
+	for w, want := range totals.workerOps {
+		if want == 0 {
+			continue
+		}
+		if err := check("stress_worker_ops_total", map[string]string{"worker": strconv.Itoa(w)}, want); err != nil {
+			return err
+		}
+	}
+	if totals.sharedOps > 0 {
+		if err := check("stress_shared_ops_total", nil, totals.sharedOps); err != nil {
+			return err
+		}
+	}
+	if totals.observations > 0 {
+		// A histogram's value is its count
+		if err := check("stress_latency_seconds", nil, float64(totals.observations)); err != nil {
+			return err
+		}
+	}
+	if value, exists := seriesValue(families, "stress_last_worker", nil); exists && (value < 0 || value >= float64(workers)) {
+		return fmt.Errorf("stress_last_worker is %g, which no worker wrote", value)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStress(t *testing.T) {
+	t.Run("no updates lost", func(t *testing.T) {
+		testFile := filepath.Join(t.TempDir(), "stress.prom")
+		output := captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "stress", "-f", testFile, "--workers", "4", "--ops", "20", "--in-process"}))
+		})
+		assert.Contains(t, output, "OK: no updates lost")
+		assert.NoFileExists(t, testFile)
+	})
+
+	t.Run("removes sidecar files", func(t *testing.T) {
+		dir := t.TempDir()
+		testFile := filepath.Join(dir, "stress.prom")
+		for _, name := range []string{"stress.prom.journal", "stress.prom.history", "stress.prom.lockq", "stress.prom.lockq.1-2-3"} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+		}
+		captureOutput(t, func() {
+			require.NoError(t, createTestApp().Run([]string{"omet", "stress", "-f", testFile, "--workers", "2", "--ops", "5", "--in-process"}))
+		})
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("refuses an existing file", func(t *testing.T) {
+		testFile := createTempFile(t, "jobs_total 1\n")
+		err := createTestApp().Run([]string{"omet", "stress", "-f", testFile, "--in-process"})
+		assert.ErrorContains(t, err, "already exists")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, "jobs_total 1\n", string(content))
+	})
+}
+
+func TestVerifyStress(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "stress.prom")
+	for _, args := range [][]string{
+		{"-l", "worker=0", "stress_worker_ops_total", "inc", "3"},
+		{"stress_shared_ops_total", "inc"},
+		{"stress_latency_seconds", "observe", "0.5"},
+	} {
+		require.NoError(t, stressInProcess(append([]string{"-i", "-q", "-f", testFile}, args...)))
+	}
+
+	assert.NoError(t, verifyStress(testFile, &stressTotals{workerOps: []float64{3}, sharedOps: 1, observations: 1}, 1))
+	assert.ErrorContains(t, verifyStress(testFile, &stressTotals{workerOps: []float64{3}, sharedOps: 2, observations: 1}, 1), "updates were lost")
+
+	// A torn write leaves a file whose checksum doesn't match
+	data, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(testFile, data[:len(data)/2], 0644))
+	assert.Error(t, verifyStress(testFile, &stressTotals{workerOps: []float64{3}}, 1))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+func syncCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "sync",
+		Usage:     "Make selected families of one file match another",
+		ArgsUsage: "<family>...",
+		Description: `Updates the destination file so the families matching the given names or
+glob patterns are exactly as in the source: series missing from the
+destination are created, changed ones updated, and ones the source no
+longer has deleted, along with families that disappeared entirely. Other
+families in the destination are left alone.
+
+The source is read under a shared lock and the destination rewritten under
+an exclusive one, taken in path order so opposing syncs can't deadlock.
+The destination is only rewritten if something changed.
+
+Examples:
+  omet sync --from /tmp/job.prom --to /var/lib/node_exporter/job.prom 'backup_*'
+  omet sync --from scratch.prom --to app.prom --dry-run jobs_total queue_depth`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "from",
+				Usage:    "Source metrics file",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "to",
+				Usage:    "Destination metrics file, created if missing",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the changes without writing the destination",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for each file lock",
+			},
+		},
+		Action: runSync,
+	}
+}
+
+// syncChange counts the series a sync creates, updates, and deletes in one
+// family.
+type syncChange struct {
+	family                    string
+	created, updated, deleted int
+}
+
+func (c syncChange) String() string {
+	return fmt.Sprintf("%s: %d created, %d updated, %d deleted", c.family, c.created, c.updated, c.deleted)
+}
+
+func runSync(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return fmt.Errorf("sync requires at least one family name or pattern")
+	}
+	patterns := ctx.Args().Slice()
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid family pattern %q: %w", pattern, err)
+		}
+	}
+
+	from, to := ctx.String("from"), ctx.String("to")
+	if sameFile(from, to) {
+		return fmt.Errorf("--from and --to are the same file")
+	}
+
+	source, err := metricsfile.NewReadLock(from, ctx.Duration("lock-timeout"))
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := metricsfile.NewFileLock(to, ctx.Duration("lock-timeout"))
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+	defer destination.Close()
+
+	acquire := []func() error{
+		func() error { return source.LockShared(context.Background()) },
+		func() error { return destination.Lock(context.Background()) },
+	}
+	if absPath(to) < absPath(from) {
+		acquire[0], acquire[1] = acquire[1], acquire[0]
+	}
+	for _, lock := range acquire {
+		if err := lock(); err != nil {
+			return fmt.Errorf("failed to acquire lock: %w", err)
+		}
+	}
+
+	src, err := metricsfile.Parse(source.File())
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", from, err)
+	}
+	dst, err := metricsfile.Parse(destination.File())
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", to, err)
+	}
+
+	changes := syncFamilies(dst, src, patterns)
+	if len(changes) == 0 {
+		fmt.Println("Already in sync")
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if ctx.Bool("dry-run") {
+		return nil
+	}
+
+	return destination.RewriteWithChecksum(func(file *os.File) error {
+		return writeMetrics(dst, file)
+	})
+}
+
+// syncFamilies makes the families of dst matching patterns equal to src's,
+// returning the changes by family name.
+func syncFamilies(dst, src map[string]*dto.MetricFamily, patterns []string) []syncChange {
+	names := make(map[string]bool)
+	for _, families := range []map[string]*dto.MetricFamily{src, dst} {
+		for name := range families {
+			if matchesAny(name, patterns) {
+				names[name] = true
+			}
+		}
+	}
+
+	var changes []syncChange
+	for name := range names {
+		want, have := src[name], dst[name]
+		if proto.Equal(want, have) {
+			continue
+		}
+
+		change := syncChange{family: name}
+		for _, metric := range want.GetMetric() {
+			existing := findSameSeries(have, metric)
+			switch {
+			case existing == nil:
+				change.created++
+			case !proto.Equal(existing, metric):
+				change.updated++
+			}
+		}
+		for _, metric := range have.GetMetric() {
+			if findSameSeries(want, metric) == nil {
+				change.deleted++
+			}
+		}
+		changes = append(changes, change)
+
+		if want == nil {
+			delete(dst, name)
+		} else {
+			dst[name] = want
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].family < changes[j].family })
+	return changes
+}
+
+// findSameSeries returns the series of family with metric's labels, if any.
+func findSameSeries(family *dto.MetricFamily, metric *dto.Metric) *dto.Metric {
+	for _, candidate := range family.GetMetric() {
+		if sameLabels(candidate.Label, metric.Label) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func absPath(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filename
+}
+
+// sameFile reports whether two paths name the same existing file.
+func sameFile(a, b string) bool {
+	statA, errA := os.Stat(a)
+	statB, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return absPath(a) == absPath(b)
+	}
+	return os.SameFile(statA, statB)
+}
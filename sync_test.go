@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"omet/internal/metricsfile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "scratch.prom")
+	to := filepath.Join(dir, "collector.prom")
+	require.NoError(t, os.WriteFile(from, []byte(`# TYPE backup_runs_total counter
+backup_runs_total{job="db"} 5
+backup_runs_total{job="files"} 2
+# TYPE backup_size_bytes gauge
+backup_size_bytes 1024
+# TYPE scratch_only gauge
+scratch_only 1
+`), 0644))
+	require.NoError(t, os.WriteFile(to, []byte(`# TYPE backup_runs_total counter
+backup_runs_total{job="db"} 4
+backup_runs_total{job="old"} 9
+# TYPE backup_last_error gauge
+backup_last_error 1
+# TYPE app_up gauge
+app_up 1
+`), 0644))
+
+	output := captureOutput(t, func() {
+		require.NoError(t, createTestApp().Run([]string{"omet", "sync", "--from", from, "--to", to, "backup_*"}))
+	})
+	assert.Equal(t, `backup_last_error: 0 created, 0 updated, 1 deleted
+backup_runs_total: 1 created, 1 updated, 1 deleted
+backup_size_bytes: 1 created, 0 updated, 0 deleted
+`, output)
+
+	families, err := metricsfile.ParseFile(to)
+	require.NoError(t, err)
+	assert.NotContains(t, families, "backup_last_error")
+	assert.NotContains(t, families, "scratch_only", "unselected families are not copied")
+	assert.Contains(t, families, "app_up", "unselected families are kept")
+	assert.Len(t, families["backup_runs_total"].Metric, 2)
+	assert.Equal(t, 1024.0, families["backup_size_bytes"].Metric[0].GetGauge().GetValue())
+
+	output = captureOutput(t, func() {
+		require.NoError(t, createTestApp().Run([]string{"omet", "sync", "--from", from, "--to", to, "backup_*"}))
+	})
+	assert.Equal(t, "Already in sync\n", output)
+
+	err = createTestApp().Run([]string{"omet", "sync", "--from", from, "--to", from, "backup_*"})
+	assert.ErrorContains(t, err, "same file")
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"omet/internal/metricsfile"
+	"omet/internal/objstore"
+	"omet/internal/selector"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+// request is a resolved omet invocation: what to apply, independent of
+// which files it is applied to.
+type request struct {
+	metricName       string
+	operation        string
+	labels           map[string]string
+	values           []float64  // one per application; several only for observe
+	metricType       string     // family type for ensure
+	alpha            float64    // smoothing factor for avg
+	window           windowSpec // --window and --window-keep for window-inc
+	expression       expression // --from, evaluated against each target's families
+	scale            float64
+	transforms       transforms // --transform, applied after scale
+	suspicious       []suspiciousLabel
+	destination      map[string]string // label overrides for copy
+	buckets          *bucketCounts     // pre-aggregated counts for observe-buckets
+	encryptKey       []byte            // --encrypt-key-file, nil for plaintext files
+	encryptPlaintext bool              // --encrypt-plaintext: a plaintext file is read as is
+	sync             metricsfile.SyncMode
+	newSeries        *seriesLimit      // --max-new-series-per-run, shared by all targets
+	match            selector.Selector // --match: apply to every series selected, see expandMatch
+	createIfMissing  bool
+	scopeName        string // --scope-label: series with another value for this label are deleted
+	scopeValue       string
+	replace          bool          // --replace: series of the updated families not given in this run are deleted
+	divide           bool          // --divide: scale divides instead of multiplying
+	newName          string        // family name for rename
+	forceMerge       bool          // --force-merge: rename may merge into an existing family
+	deadband         *deadband     // --deadband: sets that barely move a gauge are skipped
+	labelConsistency string        // --label-consistency: off, warn, refuse, or fill
+	sinks            []sink        // --sink outputs, sent each target after it's written
+	valueCmd         *valueCommand // --value-cmd run that supplied the value
+	batch            []*request    // line-protocol updates from stdin, applied instead of this request
+	line             int           // line number of a batch update
+	verbose          bool
+}
+
+// updates returns the operations a request applies, in order: its
+// line-protocol batch or --match series, or the request itself.
+func (req *request) updates() []*request {
+	if req.batch != nil {
+		return req.batch
+	}
+	return []*request{req}
+}
+
+// target is one metrics file an invocation reads and, in in-place mode,
+// writes back.
+type target struct {
+	filename     string
+	inPlace      bool
+	lock         *metricsfile.FileLock
+	lockWaitTime time.Duration
+	queue        *metricsfile.QueueStats   // set when the lock was taken with --fair-lock
+	lockTimeout  *metricsfile.TimeoutError // set when the lock wasn't acquired in time
+	remote       *remoteObject             // set for s3:// and gs:// targets
+	held         *heldFile                 // set when every --hold-lock keeps the lock beyond the run
+	validateOnly bool                      // --validate-only: read, never written, and may not exist yet
+	tombstones   []tombstone               // set when --tombstone deleted series, written with the file
+	modTime      time.Time                 // the local file's mtime when it was read
+	readOnly     bool                      // in-place, but on a read-only filesystem, so written to stdout
+	errors       *ErrorCollector
+
+	// The changed series before and after the run, nil where it doesn't
+	// exist, for --notify-url
+	oldValue, newValue *float64
+}
+
+// openTargets prepares every --file target. In in-place mode with locking,
+// locks are acquired in sorted filename order so that concurrent fan-out
+// invocations over overlapping files can't deadlock. With fairLocking, each
+// lock is requested through the file's FIFO queue. Object storage targets
+// aren't locked; see remoteObject.
+func openTargets(filenames []string, inPlace, fairLocking bool, lockTimeout time.Duration, argErrors *ErrorCollector, verbose bool) []*target {
+	targets := make([]*target, 0, len(filenames))
+	byName := make(map[string]*target)
+	for _, filename := range filenames {
+		if _, seen := byName[filename]; seen {
+			continue
+		}
+		t := &target{
+			filename: filename,
+			inPlace:  inPlace && filename != "-",
+			errors:   argErrors.clone(),
+		}
+		targets = append(targets, t)
+		byName[filename] = t
+
+		if objstore.IsURL(filename) {
+			store, key, err := objstore.Open(filename)
+			if err != nil {
+				t.errors.AddError(err, "io_error")
+				store = nil
+			}
+			t.remote = &remoteObject{store: store, key: key}
+		}
+	}
+
+	lockOrder := make([]string, 0, len(byName))
+	for filename, t := range byName {
+		if t.inPlace && t.remote == nil && readOnlyFS(filename) {
+			log.Printf("WARN: %s is on a read-only filesystem; writing its metrics to stdout instead", filename)
+			t.inPlace, t.readOnly = false, true
+		}
+		if t.inPlace && t.remote == nil {
+			lockOrder = append(lockOrder, filename)
+		}
+	}
+	sort.Strings(lockOrder)
+
+	for _, filename := range lockOrder {
+		t := byName[filename]
+		if held := heldLocks[filename]; held != nil {
+			if stale, err := held.lock.Stale(); err == nil && !stale {
+				t.lock, t.held = held.runLock(), held
+				continue
+			}
+			// Replaced since an earlier run locked it: lock the new file
+			if held.dirty {
+				log.Printf("WARN: %s was replaced, dropping the runs not flushed to it yet", filename)
+			}
+			held.release()
+			delete(heldLocks, filename)
+		}
+
+		lock, err := metricsfile.NewFileLock(filename, lockTimeout)
+		if err != nil {
+			t.errors.AddError(fmt.Errorf("failed to create file lock: %w", err), "io_error")
+			continue
+		}
+		t.lock = lock
+
+		if verbose {
+			log.Printf("Acquiring lock on %s (timeout: %v)", filename, lockTimeout)
+		}
+
+		// Measure lock wait time
+		lockStart := time.Now()
+		if fairLocking {
+			var stats metricsfile.QueueStats
+			stats, err = lock.LockFair(context.Background())
+			t.queue = &stats
+			if verbose {
+				log.Printf("Queued behind %d writers for %s", stats.Ahead, filename)
+			}
+		} else {
+			err = lock.Lock(context.Background())
+		}
+		t.lockWaitTime = time.Since(lockStart)
+
+		if err != nil {
+			t.errors.AddError(fmt.Errorf("failed to acquire lock: %w", err), "lock_error")
+			errors.As(err, &t.lockTimeout)
+			continue
+		}
+		if verbose {
+			log.Printf("Lock acquired on %s in %v", filename, t.lockWaitTime)
+		}
+		if heldLocks != nil {
+			held, err := holdLock(filename, lock)
+			if err != nil {
+				t.errors.AddError(err, "io_error")
+				lock.Close()
+				t.lock = nil
+				continue
+			}
+			t.lock, t.held = held.runLock(), held
+		}
+	}
+
+	return targets
+}
+
+// journal records the operations in the target's journal for undo. The
+// write already happened, so failures are only logged.
+func (t *target) journal(entries []journalEntry, size int) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := appendJournal(t.filename, size, entries...); err != nil {
+		log.Printf("WARN: failed to record operation in journal for %s: %v", t.filename, err)
+	}
+}
+
+func closeTargets(targets []*target) {
+	for _, t := range targets {
+		if t.lock != nil && t.held == nil {
+			t.lock.Close()
+		}
+	}
+}
+
+// writable reports whether output goes back to the target file itself.
+func (t *target) writable() bool {
+	if t.remote != nil {
+		return t.inPlace && t.remote.loaded
+	}
+	return t.inPlace && t.lock != nil && !t.errors.HasType("lock_error")
+}
+
+// load reads the target's existing metrics, decrypting them with key if
+// they are encrypted. Problems are recorded on the target's collector and
+// yield empty families; unreadable is set when the file must not be
+// overwritten, because it is too large or can't be decrypted.
+func (t *target) load(maxInputBytes int64, key []byte, allowPlaintext bool) (families map[string]*dto.MetricFamily, inputSize int64, unreadable bool) {
+	var input io.Reader
+	switch {
+	case t.remote != nil:
+		if t.remote.store == nil {
+			return make(map[string]*dto.MetricFamily), 0, false
+		}
+		data, err := t.remote.get()
+		if err != nil {
+			t.errors.AddError(fmt.Errorf("failed to read %s: %w", t.filename, err), "io_error")
+			return make(map[string]*dto.MetricFamily), 0, false
+		}
+		input, inputSize = bytes.NewReader(data), int64(len(data))
+	case t.filename == "-":
+		input = os.Stdin
+	case t.inPlace:
+		if !t.writable() {
+			return make(map[string]*dto.MetricFamily), 0, false
+		}
+		t.lock.File().Seek(0, 0) // Reset to beginning
+		input = t.lock.File()
+	default:
+		file, err := os.Open(t.filename)
+		if os.IsNotExist(err) && (t.validateOnly || t.readOnly) {
+			return make(map[string]*dto.MetricFamily), 0, false
+		}
+		if err != nil {
+			t.errors.AddError(fmt.Errorf("failed to open file %s: %w", t.filename, err), "io_error")
+			return make(map[string]*dto.MetricFamily), 0, false
+		}
+		defer file.Close()
+		input = file
+	}
+
+	// Get file size for metrics
+	if file, ok := input.(*os.File); ok && input != os.Stdin {
+		if stat, err := file.Stat(); err == nil {
+			inputSize = stat.Size()
+			t.modTime = stat.ModTime()
+		}
+	}
+
+	if key != nil && !(maxInputBytes > 0 && inputSize > maxInputBytes) {
+		data, err := io.ReadAll(input)
+		if err == nil && !(allowPlaintext && !metricsfile.IsEncrypted(data)) {
+			data, err = metricsfile.Decrypt(key, data)
+		}
+		if err != nil {
+			t.errors.AddError(fmt.Errorf("failed to read %s: %w", t.filename, err), "io_error")
+			return make(map[string]*dto.MetricFamily), inputSize, true
+		}
+		input = bytes.NewReader(data)
+	}
+
+	families, unreadable = loadMetrics(input, inputSize, maxInputBytes, t.errors)
+	return families, inputSize, unreadable
+}
+
+// appliedUpdate is the state of an update's series before it was applied,
+// for the journal, audit log, and --porcelain.
+type appliedUpdate struct {
+	oldValue float64
+	existed  bool
+	before   *seriesSnapshot
+}
+
+// blockingErrorTypes are errors that always prevent the operation, even in
+// the best-effort case where a labeled, non-zero update is still applied.
+var blockingErrorTypes = []string{"suspicious_label", "invalid_name", "label_policy", "series_limit"}
+
+// applyRequest applies one value of the request. Operations that need more
+// than a value are dispatched here; the rest go through applyOperation.
+func applyRequest(families map[string]*dto.MetricFamily, req *request, value float64) error {
+	switch req.operation {
+	case "ensure":
+		return ensureSeries(families, req.metricName, req.metricType, req.labels, value)
+	case "avg":
+		return averageGauge(families, req.metricName, req.labels, value, req.alpha)
+	case "copy":
+		return copySeries(families, req.metricName, req.labels, req.destination)
+	case "window-inc":
+		return windowIncrement(families, req.metricName, req.labels, value, req.window, timeProvider.Now())
+	case "observe":
+		if _, err := histogramFamily(families, req.metricName, req.metricType, "observe"); err != nil {
+			return err
+		}
+		return observeHistogram(families, req.metricName, req.labels, value)
+	case "observe-buckets":
+		return observeBuckets(families, req.metricName, req.metricType, req.labels, req.buckets)
+	case "scale":
+		return scaleSeries(families, req.metricName, req.labels, value, req.divide)
+	case "rename":
+		return renameFamily(families, req.metricName, req.newName, req.forceMerge)
+	case "reset":
+		return resetSeries(families, req.metricName, req.labels)
+	case "delete":
+		return deleteSeries(families, req.metricName, req.labels)
+	default:
+		return applyOperation(families, req.metricName, req.operation, req.labels, value)
+	}
+}
+
+// processTarget applies the request to one target and writes the result.
+func processTarget(ctx *cli.Context, t *target, req *request) error {
+	families, inputSize, unreadable := t.load(ctx.Int64("max-input-bytes"), req.encryptKey, req.encryptPlaintext)
+
+	if req.verbose {
+		log.Printf("Parsed %d metric families from %s", len(families), t.filename)
+	}
+	checkHistograms(families, t.filename, ctx.Bool("repair-histograms"), ctx.Bool("quiet"), req.verbose)
+	req = req.expandMatch(families).withScopeCleanup(families).withReplace(families)
+
+	// Apply the operations (best effort)
+	values := req.values
+	if req.expression != nil && !t.errors.HasErrors() {
+		value, err := req.expression.eval(families)
+		if err != nil {
+			t.errors.AddError(fmt.Errorf("failed to evaluate --from: %w", err), "operation_error")
+		} else {
+			values = []float64{req.transforms.apply(value * req.scale)}
+		}
+	}
+	// Deleted series are kept only if the existing tombstones can be read
+	// back; otherwise deletes are refused rather than losing them
+	recordTombstones := t.writable() && ctx.Bool("tombstone")
+	var tombstones, deleted []tombstone
+	if recordTombstones {
+		var err error
+		if tombstones, err = readTombstones(t.filename); err != nil {
+			t.errors.AddError(err, "io_error")
+		}
+	}
+
+	refused := false
+	for _, errorType := range blockingErrorTypes {
+		refused = refused || t.errors.HasType(errorType)
+	}
+	if t.validateOnly {
+		// Check every update, whatever else is wrong
+		refused = false
+	} else if req.batch != nil {
+		// A batch is only applied if every line was valid
+		refused = refused || t.errors.HasErrors()
+	} else if t.errors.HasErrors() && (req.labels == nil || values[0] == 0) {
+		// A labeled, non-zero update is still applied despite other errors
+		refused = true
+	}
+
+	markStaleSeries := ctx.Bool("mark-stale")
+	updates := req.updates()
+	applied := make([]appliedUpdate, len(updates))
+	withinDeadband := 0
+	recordJournal := t.writable() && ctx.Int("journal-size") > 0
+	var journal []journalEntry
+	for i, u := range updates {
+		if req.labelConsistency == "fill" && families[u.metricName] != nil {
+			// Match the family's series, which carry every label name
+			u.labels = fillLabels(families[u.metricName], u.labels)
+		}
+		a := &applied[i]
+		a.oldValue, a.existed = seriesValue(families, u.metricName, u.labels)
+		if t.writable() && (recordJournal || ctx.String("audit-log") != "") {
+			a.before = snapshotSeries(families, u)
+		}
+		changedOld := valuePtr(seriesValue(families, u.metricName, changedLabels(u)))
+		if u == req {
+			t.oldValue = changedOld
+		}
+		if refused {
+			continue
+		}
+		if series := formatSeries(u.metricName, changedLabels(u)); changedOld == nil && !req.newSeries.allow(t.filename, series) {
+			t.errors.AddError(fmt.Errorf("not creating %s: --max-new-series-per-run=%d reached", series, req.newSeries.max), "series_limit")
+			continue
+		}
+
+		uValues := u.values
+		if u.expression != nil {
+			uValues = values
+		}
+		if mode := req.labelConsistency; (mode == "warn" || mode == "refuse") && families[u.metricName] != nil && findSeries(families, u.metricName, changedLabels(u)) == nil && u.operation != "delete" && u.operation != "rename" {
+			if err := checkLabelConsistency(u.metricName, families[u.metricName], changedLabels(u)); err != nil {
+				if mode == "refuse" {
+					t.errors.AddError(fmt.Errorf("refusing %w (use --label-consistency=fill to pad it)", err), "inconsistent_labels")
+					continue
+				}
+				if !ctx.Bool("quiet") {
+					log.Printf("WARN: %v", err)
+				}
+			}
+		}
+		if len(uValues) == 1 && req.deadband.holds(families, u, uValues[0]) {
+			withinDeadband++
+			continue
+		}
+		family := families[u.metricName]
+		var before *seriesSnapshot
+		if recordTombstones && u.operation == "delete" {
+			before = snapshotSeries(families, u)
+		}
+		for _, value := range uValues {
+			err := applyRequest(families, u, value)
+			if err != nil {
+				err = fmt.Errorf("failed to apply operation: %w", err)
+				if u.line > 0 {
+					err = fmt.Errorf("line %d: %w", u.line, err)
+				}
+				t.errors.AddError(err, "operation_error")
+				break
+			}
+		}
+		if req.labelConsistency == "fill" && families[u.metricName] != nil {
+			// A new series may have brought label names of its own
+			fillFamilyLabels(families[u.metricName])
+		}
+		if u.operation == "delete" {
+			if markStaleSeries && family != nil && findSeries(families, u.metricName, u.labels) == nil {
+				if err := markStale(families, family, u.labels, timeProvider.Now()); err != nil {
+					log.Printf("WARN: failed to mark %s stale: %v", formatSeries(u.metricName, u.labels), err)
+				}
+			}
+		} else if findSeries(families, u.metricName, u.labels) != nil {
+			clearStale(families, u.metricName, u.labels)
+		}
+		if before != nil && findSeries(families, u.metricName, u.labels) == nil {
+			ts, err := newTombstone(u.metricName, family, before)
+			if err != nil {
+				log.Printf("WARN: failed to keep deleted %s: %v", formatSeries(u.metricName, u.labels), err)
+			} else {
+				deleted = append(deleted, *ts)
+			}
+		}
+		if recordJournal && u.operation != "rename" {
+			// Undo works series by series, so a moved family isn't journaled
+			entry, err := newJournalEntry(families, u, a.before)
+			if err != nil {
+				log.Printf("WARN: failed to record operation in journal for %s: %v", t.filename, err)
+			} else if entry != nil {
+				journal = append(journal, *entry)
+			}
+		}
+	}
+
+	if req.batch == nil {
+		t.newValue = valuePtr(seriesValue(families, req.metricName, changedLabels(req)))
+	}
+	if t.validateOnly {
+		return t.reportValidation(len(updates))
+	}
+	if withinDeadband == len(updates) && t.writable() && !t.errors.HasErrors() {
+		// Nothing moved enough to be worth rewriting the file
+		if req.verbose {
+			log.Printf("Within --deadband, leaving %s unchanged", t.filename)
+		}
+		return nil
+	}
+
+	if len(deleted) > 0 {
+		t.tombstones = append(tombstones, deleted...)
+		addTombstoneMetrics(families, t.tombstones, ctx.Duration("tombstone-retention"))
+	}
+
+	// Always try to write metrics (including error metrics)
+	addErrorMetrics(families, t.errors)
+	addSuspiciousLabelMetrics(families, req.suspicious)
+	addOperationalMetrics(families, req.operation, inputSize, t.lockWaitTime, t.errors)
+	addLockQueueMetrics(families, t.queue)
+	addLockTimeoutMetrics(families, t.lockTimeout)
+	addValueCommandMetrics(families, req.valueCmd)
+	addClockSkewMetrics(families, t.modTime, timeProvider.Now())
+
+	// Optionally convert line endings for Windows consumers
+	outputWriter := func(w io.Writer) io.Writer {
+		if ctx.Bool("crlf") {
+			return metricsfile.NewCRLFWriter(w)
+		}
+		return w
+	}
+
+	if ctx.Bool("strict-roundtrip") && !unreadable {
+		if err := metricsfile.CheckRoundTrip(families); err != nil {
+			return fmt.Errorf("refusing to write %s: the output wouldn't read back the same: %w", t.filename, err)
+		}
+	}
+
+	// Write output based on mode
+	var err, auditErr, hookErr error
+	if unreadable && t.inPlace {
+		// Never rewrite a file we refused or failed to read
+		t.audit(ctx, updates, applied, nil, nil)
+		return t.errors.FirstError()
+	} else if t.writable() {
+		// In-place mode: write back to the target file
+		err = t.write(ctx, req, families, journal, outputWriter)
+		auditErr = t.audit(ctx, updates, applied, families, err)
+		var verifyErr *verifyError
+		if errors.As(err, &verifyErr) {
+			return err
+		}
+		if err == nil && !t.errors.HasErrors() {
+			hookErr = t.postWrite(ctx, req)
+		}
+	} else if t.readOnly {
+		// The write was diverted, so stdout gets the metrics whatever the mode
+		err = writeMetricsWithSelfMonitoring(families, outputWriter(os.Stdout))
+	} else if ctx.Bool("quiet") || ctx.Bool("porcelain") || ctx.Bool("print-result") {
+		// Metrics would only go to stdout, which these modes keep clean
+		err = writeMetricsWithSelfMonitoring(families, io.Discard)
+	} else {
+		// Default mode: write to stdout (enables pipelines)
+		err = writeMetricsWithSelfMonitoring(families, outputWriter(os.Stdout))
+	}
+
+	if t.inPlace && !t.writable() {
+		// Locking failed, so nothing was written
+		auditErr = t.audit(ctx, updates, applied, nil, t.errors.FirstError())
+	}
+
+	if err != nil {
+		// This is a critical error - we can't write output
+		return fmt.Errorf("failed to write metrics to %s: %w", t.filename, err)
+	}
+
+	// Sinks get the metrics once they're safely written, or printed when
+	// not in-place
+	var sinkErr error
+	if len(req.sinks) > 0 && !t.errors.HasErrors() && (t.writable() || !t.inPlace) {
+		sinkErr = sendToSinks(req.sinks, families, updates, req.verbose)
+	}
+
+	for i, u := range updates {
+		if ctx.Bool("porcelain") {
+			newValue, exists := seriesValue(families, u.metricName, u.labels)
+			fmt.Println(formatPorcelain(!t.errors.HasErrors(), u.operation, formatSeries(u.metricName, u.labels), applied[i].oldValue, applied[i].existed, newValue, exists))
+		}
+
+		if ctx.Bool("print-result") && !t.errors.HasErrors() {
+			newValue, _ := seriesValue(families, u.metricName, u.labels)
+			fmt.Println(strconv.FormatFloat(newValue, 'f', -1, 64))
+			if !applied[i].existed {
+				fmt.Fprintf(os.Stderr, "created %s\n", formatSeries(u.metricName, u.labels))
+			}
+		}
+	}
+
+	if t.readOnly {
+		return &readOnlyError{filename: t.filename}
+	}
+
+	// Return first error for exit code, but after writing metrics
+	if err := t.errors.FirstError(); err != nil {
+		return err
+	}
+	return errors.Join(auditErr, hookErr, sinkErr)
+}
+
+// write rewrites the target file with families, verifying the result with
+// --verify-write and recording the operations in the journal and history.
+// Object storage targets get none of these, just a conditional put, and
+// encrypted files skip the journal and history, which aren't encrypted.
+func (t *target) write(ctx *cli.Context, req *request, families map[string]*dto.MetricFamily, journal []journalEntry, outputWriter func(io.Writer) io.Writer) error {
+	if t.remote != nil {
+		data, err := sealMetrics(families, req.encryptKey, outputWriter)
+		if err != nil {
+			return err
+		}
+		return t.remote.put(data)
+	}
+
+	var backup []byte
+	verify := ctx.Bool("verify-write")
+	staging := t.held != nil && t.held.staging != nil
+	if verify && staging {
+		return fmt.Errorf("--verify-write can't check %s before every flushes it", t.filename)
+	}
+	if verify {
+		var err error
+		if backup, err = readBackup(t.lock.File()); err != nil {
+			return fmt.Errorf("failed to back up %s before writing: %w", t.filename, err)
+		}
+	}
+	var err error
+	if req.encryptKey != nil {
+		var data []byte
+		if data, err = sealMetrics(families, req.encryptKey, outputWriter); err == nil {
+			err = t.lock.Rewrite(func(file *os.File) error {
+				_, err := file.Write(data)
+				return err
+			})
+		}
+	} else {
+		err = t.lock.RewriteWithChecksum(func(file *os.File) error {
+			return writeMetricsWithSelfMonitoring(families, outputWriter(file))
+		})
+	}
+	if err == nil && staging {
+		t.held.staged(req.sync)
+	} else if err == nil {
+		err = t.lock.Sync(req.sync)
+	}
+	if err != nil {
+		return err
+	}
+	if verify {
+		if verifyErr := verifyWrite(t.filename, req, families); verifyErr != nil {
+			if restoreErr := restoreBackup(t.lock, backup); restoreErr != nil {
+				verifyErr = errors.Join(verifyErr, fmt.Errorf("failed to restore %s: %w", t.filename, restoreErr))
+			}
+			return &verifyError{err: verifyErr}
+		}
+		if req.verbose {
+			log.Printf("Verified write to %s", t.filename)
+		}
+	}
+	if req.encryptKey != nil {
+		return nil
+	}
+	if size := ctx.Int("journal-size"); size > 0 {
+		t.journal(journal, size)
+	}
+	if t.tombstones != nil {
+		if err := writeTombstones(t.filename, t.tombstones, ctx.Duration("tombstone-retention")); err != nil {
+			log.Printf("WARN: failed to keep deleted series for %s: %v", t.filename, err)
+		}
+	}
+	if ctx.Bool("history") && ctx.Int("history-size") > 0 {
+		for _, u := range req.updates() {
+			if err := appendHistory(t.filename, families, u, ctx.Int("history-size")); err != nil {
+				log.Printf("WARN: failed to record history for %s: %v", t.filename, err)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// sealMetrics renders families with a checksum trailer, encrypted when key
+// is set, for writers that produce the whole file at once.
+func sealMetrics(families map[string]*dto.MetricFamily, key []byte, outputWriter func(io.Writer) io.Writer) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeMetricsWithSelfMonitoring(families, outputWriter(&buf)); err != nil {
+		return nil, err
+	}
+	data := metricsfile.AppendChecksum(buf.Bytes())
+	if key == nil {
+		return data, nil
+	}
+	return metricsfile.Encrypt(key, data)
+}
+
+// audit appends a record of each update's effect on the target to
+// --audit-log. families is nil when nothing was written. Failures are
+// logged as well as returned, since they may be shadowed by an earlier
+// error.
+func (t *target) audit(ctx *cli.Context, updates []*request, applied []appliedUpdate, families map[string]*dto.MetricFamily, writeErr error) error {
+	filename := ctx.String("audit-log")
+	if filename == "" {
+		return nil
+	}
+	runErr := writeErr
+	if runErr == nil {
+		runErr = t.errors.FirstError()
+	}
+	for i, u := range updates {
+		if err := appendAudit(filename, newAuditRecord(t, u, families, applied[i].before, runErr, ctx.String("encrypt-key-file") != "")); err != nil {
+			err = fmt.Errorf("failed to write audit log %s: %w", filename, err)
+			log.Printf("WARN: %v", err)
+			return err
+		}
+	}
+	return nil
+}
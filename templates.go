@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// labelTemplateFuncs are the functions available in label value templates.
+// Times come from timeProvider so runs are reproducible in tests.
+var labelTemplateFuncs = template.FuncMap{
+	// date formats the current UTC time with a Go layout: {{date "2006-01-02"}}
+	"date": func(layout string) string {
+		return timeProvider.Now().UTC().Format(layout)
+	},
+	// strftime formats the current UTC time with a C-style format: {{strftime "%Y-%m-%d"}}
+	"strftime": func(format string) (string, error) {
+		layout, err := strftimeLayout(format)
+		if err != nil {
+			return "", err
+		}
+		return timeProvider.Now().UTC().Format(layout), nil
+	},
+	"hostname": os.Hostname,
+	"env":      os.Getenv,
+}
+
+// expandLabelTemplates expands {{...}} placeholders in label values, such as
+// -l day={{date "2006-01-02"}} or -l host={{hostname}}.
+func expandLabelTemplates(labels map[string]string) error {
+	for name, value := range labels {
+		if !strings.Contains(value, "{{") {
+			continue
+		}
+
+		tmpl, err := template.New(name).Funcs(labelTemplateFuncs).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid template in label %s: %w", name, err)
+		}
+
+		var expanded strings.Builder
+		if err := tmpl.Execute(&expanded, nil); err != nil {
+			return fmt.Errorf("failed to expand label %s: %w", name, err)
+		}
+		labels[name] = expanded.String()
+	}
+	return nil
+}
+
+// strftimeDirectives maps C strftime directives to Go layout fragments.
+var strftimeDirectives = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'H': "15",
+	'M': "04",
+	'S': "05",
+	'b': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'p': "PM",
+	'Z': "MST",
+	'z': "-0700",
+	'j': "002",
+	'F': "2006-01-02",
+	'T': "15:04:05",
+	'%': "%",
+}
+
+func strftimeLayout(format string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+		if i+1 >= len(format) {
+			return "", fmt.Errorf("strftime format %q ends with %%", format)
+		}
+		i++
+		fragment, ok := strftimeDirectives[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported strftime directive %%%c", format[i])
+		}
+		layout.WriteString(fragment)
+	}
+	return layout.String(), nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandLabelTemplates(t *testing.T) {
+	setupMockTime(t, time.Date(2024, 3, 9, 14, 5, 0, 0, time.UTC))
+	t.Setenv("OMET_TEST_REGION", "eu-west")
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	labels := map[string]string{
+		"day":    `{{date "2006-01-02"}}`,
+		"month":  `{{strftime "%Y-%m"}}`,
+		"hour":   `h{{strftime "%H"}}`,
+		"host":   `{{hostname}}`,
+		"region": `{{env "OMET_TEST_REGION"}}`,
+		"plain":  "static",
+	}
+	require.NoError(t, expandLabelTemplates(labels))
+
+	assert.Equal(t, "2024-03-09", labels["day"])
+	assert.Equal(t, "2024-03", labels["month"])
+	assert.Equal(t, "h14", labels["hour"])
+	assert.Equal(t, hostname, labels["host"])
+	assert.Equal(t, "eu-west", labels["region"])
+	assert.Equal(t, "static", labels["plain"])
+
+	assert.Error(t, expandLabelTemplates(map[string]string{"bad": "{{date"}))
+	assert.Error(t, expandLabelTemplates(map[string]string{"bad": "{{nope}}"}))
+	assert.Error(t, expandLabelTemplates(map[string]string{"bad": `{{strftime "%Q"}}`}))
+}
+
+func TestLabelTemplatesCLI(t *testing.T) {
+	setupMockTime(t, time.Date(2024, 3, 9, 14, 5, 0, 0, time.UTC))
+	testFile := createTempFile(t, "")
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", `day={{date "2006-01-02"}}`, "backups_total", "inc"})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `backups_total{day="2024-03-09"} 1`)
+}
@@ -69,6 +69,8 @@ func createTempFile(t *testing.T, content string) string {
 	// Clean up after test
 	t.Cleanup(func() {
 		os.Remove(tmpFile.Name())
+		os.Remove(tmpFile.Name() + journalSuffix)
+		os.Remove(tmpFile.Name() + historySuffix)
 	})
 	
 	return tmpFile.Name()
@@ -102,29 +104,5 @@ func setupMockTime(t *testing.T, mockTime time.Time) *MockTimeProvider {
 
 // createTestApp creates a CLI app instance for testing
 func createTestApp() *cli.App {
-	app := &cli.App{
-		Name:  "omet",
-		Usage: "OpenMetrics manipulation tool",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:    "file",
-				Aliases: []string{"f"},
-				Usage:   "Input metrics file (default: stdin)",
-				Value:   "-",
-			},
-			&cli.StringSliceFlag{
-				Name:    "label",
-				Aliases: []string{"l"},
-				Usage:   "Add label in KEY=VALUE format (can be repeated)",
-			},
-			&cli.BoolFlag{
-				Name:    "verbose",
-				Aliases: []string{"v"},
-				Usage:   "Enable verbose logging",
-			},
-		},
-		ArgsUsage: "<metric_name> <operation> [value]",
-		Action:    runOmet,
-	}
-	return app
+	return newApp()
 }
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// tombstoneSuffix names the file next to a metrics file that keeps series
+// deleted with --tombstone. Like the journal, it doesn't end in .prom.
+const tombstoneSuffix = ".tombstones"
+
+// How long deleted series can be restored unless --tombstone-retention says
+// otherwise
+const defaultTombstoneRetention = 7 * 24 * time.Hour
+
+// tombstone is a deleted series, kept so omet restore can put it back.
+type tombstone struct {
+	Time   int64             `json:"time"`
+	Metric string            `json:"metric"`
+	Type   string            `json:"type"`
+	Help   string            `json:"help,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Series json.RawMessage   `json:"series"`
+}
+
+func newTombstone(name string, family *dto.MetricFamily, before *seriesSnapshot) (*tombstone, error) {
+	data, err := protojson.Marshal(before.metric)
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(before.metric.Label))
+	for _, label := range before.metric.Label {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return &tombstone{
+		Time:   timeProvider.Now().Unix(),
+		Metric: name,
+		Type:   before.typeName,
+		Help:   family.GetHelp(),
+		Labels: labels,
+		Series: data,
+	}, nil
+}
+
+func (ts *tombstone) expired(retention time.Duration) bool {
+	return timeProvider.Now().Sub(time.Unix(ts.Time, 0)) > retention
+}
+
+func readTombstones(filename string) ([]tombstone, error) {
+	file, err := os.Open(filename + tombstoneSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tombstones []tombstone
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var ts tombstone
+		if err := json.Unmarshal(scanner.Bytes(), &ts); err != nil {
+			return nil, fmt.Errorf("corrupt tombstones %s: %w", filename+tombstoneSuffix, err)
+		}
+		tombstones = append(tombstones, ts)
+	}
+	return tombstones, scanner.Err()
+}
+
+// writeTombstones replaces the tombstones with the ones still within
+// retention, removing the file when none are left. Callers hold the metrics
+// file's lock.
+func writeTombstones(filename string, tombstones []tombstone, retention time.Duration) error {
+	var buf bytes.Buffer
+	for _, ts := range tombstones {
+		if ts.expired(retention) {
+			continue
+		}
+		line, err := json.Marshal(ts)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if buf.Len() == 0 {
+		if err := os.Remove(filename + tombstoneSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	tmp := filename + tombstoneSuffix + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename+tombstoneSuffix)
+}
+
+// addTombstoneMetrics records how many deleted series of each family can
+// still be restored.
+func addTombstoneMetrics(families map[string]*dto.MetricFamily, tombstones []tombstone, retention time.Duration) {
+	delete(families, "omet_tombstoned_series")
+	counts := make(map[string]int)
+	for _, ts := range tombstones {
+		if !ts.expired(retention) {
+			counts[ts.Metric]++
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+	family, err := getOrCreateFamily(families, "omet_tombstoned_series", dto.MetricType_GAUGE)
+	if err != nil {
+		return
+	}
+	family.Help = stringPtr("Deleted series that omet restore can still bring back, by family")
+	for name, count := range counts {
+		metric := findOrCreateMetric(family, map[string]string{"metric": name})
+		metric.Gauge = &dto.Gauge{Value: float64Ptr(float64(count))}
+	}
+}
+
+func restoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "restore",
+		Usage:     "Bring back series deleted with --tombstone",
+		ArgsUsage: "[pattern]",
+		Description: `delete --tombstone keeps the deleted series next to the metrics file
+(metrics.prom.tombstones) for --tombstone-retention, 7 days by default.
+restore puts back those of the families matching the glob pattern (default:
+all), narrowed by -l labels. A series that exists again is skipped unless
+--force is given, in which case the deleted version replaces it.
+
+Examples:
+  omet restore -f /var/lib/node_exporter/app.prom --list
+  omet restore -f app.prom -l job=db 'backup_*'`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Metrics file to restore series in",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:    "label",
+				Aliases: []string{"l"},
+				Usage:   "Only restore series with this label in KEY=VALUE format (can be repeated)",
+			},
+			&cli.BoolFlag{
+				Name:  "list",
+				Usage: "List the restorable series without restoring them",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Replace series that exist again",
+			},
+			&cli.DurationFlag{
+				Name:  "tombstone-retention",
+				Value: defaultTombstoneRetention,
+				Usage: "How long deleted series can be restored",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 30 * time.Second,
+				Usage: "How long to wait for file lock",
+			},
+		},
+		Action: runRestore,
+	}
+}
+
+func runRestore(ctx *cli.Context) error {
+	if ctx.NArg() > 1 {
+		return fmt.Errorf("restore takes at most one pattern")
+	}
+	pattern := "*"
+	if ctx.NArg() == 1 {
+		pattern = ctx.Args().First()
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid family pattern %q: %w", pattern, err)
+	}
+	labels, err := parseLabels(ctx.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+	retention := ctx.Duration("tombstone-retention")
+
+	filename := ctx.String("file")
+	lock, err := metricsfile.NewFileLock(filename, ctx.Duration("lock-timeout"))
+	if err != nil {
+		return fmt.Errorf("failed to create file lock: %w", err)
+	}
+	defer lock.Close()
+	if err := lock.Lock(context.Background()); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	tombstones, err := readTombstones(filename)
+	if err != nil {
+		return err
+	}
+	var selected, kept []tombstone
+	for _, ts := range tombstones {
+		matched, _ := path.Match(pattern, ts.Metric)
+		if matched && !ts.expired(retention) && hasLabelValues(ts.Labels, labels) {
+			selected = append(selected, ts)
+		} else {
+			kept = append(kept, ts)
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no restorable series of %s in %s", pattern, filename)
+	}
+
+	if ctx.Bool("list") {
+		for _, ts := range selected {
+			age := timeProvider.Now().Sub(time.Unix(ts.Time, 0)).Truncate(time.Second)
+			fmt.Printf("%s\tdeleted %s ago\n", formatSeries(ts.Metric, ts.Labels), age)
+		}
+		return nil
+	}
+
+	families, err := metricsfile.Parse(lock.File())
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	// Newest first, so the last deleted version of a series wins
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].Time > selected[j].Time })
+	var report []string
+	restored := make(map[string]bool)
+	for _, ts := range selected {
+		series := formatSeries(ts.Metric, ts.Labels)
+		if restored[series] {
+			continue
+		}
+		if findSeries(families, ts.Metric, ts.Labels) != nil && !ctx.Bool("force") {
+			kept = append(kept, ts)
+			report = append(report, fmt.Sprintf("skipped %s: it exists again (use --force to replace it)", series))
+			continue
+		}
+		if err := restoreTombstone(families, &ts); err != nil {
+			return err
+		}
+		restored[series] = true
+		report = append(report, "restored "+series)
+	}
+
+	addTombstoneMetrics(families, kept, retention)
+	if err := lock.RewriteWithChecksum(func(file *os.File) error {
+		return writeMetrics(families, file)
+	}); err != nil {
+		return err
+	}
+	if err := writeTombstones(filename, kept, retention); err != nil {
+		return fmt.Errorf("restored series, but failed to update %s: %w", filename+tombstoneSuffix, err)
+	}
+	fmt.Println(strings.Join(report, "\n"))
+	return nil
+}
+
+func restoreTombstone(families map[string]*dto.MetricFamily, ts *tombstone) error {
+	series := formatSeries(ts.Metric, ts.Labels)
+	metric := &dto.Metric{}
+	if err := protojson.Unmarshal(ts.Series, metric); err != nil {
+		return fmt.Errorf("corrupt tombstone for %s: %w", series, err)
+	}
+	metricType, ok := metricsfile.ParseTypeName(ts.Type)
+	if !ok {
+		return fmt.Errorf("corrupt tombstone for %s: unknown type %q", series, ts.Type)
+	}
+	_, existed := families[ts.Metric]
+	family, err := getOrCreateFamily(families, ts.Metric, metricType)
+	if err != nil {
+		return fmt.Errorf("can't restore %s: %w", series, err)
+	}
+	if !existed && ts.Help != "" {
+		family.Help = stringPtr(ts.Help)
+	}
+	current := findOrCreateMetric(family, ts.Labels)
+	proto.Reset(current)
+	proto.Merge(current, metric)
+	return nil
+}
+
+// hasLabelValues reports whether labels include every one of want.
+func hasLabelValues(labels, want map[string]string) bool {
+	for name, value := range want {
+		if got, ok := labels[name]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTombstones(t *testing.T) {
+	const content = `# HELP backup_size_bytes Size of the last backup
+# TYPE backup_size_bytes gauge
+backup_size_bytes{job="db"} 1024
+backup_size_bytes{job="web"} 512
+`
+	run := func(t *testing.T, args ...string) error {
+		return createTestApp().Run(append([]string{"omet"}, args...))
+	}
+	read := func(t *testing.T, filename string) string {
+		data, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("deleted series can be restored", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		require.NoError(t, run(t, "-i", "-f", testFile, "--tombstone", "backup_*", "delete"))
+
+		data := read(t, testFile)
+		assert.NotContains(t, data, "backup_size_bytes{")
+		assert.Contains(t, data, `omet_tombstoned_series{metric="backup_size_bytes"} 2`)
+		tombstones, err := readTombstones(testFile)
+		require.NoError(t, err)
+		require.Len(t, tombstones, 2)
+
+		output := captureOutput(t, func() {
+			require.NoError(t, run(t, "restore", "-f", testFile, "--list"))
+		})
+		assert.Contains(t, output, `backup_size_bytes{job="db"}`)
+
+		output = captureOutput(t, func() {
+			require.NoError(t, run(t, "restore", "-f", testFile, "-l", "job=db"))
+		})
+		assert.Equal(t, "restored backup_size_bytes{job=\"db\"}\n", output)
+		data = read(t, testFile)
+		assert.Contains(t, data, "# HELP backup_size_bytes Size of the last backup")
+		assert.Contains(t, data, `backup_size_bytes{job="db"} 1024`)
+		assert.NotContains(t, data, `job="web"`)
+		assert.Contains(t, data, `omet_tombstoned_series{metric="backup_size_bytes"} 1`)
+
+		require.NoError(t, run(t, "restore", "-f", testFile))
+		assert.Contains(t, read(t, testFile), `backup_size_bytes{job="web"} 512`)
+		assert.NoFileExists(t, testFile+tombstoneSuffix)
+		assert.NotContains(t, read(t, testFile), "omet_tombstoned_series")
+	})
+
+	t.Run("recreated series are only replaced with --force", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		require.NoError(t, run(t, "-i", "-f", testFile, "--tombstone", "-l", "job=db", "backup_size_bytes", "delete"))
+		require.NoError(t, run(t, "-i", "-f", testFile, "-l", "job=db", "backup_size_bytes", "set", "2048"))
+
+		output := captureOutput(t, func() {
+			require.NoError(t, run(t, "restore", "-f", testFile))
+		})
+		assert.Contains(t, output, "skipped")
+		assert.Contains(t, read(t, testFile), `backup_size_bytes{job="db"} 2048`)
+
+		require.NoError(t, run(t, "restore", "-f", testFile, "--force"))
+		assert.Contains(t, read(t, testFile), `backup_size_bytes{job="db"} 1024`)
+	})
+
+	t.Run("expired tombstones can't be restored", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		past := time.Now().Add(-8 * 24 * time.Hour).Format(time.RFC3339)
+		require.NoError(t, run(t, "--now", past, "-i", "-f", testFile, "--tombstone", "-l", "job=db", "backup_size_bytes", "delete"))
+
+		assert.Error(t, run(t, "restore", "-f", testFile))
+		require.NoError(t, run(t, "restore", "-f", testFile, "--tombstone-retention", "240h"))
+		assert.Contains(t, read(t, testFile), `backup_size_bytes{job="db"} 1024`)
+	})
+
+	t.Run("requires an in-place local file", func(t *testing.T) {
+		testFile := createTempFile(t, content)
+		assert.Error(t, run(t, "-f", testFile, "--tombstone", "backup_size_bytes", "delete"))
+	})
+}
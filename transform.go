@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// transforms are --transform steps applied, in order, to a supplied value
+// after --scale.
+type transforms []func(float64) float64
+
+func (ts transforms) apply(value float64) float64 {
+	for _, t := range ts {
+		value = t(value)
+	}
+	return value
+}
+
+// transformSpecs collects repeated --transform flags. Unlike a string slice
+// flag it doesn't split on commas, which clamp:MIN,MAX needs.
+type transformSpecs []string
+
+func (s *transformSpecs) Set(spec string) error {
+	*s = append(*s, spec)
+	return nil
+}
+
+func (s *transformSpecs) String() string {
+	return strings.Join(*s, " ")
+}
+
+// parseTransforms parses abs, ceil, floor, round[:N], and clamp:MIN,MAX
+// specs. Either clamp bound may be empty to leave that side open.
+func parseTransforms(specs []string) (transforms, error) {
+	var ts transforms
+	for _, spec := range specs {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(spec), ":")
+		switch {
+		case name == "abs" && !hasArg:
+			ts = append(ts, math.Abs)
+		case name == "ceil" && !hasArg:
+			ts = append(ts, math.Ceil)
+		case name == "floor" && !hasArg:
+			ts = append(ts, math.Floor)
+		case name == "round":
+			places := 0
+			if hasArg {
+				n, err := strconv.Atoi(arg)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid --transform %q: decimal places must be a non-negative integer", spec)
+				}
+				places = n
+			}
+			factor := math.Pow10(places)
+			ts = append(ts, func(v float64) float64 { return math.Round(v*factor) / factor })
+		case name == "clamp" && hasArg:
+			low, high, err := parseClamp(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --transform %q: %w", spec, err)
+			}
+			ts = append(ts, func(v float64) float64 { return math.Max(low, math.Min(high, v)) })
+		default:
+			return nil, fmt.Errorf("invalid --transform %q (supported: abs, ceil, floor, round[:N], clamp:MIN,MAX)", spec)
+		}
+	}
+	return ts, nil
+}
+
+func parseClamp(arg string) (low, high float64, err error) {
+	lowStr, highStr, ok := strings.Cut(arg, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected MIN,MAX")
+	}
+	low, high = math.Inf(-1), math.Inf(1)
+	if lowStr = strings.TrimSpace(lowStr); lowStr != "" {
+		if low, err = strconv.ParseFloat(lowStr, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid minimum %q", lowStr)
+		}
+	}
+	if highStr = strings.TrimSpace(highStr); highStr != "" {
+		if high, err = strconv.ParseFloat(highStr, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid maximum %q", highStr)
+		}
+	}
+	if low > high {
+		return 0, 0, fmt.Errorf("minimum %g is above maximum %g", low, high)
+	}
+	return low, high, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTransforms(t *testing.T) {
+	for _, tc := range []struct {
+		specs []string
+		in    float64
+		want  float64
+	}{
+		{[]string{"abs"}, -3.5, 3.5},
+		{[]string{"ceil"}, 1.2, 2},
+		{[]string{"floor"}, 1.8, 1},
+		{[]string{"round"}, 2.5, 3},
+		{[]string{"round:2"}, 1.23456, 1.23},
+		{[]string{"clamp:0,1"}, 1.7, 1},
+		{[]string{"clamp:0,"}, -4, 0},
+		{[]string{"clamp:,10"}, 42, 10},
+		{[]string{"abs", "clamp:0,5"}, -9, 5},
+		{[]string{"clamp:0,5", "abs"}, -9, 0},
+	} {
+		ts, err := parseTransforms(tc.specs)
+		require.NoError(t, err, tc.specs)
+		assert.InDelta(t, tc.want, ts.apply(tc.in), 1e-9, tc.specs)
+	}
+
+	for _, spec := range []string{"sqrt", "abs:1", "round:x", "round:-1", "clamp", "clamp:1", "clamp:5,1", "clamp:a,b"} {
+		_, err := parseTransforms([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestTransformFlag(t *testing.T) {
+	testFile := createTempFile(t, "")
+
+	err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--scale", "0.01", "--transform", "round:1", "--transform", "clamp:0,1", "cache_hit_ratio", "set", "87.46"})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(testFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "cache_hit_ratio 0.9")
+
+	err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--transform", "sqrt", "cache_hit_ratio", "set", "4"})
+	assert.ErrorContains(t, err, "invalid --transform")
+}
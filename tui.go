@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/urfave/cli/v2"
+)
+
+func tuiCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "tui",
+		Usage: "Browse a metrics file interactively",
+		Description: `Shows the file's families as a tree that expands into series and their
+values. The file is re-read whenever it changes, keeping the current
+position; if a new version doesn't parse, the last good one stays on
+screen with the error in the status line.
+
+Keys:
+  up/down, j/k     move            pgup/pgdn   page
+  enter, space     expand/collapse right/left  expand/collapse
+  /                filter by name, HELP, or label (esc clears)
+  q, ctrl-c        quit
+
+Example:
+  omet tui -f /var/lib/node_exporter/app.prom`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "Metrics file to browse",
+				Required: true,
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Value: time.Second,
+				Usage: "How often to check the file for changes",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 5 * time.Second,
+				Usage: "How long each load waits for writers holding the file lock",
+			},
+			encryptKeyFlag(),
+		},
+		Action: runTUI,
+	}
+}
+
+// tuiRow is one line of the tree: a family, or a series of an expanded
+// family.
+type tuiRow struct {
+	family string
+	labels string // empty for family rows
+	text   string
+}
+
+func (r tuiRow) key() string {
+	return r.family + r.labels
+}
+
+// tuiModel is the browser's state, kept apart from the terminal so it can
+// be driven by tests.
+type tuiModel struct {
+	families  map[string]*dto.MetricFamily
+	expanded  map[string]bool
+	filter    string
+	filtering bool
+	cursor    int
+	offset    int
+	status    string
+	rows      []tuiRow
+}
+
+func newTUIModel(families map[string]*dto.MetricFamily) *tuiModel {
+	m := &tuiModel{expanded: make(map[string]bool)}
+	m.setFamilies(families)
+	return m
+}
+
+// setFamilies replaces the data, e.g. after a reload, keeping the cursor on
+// the same family or series when it still exists.
+func (m *tuiModel) setFamilies(families map[string]*dto.MetricFamily) {
+	var current string
+	if m.cursor < len(m.rows) {
+		current = m.rows[m.cursor].key()
+	}
+	m.families = families
+	m.rebuild()
+	for i, row := range m.rows {
+		if row.key() == current {
+			m.cursor = i
+			return
+		}
+	}
+	m.cursor = min(m.cursor, max(len(m.rows)-1, 0))
+}
+
+// rebuild recomputes the visible rows from the families, the filter, and
+// which families are expanded. A filter expands every family it matches.
+func (m *tuiModel) rebuild() {
+	families := m.families
+	if m.filter != "" {
+		families = grepFamilies(families, regexp.MustCompile("(?i)"+regexp.QuoteMeta(m.filter)))
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.rows = m.rows[:0]
+	for _, name := range names {
+		family := families[name]
+		open := m.expanded[name] || m.filter != ""
+		marker := "▸"
+		if open {
+			marker = "▾"
+		}
+		text := fmt.Sprintf("%s %-9s %s (%d)", marker, typeName(family), name, len(family.Metric))
+		if help := family.GetHelp(); help != "" {
+			text += "  " + help
+		}
+		m.rows = append(m.rows, tuiRow{family: name, text: text})
+		if !open {
+			continue
+		}
+
+		series := make([][2]string, len(family.Metric))
+		width := 0
+		for i, metric := range family.Metric {
+			series[i] = [2]string{labelString(metric.Label), seriesSummary(family.GetType(), metric, humanizeValue)}
+			width = max(width, len(series[i][0]))
+		}
+		sort.Slice(series, func(a, b int) bool { return series[a][0] < series[b][0] })
+		for _, s := range series {
+			m.rows = append(m.rows, tuiRow{family: name, labels: s[0], text: fmt.Sprintf("    %-*s  %s", width, s[0], s[1])})
+		}
+	}
+	m.cursor = min(m.cursor, max(len(m.rows)-1, 0))
+}
+
+// handleKey applies one key press and reports whether to quit. page is the
+// number of rows on screen.
+func (m *tuiModel) handleKey(key string, page int) bool {
+	if m.filtering {
+		switch key {
+		case "\r", "\n":
+			m.filtering = false
+		case "\x1b":
+			m.filtering = false
+			m.filter = ""
+		case "\x7f", "\b":
+			if m.filter != "" {
+				_, size := utf8.DecodeLastRuneInString(m.filter)
+				m.filter = m.filter[:len(m.filter)-size]
+			}
+		case "\x03":
+			return true
+		default:
+			if utf8.ValidString(key) && !strings.ContainsAny(key, "\x1b\r\n\t") {
+				m.filter += key
+			}
+		}
+		m.cursor = 0
+		m.rebuild()
+		return false
+	}
+
+	switch key {
+	case "q", "\x03":
+		return true
+	case "\x1b[A", "k":
+		m.cursor = max(m.cursor-1, 0)
+	case "\x1b[B", "j":
+		m.cursor = min(m.cursor+1, max(len(m.rows)-1, 0))
+	case "\x1b[5~":
+		m.cursor = max(m.cursor-page, 0)
+	case "\x1b[6~":
+		m.cursor = min(m.cursor+page, max(len(m.rows)-1, 0))
+	case "\r", " ", "\x1b[C", "l", "\x1b[D", "h":
+		if m.cursor >= len(m.rows) {
+			return false
+		}
+		family := m.rows[m.cursor].family
+		switch key {
+		case "\x1b[C", "l":
+			m.expanded[family] = true
+		case "\x1b[D", "h":
+			m.expanded[family] = false
+		default:
+			m.expanded[family] = !m.expanded[family]
+		}
+		// Keep the cursor on the family row when collapsing from a series
+		m.rebuild()
+		for i, row := range m.rows {
+			if row.family == family && row.labels == "" {
+				m.cursor = i
+				break
+			}
+		}
+	case "/":
+		m.filtering = true
+	case "\x1b":
+		m.filter = ""
+		m.rebuild()
+	}
+	return false
+}
+
+// render draws the screen: a title line, the rows around the cursor, and
+// a status line. Lines end in \r\n since the terminal is in raw mode.
+func (m *tuiModel) render(w io.Writer, title string, width, height int) {
+	page := max(height-2, 1)
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+page {
+		m.offset = m.cursor - page + 1
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\033[H\033[2J")
+	buf.WriteString(ansiBold + truncate(title, width) + ansiReset + "\r\n")
+	for i := m.offset; i < m.offset+page; i++ {
+		if i < len(m.rows) {
+			line := truncate(m.rows[i].text, width)
+			if i == m.cursor {
+				line = "\033[7m" + line + ansiReset
+			}
+			buf.WriteString(line)
+		}
+		buf.WriteString("\r\n")
+	}
+
+	status := "↑↓ move  enter expand  / filter  q quit"
+	switch {
+	case m.filtering:
+		status = "filter: " + m.filter + "█"
+	case m.filter != "":
+		status = fmt.Sprintf("filter: %s (esc clears)  %s", m.filter, status)
+	}
+	if m.status != "" {
+		status = m.status + "  " + status
+	}
+	buf.WriteString(ansiDim + truncate(status, width) + ansiReset)
+	w.Write(buf.Bytes())
+}
+
+func truncate(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	runes := []rune(line)
+	return string(runes[:width-1]) + "…"
+}
+
+func runTUI(ctx *cli.Context) error {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return fmt.Errorf("omet tui needs a terminal; use omet show or omet grep in scripts")
+	}
+
+	// Loads take a shared lock so they never see a writer's half-written file
+	filename := ctx.String("file")
+	lockTimeout := ctx.Duration("lock-timeout")
+	key, err := loadEncryptKey(ctx)
+	if err != nil {
+		return err
+	}
+	families, err := metricsfile.ParseFileShared(filename, lockTimeout, key)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	model := newTUIModel(families)
+
+	restore, err := rawTerminal()
+	if err != nil {
+		return err
+	}
+	defer restore()
+	fmt.Print("\033[?1049h\033[?25l") // alternate screen, hide cursor
+	defer fmt.Print("\033[?25h\033[?1049l")
+
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- string(buf[:n])
+		}
+	}()
+
+	ticker := time.NewTicker(ctx.Duration("interval"))
+	defer ticker.Stop()
+	height, width := terminalSize()
+	for {
+		series := 0
+		for _, family := range model.families {
+			series += len(family.Metric)
+		}
+		title := fmt.Sprintf("omet tui — %s (%d families, %d series)", filename, len(model.families), series)
+		model.render(os.Stdout, title, width, height)
+
+		select {
+		case key, ok := <-keys:
+			if !ok || model.handleKey(key, max(height-2, 1)) {
+				return nil
+			}
+		case <-ticker.C:
+			height, width = terminalSize()
+			current, err := os.Stat(filename)
+			if err != nil {
+				model.status = fmt.Sprintf("stat failed: %v", err)
+				continue
+			}
+			if current.ModTime().Equal(info.ModTime()) && current.Size() == info.Size() {
+				continue
+			}
+			// A failed reload is retried on the next tick
+			if families, err := metricsfile.ParseFileShared(filename, lockTimeout, key); err != nil {
+				model.status = fmt.Sprintf("reload failed: %v", err)
+			} else {
+				info = current
+				model.setFamilies(families)
+				model.status = "reloaded " + time.Now().Format("15:04:05")
+			}
+		}
+	}
+}
+
+// rawTerminal switches the terminal to raw mode with stty and returns a
+// function that restores the previous settings.
+func rawTerminal() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read terminal settings: %w", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("failed to enter raw mode: %w", err)
+	}
+	return func() { stty(strings.TrimSpace(saved)) }, nil
+}
+
+// terminalSize returns rows and columns, falling back to 24x80.
+func terminalSize() (int, int) {
+	var rows, cols int
+	if out, err := stty("size"); err == nil {
+		if _, err := fmt.Sscan(out, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return rows, cols
+		}
+	}
+	return 24, 80
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
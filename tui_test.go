@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTUIModel(t *testing.T) {
+	families, err := parseMetrics(strings.NewReader(`# HELP backups_total Completed backups
+# TYPE backups_total counter
+backups_total{job="db"} 3
+backups_total{job="files"} 1500
+# TYPE disk_free_bytes gauge
+disk_free_bytes{mount="/"} 2e9
+`))
+	require.NoError(t, err)
+	m := newTUIModel(families)
+
+	texts := func() []string {
+		var lines []string
+		for _, row := range m.rows {
+			lines = append(lines, strings.TrimSpace(row.text))
+		}
+		return lines
+	}
+
+	assert.Equal(t, []string{
+		"▸ counter   backups_total (2)  Completed backups",
+		"▸ gauge     disk_free_bytes (1)",
+	}, texts(), "families start collapsed")
+
+	t.Run("expand and move", func(t *testing.T) {
+		m.handleKey("\r", 10)
+		assert.Equal(t, []string{
+			"▾ counter   backups_total (2)  Completed backups",
+			`{job="db"}     3`,
+			`{job="files"}  1.5K`,
+			"▸ gauge     disk_free_bytes (1)",
+		}, texts())
+
+		m.handleKey("j", 10)
+		m.handleKey("\x1b[B", 10)
+		assert.Equal(t, `backups_total{job="files"}`, m.rows[m.cursor].key())
+
+		m.handleKey("h", 10)
+		assert.Len(t, m.rows, 2)
+		assert.Equal(t, 0, m.cursor, "collapsing moves the cursor to the family")
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		for _, key := range []string{"/", "F", "i", "l", "x", "\x7f", "\r"} {
+			m.handleKey(key, 10)
+		}
+		assert.Equal(t, "Fil", m.filter)
+		assert.Equal(t, []string{
+			"▾ counter   backups_total (1)  Completed backups",
+			`{job="files"}  1.5K`,
+		}, texts(), "filters match label values case-insensitively and expand")
+
+		m.handleKey("\x1b", 10)
+		assert.Len(t, m.rows, 2)
+	})
+
+	t.Run("reload keeps the position", func(t *testing.T) {
+		m.handleKey("j", 10)
+		m.handleKey("l", 10)
+		m.handleKey("j", 10)
+		require.Equal(t, `disk_free_bytes{mount="/"}`, m.rows[m.cursor].key())
+
+		reloaded, err := parseMetrics(strings.NewReader("# TYPE a_total counter\na_total 1\n# TYPE disk_free_bytes gauge\ndisk_free_bytes{mount=\"/\"} 1e9\n"))
+		require.NoError(t, err)
+		m.setFamilies(reloaded)
+		assert.Equal(t, `disk_free_bytes{mount="/"}`, m.rows[m.cursor].key())
+		assert.Contains(t, m.rows[m.cursor].text, "1G")
+	})
+
+	t.Run("render scrolls to the cursor", func(t *testing.T) {
+		var screen bytes.Buffer
+		m.render(&screen, "title", 40, 3)
+		lines := strings.Split(screen.String(), "\r\n")
+		require.Len(t, lines, 3)
+		assert.Contains(t, lines[1], "\033[7m", "the cursor row is highlighted")
+		assert.Contains(t, lines[1], `{mount="/"}`)
+		assert.Contains(t, lines[2], "q quit")
+	})
+
+	assert.True(t, m.handleKey("q", 10))
+}
@@ -0,0 +1,17 @@
+package main
+
+import "fmt"
+
+// reportValidation prints what --validate-only found for the target: every
+// problem, or how many updates would apply cleanly.
+func (t *target) reportValidation(updates int) error {
+	problems := t.errors.messages()
+	if len(problems) == 0 {
+		fmt.Printf("OK    %s: %d updates valid\n", t.filename, updates)
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Printf("FAIL  %s: %s\n", t.filename, problem)
+	}
+	return fmt.Errorf("validation failed: %d problems in %s", len(problems), t.filename)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// valueCommand is a --value-cmd run, kept to record how it went.
+type valueCommand struct {
+	duration time.Duration
+	exitCode int
+}
+
+// runValueCommand runs command through sh -c and reads the value from the
+// first line of its output, like --value-from. The command's stderr passes
+// through to ours.
+func runValueCommand(command string) (float64, *valueCommand, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = &stdout, os.Stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	run := &valueCommand{duration: time.Since(start)}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		run.exitCode = exitErr.ExitCode()
+		return 0, run, fmt.Errorf("value command failed: %w", err)
+	case err != nil:
+		return 0, nil, fmt.Errorf("failed to run value command: %w", err)
+	}
+
+	value, err := readValue(&stdout)
+	if err != nil {
+		return 0, run, fmt.Errorf("value command output: %w", err)
+	}
+	return value, run, nil
+}
+
+// addValueCommandMetrics records how long the --value-cmd run took and how it
+// exited.
+func addValueCommandMetrics(families map[string]*dto.MetricFamily, run *valueCommand) {
+	if run == nil {
+		return
+	}
+	if family, err := getOrCreateFamily(families, "omet_value_cmd_duration_seconds", dto.MetricType_GAUGE); err == nil {
+		family.Help = stringPtr("Duration of the last --value-cmd run in seconds")
+		metric := findOrCreateMetric(family, map[string]string{})
+		metric.Gauge = &dto.Gauge{Value: float64Ptr(run.duration.Seconds())}
+	}
+	if family, err := getOrCreateFamily(families, "omet_value_cmd_exit_code", dto.MetricType_GAUGE); err == nil {
+		family.Help = stringPtr("Exit code of the last --value-cmd run")
+		metric := findOrCreateMetric(family, map[string]string{})
+		metric.Gauge = &dto.Gauge{Value: float64Ptr(float64(run.exitCode))}
+	}
+}
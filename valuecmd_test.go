@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueCmd(t *testing.T) {
+	t.Run("reads the first line of the command's output", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--value-cmd", "printf '2048\\nignored\\n'", "--scale", "1024", "root_available_bytes", "set"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "root_available_bytes 2.097152e+06")
+		assert.Contains(t, string(content), "omet_value_cmd_exit_code 0")
+		assert.Contains(t, string(content), "omet_value_cmd_duration_seconds ")
+	})
+
+	t.Run("a failing command records its exit code", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--value-cmd", "echo 5; exit 3", "queue_depth", "set"})
+		assert.ErrorContains(t, err, "value command failed: exit status 3")
+
+		content, readErr := os.ReadFile(testFile)
+		require.NoError(t, readErr)
+		assert.NotContains(t, string(content), "queue_depth")
+		assert.Contains(t, string(content), "omet_value_cmd_exit_code 3")
+		assert.Contains(t, string(content), `omet_errors_total{type="io_error"} 1`)
+	})
+
+	t.Run("output that isn't a number is an error", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--value-cmd", "echo lots", "queue_depth", "set"})
+		assert.ErrorContains(t, err, "invalid number: lots")
+	})
+
+	t.Run("conflicts with other value sources", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--value-cmd", "echo 1", "queue_depth", "set", "2"})
+		assert.ErrorContains(t, err, "can't be combined")
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"omet/internal/metricsfile"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// exitVerifyFailed is the exit status when --verify-write rejected a write
+// and the file was restored.
+const exitVerifyFailed = 3
+
+// verifyError marks a write that failed verification. It deliberately isn't
+// a cli.ExitCoder, which would make the cli package exit from inside Run.
+type verifyError struct {
+	err error
+}
+
+func (e *verifyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *verifyError) Unwrap() error {
+	return e.err
+}
+
+// readBackup returns the locked file's contents before it is rewritten.
+func readBackup(file *os.File) ([]byte, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(file)
+}
+
+// verifyWrite re-reads filename from disk and checks that it parses, that
+// its checksum trailer matches, and that the series the request's updates
+// touched hold the values that were written.
+func verifyWrite(filename string, req *request, written map[string]*dto.MetricFamily) error {
+	data, err := os.ReadFile(filename)
+	if err == nil && req.encryptKey != nil {
+		data, err = metricsfile.Decrypt(req.encryptKey, data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to re-read %s: %w", filename, err)
+	}
+	if !metricsfile.Verify(data) {
+		return fmt.Errorf("%s: checksum trailer missing or wrong after write", filename)
+	}
+	families, err := metricsfile.Parse(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s no longer parses after write: %w", filename, err)
+	}
+
+	for _, u := range req.updates() {
+		want, wantExists := seriesValue(written, u.metricName, u.labels)
+		got, gotExists := seriesValue(families, u.metricName, u.labels)
+		switch {
+		case wantExists && !gotExists:
+			return fmt.Errorf("%s: %s missing after write", filename, formatSeries(u.metricName, u.labels))
+		case wantExists && got != want && !(math.IsNaN(got) && math.IsNaN(want)):
+			return fmt.Errorf("%s: %s is %g after write, expected %g", filename, formatSeries(u.metricName, u.labels), got, want)
+		}
+	}
+	return nil
+}
+
+// restoreBackup puts the pre-write contents back into the locked file.
+func restoreBackup(lock *metricsfile.FileLock, backup []byte) error {
+	return lock.Rewrite(func(file *os.File) error {
+		_, err := file.Write(backup)
+		return err
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"omet/internal/metricsfile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyWrite(t *testing.T) {
+	original := "# TYPE jobs_total counter\njobs_total 4\n"
+
+	t.Run("verified write succeeds", func(t *testing.T) {
+		testFile := createTempFile(t, original)
+
+		err := createTestApp().Run([]string{"omet", "--verify-write", "-i", "-f", testFile, "jobs_total", "inc"})
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "jobs_total 5")
+	})
+
+	t.Run("detects a write that didn't land and restores the backup", func(t *testing.T) {
+		testFile := createTempFile(t, original)
+		req := &request{metricName: "jobs_total", operation: "inc"}
+
+		lock, err := metricsfile.NewFileLock(testFile, time.Second)
+		require.NoError(t, err)
+		defer lock.Close()
+		require.NoError(t, lock.Lock(t.Context()))
+
+		backup, err := readBackup(lock.File())
+		require.NoError(t, err)
+		written, err := metricsfile.Parse(strings.NewReader("# TYPE jobs_total counter\njobs_total 5\n"))
+		require.NoError(t, err)
+
+		// The file on disk still holds the old value
+		require.NoError(t, lock.RewriteWithChecksum(func(file *os.File) error {
+			_, err := file.WriteString(original)
+			return err
+		}))
+		err = verifyWrite(testFile, req, written)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "jobs_total is 4 after write, expected 5")
+
+		require.NoError(t, restoreBackup(lock, backup))
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, original, string(content))
+	})
+
+	t.Run("rejects a file without a valid checksum", func(t *testing.T) {
+		testFile := createTempFile(t, original)
+		written, err := metricsfile.Parse(strings.NewReader(original))
+		require.NoError(t, err)
+
+		err = verifyWrite(testFile, &request{metricName: "jobs_total"}, written)
+		assert.ErrorContains(t, err, "checksum trailer")
+	})
+}
+
+func TestStrictRoundTrip(t *testing.T) {
+	t.Run("writes output that reads back the same", func(t *testing.T) {
+		testFile := createTempFile(t, "# HELP jobs_total Jobs \\\\ tasks\n# TYPE jobs_total counter\njobs_total{path=\"C:\\\\tmp\"} 4\n")
+		require.NoError(t, createTestApp().Run([]string{"omet", "--strict-roundtrip", "-i", "-f", testFile, "-l", `path=C:\tmp`, "jobs_total", "inc"}))
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `jobs_total{path="C:\\tmp"} 5`)
+	})
+
+	t.Run("aborts a write that wouldn't", func(t *testing.T) {
+		// A quoted UTF-8 name reads fine but can't be written unquoted
+		original := "# TYPE jobs_total counter\njobs_total 4\n{\"jobs.queued\"} 2\n"
+		testFile := createTempFile(t, original)
+
+		err := createTestApp().Run([]string{"omet", "--strict-roundtrip", "-i", "-f", testFile, "jobs_total", "inc"})
+		assert.ErrorContains(t, err, "wouldn't read back the same")
+
+		content, err := os.ReadFile(testFile)
+		require.NoError(t, err)
+		assert.Equal(t, original, string(content))
+	})
+}
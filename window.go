@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// windowSpec is how window-inc buckets increments: --window and
+// --window-keep.
+type windowSpec struct {
+	period string // "hour" or "day", in local time
+	keep   int    // windows kept, counting the current one
+}
+
+func parseWindow(period string, keep int) (windowSpec, error) {
+	if period != "hour" && period != "day" {
+		return windowSpec{}, fmt.Errorf("invalid --window: %s (supported: hour, day)", period)
+	}
+	if keep < 1 {
+		return windowSpec{}, fmt.Errorf("--window-keep must be at least 1, got %d", keep)
+	}
+	return windowSpec{period: period, keep: keep}, nil
+}
+
+// label names the window containing t. Labels sort in time order.
+func (w windowSpec) label(t time.Time) string {
+	if w.period == "hour" {
+		return t.Local().Format("2006-01-02T15")
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+// oldest returns the label of the oldest window kept at t.
+func (w windowSpec) oldest(t time.Time) string {
+	t = t.Local()
+	if w.period == "hour" {
+		return w.label(t.Truncate(time.Hour).Add(-time.Duration(w.keep-1) * time.Hour))
+	}
+	// By date rather than 24h steps, which DST changes would skew
+	return w.label(time.Date(t.Year(), t.Month(), t.Day()-(w.keep-1), 12, 0, 0, 0, time.Local))
+}
+
+// windowFamilyName is the family holding a counter's per-window counts,
+// errors_window for errors_total.
+func windowFamilyName(name string) string {
+	return strings.TrimSuffix(name, "_total") + "_window"
+}
+
+// windowIncrement increments the lifetime counter name and, in the
+// windowed gauge family beside it, the series for the current window.
+// Windows older than w.keep are pruned from that family, whatever their
+// other labels, so it never grows past keep windows per label set.
+func windowIncrement(families map[string]*dto.MetricFamily, name string, labels map[string]string, value float64, w windowSpec, now time.Time) error {
+	if _, ok := labels["window"]; ok {
+		return fmt.Errorf("window-inc sets the window label itself; drop -l window=...")
+	}
+	windowName := windowFamilyName(name)
+	if existing, ok := families[windowName]; ok {
+		if err := validateMetricType(existing, dto.MetricType_GAUGE, windowName); err != nil {
+			return err
+		}
+	}
+	if err := incrementCounter(families, name, labels, value); err != nil {
+		return err
+	}
+
+	family, err := getOrCreateFamily(families, windowName, dto.MetricType_GAUGE)
+	if err != nil {
+		return err
+	}
+	family.Help = stringPtr(fmt.Sprintf("Increments of %s per %s, by the window label", name, w.period))
+
+	windowLabels := map[string]string{"window": w.label(now)}
+	for k, v := range labels {
+		windowLabels[k] = v
+	}
+	metric := findOrCreateMetric(family, windowLabels)
+	metric.Gauge = &dto.Gauge{Value: float64Ptr(metric.GetGauge().GetValue() + value)}
+
+	oldest := w.oldest(now)
+	kept := family.Metric[:0]
+	for _, m := range family.Metric {
+		if windowOf(m) >= oldest {
+			kept = append(kept, m)
+		}
+	}
+	family.Metric = kept
+	return nil
+}
+
+func windowOf(metric *dto.Metric) string {
+	for _, label := range metric.Label {
+		if label.GetName() == "window" {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
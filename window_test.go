@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowInc(t *testing.T) {
+	day := func(unix int64) string { return time.Unix(unix, 0).Local().Format("2006-01-02") }
+	run := func(t *testing.T, testFile string, now int64, args ...string) {
+		t.Helper()
+		base := []string{"omet", "--now", time.Unix(now, 0).Format(time.RFC3339), "-i", "-f", testFile}
+		require.NoError(t, createTestApp().Run(append(base, args...)))
+	}
+	const start = 1700000000
+
+	t.Run("counts per day and prunes old days", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		run(t, testFile, start, "-l", "job=a", "errors_total", "window-inc")
+		run(t, testFile, start, "-l", "job=a", "errors_total", "window-inc", "2")
+		run(t, testFile, start+86400, "-l", "job=a", "errors_total", "window-inc")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		value, _ := seriesValue(families, "errors_total", map[string]string{"job": "a"})
+		assert.Equal(t, 4.0, value)
+		value, _ = seriesValue(families, "errors_window", map[string]string{"job": "a", "window": day(start)})
+		assert.Equal(t, 3.0, value)
+		value, _ = seriesValue(families, "errors_window", map[string]string{"job": "a", "window": day(start + 86400)})
+		assert.Equal(t, 1.0, value)
+
+		// Two days on, the first day falls out of the default two windows
+		run(t, testFile, start+2*86400, "-l", "job=b", "errors_total", "window-inc")
+		families, err = parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		_, exists := seriesValue(families, "errors_window", map[string]string{"job": "a", "window": day(start)})
+		assert.False(t, exists)
+		assert.Len(t, families["errors_window"].Metric, 2)
+		value, _ = seriesValue(families, "errors_total", map[string]string{"job": "a"})
+		assert.Equal(t, 4.0, value, "the lifetime counter is never pruned")
+	})
+
+	t.Run("hourly windows", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		run(t, testFile, start, "--window", "hour", "--window-keep", "1", "jobs_total", "window-inc")
+		run(t, testFile, start+3600, "--window", "hour", "--window-keep", "1", "jobs_total", "window-inc")
+
+		families, err := parseMetrics(mustOpen(t, testFile))
+		require.NoError(t, err)
+		require.Len(t, families["jobs_window"].Metric, 1)
+		value, _ := seriesValue(families, "jobs_window", map[string]string{"window": time.Unix(start+3600, 0).Local().Format("2006-01-02T15")})
+		assert.Equal(t, 1.0, value)
+	})
+
+	t.Run("rejects bad options", func(t *testing.T) {
+		testFile := createTempFile(t, "")
+		err := createTestApp().Run([]string{"omet", "-i", "-f", testFile, "--window", "week", "errors_total", "window-inc"})
+		assert.ErrorContains(t, err, "invalid --window: week")
+
+		err = createTestApp().Run([]string{"omet", "-i", "-f", testFile, "-l", "window=x", "errors_total", "window-inc"})
+		assert.ErrorContains(t, err, "window-inc sets the window label itself")
+	})
+}
+
+func TestWindowOldest(t *testing.T) {
+	now := time.Date(2024, 3, 9, 14, 30, 0, 0, time.Local)
+	assert.Equal(t, "2024-03-03", windowSpec{period: "day", keep: 7}.oldest(now))
+	assert.Equal(t, "2024-03-09", windowSpec{period: "day", keep: 1}.oldest(now))
+	assert.Equal(t, "2024-03-09T12", windowSpec{period: "hour", keep: 3}.oldest(now))
+}